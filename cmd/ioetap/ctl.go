@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/control"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runCtl implements the ctl subcommand, which sends a command to the
+// control socket of a running ioetap (see --control-socket).
+func runCtl(args []string) int {
+	opts, err := cli.ParseCtl(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap ctl <socket> pause|resume|flush|stats\n")
+		fmt.Fprintf(os.Stderr, "       ioetap ctl <socket> mark <label>\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	resp, err := control.Send(opts.Socket, control.Request{Command: opts.Command, Label: opts.Label})
+	if err != nil {
+		logging.Errorf("%s: %v", opts.Socket, err)
+		return 1
+	}
+	if resp.Stats != nil {
+		data, err := json.MarshalIndent(resp.Stats, "", "  ")
+		if err != nil {
+			logging.Errorf("%v", err)
+			return 1
+		}
+		fmt.Printf("%s\n", data)
+	}
+	return 0
+}
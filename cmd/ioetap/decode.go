@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runDecode implements the decode subcommand, which writes the raw bytes of
+// one recorded stream.
+func runDecode(args []string) int {
+	opts, err := cli.ParseDecode(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap decode --source=<source> <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --source=<source>        Stream to decode: stdin, stdout or stderr\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	result, err := analysis.Decode(opts.Input, opts.Source, os.Stdout)
+	if err != nil {
+		logging.Errorf("%v", err)
+		return 1
+	}
+	if result.JSON > 0 {
+		logging.Warnf("%d json records were written as compact JSON; their original spacing is not recorded", result.JSON)
+	}
+	if result.Truncated > 0 {
+		logging.Warnf("%d records were truncated; the output is incomplete", result.Truncated)
+		return 1
+	}
+	return 0
+}
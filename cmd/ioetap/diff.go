@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runDiff implements the diff subcommand, which compares the streams of two
+// recordings. Like diff(1), it exits with 1 if they differ and 2 on errors.
+func runDiff(args []string) int {
+	opts, err := cli.ParseDiff(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap diff [options] <a.jsonl> <b.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --source=<list>          Streams to compare (default: stdin,stdout,stderr)\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 2
+	}
+
+	result, err := analysis.Diff(opts.A, opts.B, opts.Sources)
+	if err != nil {
+		logging.Errorf("%v", err)
+		return 2
+	}
+	for _, err := range result.Incomplete {
+		logging.Warnf("%v; the comparison is not exact", err)
+	}
+	for _, diff := range result.Diffs {
+		fmt.Printf("%s differs at line %d (byte %d):\n", diff.Source, diff.Line, diff.Offset)
+		fmt.Printf("  %s: %s\n", opts.A, quoteLine(diff.A))
+		fmt.Printf("  %s: %s\n", opts.B, quoteLine(diff.B))
+	}
+	if len(result.Diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// quoteLine returns a line of a StreamDiff for printing.
+func quoteLine(line []byte) string {
+	if line == nil {
+		return "(end of stream)"
+	}
+	return fmt.Sprintf("%q", line)
+}
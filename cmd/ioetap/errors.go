@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/pkg/ioetap"
+)
+
+// Error codes reported with --error-format=json. They are stable, so that
+// automation can tell the errors apart without parsing the messages.
+const (
+	codeParse    = "E_PARSE"     // Invalid command line
+	codeRecorder = "E_RECORDER"  // The recording could not be created or written
+	codeMetrics  = "E_METRICS"   // --metrics-addr could not be listened on
+	codePprof    = "E_PPROF"     // --pprof could not be listened on
+	codeControl  = "E_CONTROL"   // --control-socket could not be created
+	codeSpawn    = "E_SPAWN"     // The command could not be executed
+	codeNotFound = "E_NOT_FOUND" // The command was not found
+	codePanic    = "E_PANIC"     // ioetap panicked; the recording so far was kept
+)
+
+// errorExitCodes maps the error codes to the exit codes ioetap returns for
+// them. Recording errors after the child started return the child's exit
+// code instead.
+var errorExitCodes = map[string]int{
+	codeParse:    2,
+	codeRecorder: 3,
+	codeMetrics:  4,
+	codePprof:    5,
+	codeControl:  6,
+	codeSpawn:    126,
+	codeNotFound: 127,
+	codePanic:    70, // EX_SOFTWARE of sysexits.h
+}
+
+// errorReporter prints errors to stderr in the --error-format.
+type errorReporter struct {
+	format string
+}
+
+// report prints err with the given error code. The stack of a panic is
+// printed too, so that recovering from it does not hide where it happened.
+func (r errorReporter) report(code string, err error) {
+	var stack string
+	var panicErr *ioetap.PanicError
+	if errors.As(err, &panicErr) {
+		stack = string(panicErr.Stack)
+	}
+
+	if r.format != cli.ErrorFormatJSON {
+		if stack != "" {
+			logging.Errorf("%v\n\n%s", err, stack)
+		} else {
+			logging.Errorf("%v", err)
+		}
+		return
+	}
+	data, _ := json.Marshal(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Stack   string `json:"stack,omitempty"`
+	}{code, err.Error(), stack})
+	fmt.Fprintf(os.Stderr, "%s\n", data)
+}
+
+// runErrorCode returns the error code of err, returned by ioetap.Run with
+// status.
+func runErrorCode(err error, status ioetap.ExitStatus) string {
+	var startErr *ioetap.StartError
+	var panicErr *ioetap.PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		return codePanic
+	case errors.As(err, &startErr):
+		if status.Code == errorExitCodes[codeNotFound] {
+			return codeNotFound
+		}
+		return codeSpawn
+	case errors.Is(err, ioetap.ErrMetricsListen):
+		return codeMetrics
+	case errors.Is(err, ioetap.ErrControlListen):
+		return codeControl
+	default:
+		return codeRecorder
+	}
+}
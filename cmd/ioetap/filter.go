@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runFilter implements the filter subcommand, which writes the records of a
+// recording that match the given criteria to a new recording.
+func runFilter(args []string) int {
+	opts, err := cli.ParseFilter(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap filter [options] <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --source=<list>          Keep records from these sources (e.g. stdout,stderr)\n")
+		fmt.Fprintf(os.Stderr, "  --encoding=<list>        Keep records with these encodings (e.g. text,json)\n")
+		fmt.Fprintf(os.Stderr, "  --min-seq=<n>            Keep records with seq >= n\n")
+		fmt.Fprintf(os.Stderr, "  --max-seq=<n>            Keep records with seq <= n\n")
+		fmt.Fprintf(os.Stderr, "  --seq-range=<min>:<max>  Keep records within the seq range (either side optional)\n")
+		fmt.Fprintf(os.Stderr, "  --time-range=<from>/<to> Keep records within the RFC 3339 time range (either side optional)\n")
+		fmt.Fprintf(os.Stderr, "  --pattern=<regex>        Keep records whose content matches the regular expression\n")
+		fmt.Fprintf(os.Stderr, "  --or                     Keep records matching any criterion (default: all)\n")
+		fmt.Fprintf(os.Stderr, "  --out=<file>             Output file (default: stdout)\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	var filters []analysis.RecordFilter
+	if len(opts.Sources) > 0 {
+		filters = append(filters, analysis.SourceFilter{Sources: opts.Sources})
+	}
+	if len(opts.Encodings) > 0 {
+		filters = append(filters, analysis.EncodingFilter{Encodings: opts.Encodings})
+	}
+	if opts.MinSeq != nil || opts.MaxSeq != nil {
+		filters = append(filters, analysis.SeqRangeFilter{Min: opts.MinSeq, Max: opts.MaxSeq})
+	}
+	if !opts.From.IsZero() || !opts.To.IsZero() {
+		filters = append(filters, analysis.TimeRangeFilter{From: opts.From, To: opts.To})
+	}
+	if opts.Pattern != nil {
+		filters = append(filters, analysis.PatternFilter{Pattern: opts.Pattern})
+	}
+
+	var filter analysis.RecordFilter = analysis.AllOf(filters)
+	if opts.Or {
+		filter = analysis.AnyOf(filters)
+	}
+
+	output := os.Stdout
+	if opts.Output != "" {
+		output, err = os.Create(opts.Output)
+		if err != nil {
+			logging.Errorf("failed to create output file: %v", err)
+			return 1
+		}
+		defer output.Close()
+	}
+
+	if err := analysis.FilterRecordings(opts.Input, filter, output); err != nil {
+		logging.Errorf("%v", err)
+		return 1
+	}
+	return 0
+}
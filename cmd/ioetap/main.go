@@ -2,114 +2,819 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sync"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	"github.com/trustin/ioetap/internal/analysis"
 	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/output"
 	"github.com/trustin/ioetap/internal/process"
+	"github.com/trustin/ioetap/internal/profiling"
+	"github.com/trustin/ioetap/internal/progress"
 	"github.com/trustin/ioetap/internal/recorder"
 	"github.com/trustin/ioetap/internal/version"
+	"github.com/trustin/ioetap/internal/watch"
+	"github.com/trustin/ioetap/pkg/ioetap"
+	"github.com/trustin/ioetap/pkg/reading"
 )
 
 func main() {
 	os.Exit(run())
 }
 
-func run() int {
-	// Handle --version / -v before parsing other arguments
+func run() (exitCode int) {
+	// The subcommands read zstd-compressed recordings with the zstd command
+	reading.UseZstdCommand("zstd")
+
+	// Handle --version / -v and subcommands before parsing other arguments.
+	// A command with the same name as a subcommand can be run with
+	// "ioetap -- <command>".
 	if len(os.Args) > 1 {
-		arg := os.Args[1]
-		if arg == "--version" || arg == "-v" {
+		switch os.Args[1] {
+		case "--version", "-v":
 			fmt.Println(version.Info())
 			return 0
+		case "filter":
+			return runFilter(os.Args[2:])
+		case "merge":
+			return runMerge(os.Args[2:])
+		case "show":
+			return runShow(os.Args[2:])
+		case "decode":
+			return runDecode(os.Args[2:])
+		case "verify":
+			return runVerify(os.Args[2:])
+		case "replay":
+			return runReplay(os.Args[2:])
+		case "diff":
+			return runDiff(os.Args[2:])
+		case "schema":
+			return runSchema(os.Args[2:])
+		case "ctl":
+			return runCtl(os.Args[2:])
 		}
 	}
 
+	// Errors are reported in the --error-format even if parsing fails
+	reporter := errorReporter{format: cli.ParseErrorFormat(os.Args[1:])}
 	opts, err := cli.Parse(os.Args[1:])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Usage: ioetap [options] -- <command> [args...]\n")
-		fmt.Fprintf(os.Stderr, "       ioetap <command> [args...]\n")
+		if reporter.format == cli.ErrorFormatJSON {
+			reporter.report(codeParse, err)
+			return errorExitCodes[codeParse]
+		}
+		fmt.Fprintf(os.Stderr, "Usage: ioetap [options] [--] <command> [args...]\n")
+		fmt.Fprintf(os.Stderr, "       ioetap filter [options] <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "       ioetap merge [options] <recording.jsonl>...\n")
+		fmt.Fprintf(os.Stderr, "       ioetap show [options] <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "       ioetap decode --source=<source> <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "       ioetap verify [options] <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "       ioetap replay <recording.jsonl> [--] <command> [args...]\n")
+		fmt.Fprintf(os.Stderr, "       ioetap diff [options] <a.jsonl> <b.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "       ioetap schema [--version=<n>]\n")
+		fmt.Fprintf(os.Stderr, "       ioetap ctl <socket> <command> [<label>]\n")
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		fmt.Fprintf(os.Stderr, "  --out=<file>             Output file (default: <basename>-<pid>.jsonl)\n")
+		fmt.Fprintf(os.Stderr, "  --out=tcp://<host>:<port>  Also send the records to a TCP listener (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --out=http(s)://<url>    Also POST the records to a URL in NDJSON batches (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --remote-on-error=<p>    On a remote --out failure, continue without it (default) or abort\n")
+		fmt.Fprintf(os.Stderr, "  --sink-retry-initial=<dur>  Delay before retrying a failed remote --out, OTLP, S3 or Kafka destination, doubled every attempt (default: 100ms)\n")
+		fmt.Fprintf(os.Stderr, "  --sink-retry-cap=<dur>   Max delay between retries of a remote destination (default: 30s)\n")
+		fmt.Fprintf(os.Stderr, "  --sink-retry-max=<n>     Give up on a remote destination after <n> failed retries in a row (default: 0, never)\n")
+		fmt.Fprintf(os.Stderr, "  --http-keep-alive=false  Open a new connection for every request to an http(s) --out\n")
+		fmt.Fprintf(os.Stderr, "  --http-max-idle-conns=<n>  Idle connections kept open per http(s) --out host (default: 10)\n")
+		fmt.Fprintf(os.Stderr, "  --output-file-permissions=<mode>  Octal permissions of the output file, e.g. 0600 (default: umask)\n")
 		fmt.Fprintf(os.Stderr, "  --max-line-length=<n>    Max bytes per line (0=unlimited, default: 16MiB)\n")
+		fmt.Fprintf(os.Stderr, "  --truncate-binary=<n>    Max bytes per binary (base64) line instead of --max-line-length\n")
+		fmt.Fprintf(os.Stderr, "  --long-line-mode=<mode>  Truncate (default) or split lines over the limit into continued records\n")
+		fmt.Fprintf(os.Stderr, "  --truncation-strategy=<s>  Keep the first (head, default) or last (tail) bytes of truncated lines\n")
+		fmt.Fprintf(os.Stderr, "  --max-seq=<n>            Stop recording at seq <n> with a max-seq-reached meta record\n")
+		fmt.Fprintf(os.Stderr, "  --rlimit=<name>=<value>  Resource limit for the child (repeatable, Linux only)\n")
+		fmt.Fprintf(os.Stderr, "  --rlimit-<name>=<value>  Same as --rlimit=<name>=<value> (e.g. --rlimit-cpu=60)\n")
+		fmt.Fprintf(os.Stderr, "  --stdin-timeout=<dur>    Kill the child if it does not read stdin in time (exit code 124)\n")
+		fmt.Fprintf(os.Stderr, "  --stdin-rate-limit=<n>   Forward at most <n> bytes per second to the child's stdin\n")
+		fmt.Fprintf(os.Stderr, "  --annotate-stdin=<prefix>  Record stdin lines starting with <prefix> as annotations instead of forwarding them\n")
+		fmt.Fprintf(os.Stderr, "  --stdin-broadcast=<path> Copy the stdin forwarded to the child to a named pipe or file, without blocking\n")
+		fmt.Fprintf(os.Stderr, "  --stdin-split-on-newline=false\n")
+		fmt.Fprintf(os.Stderr, "                           Record stdin read by read instead of line by line, for binary input\n")
+		fmt.Fprintf(os.Stderr, "  --exit-code-map=<s:d,..> Return exit code <d> to the shell when the child exits with <s>\n")
+		fmt.Fprintf(os.Stderr, "  --source-weight=<s:w,..> Record source <s> <w> times as often as others when contending under load\n")
+		fmt.Fprintf(os.Stderr, "  --queue-size=<n>         Record through one writer goroutine with a queue of <n> operations instead of a mutex\n")
+		fmt.Fprintf(os.Stderr, "  --otlp-endpoint=<url>    Also export records as OpenTelemetry log records over OTLP/HTTP\n")
+		fmt.Fprintf(os.Stderr, "  --s3-bucket=<bucket>     Also upload the records to S3, with --s3-key (credentials: $AWS_ACCESS_KEY_ID, ...)\n")
+		fmt.Fprintf(os.Stderr, "  --s3-key=<key>           Key of the object the records are uploaded to\n")
+		fmt.Fprintf(os.Stderr, "  --s3-region=<region>     Region of the --s3-bucket (default: $AWS_REGION, $AWS_DEFAULT_REGION or us-east-1)\n")
+		fmt.Fprintf(os.Stderr, "  --s3-endpoint=<url>      URL of an S3-compatible service to upload to instead of AWS, e.g. MinIO\n")
+		fmt.Fprintf(os.Stderr, "  --kafka-broker=<addr>    Also publish the records to Kafka via the broker at <host>:<port>, with --kafka-topic (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --kafka-topic=<topic>    Topic the records are published to, one message per record keyed by source\n")
+		fmt.Fprintf(os.Stderr, "  --metrics-addr=<addr>    Serve Prometheus metrics at http://<addr>/metrics while the child runs\n")
+		fmt.Fprintf(os.Stderr, "  --control-socket=<path>  Accept pause, resume, mark, flush and stats commands on a unix socket (see ioetap ctl)\n")
+		fmt.Fprintf(os.Stderr, "  --control-socket-permissions=<mode>  Octal permissions of the control socket (default: 0600)\n")
+		fmt.Fprintf(os.Stderr, "  --pprof=<addr>           Serve ioetap's own pprof profiles at http://<addr>/debug/pprof/ while the child runs\n")
+		fmt.Fprintf(os.Stderr, "  --cpu-time-record=<dur>  Record the child's CPU time and RSS at this interval (Linux only)\n")
+		fmt.Fprintf(os.Stderr, "  --keep-on-error          Keep the recording only if the child exits with nonzero code\n")
+		fmt.Fprintf(os.Stderr, "  --output-format=<fmt>    Recording format: jsonl (default), jsonl-compact, ndjson-schema, html, newline-json-sorted or json-array\n")
+		fmt.Fprintf(os.Stderr, "  --header                 Write a header line describing the recording before the first record\n")
+		fmt.Fprintf(os.Stderr, "  --pass-fd=<fd>           Pass descriptor <fd> to the child as fd 3, 4, ... (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --watch=<glob>           Re-run the command when the matching files change (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --retry-command=<n>      Re-run the command up to n times while it exits with a nonzero code\n")
+		fmt.Fprintf(os.Stderr, "  --retry-delay=<dur>      Time to wait before re-running the command (default: 0s)\n")
+		fmt.Fprintf(os.Stderr, "  --grace-period=<dur>     Time the child has to exit after ioetap gets SIGTERM/SIGHUP (default: 5s)\n")
+		fmt.Fprintf(os.Stderr, "  --field=<key>=<value>    Add a custom field to every record (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --command-label=<text>   Describe the command in a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --comment=<text>         Add a free-text note to a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --comment-file=<file>    Add the content of <file> as a note to a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --record-cwd             Add the child's working directory to a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --record-command-path    Add the absolute path of the executed command to a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --record-identity        Add the child's effective uid, gid and umask to a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --record-config          Add ioetap's version and effective options to a start meta record\n")
+		fmt.Fprintf(os.Stderr, "  --no-buffering           Write every record to the file right away (alias: --flush-every-record)\n")
+		fmt.Fprintf(os.Stderr, "  --flush-on-line          Write every complete line to the file right away (alias: --force-flush-on-newline)\n")
+		fmt.Fprintf(os.Stderr, "  --progress               Show the records and bytes recorded so far on stderr if it is a terminal\n")
+		fmt.Fprintf(os.Stderr, "  --out-slog               Also log every record with the log/slog JSON handler to stderr\n")
+		fmt.Fprintf(os.Stderr, "  --no-escape-html         Write <, > and & in records as is instead of as \\u003c, \\u003e and \\u0026\n")
+		fmt.Fprintf(os.Stderr, "  --escape-html            Escape <, > and & in records (default)\n")
+		fmt.Fprintf(os.Stderr, "  --json-raw-content       Write the content of json records as a string of the JSON text\n")
+		fmt.Fprintf(os.Stderr, "  --lossy-text             Record invalid UTF-8 as text with U+FFFD instead of as base64\n")
+		fmt.Fprintf(os.Stderr, "  --record-signals         Record a signal meta record for every signal forwarded to the child\n")
+		fmt.Fprintf(os.Stderr, "  --consume-usr1           Do not forward SIGUSR1, which syncs the recording to disk, to the child\n")
+		fmt.Fprintf(os.Stderr, "  --toggle-signal=<sig>    Pause and resume the recording on USR2 or QUIT instead of forwarding it\n")
+		fmt.Fprintf(os.Stderr, "  --process-reap           Reap orphaned descendants of the child (Linux only)\n")
+		fmt.Fprintf(os.Stderr, "  --record-checksums       Link every record to the one before it with a chain hash (see ioetap verify)\n")
+		fmt.Fprintf(os.Stderr, "  --strict-order           Record in the order data was read across stdin/stdout/stderr\n")
+		fmt.Fprintf(os.Stderr, "  --stdin-echo             Record stdin again as stdout, as if the terminal echoed it\n")
+		fmt.Fprintf(os.Stderr, "  --mark-bursts            Mark records whose data was read back-to-back as \"burst\"\n")
+		fmt.Fprintf(os.Stderr, "  --deltas                 Add delta_ms, the time since the previous record of the same source\n")
+		fmt.Fprintf(os.Stderr, "  --record-read-sizes      Record a read meta record with the size of every read\n")
+		fmt.Fprintf(os.Stderr, "  --record-timing-histogram  Append a histogram of inter-record latencies\n")
+		fmt.Fprintf(os.Stderr, "  --error-format=<fmt>     Print ioetap's errors as human (default) or json lines with a stable code\n")
+		fmt.Fprintf(os.Stderr, "  --log-level=<level>      Print ioetap's messages up to error, warn (default), info or debug\n")
+		fmt.Fprintf(os.Stderr, "  --debug                  Same as --log-level=debug\n")
 		fmt.Fprintf(os.Stderr, "  --version, -v            Show version information\n")
 		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
-		return 1
+		return errorExitCodes[codeParse]
 	}
+	logging.SetLevel(opts.LogLevel)
 
-	// Start child process
-	ctx := context.Background()
-	proc, err := process.Start(ctx, opts.Command, opts.Args)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ioetap: %v\n", err)
-		return 1
+	// Look up the descriptors to pass before ioetap opens any files of its own
+	runOpts := runOptions(opts)
+	if opts.RecordPath {
+		runOpts.CommandPath = commandPath(opts.Command)
+	}
+	if opts.RecordConfig {
+		runOpts.Config = version.Details()
+		runOpts.Config["options"] = cli.EffectiveOptions(opts)
+	}
+	for _, fd := range opts.PassFDs {
+		file, err := process.InheritedFile(fd)
+		if err != nil {
+			reporter.report(codeParse, fmt.Errorf("--pass-fd: %w", err))
+			return errorExitCodes[codeParse]
+		}
+		runOpts.ExtraFiles = append(runOpts.ExtraFiles, file)
+	}
+	if opts.CommentFile != "" {
+		comment, err := readComment(opts.CommentFile)
+		if err != nil {
+			reporter.report(codeParse, fmt.Errorf("--comment-file: %w", err))
+			return errorExitCodes[codeParse]
+		}
+		runOpts.Comment = comment
 	}
 
-	// Determine output filename
-	var filename string
-	if opts.OutputFile != "" {
-		filename = opts.OutputFile
-	} else {
-		// Default: <basename>-<pid>.jsonl
-		basename := filepath.Base(opts.Command)
-		filename = fmt.Sprintf("%s-%d.jsonl", basename, proc.PID())
+	// Profile ioetap itself while the child runs. Nothing is served, or
+	// even registered, without --pprof.
+	var pprofServer *http.Server
+	if opts.PprofAddr != "" {
+		listener, err := net.Listen("tcp", opts.PprofAddr)
+		if err != nil {
+			reporter.report(codePprof, fmt.Errorf("failed to listen for pprof: %w", err))
+			return errorExitCodes[codePprof]
+		}
+		pprofServer = profiling.Serve(listener)
+		defer pprofServer.Close()
 	}
 
-	rec, err := recorder.NewRecorder(filename, opts.MaxLineLength)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ioetap: %v\n", err)
-		_ = proc.Signal(os.Kill)
-		proc.Wait()
-		return 1
-	}
-	defer rec.Close()
-
-	// Set up signal forwarding
-	sigChan := process.ForwardSignals(proc)
-	defer process.StopForwardingSignals(sigChan)
-
-	// Wait group for stdout/stderr goroutines only
-	// (stdin goroutine is not included because it blocks on os.Stdin.Read()
-	// which cannot be interrupted when the child process exits)
-	var wg sync.WaitGroup
-
-	// Forward stdin with recording (not in WaitGroup because os.Stdin.Read()
-	// blocks and cannot be interrupted when the child process exits)
-	go func() {
-		defer proc.Stdin.Close()
-		_ = rec.CopyAndRecord(recorder.Stdin, os.Stdin, proc.Stdin)
-	}()
+	// Every remote destination that does not abort the recording is retried
+	// the same way when it fails
+	retryOpts := &recorder.RetryOptions{
+		Initial:     opts.RetryInitial,
+		Cap:         opts.RetryCap,
+		MaxAttempts: opts.RetryMax,
+	}
 
-	// Forward stdout with recording
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		_ = rec.CopyAndRecord(recorder.Stdout, proc.Stdout, os.Stdout)
-	}()
+	// Connect to the remote destinations before the child starts, so that
+	// an unreachable one fails early with --remote-on-error=abort
+	for _, addr := range opts.RemoteOutputs {
+		name := "tcp://" + addr
+		conn, err := net.DialTimeout("tcp", addr, remoteDialTimeout)
+		if err != nil {
+			if opts.RemoteOnError == cli.RemoteOnErrorAbort {
+				reporter.report(codeRecorder, fmt.Errorf("failed to connect to %s: %w", name, err))
+				return errorExitCodes[codeRecorder]
+			}
+			logging.Warnf("%s: %v; reconnecting", name, err)
+		}
+		if opts.RemoteOnError == cli.RemoteOnErrorAbort {
+			defer conn.Close()
+			runOpts.Sinks = append(runOpts.Sinks, conn)
+			continue
+		}
+		// A destination that can be dropped is reconnected to if it fails,
+		// or could not be connected to in the first place
+		rw := recorder.NewRetryWriter(name, conn, func() (io.WriteCloser, error) {
+			return net.DialTimeout("tcp", addr, remoteDialTimeout)
+		}, *retryOpts)
+		defer func() {
+			if err := rw.Close(); err != nil {
+				logging.Warnf("%s: %v", name, err)
+			}
+		}()
+		runOpts.OptionalSinks = append(runOpts.OptionalSinks, ioetap.OptionalSink{Name: name, W: rw})
+	}
 
-	// Forward stderr with recording
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		_ = rec.CopyAndRecord(recorder.Stderr, proc.Stderr, os.Stderr)
+	// HTTP destinations get batches of records over kept-alive connections.
+	// Batches that fail are retried unless they abort the recording.
+	for _, u := range opts.HTTPOutputs {
+		httpOpts := recorder.HTTPSinkOptions{
+			DisableKeepAlives: !opts.HTTPKeepAlive,
+			MaxIdleConns:      opts.HTTPIdleConns,
+		}
+		if opts.RemoteOnError != cli.RemoteOnErrorAbort {
+			httpOpts.Retry = retryOpts
+		}
+		sink := recorder.NewHTTPSink(u, httpOpts)
+		defer sink.Close()
+		if opts.RemoteOnError == cli.RemoteOnErrorAbort {
+			runOpts.Sinks = append(runOpts.Sinks, sink)
+			continue
+		}
+		runOpts.OptionalSinks = append(runOpts.OptionalSinks, ioetap.OptionalSink{Name: u, W: sink})
+	}
+
+	// The recording file is named after the child's PID, so it is opened once
+	// the child has started. Without a PID, the start time is used instead.
+	var file io.WriteCloser
+	var filename, recordingFile string
+	var sinkFailed bool
+
+	// Run finalizes the recording if recording panics. A panic in ioetap's
+	// own handling of the recording, e.g. converting it to HTML, leaves the
+	// file written so far as is.
+	defer func() {
+		if p := recover(); p != nil {
+			if file != nil {
+				file.Close()
+			}
+			reporter.report(codePanic, &ioetap.PanicError{Value: p, Stack: debug.Stack()})
+			exitCode = errorExitCodes[codePanic]
+		}
 	}()
+	runOpts.OpenSink = func(pid int) (io.Writer, error) {
+		id := strconv.Itoa(pid)
+		if pid == 0 {
+			id = time.Now().UTC().Format(startFailureTimeFormat)
+		}
+		filename = recordingFilename(opts, id)
+		recordingFile = temporaryFilename(opts, filename)
+
+		f, err := createFile(recordingFile, opts.OutputFilePerm)
+		if err != nil {
+			sinkFailed = true
+			return nil, fmt.Errorf("failed to create recording file: %w", err)
+		}
+		file = f
+		if opts.OutputFormat == cli.FormatJSONArray {
+			file = &jsonArrayFile{JSONArrayWriter: output.NewJSONArrayWriter(f), file: f}
+		}
+		return file, nil
+	}
+
+	// Keep draining the child's output if whoever reads ioetap's output
+	// exits early, instead of dying with the child blocked on a full pipe
+	process.IgnoreBrokenPipe()
 
-	// Wait for stdout/stderr goroutines to finish first.
-	// They will finish when they read EOF from the pipes, which happens
-	// when the child process exits and closes its end of the pipes.
-	wg.Wait()
+	// With --watch, the command is re-run when the watched files change
+	// until ioetap is interrupted or terminated
+	ctx := context.Background()
+	if len(opts.Watch) > 0 {
+		watcher, err := watch.New(opts.Watch, watchInterval)
+		if err != nil {
+			reporter.report(codeParse, fmt.Errorf("--watch: %w", err))
+			return errorExitCodes[codeParse]
+		}
+		defer watcher.Close()
+		runOpts.Restart = watcher.Changes()
+
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		defer stop()
+	}
+
+	// Forward stdin with recording. Reading is interrupted once the child
+	// exits so that ioetap neither hangs nor consumes input meant for
+	// whoever reads stdin next. Without a readable stdin, the child's stdin
+	// is closed right away. Stdin is never forwarded with --watch.
+	switch err := process.CheckStdin(os.Stdin); {
+	case runOpts.Restart != nil, errors.Is(err, process.ErrStdinNull):
+		// Nothing to forward or record
+	case err != nil:
+		runOpts.StdinUnavailable = err
+	default:
+		stdin := process.NewStdinReader(os.Stdin)
+		defer stdin.Close()
+		runOpts.Stdin = stdin
 
-	// Now get the exit code from the child process
-	exitCode := proc.Wait()
+		if opts.StdinBroadcast != "" {
+			broadcast, err := process.OpenBroadcast(opts.StdinBroadcast)
+			if err != nil {
+				reporter.report(codeRecorder, fmt.Errorf("--stdin-broadcast: %w", err))
+				return errorExitCodes[codeRecorder]
+			}
+			defer broadcast.Close()
+			runOpts.StdinBroadcast = broadcast
+		}
+	}
 
-	// Close stdin pipe (child has exited, so this just cleans up)
-	proc.Stdin.Close()
+	// Records are also exported to the OTLP endpoint, in the trace of the
+	// caller if it passed one down in $TRACEPARENT
+	var otlpSink recorder.Sink
+	if opts.OTLPEndpoint != "" {
+		otlpSink = recorder.NewOTLPSink(opts.OTLPEndpoint, recorder.OTLPSinkOptions{
+			Traceparent: os.Getenv("TRACEPARENT"),
+			Retry:       retryOpts,
+		})
+		runOpts.Sinks = append(runOpts.Sinks, otlpSink)
+	}
+
+	// Records are also uploaded to S3. Like a remote --out, a failed upload
+	// does not fail the recording, which the file still has.
+	var s3Sink recorder.Sink
+	if opts.S3Bucket != "" {
+		store, err := newS3Client(opts)
+		if err != nil {
+			reporter.report(codeRecorder, fmt.Errorf("--s3-bucket: %w", err))
+			return errorExitCodes[codeRecorder]
+		}
+		s3Sink = recorder.NewS3Sink(store, opts.S3Key, recorder.S3SinkOptions{Retry: retryOpts})
+		runOpts.OptionalSinks = append(runOpts.OptionalSinks, ioetap.OptionalSink{
+			Name: "s3://" + opts.S3Bucket + "/" + opts.S3Key,
+			W:    s3Sink,
+		})
+	}
+
+	// Records are also published to Kafka, one message per record. Like a
+	// remote --out, a failed delivery does not fail the recording: the
+	// records are dropped, and the topic gets a records-dropped meta record.
+	var kafkaSink recorder.Sink
+	if opts.KafkaTopic != "" {
+		client, err := recorder.NewKafkaClient(recorder.KafkaClientOptions{
+			Brokers: opts.KafkaBrokers,
+			Topic:   opts.KafkaTopic,
+		})
+		if err != nil {
+			reporter.report(codeParse, fmt.Errorf("--kafka-broker: %w", err))
+			return errorExitCodes[codeParse]
+		}
+		kafkaSink = recorder.NewKafkaSink(client, recorder.KafkaSinkOptions{Retry: retryOpts})
+		runOpts.OptionalSinks = append(runOpts.OptionalSinks, ioetap.OptionalSink{
+			Name: "kafka://" + opts.KafkaBrokers[0] + "/" + opts.KafkaTopic,
+			W:    kafkaSink,
+		})
+	}
+
+	// Show the progress on stderr, clearing it before the child's output is
+	// forwarded to the same terminal. It is never recorded.
+	var prog *progress.Reporter
+	if opts.Progress && process.IsTerminal(os.Stderr) {
+		prog = progress.New(os.Stderr)
+		runOpts.Stderr = prog.Writer(os.Stderr)
+		if process.IsTerminal(os.Stdout) {
+			runOpts.Stdout = prog.Writer(os.Stdout)
+		}
+		runOpts.Hooks.OnRecording = func(stats func() ioetap.Stats) {
+			prog.Start(stats, time.Now(), progressInterval)
+		}
+	}
+
+	title := commandTitle(opts)
+	runOpts.Hooks.OnTerminate = func(os.Signal) {
+		if prog != nil {
+			prog.Stop()
+		}
+		if err := finalizeRecording(file, recordingFile, filename, opts, title, true); err != nil {
+			reporter.report(codeRecorder, err)
+		}
+		closeSink(otlpSink, reporter)
+		closeSink(s3Sink, reporter)
+		closeSink(kafkaSink, reporter)
+	}
+
+	started := time.Now()
+	status, stats, err := ioetap.Run(ctx, runOpts)
+	logSummary(stats, time.Since(started))
+	if pprofServer != nil {
+		pprofServer.Close()
+	}
+	if prog != nil {
+		prog.Stop()
+	}
+	exitCode = status.ShellCode()
+	if err != nil {
+		code := runErrorCode(err, status)
+		reporter.report(code, err)
+		// A recording error after the child started does not hide its exit code
+		if code != codeRecorder || sinkFailed {
+			exitCode = errorExitCodes[code]
+		}
+	}
+
+	if stats.SeqLimitReached {
+		logging.Warnf("recording stopped at seq %d (--max-seq); later I/O was not recorded", opts.MaxSeq)
+	}
+
+	if file != nil {
+		keep := !opts.KeepOnError || exitCode != 0
+		if err := finalizeRecording(file, recordingFile, filename, opts, title, keep); err != nil {
+			reporter.report(codeRecorder, err)
+		}
+	}
+	closeSink(otlpSink, reporter)
+	closeSink(s3Sink, reporter)
+	closeSink(kafkaSink, reporter)
 
 	// Sync stdout/stderr to ensure all data is flushed before exit
 	os.Stdout.Sync()
 	os.Stderr.Sync()
 
+	// Only the code returned to the shell is translated; --keep-on-error
+	// above still sees the child's exit code
+	if mapped, ok := opts.ExitCodeMap[exitCode]; ok {
+		return mapped
+	}
 	return exitCode
 }
+
+// logSummary logs how much was recorded, at the info level.
+func logSummary(stats ioetap.Stats, elapsed time.Duration) {
+	if !logging.Enabled(logging.LevelInfo) {
+		return
+	}
+	var bytes uint64
+	for _, n := range stats.Bytes {
+		bytes += n
+	}
+	logging.Infof("recorded %d records, %d bytes in %v (%.0f bytes/s)",
+		stats.Records, bytes, elapsed.Round(time.Millisecond), float64(bytes)/elapsed.Seconds())
+	if http := stats.HTTP; http != (recorder.HTTPSinkStats{}) {
+		logging.Infof("sent %d requests, %d bytes to the http(s) destinations; %d requests failed",
+			http.RequestsSent, http.BytesSent, http.Errors)
+	}
+}
+
+// readComment returns the content of the --comment-file, without the
+// newline that ends the last line of a text file.
+func readComment(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	comment := strings.TrimSuffix(string(data), "\n")
+	if comment == "" {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+	return comment, nil
+}
+
+// closeSink closes sink, if any, which exports the records left in an OTLP
+// or Kafka sink and completes the upload of an S3 sink.
+func closeSink(sink recorder.Sink, reporter errorReporter) {
+	if sink == nil {
+		return
+	}
+	if err := sink.Close(); err != nil {
+		reporter.report(codeRecorder, err)
+	}
+}
+
+// newS3Client returns the client for --s3-bucket, with the credentials in
+// the environment variables the AWS CLI reads.
+func newS3Client(opts *cli.Options) (*recorder.S3Client, error) {
+	region := opts.S3Region
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(name)
+		}
+	}
+	accessKeyID, secretAccessKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("$AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return recorder.NewS3Client(recorder.S3ClientOptions{
+		Bucket:          opts.S3Bucket,
+		Region:          region,
+		Endpoint:        opts.S3Endpoint,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+// startFailureTimeFormat is used in the default file name of a recording of a
+// child that failed to start, since there is no PID to name it after.
+const startFailureTimeFormat = "20060102T150405Z"
+
+// runOptions returns the ioetap.RunOptions for the command-line options,
+// except for those that need opened files.
+func runOptions(opts *cli.Options) ioetap.RunOptions {
+	runOpts := ioetap.RunOptions{
+		Command:         opts.Command,
+		Args:            opts.Args,
+		MaxLineLength:   opts.MaxLineLength,
+		BinaryLimit:     opts.BinaryLimit,
+		SplitLongLines:  opts.LongLineMode == cli.LongLineSplit,
+		TruncateTail:    opts.Truncation == cli.TruncateTail,
+		MaxSeq:          opts.MaxSeq,
+		MarkBursts:      opts.MarkBursts,
+		Deltas:          opts.Deltas,
+		RecordReadSizes: opts.RecordReadSizes,
+		StrictOrder:     opts.StrictOrder,
+		StdinEcho:       opts.StdinEcho,
+		StdinChunks:     !opts.SplitStdin,
+		NoBuffering:     opts.NoBuffering,
+		FlushOnLine:     opts.FlushOnLine,
+		NoEscapeHTML:    !opts.EscapeHTML,
+		JSONRawContent:  opts.JSONRawContent,
+		LossyText:       opts.LossyText,
+		TimingHistogram: opts.TimingStats,
+		Fields:          opts.Fields,
+		SourceWeights:   opts.SourceWeights,
+		QueueSize:       opts.QueueSize,
+		Label:           opts.CommandLabel,
+		Comment:         opts.Comment,
+		RecordCWD:       opts.RecordCWD,
+		RecordIdentity:  opts.RecordIdentity,
+		RecordSignals:   opts.RecordSignals,
+		ConsumeUSR1:     opts.ConsumeUSR1,
+		ToggleSignal:    toggleSignal(opts),
+		ReapProcesses:   opts.ProcessReap,
+		RecordChecksums: opts.RecordChecksums,
+		Stdout:          os.Stdout,
+		Stderr:          os.Stderr,
+		StdinTimeout:    opts.StdinTimeout,
+		StdinRateLimit:  opts.StdinRateLimit,
+		AnnotatePrefix:  opts.AnnotatePrefix,
+		MetricsAddr:     opts.MetricsAddr,
+		ControlSocket:   opts.ControlSocket,
+		CPUTimeInterval: opts.CPUTimeRecord,
+		ForwardSignals:  true,
+		GracePeriod:     opts.GracePeriod,
+		Retries:         opts.RetryCommand,
+		RetryDelay:      opts.RetryDelay,
+	}
+	if opts.ControlPerm != nil {
+		runOpts.ControlSocketPerm = *opts.ControlPerm
+	}
+	if opts.OutSlog {
+		runOpts.SlogHandler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	if opts.OutputFormat == cli.FormatSortedNDJSON {
+		runOpts.SortedFields = true
+	}
+	if opts.OutputFormat == cli.FormatNDJSONSchema {
+		runOpts.Header = output.GenerateRecordSchema()
+	}
+	if opts.Header {
+		header, err := recorder.NewHeader(opts.Command, opts.Args, opts.Fields).ToJSON()
+		if err != nil {
+			// The header only contains strings and numbers
+			panic(err)
+		}
+		runOpts.Header = header
+	}
+	for _, rlimit := range opts.Rlimits {
+		runOpts.Rlimits = append(runOpts.Rlimits, ioetap.Rlimit{
+			Name:     rlimit.Name,
+			Resource: rlimit.Resource,
+			Cur:      rlimit.Cur,
+			Max:      rlimit.Max,
+		})
+	}
+	return runOpts
+}
+
+// toggleSignal returns the --toggle-signal, or nil without it.
+func toggleSignal(opts *cli.Options) os.Signal {
+	if opts.ToggleSignal == 0 {
+		return nil
+	}
+	return opts.ToggleSignal
+}
+
+// recordingFilename returns the path of the recording file: --out if given,
+// or <basename>-<id>.<ext> in the current directory.
+func recordingFilename(opts *cli.Options, id string) string {
+	if opts.OutputFile != "" {
+		return opts.OutputFile
+	}
+	ext := opts.OutputFormat
+	switch ext {
+	case cli.FormatNDJSONSchema, cli.FormatSortedNDJSON:
+		ext = cli.FormatJSONL
+	case cli.FormatJSONArray:
+		ext = "json"
+	}
+	return fmt.Sprintf("%s-%s.%s", filenameBase(opts.Command), id, ext)
+}
+
+// progressInterval is how often --progress redraws the progress line.
+const progressInterval = time.Second
+
+// remoteDialTimeout is how long ioetap tries to connect to a remote --out
+// destination.
+const remoteDialTimeout = 5 * time.Second
+
+// watchInterval is how often --watch checks the watched files for changes.
+const watchInterval = 250 * time.Millisecond
+
+// maxFilenameBase is the maximum length in bytes of the command's part of
+// a default recording file name.
+const maxFilenameBase = 64
+
+// windowsReservedNames are the device names Windows reserves, with or
+// without an extension.
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// filenameBase returns the base name of command made safe for use in a file
+// name: path separators, control characters and characters reserved on
+// Windows are replaced with '_', and so are a leading dash, which would
+// look like an option, and a Windows device name. The result is at most
+// maxFilenameBase bytes long, and "ioetap" if nothing usable is left.
+func filenameBase(command string) string {
+	base := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == utf8.RuneError || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, filepath.Base(command))
+
+	if strings.Trim(base, "._") == "" {
+		return "ioetap"
+	}
+	if strings.HasPrefix(base, "-") {
+		base = "_" + base[1:]
+	}
+	stem, _, _ := strings.Cut(base, ".")
+	for _, name := range windowsReservedNames {
+		if strings.EqualFold(stem, name) {
+			base = "_" + base
+			break
+		}
+	}
+
+	// Cut at a rune boundary
+	for len(base) > maxFilenameBase {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base
+}
+
+// temporaryFilename returns the file to record to. With --keep-on-error or
+// --output-format=html, it is a temporary file next to filename that is
+// finalized after the child exits.
+func temporaryFilename(opts *cli.Options, filename string) string {
+	if opts.KeepOnError || opts.OutputFormat == cli.FormatHTML {
+		return fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	}
+	return filename
+}
+
+// commandPath returns the absolute path of the executable that runs for
+// command, looked up in $PATH like the child is, or "" if there is none.
+func commandPath(command string) string {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path
+}
+
+// commandTitle returns the command line of the child, used as the title of
+// HTML recordings.
+func commandTitle(opts *cli.Options) string {
+	return strings.Join(append([]string{opts.Command}, opts.Args...), " ")
+}
+
+// jsonArrayFile is the recording file with --output-format=json-array, whose
+// records are written as a JSON array.
+type jsonArrayFile struct {
+	*output.JSONArrayWriter
+	file *os.File
+}
+
+// Close ends the array and closes the file.
+func (f *jsonArrayFile) Close() error {
+	if err := f.JSONArrayWriter.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// finalizeRecording closes file, the recording written to tmpFile, then
+// either moves tmpFile to filename in the --output-format (keep) or removes
+// it. If tmpFile is filename, it only closes file.
+func finalizeRecording(file io.Closer, tmpFile, filename string, opts *cli.Options, title string, keep bool) error {
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	if tmpFile == filename {
+		return nil
+	}
+
+	if !keep {
+		if err := os.Remove(tmpFile); err != nil {
+			return fmt.Errorf("failed to remove recording: %w", err)
+		}
+		return nil
+	}
+
+	if opts.OutputFormat == cli.FormatHTML {
+		return convertToHTML(tmpFile, filename, title, opts.OutputFilePerm)
+	}
+
+	if err := os.Rename(tmpFile, filename); err != nil {
+		return fmt.Errorf("failed to finalize recording: %w", err)
+	}
+	return nil
+}
+
+// convertToHTML renders the NDJSON recording at tmpFile as an HTML session
+// viewer at filename, created with perm (see createFile), and removes
+// tmpFile.
+func convertToHTML(tmpFile, filename, title string, perm *fs.FileMode) error {
+	records, err := analysis.ReadRecords(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	file, err := createFile(filename, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create HTML file: %w", err)
+	}
+	if err := output.WriteHTML(file, title, records); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write HTML file: %w", err)
+	}
+
+	if err := os.Remove(tmpFile); err != nil {
+		return fmt.Errorf("failed to remove recording: %w", err)
+	}
+	return nil
+}
+
+// createFile creates or truncates the file at name like os.Create. If perm is
+// not nil, the file gets exactly the permissions perm regardless of the umask.
+// It is created with perm, which the umask can only narrow, so the file is
+// never more accessible than requested, even before the permissions are set.
+func createFile(name string, perm *fs.FileMode) (*os.File, error) {
+	if perm == nil {
+		return os.Create(name)
+	}
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, *perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(*perm); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/trustin/ioetap/internal/cli"
+)
+
+func TestFilenameBase(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{"plain", "ls", "ls"},
+		{"path", "/usr/bin/python3", "python3"},
+		{"relative path with space", "./tools/run me.sh", "run me.sh"},
+		{"trailing slash", "bin/", "bin"},
+		{"backslash", `tools\run.bat`, "tools_run.bat"},
+		{"windows reserved characters", `a:b*c?d"e<f>g|h`, "a_b_c_d_e_f_g_h"},
+		{"control characters", "a\tb\nc\x7fd", "a_b_c_d"},
+		{"invalid utf-8", "a\xffb", "a_b"},
+		{"unicode", "día", "día"},
+		{"leading dash", "-rf", "_rf"},
+		{"leading dashes", "--help", "_-help"},
+		{"empty", "", "ioetap"},
+		{"dot", ".", "ioetap"},
+		{"dot dot", "..", "ioetap"},
+		{"root", "/", "ioetap"},
+		{"only reserved characters", "::", "ioetap"},
+		{"windows reserved name", "nul", "_nul"},
+		{"windows reserved name with extension", "CON.exe", "_CON.exe"},
+		{"windows reserved name in path", "/dev/aux", "_aux"},
+		{"windows reserved name prefix", "console", "console"},
+		{"windows reserved port", "com1", "_com1"},
+		{"long", strings.Repeat("x", 100), strings.Repeat("x", maxFilenameBase)},
+		{"long multibyte", strings.Repeat("é", 40), strings.Repeat("é", maxFilenameBase/2)},
+		{"long multibyte odd", "x" + strings.Repeat("é", 40), "x" + strings.Repeat("é", (maxFilenameBase-1)/2)},
+		{"long reserved name", "nul." + strings.Repeat("x", 100), "_nul." + strings.Repeat("x", maxFilenameBase-5)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filenameBase(tt.command)
+			if got != tt.want {
+				t.Errorf("filenameBase(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+			if len(got) > maxFilenameBase || !utf8.ValidString(got) {
+				t.Errorf("filenameBase(%q) = %q, want at most %d bytes of valid UTF-8", tt.command, got, maxFilenameBase)
+			}
+		})
+	}
+}
+
+func TestRecordingFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		format  string
+		want    string
+	}{
+		{"relative path", "./myapp", cli.FormatJSONL, "myapp-42.jsonl"},
+		{"nested path", "tools/bin/run", cli.FormatJSONL, "run-42.jsonl"},
+		{"trailing slash", "./build/", cli.FormatJSONL, "build-42.jsonl"},
+		{"trailing slashes", "dir//", cli.FormatJSONL, "dir-42.jsonl"},
+		{"empty", "", cli.FormatJSONL, "ioetap-42.jsonl"},
+		{"only slashes", "///", cli.FormatJSONL, "ioetap-42.jsonl"},
+		{"dot", "./.", cli.FormatJSONL, "ioetap-42.jsonl"},
+		{"separators and colons", `C:\tools\run`, cli.FormatJSONL, "C__tools_run-42.jsonl"},
+		{"newline", "run\nme", cli.FormatJSONL, "run_me-42.jsonl"},
+		{"html", "./myapp", cli.FormatHTML, "myapp-42.html"},
+		{"ndjson-schema", "./myapp", cli.FormatNDJSONSchema, "myapp-42.jsonl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &cli.Options{Command: tt.command, OutputFormat: tt.format}
+			got := recordingFilename(opts, "42")
+			if got != tt.want {
+				t.Errorf("recordingFilename(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+			// The file lands in the current directory
+			if filepath.Base(got) != got {
+				t.Errorf("recordingFilename(%q) = %q, want a file in the current directory", tt.command, got)
+			}
+		})
+	}
+
+	// --out is used as is
+	opts := &cli.Options{Command: "./myapp", OutputFile: "out/rec.jsonl", OutputFormat: cli.FormatJSONL}
+	if got := recordingFilename(opts, "42"); got != "out/rec.jsonl" {
+		t.Errorf("recordingFilename() with --out = %q, want %q", got, "out/rec.jsonl")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runMerge implements the merge subcommand, which interleaves the records of
+// several recordings by timestamp into a single recording.
+func runMerge(args []string) int {
+	opts, err := cli.ParseMerge(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap merge [options] <recording.jsonl>...\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --out=<file>             Output file (default: stdout)\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	output := os.Stdout
+	if opts.Output != "" {
+		output, err = os.Create(opts.Output)
+		if err != nil {
+			logging.Errorf("failed to create output file: %v", err)
+			return 1
+		}
+		defer output.Close()
+	}
+
+	if err := analysis.MergeRecordings(opts.Inputs, output); err != nil {
+		logging.Errorf("%v", err)
+		return 1
+	}
+	return 0
+}
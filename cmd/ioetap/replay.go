@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/process"
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// runReplay implements the replay subcommand, which runs a command with the
+// stdin of a recording.
+func runReplay(args []string) int {
+	opts, err := cli.ParseReplay(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap replay <recording.jsonl> [--] <command> [args...]\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	stdin, err := analysis.ReconstructStream(opts.Input, "stdin")
+	switch {
+	case errors.Is(err, recorder.ErrTruncated), errors.Is(err, recorder.ErrLossy):
+		logging.Warnf("%v; the replayed stdin is incomplete", err)
+	case err != nil:
+		logging.Errorf("%v", err)
+		return 1
+	}
+
+	cmd := exec.Command(opts.Command[0], opts.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+
+	// Exit like a shell would, as ioetap does
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		status := process.ExitStatus{Code: exitErr.ExitCode()}
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			status.Signal = ws.Signal()
+		}
+		return status.ShellCode()
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		logging.Errorf("%v", err)
+		return 127
+	default:
+		logging.Errorf("%v", err)
+		return 126
+	}
+}
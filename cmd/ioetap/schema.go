@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/output"
+)
+
+// runSchema implements the schema subcommand, which prints the JSON Schema
+// of a record.
+func runSchema(args []string) int {
+	opts, err := cli.ParseSchema(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap schema [options]\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --version=<n>            Record format version to describe (default: the current one)\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	data, err := output.FormatRecordSchema(opts.Version)
+	if err != nil {
+		logging.Errorf("%v", err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runShow implements the show subcommand, which prints the recorded data of
+// a recording as it appeared on the original streams.
+func runShow(args []string) int {
+	opts, err := cli.ParseShow(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap show [options] <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --head=<n>               Show only the first n records\n")
+		fmt.Fprintf(os.Stderr, "  --tail=<n>               Show only the last n records\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	showOpts := analysis.ShowOptions{Head: opts.Head, Tail: opts.Tail}
+	if err := analysis.Show(opts.Input, showOpts, os.Stdout); err != nil {
+		logging.Errorf("%v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trustin/ioetap/internal/analysis"
+	"github.com/trustin/ioetap/internal/cli"
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// runVerify implements the verify subcommand, which checks that a recording
+// is intact.
+func runVerify(args []string) int {
+	opts, err := cli.ParseVerify(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: ioetap verify [options] <recording.jsonl>\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "  --chain                  Verify the chain hashes of a recording made with --record-checksums\n")
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+
+	result, err := analysis.Verify(opts.Input, analysis.VerifyOptions{Chain: opts.Chain})
+	if err != nil {
+		logging.Errorf("%v", err)
+		return 1
+	}
+	for _, b := range result.Breaks {
+		logging.Errorf("chain broken at seq %d: %v", b.Seq, b.Err)
+	}
+	if len(result.Breaks) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: %d records verified\n", opts.Input, result.Records)
+	return 0
+}
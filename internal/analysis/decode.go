@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// DecodeResult summarizes a Decode run.
+type DecodeResult struct {
+	Records   int // Records decoded
+	Truncated int // Records that were truncated, so their data is incomplete
+	JSON      int // json records, whose data was re-serialized
+}
+
+// Decode writes the raw bytes recorded from source in the recording file at
+// input to output, i.e. the decoded content and the line ending of each of
+// its records in order. It reproduces the stream byte for byte, except for
+// truncated records, of which only the bytes kept are written, and json
+// records, which are written as compact JSON since their original spacing
+// is not recorded. DecodeResult counts both so the caller can warn.
+func Decode(input, source string, output io.Writer) (DecodeResult, error) {
+	writer := bufio.NewWriter(output)
+
+	var result DecodeResult
+	err := forEachRecord(input, func(record recorder.Record) error {
+		if record.Source != source {
+			return nil
+		}
+		data, err := rawRecordData(record)
+		if err != nil {
+			return fmt.Errorf("seq %d: %w", record.Seq, err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		result.Records++
+		if record.Truncated {
+			result.Truncated++
+		}
+		if record.Encoding == "json" {
+			result.JSON++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return result, fmt.Errorf("failed to write output: %w", err)
+	}
+	return result, nil
+}
+
+// rawRecordData returns the bytes of a record as they appeared on its
+// stream, as far as they are known. Unlike Record.Bytes, json content is not
+// HTML-escaped, since the child did not write it so.
+func rawRecordData(record recorder.Record) ([]byte, error) {
+	if record.Encoding != "json" {
+		data, err := record.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return append(data, record.End...), nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(record.Content); err != nil {
+		return nil, fmt.Errorf("failed to serialize json content: %w", err)
+	}
+	// Encode terminates the value with a newline
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return append(data, record.End...), nil
+}
@@ -0,0 +1,84 @@
+package analysis
+
+import (
+	"bytes"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+func TestDecode_Binary(t *testing.T) {
+	data := make([]byte, 64*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate data: %v", err)
+	}
+	// Start every record with a byte that is not valid UTF-8, so that no
+	// random line happens to be text or JSON, e.g. a single digit. A line
+	// is split into records where the chunks below end.
+	for i := 0; i < len(data); i++ {
+		if i%1000 == 0 || data[i-1] == '\n' {
+			data[i] = 0xff
+		}
+	}
+
+	// Record the data in chunks, with text on another source in between
+	input := filepath.Join(t.TempDir(), "binary.jsonl")
+	rec, err := recorder.NewFileRecorder(input, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	for i := 0; i < len(data); i += 1000 {
+		if err := rec.Record(recorder.Stdout, data[i:min(i+1000, len(data))]); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+		if err := rec.Record(recorder.Stderr, []byte("progress\n")); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var output bytes.Buffer
+	result, err := Decode(input, "stdout", &output)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !bytes.Equal(output.Bytes(), data) {
+		t.Errorf("decoded %d bytes differ from the %d recorded bytes", output.Len(), len(data))
+	}
+	if result.Truncated != 0 || result.JSON != 0 {
+		t.Errorf("expected no truncated or json records, got %+v", result)
+	}
+}
+
+func TestDecode_Encodings(t *testing.T) {
+	now := time.Now()
+	input := writeRecording(t, []recorder.Record{
+		recorder.NewRecord(0, now, "stdout", []byte("text\r\n")),
+		recorder.NewMetaRecord(1, now, map[string]any{"event": "start"}),
+		recorder.NewRecord(2, now, "stdout", []byte(`{"html":"<b>"}`+"\n")),
+		recorder.NewRecord(3, now, "stderr", []byte("other\n")),
+		recorder.NewRecord(4, now, "stdout", []byte{0xff, 0x00, '\n'}),
+		{Seq: 5, Timestamp: "2024-01-15T10:30:45.123Z", Source: "stdout", Content: "cut", Encoding: "text", Truncated: true},
+	})
+
+	var output bytes.Buffer
+	result, err := Decode(input, "stdout", &output)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	want := "text\r\n" + `{"html":"<b>"}` + "\n\xff\x00\ncut"
+	if output.String() != want {
+		t.Errorf("expected %q, got %q", want, output.String())
+	}
+	if result.Records != 4 || result.Truncated != 1 || result.JSON != 1 {
+		t.Errorf("expected 4 records with 1 truncated and 1 json, got %+v", result)
+	}
+}
@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// StreamDiff is the first difference between a stream of two recordings.
+type StreamDiff struct {
+	Source string // stdin, stdout or stderr
+	Offset int    // Byte offset of the first difference
+	Line   int    // 1-based line of the first difference
+	A, B   []byte // Line of each stream at the difference, without its newline; nil past the end
+}
+
+// DiffResult summarizes a Diff run.
+type DiffResult struct {
+	Diffs      []StreamDiff // Streams that differ, in the order of the sources
+	Incomplete []error      // Streams that could not be fully reconstructed
+}
+
+// Diff compares the streams of sources in the recording files a and b,
+// rebuilt byte for byte as ReconstructStream does, and returns the first
+// difference of each stream that differs. Timestamps, the split into
+// records and meta records are not compared, so two runs that read and
+// wrote the same bytes are equal. A stream with truncated or lossy records
+// is compared as recorded and listed in Incomplete, since the comparison
+// cannot be trusted.
+func Diff(a, b string, sources []string) (DiffResult, error) {
+	recordsA, err := ReadRecords(a)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	recordsB, err := ReadRecords(b)
+	if err != nil {
+		return DiffResult{}, err
+	}
+
+	var result DiffResult
+	for _, source := range sources {
+		streamA, err := result.reconstruct(recordsA, a, source)
+		if err != nil {
+			return result, err
+		}
+		streamB, err := result.reconstruct(recordsB, b, source)
+		if err != nil {
+			return result, err
+		}
+		if diff, ok := firstDifference(streamA, streamB); ok {
+			diff.Source = source
+			result.Diffs = append(result.Diffs, diff)
+		}
+	}
+	return result, nil
+}
+
+// reconstruct returns the stream of source rebuilt from the records of the
+// recording file input, adding it to Incomplete if some bytes are unknown.
+func (r *DiffResult) reconstruct(records []recorder.Record, input, source string) ([]byte, error) {
+	stream, err := reconstruct(records, source)
+	if errors.Is(err, recorder.ErrTruncated) || errors.Is(err, recorder.ErrLossy) {
+		r.Incomplete = append(r.Incomplete, fmt.Errorf("%s: %s: %w", input, source, err))
+		return stream, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", input, source, err)
+	}
+	return stream, nil
+}
+
+// firstDifference returns where a and b first differ, and false if they are
+// equal.
+func firstDifference(a, b []byte) (StreamDiff, bool) {
+	offset := 0
+	for offset < len(a) && offset < len(b) && a[offset] == b[offset] {
+		offset++
+	}
+	if offset == len(a) && offset == len(b) {
+		return StreamDiff{}, false
+	}
+
+	// Both streams are equal up to offset, so the line starts at the same
+	// position in both
+	start := bytes.LastIndexByte(a[:offset], '\n') + 1
+	return StreamDiff{
+		Offset: offset,
+		Line:   bytes.Count(a[:start], []byte("\n")) + 1,
+		A:      lineAt(a, start),
+		B:      lineAt(b, start),
+	}, true
+}
+
+// lineAt returns the line of stream that starts at start, without its
+// newline, or nil if stream ends before.
+func lineAt(stream []byte, start int) []byte {
+	if start >= len(stream) {
+		return nil
+	}
+	line := stream[start:]
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	return line
+}
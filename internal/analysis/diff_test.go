@@ -0,0 +1,78 @@
+package analysis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+func TestDiff(t *testing.T) {
+	a := recordChunks(t, []recordChunk{
+		{recorder.Stdin, "input\n"},
+		{recorder.Stdout, "one\ntwo\nthree\n"},
+		{recorder.Stderr, "warning\n"},
+	})
+	// The same streams split into other records and interleaved otherwise,
+	// except for the second line of stdout
+	b := recordChunks(t, []recordChunk{
+		{recorder.Stdout, "one\n"},
+		{recorder.Stderr, "warn"},
+		{recorder.Stdin, "input\n"},
+		{recorder.Stdout, "twO\nthree\n"},
+		{recorder.Stderr, "ing\n"},
+	})
+
+	result, err := Diff(a, b, []string{"stdin", "stdout", "stderr"})
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(result.Diffs) != 1 || len(result.Incomplete) != 0 {
+		t.Fatalf("expected a single difference, got %+v", result)
+	}
+	diff := result.Diffs[0]
+	if diff.Source != "stdout" || diff.Offset != 6 || diff.Line != 2 || string(diff.A) != "two" || string(diff.B) != "twO" {
+		t.Errorf("expected stdout to differ at offset 6 of line 2, got %+v (%q, %q)", diff, diff.A, diff.B)
+	}
+
+	result, err = Diff(a, a, []string{"stdout"})
+	if err != nil || len(result.Diffs) != 0 {
+		t.Errorf("expected a recording to equal itself, got %+v, %v", result, err)
+	}
+}
+
+func TestDiff_EndOfStream(t *testing.T) {
+	a := recordChunks(t, []recordChunk{{recorder.Stdout, "one\n"}})
+	b := recordChunks(t, []recordChunk{{recorder.Stdout, "one\ntwo\n"}})
+
+	result, err := Diff(a, b, []string{"stdout"})
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(result.Diffs) != 1 {
+		t.Fatalf("expected a single difference, got %+v", result)
+	}
+	diff := result.Diffs[0]
+	if diff.Offset != 4 || diff.Line != 2 || diff.A != nil || string(diff.B) != "two" {
+		t.Errorf("expected a to end at line 2, got %+v (%q, %q)", diff, diff.A, diff.B)
+	}
+}
+
+func TestDiff_Incomplete(t *testing.T) {
+	a := recordChunks(t, []recordChunk{{recorder.Stdout, "abc\n"}})
+	b := writeRecording(t, []recorder.Record{
+		{Seq: 0, Timestamp: "2024-01-15T10:30:00.000Z", Source: "stdout", Content: "abc", Encoding: "text", End: "\n", Truncated: true},
+	})
+
+	result, err := Diff(a, b, []string{"stdout"})
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(result.Diffs) != 0 || len(result.Incomplete) != 1 || !errors.Is(result.Incomplete[0], recorder.ErrTruncated) {
+		t.Errorf("expected stdout to be equal but incomplete, got %+v", result)
+	}
+
+	if _, err := Diff(a, "missing.jsonl", []string{"stdout"}); err == nil {
+		t.Error("expected an error for a missing recording")
+	}
+}
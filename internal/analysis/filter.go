@@ -0,0 +1,145 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// RecordFilter decides whether a record is kept.
+type RecordFilter interface {
+	Match(record recorder.Record) bool
+}
+
+// SourceFilter matches records whose source is one of the given sources.
+type SourceFilter struct {
+	Sources []string
+}
+
+// Match implements RecordFilter.
+func (f SourceFilter) Match(record recorder.Record) bool {
+	return slices.Contains(f.Sources, record.Source)
+}
+
+// EncodingFilter matches records whose encoding is one of the given encodings.
+type EncodingFilter struct {
+	Encodings []string
+}
+
+// Match implements RecordFilter.
+func (f EncodingFilter) Match(record recorder.Record) bool {
+	return slices.Contains(f.Encodings, record.Encoding)
+}
+
+// SeqRangeFilter matches records whose sequence number is within [Min, Max].
+// A nil bound is unbounded.
+type SeqRangeFilter struct {
+	Min *uint64
+	Max *uint64
+}
+
+// Match implements RecordFilter.
+func (f SeqRangeFilter) Match(record recorder.Record) bool {
+	if f.Min != nil && record.Seq < *f.Min {
+		return false
+	}
+	if f.Max != nil && record.Seq > *f.Max {
+		return false
+	}
+	return true
+}
+
+// TimeRangeFilter matches records whose timestamp is within [From, To].
+// A zero bound is unbounded. Records with an unparsable timestamp never match.
+type TimeRangeFilter struct {
+	From time.Time
+	To   time.Time
+}
+
+// Match implements RecordFilter.
+func (f TimeRangeFilter) Match(record recorder.Record) bool {
+	t, err := record.Time()
+	if err != nil {
+		return false
+	}
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && t.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// PatternFilter matches records whose content matches the regular expression.
+// For json encoding, the pattern is matched against the JSON representation.
+type PatternFilter struct {
+	Pattern *regexp.Regexp
+}
+
+// Match implements RecordFilter.
+func (f PatternFilter) Match(record recorder.Record) bool {
+	return f.Pattern.MatchString(record.ContentString())
+}
+
+// AllOf matches records matched by all of its filters (AND).
+// An empty AllOf matches every record.
+type AllOf []RecordFilter
+
+// Match implements RecordFilter.
+func (f AllOf) Match(record recorder.Record) bool {
+	for _, filter := range f {
+		if !filter.Match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// AnyOf matches records matched by at least one of its filters (OR).
+// An empty AnyOf matches every record.
+type AnyOf []RecordFilter
+
+// Match implements RecordFilter.
+func (f AnyOf) Match(record recorder.Record) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, filter := range f {
+		if filter.Match(record) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterRecordings reads the recording file at input and writes the records
+// matched by filter to output as NDJSON.
+// Sequence numbers are renumbered from 0 in the output.
+func FilterRecordings(input string, filter RecordFilter, output io.Writer) error {
+	writer := bufio.NewWriter(output)
+	var seq uint64
+
+	err := forEachRecord(input, func(record recorder.Record) error {
+		if !filter.Match(record) {
+			return nil
+		}
+
+		record.Seq = seq
+		seq++
+		return writeRecord(writer, record)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	return nil
+}
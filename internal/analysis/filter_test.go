@@ -0,0 +1,203 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// writeRecording writes records to a recording file and returns its path.
+func writeRecording(t *testing.T, records []recorder.Record) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, r := range records {
+		data, err := r.ToJSON()
+		if err != nil {
+			t.Fatalf("failed to serialize record: %v", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	filename := filepath.Join(t.TempDir(), "input.jsonl")
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+	return filename
+}
+
+// sampleRecording returns records with seq 0-5, one second apart.
+func sampleRecording() []recorder.Record {
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	data := []struct {
+		source string
+		data   string
+	}{
+		{"stdin", "hello\n"},
+		{"stdout", "hello\n"},
+		{"stderr", "warning: disk\n"},
+		{"stdout", `{"status":"ok"}` + "\n"},
+		{"stdout", "\xff\xfe"},
+		{"stderr", "error: disk full\n"},
+	}
+
+	var records []recorder.Record
+	for i, d := range data {
+		records = append(records, recorder.NewRecord(uint64(i), base.Add(time.Duration(i)*time.Second), d.source, []byte(d.data)))
+	}
+	return records
+}
+
+func runFilter(t *testing.T, filter RecordFilter) []recorder.Record {
+	t.Helper()
+
+	input := writeRecording(t, sampleRecording())
+
+	var output bytes.Buffer
+	if err := FilterRecordings(input, filter, &output); err != nil {
+		t.Fatalf("FilterRecordings failed: %v", err)
+	}
+
+	var records []recorder.Record
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r recorder.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func ptr(n uint64) *uint64 {
+	return &n
+}
+
+func TestFilterRecordings(t *testing.T) {
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter RecordFilter
+		want   []string // expected "source:content" of kept records
+	}{
+		{
+			name:   "no criteria",
+			filter: AllOf{},
+			want:   []string{"stdin:hello", "stdout:hello", "stderr:warning: disk", `stdout:{"status":"ok"}`, "stdout://4=", "stderr:error: disk full"},
+		},
+		{
+			name:   "source",
+			filter: SourceFilter{Sources: []string{"stderr", "stdin"}},
+			want:   []string{"stdin:hello", "stderr:warning: disk", "stderr:error: disk full"},
+		},
+		{
+			name:   "encoding",
+			filter: EncodingFilter{Encodings: []string{"json", "base64"}},
+			want:   []string{`stdout:{"status":"ok"}`, "stdout://4="},
+		},
+		{
+			name:   "seq range",
+			filter: SeqRangeFilter{Min: ptr(2), Max: ptr(3)},
+			want:   []string{"stderr:warning: disk", `stdout:{"status":"ok"}`},
+		},
+		{
+			name:   "seq range min only",
+			filter: SeqRangeFilter{Min: ptr(4)},
+			want:   []string{"stdout://4=", "stderr:error: disk full"},
+		},
+		{
+			name:   "time range",
+			filter: TimeRangeFilter{From: base.Add(time.Second), To: base.Add(2 * time.Second)},
+			want:   []string{"stdout:hello", "stderr:warning: disk"},
+		},
+		{
+			name:   "time range to only",
+			filter: TimeRangeFilter{To: base},
+			want:   []string{"stdin:hello"},
+		},
+		{
+			name:   "pattern",
+			filter: PatternFilter{Pattern: regexp.MustCompile(`disk|status`)},
+			want:   []string{"stderr:warning: disk", `stdout:{"status":"ok"}`, "stderr:error: disk full"},
+		},
+		{
+			name: "and composition",
+			filter: AllOf{
+				SourceFilter{Sources: []string{"stderr"}},
+				PatternFilter{Pattern: regexp.MustCompile(`^error`)},
+			},
+			want: []string{"stderr:error: disk full"},
+		},
+		{
+			name: "or composition",
+			filter: AnyOf{
+				SourceFilter{Sources: []string{"stdin"}},
+				EncodingFilter{Encodings: []string{"json"}},
+			},
+			want: []string{"stdin:hello", `stdout:{"status":"ok"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := runFilter(t, tt.filter)
+
+			var got []string
+			for i, r := range records {
+				// Sequence numbers are renumbered from 0
+				if r.Seq != uint64(i) {
+					t.Errorf("record %d: expected seq %d, got %d", i, i, r.Seq)
+				}
+				got = append(got, r.Source+":"+r.ContentString())
+			}
+
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterRecordings_PreservesFields(t *testing.T) {
+	records := runFilter(t, SourceFilter{Sources: []string{"stdout"}})
+	if len(records) == 0 {
+		t.Fatal("expected records")
+	}
+
+	first := records[0]
+	if first.End != "\n" {
+		t.Errorf("expected end %q, got %q", "\n", first.End)
+	}
+	if first.Timestamp != "2024-01-15T10:30:01.000Z" {
+		t.Errorf("expected original timestamp, got %q", first.Timestamp)
+	}
+}
+
+func TestFilterRecordings_InvalidInput(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bad.jsonl")
+	if err := os.WriteFile(filename, []byte("not json\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var output bytes.Buffer
+	err := FilterRecordings(filename, AllOf{}, &output)
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected parse error for line 1, got %v", err)
+	}
+
+	if err := FilterRecordings(filepath.Join(t.TempDir(), "missing.jsonl"), AllOf{}, &output); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,118 @@
+package analysis
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+	"github.com/trustin/ioetap/pkg/reading"
+)
+
+// MergeRecordings reads the recording files at inputs and writes their
+// records to output as a single NDJSON stream ordered by timestamp.
+// Records with the same timestamp are written in the order of inputs, and
+// records of the same recording keep their order. Sequence numbers are
+// renumbered from 0 in the output.
+//
+// Each recording is expected to be in timestamp order already, as ioetap
+// writes them, so the records are merged as they are read.
+func MergeRecordings(inputs []string, output io.Writer) error {
+	var queue mergeQueue
+	for i, input := range inputs {
+		reader, err := reading.Open(input)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		cursor := &mergeCursor{input: input, index: i, reader: reader}
+		if err := cursor.next(); err != nil {
+			return err
+		}
+		if cursor.ok {
+			queue = append(queue, cursor)
+		}
+	}
+	heap.Init(&queue)
+
+	writer := bufio.NewWriter(output)
+	var seq uint64
+	for queue.Len() > 0 {
+		cursor := queue[0]
+		record := cursor.record
+		record.Seq = seq
+		seq++
+		if err := writeRecord(writer, record); err != nil {
+			return err
+		}
+
+		if err := cursor.next(); err != nil {
+			return err
+		}
+		if cursor.ok {
+			heap.Fix(&queue, 0)
+		} else {
+			heap.Pop(&queue)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+	return nil
+}
+
+// mergeCursor is the next record of one of the merged recordings.
+type mergeCursor struct {
+	input  string
+	index  int // position in the inputs, which breaks timestamp ties
+	reader *reading.Reader
+	record recorder.Record
+	time   time.Time
+	ok     bool // false once the recording has no more records
+}
+
+// next reads the next record of the recording.
+func (c *mergeCursor) next() error {
+	if !c.reader.Next() {
+		c.ok = false
+		if err := c.reader.Err(); err != nil {
+			return fmt.Errorf("%s: %w", c.input, err)
+		}
+		return nil
+	}
+
+	record := c.reader.Record()
+	t, err := record.Time()
+	if err != nil {
+		return fmt.Errorf("%s: invalid timestamp at seq %d: %s", c.input, record.Seq, record.Timestamp)
+	}
+	c.record, c.time, c.ok = record, t, true
+	return nil
+}
+
+// mergeQueue is a min-heap of cursors ordered by timestamp, then by input.
+type mergeQueue []*mergeCursor
+
+func (q mergeQueue) Len() int { return len(q) }
+
+func (q mergeQueue) Less(i, j int) bool {
+	if !q[i].time.Equal(q[j].time) {
+		return q[i].time.Before(q[j].time)
+	}
+	return q[i].index < q[j].index
+}
+
+func (q mergeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *mergeQueue) Push(x any) { *q = append(*q, x.(*mergeCursor)) }
+
+func (q *mergeQueue) Pop() any {
+	old := *q
+	cursor := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return cursor
+}
@@ -0,0 +1,161 @@
+package analysis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+	"github.com/trustin/ioetap/pkg/reading"
+)
+
+// timedRecording returns a stdout record of each line at the given offset in
+// milliseconds from a fixed base time.
+func timedRecording(lines map[int]string) []recorder.Record {
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	var offsets []int
+	for offset := range lines {
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+
+	var records []recorder.Record
+	for i, offset := range offsets {
+		timestamp := base.Add(time.Duration(offset) * time.Millisecond)
+		records = append(records, recorder.NewRecord(uint64(i), timestamp, "stdout", []byte(lines[offset]+"\n")))
+	}
+	return records
+}
+
+func TestMergeRecordings(t *testing.T) {
+	a := writeRecording(t, timedRecording(map[int]string{0: "a0", 20: "a20", 30: "a30", 50: "a50"}))
+	b := writeRecording(t, timedRecording(map[int]string{10: "b10", 20: "b20", 40: "b40"}))
+
+	var output bytes.Buffer
+	if err := MergeRecordings([]string{a, b}, &output); err != nil {
+		t.Fatalf("MergeRecordings failed: %v", err)
+	}
+
+	var records []recorder.Record
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var r recorder.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		records = append(records, r)
+	}
+
+	// Ties are broken by the order of the inputs
+	want := []string{"a0", "b10", "a20", "b20", "a30", "b40", "a50"}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(records))
+	}
+	var last time.Time
+	for i, r := range records {
+		if r.Seq != uint64(i) {
+			t.Errorf("record %d: expected seq %d, got %d", i, i, r.Seq)
+		}
+		if got := r.ContentString(); got != want[i] {
+			t.Errorf("record %d: expected %q, got %q", i, want[i], got)
+		}
+		timestamp, err := r.Time()
+		if err != nil {
+			t.Fatalf("record %d: invalid timestamp: %v", i, err)
+		}
+		if timestamp.Before(last) {
+			t.Errorf("record %d: timestamp %s is before %s", i, r.Timestamp, last.Format(time.RFC3339Nano))
+		}
+		last = timestamp
+	}
+}
+
+func TestMergeRecordings_Compressed(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skipf("no zstd command: %v", err)
+	}
+	reading.UseZstdCommand("zstd")
+	t.Cleanup(func() { reading.UseZstdCommand("") })
+
+	a := writeRecording(t, timedRecording(map[int]string{0: "a0", 20: "a20", 40: "a40"}))
+	b := writeRecording(t, timedRecording(map[int]string{10: "b10", 30: "b30"}))
+
+	// Compress a with gzip and b with zstd, and merge them into plain NDJSON
+	data, err := os.ReadFile(a)
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to compress recording: %v", err)
+	}
+	gzipped := a + ".gz"
+	if err := os.WriteFile(gzipped, compressed.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+	zstded := b + ".zst"
+	if out, err := exec.Command("zstd", "-q", b, "-o", zstded).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compress recording: %v\n%s", err, out)
+	}
+
+	var output bytes.Buffer
+	if err := MergeRecordings([]string{gzipped, zstded}, &output); err != nil {
+		t.Fatalf("MergeRecordings failed: %v", err)
+	}
+
+	var got []string
+	for i, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var r recorder.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("line %d is not a plain record: %v", i+1, err)
+		}
+		if r.Seq != uint64(i) {
+			t.Errorf("record %d: expected seq %d, got %d", i, i, r.Seq)
+		}
+		got = append(got, r.ContentString())
+	}
+	want := []string{"a0", "b10", "a20", "b30", "a40"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMergeRecordings_Empty(t *testing.T) {
+	a := writeRecording(t, timedRecording(map[int]string{0: "a0"}))
+	empty := writeRecording(t, nil)
+
+	var output bytes.Buffer
+	if err := MergeRecordings([]string{empty, a}, &output); err != nil {
+		t.Fatalf("MergeRecordings failed: %v", err)
+	}
+	if got := strings.Count(output.String(), "\n"); got != 1 {
+		t.Errorf("expected 1 record, got %d:\n%s", got, output.String())
+	}
+}
+
+func TestMergeRecordings_InvalidInput(t *testing.T) {
+	a := writeRecording(t, timedRecording(map[int]string{0: "a0"}))
+	bad := filepath.Join(t.TempDir(), "bad.jsonl")
+	if err := os.WriteFile(bad, []byte("not json\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var output bytes.Buffer
+	err := MergeRecordings([]string{a, bad}, &output)
+	if err == nil || !strings.Contains(err.Error(), bad) || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected parse error for line 1 of %s, got %v", bad, err)
+	}
+
+	if err := MergeRecordings([]string{a, filepath.Join(t.TempDir(), "missing.jsonl")}, &output); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
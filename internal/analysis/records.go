@@ -0,0 +1,62 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/trustin/ioetap/internal/recorder"
+	"github.com/trustin/ioetap/pkg/reading"
+)
+
+// errStop is returned by a forEachRecord callback to stop reading early.
+var errStop = errors.New("stop")
+
+// forEachRecord reads the recording file at input and calls fn for each
+// record in file order. Reading stops at the first error returned by fn;
+// errStop stops reading without an error.
+func forEachRecord(input string, fn func(recorder.Record) error) error {
+	reader, err := reading.Open(input)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for reader.Next() {
+		if err := fn(reader.Record()); err != nil {
+			if errors.Is(err, errStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return reader.Err()
+}
+
+// ReadRecords reads all records from the recording file at input.
+func ReadRecords(input string) ([]recorder.Record, error) {
+	reader, err := reading.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	records, err := reader.All()
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// writeRecord writes a record to w as a single NDJSON line.
+func writeRecord(w io.Writer, record recorder.Record) error {
+	jsonData, err := record.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize record: %w", err)
+	}
+	jsonData = append(jsonData, '\n')
+	if _, err := w.Write(jsonData); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// ReconstructStream returns the bytes of source (stdin, stdout or stderr)
+// in the recording file at input, rebuilt by the reconstructor of the
+// recorder package. All records are read into memory first, since the
+// reconstructors sort them by seq. If some bytes are not known because
+// records were truncated or lossy, the stream is returned along with an
+// error wrapping recorder.ErrTruncated or recorder.ErrLossy.
+func ReconstructStream(input, source string) ([]byte, error) {
+	records, err := ReadRecords(input)
+	if err != nil {
+		return nil, err
+	}
+	return reconstruct(records, source)
+}
+
+// reconstruct returns the stream of source rebuilt from records.
+func reconstruct(records []recorder.Record, source string) ([]byte, error) {
+	switch source {
+	case "stdin":
+		return recorder.StdinReconstructor(records)
+	case "stdout":
+		return recorder.StdoutReconstructor(records)
+	case "stderr":
+		return recorder.StderrReconstructor(records)
+	default:
+		return nil, fmt.Errorf("unknown source: %s", source)
+	}
+}
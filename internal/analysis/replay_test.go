@@ -0,0 +1,67 @@
+package analysis
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// recordChunk is data recorded from a source.
+type recordChunk struct {
+	source recorder.Source
+	data   string
+}
+
+// recordChunks records chunks in order to a new recording file and returns
+// its path.
+func recordChunks(t *testing.T, chunks []recordChunk) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	rec, err := recorder.NewFileRecorder(path, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	for _, chunk := range chunks {
+		if err := rec.Record(chunk.source, []byte(chunk.data)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	return path
+}
+
+func TestReconstructStream(t *testing.T) {
+	input := recordChunks(t, []recordChunk{
+		{recorder.Stdin, "first\nsec"},
+		{recorder.Stdout, "output\n"},
+		{recorder.Stdin, "ond\n\xff\xfe"},
+	})
+
+	stdin, err := ReconstructStream(input, "stdin")
+	if err != nil {
+		t.Fatalf("ReconstructStream failed: %v", err)
+	}
+	if string(stdin) != "first\nsecond\n\xff\xfe" {
+		t.Errorf("expected the recorded stdin, got %q", stdin)
+	}
+	stderr, err := ReconstructStream(input, "stderr")
+	if err != nil || len(stderr) != 0 {
+		t.Errorf("expected an empty stderr, got %q, %v", stderr, err)
+	}
+
+	// Truncated records are returned as recorded, with an error
+	truncated := writeRecording(t, []recorder.Record{
+		{Seq: 0, Timestamp: "2024-01-15T10:30:00.000Z", Source: "stdin", Content: "abc", Encoding: "text", End: "\n", Truncated: true},
+	})
+	stdin, err = ReconstructStream(truncated, "stdin")
+	if !errors.Is(err, recorder.ErrTruncated) || string(stdin) != "abc\n" {
+		t.Errorf("expected abc\\n and ErrTruncated, got %q, %v", stdin, err)
+	}
+}
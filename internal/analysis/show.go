@@ -0,0 +1,95 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// ShowOptions configures Show.
+type ShowOptions struct {
+	Head int // Output only the first Head records (0 = no limit)
+	Tail int // Output only the last Tail records (0 = no limit)
+}
+
+// Show writes the recorded data of the recording file at input to output, as
+// it appeared on the original streams. Meta and stats records are skipped.
+// If both Head and Tail are set, Head is applied first (like head | tail).
+func Show(input string, opts ShowOptions, output io.Writer) error {
+	writer := bufio.NewWriter(output)
+
+	var records []recorder.Record
+	if opts.Tail > 0 {
+		// Keep only the last Tail records in a ring buffer
+		records = make([]recorder.Record, 0, opts.Tail)
+	}
+	var count, next int
+
+	err := forEachRecord(input, func(record recorder.Record) error {
+		if record.Source == recorder.MetaSource || record.Source == recorder.StatsSource || record.Source == recorder.ResourceSource || record.Source == recorder.AnnotationSource {
+			return nil
+		}
+		if opts.Head > 0 && count == opts.Head {
+			return errStop
+		}
+		count++
+
+		if opts.Tail <= 0 {
+			return writeRecordData(writer, record)
+		}
+
+		if len(records) < opts.Tail {
+			records = append(records, record)
+		} else {
+			records[next] = record
+			next = (next + 1) % opts.Tail
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write the buffered tail records, oldest first
+	for i := range records {
+		if err := writeRecordData(writer, records[(next+i)%len(records)]); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// writeRecordData writes the data of a record as it appeared on its stream,
+// i.e. its content followed by its line ending. JSON records of recordings
+// made before JSON records kept their line ending have none, so a newline is
+// assumed for them.
+func writeRecordData(w io.Writer, record recorder.Record) error {
+	var data []byte
+	switch record.Encoding {
+	case "base64":
+		decoded, err := record.Bytes()
+		if err != nil {
+			return fmt.Errorf("seq %d: %w", record.Seq, err)
+		}
+		data = append(decoded, record.End...)
+	case "json":
+		end := record.End
+		if end == "" {
+			end = "\n"
+		}
+		data = []byte(record.ContentString() + end)
+	default:
+		data = []byte(record.ContentString() + record.End)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
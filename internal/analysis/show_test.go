@@ -0,0 +1,99 @@
+package analysis
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// numberedRecording returns stdout records "line 0\n" to "line <n-1>\n" with
+// a meta record in the middle.
+func numberedRecording(n int) []recorder.Record {
+	now := time.Now()
+	var records []recorder.Record
+	for i := 0; i < n; i++ {
+		if i == n/2 {
+			records = append(records, recorder.NewMetaRecord(uint64(len(records)), now, map[string]any{"event": "suspend"}))
+		}
+		data := []byte("line " + strconv.Itoa(i) + "\n")
+		records = append(records, recorder.NewRecord(uint64(len(records)), now, "stdout", data))
+	}
+	return records
+}
+
+func TestShow(t *testing.T) {
+	input := writeRecording(t, numberedRecording(6))
+
+	tests := []struct {
+		name string
+		opts ShowOptions
+		want string
+	}{
+		{"all", ShowOptions{}, "line 0\nline 1\nline 2\nline 3\nline 4\nline 5\n"},
+		{"head", ShowOptions{Head: 2}, "line 0\nline 1\n"},
+		{"head beyond end", ShowOptions{Head: 10}, "line 0\nline 1\nline 2\nline 3\nline 4\nline 5\n"},
+		{"tail", ShowOptions{Tail: 2}, "line 4\nline 5\n"},
+		{"tail across meta record", ShowOptions{Tail: 4}, "line 2\nline 3\nline 4\nline 5\n"},
+		{"tail beyond start", ShowOptions{Tail: 10}, "line 0\nline 1\nline 2\nline 3\nline 4\nline 5\n"},
+		{"head then tail", ShowOptions{Head: 4, Tail: 2}, "line 2\nline 3\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			if err := Show(input, tt.opts, &output); err != nil {
+				t.Fatalf("Show failed: %v", err)
+			}
+			if output.String() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, output.String())
+			}
+		})
+	}
+}
+
+func TestShow_Encodings(t *testing.T) {
+	now := time.Now()
+	input := writeRecording(t, []recorder.Record{
+		recorder.NewRecord(0, now, "stdout", []byte("text\r\n")),
+		recorder.NewRecord(1, now, "stdout", []byte(`{"a":1}`+"\n")),
+		recorder.NewRecord(2, now, "stdout", []byte(`[1]`+"\r\n")),
+		recorder.NewRecord(3, now, "stdout", []byte{0xff, '\r', '\n'}),
+		recorder.NewRecord(4, now, "stdout", []byte{0xff, 0xfe, 0x00}),
+		// JSON records of older recordings have no end
+		{Seq: 5, Timestamp: "2024-01-15T10:30:45.123Z", Source: "stdout", Content: true, Encoding: "json"},
+	})
+
+	var output bytes.Buffer
+	if err := Show(input, ShowOptions{}, &output); err != nil {
+		t.Fatalf("Show failed: %v", err)
+	}
+
+	want := "text\r\n{\"a\":1}\n[1]\r\n\xff\r\n\xff\xfe\x00true\n"
+	if output.String() != want {
+		t.Errorf("expected %q, got %q", want, output.String())
+	}
+}
+
+func TestShow_SchemaHeader(t *testing.T) {
+	input := writeRecording(t, numberedRecording(2))
+	content, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+	header := `{"$schema":"http://json-schema.org/draft-07/schema#","type":"object"}` + "\n"
+	if err := os.WriteFile(input, append([]byte(header), content...), 0644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+
+	var output bytes.Buffer
+	if err := Show(input, ShowOptions{}, &output); err != nil {
+		t.Fatalf("Show failed: %v", err)
+	}
+	if want := "line 0\nline 1\n"; output.String() != want {
+		t.Errorf("expected %q, got %q", want, output.String())
+	}
+}
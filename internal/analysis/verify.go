@@ -0,0 +1,42 @@
+package analysis
+
+import (
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	Chain bool // Verify the chain hashes of a recording made with --record-checksums
+}
+
+// VerifyResult describes what Verify found.
+type VerifyResult struct {
+	Records int          // Number of records read
+	Breaks  []ChainBreak // Records whose chain hash did not match, in file order
+}
+
+// ChainBreak is a record that does not link to the record before it.
+type ChainBreak struct {
+	Seq uint64 // Sequence number of the record
+	Err error  // Why the record did not verify
+}
+
+// Verify reads the recording file at input and checks that every line is a
+// valid record. With opts.Chain, it also re-walks the chain of chain hashes
+// and reports every record where it breaks. A changed record breaks the chain
+// at that record only; a removed or reordered record breaks it at the record
+// that follows. Errors reading the recording are returned as is.
+func Verify(input string, opts VerifyOptions) (VerifyResult, error) {
+	var result VerifyResult
+	verifier := recorder.NewChainVerifier()
+	err := forEachRecord(input, func(record recorder.Record) error {
+		result.Records++
+		if opts.Chain {
+			if err := verifier.Verify(record); err != nil {
+				result.Breaks = append(result.Breaks, ChainBreak{Seq: record.Seq, Err: err})
+			}
+		}
+		return nil
+	})
+	return result, err
+}
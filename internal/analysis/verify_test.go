@@ -0,0 +1,104 @@
+package analysis
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// writeChainedRecording records lines with chain hashes to a recording file
+// and returns its path.
+func writeChainedRecording(t *testing.T) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "chained.jsonl")
+	rec, err := recorder.NewFileRecorder(filename, 0, recorder.WithChainHash())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if err := rec.Record(recorder.Stdout, []byte(line)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	return filename
+}
+
+func TestVerify_Chain(t *testing.T) {
+	filename := writeChainedRecording(t)
+
+	result, err := Verify(filename, VerifyOptions{Chain: true})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if result.Records != 4 || len(result.Breaks) != 0 {
+		t.Errorf("expected 4 intact records, got %+v", result)
+	}
+}
+
+func TestVerify_ChainModified(t *testing.T) {
+	filename := writeChainedRecording(t)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+
+	// Changing a record breaks the chain at that record only
+	modified := bytes.Replace(data, []byte(`"three"`), []byte(`"THREE"`), 1)
+	if err := os.WriteFile(filename, modified, 0644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+
+	result, err := Verify(filename, VerifyOptions{Chain: true})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(result.Breaks) != 1 || result.Breaks[0].Seq != 2 || !errors.Is(result.Breaks[0].Err, recorder.ErrChainBroken) {
+		t.Errorf("expected the chain to break at seq 2, got %+v", result.Breaks)
+	}
+
+	// Without --chain, only the records themselves are checked
+	result, err = Verify(filename, VerifyOptions{})
+	if err != nil || result.Records != 4 || len(result.Breaks) != 0 {
+		t.Errorf("expected 4 valid records, got %+v, %v", result, err)
+	}
+}
+
+func TestVerify_ChainRemoved(t *testing.T) {
+	filename := writeChainedRecording(t)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+
+	// Removing a record breaks the chain at the record after it
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if err := os.WriteFile(filename, bytes.Join(append(lines[:1], lines[2:]...), nil), 0644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+
+	result, err := Verify(filename, VerifyOptions{Chain: true})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(result.Breaks) != 1 || result.Breaks[0].Seq != 2 {
+		t.Errorf("expected the chain to break at seq 2, got %+v", result.Breaks)
+	}
+}
+
+func TestVerify_InvalidRecording(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "invalid.jsonl")
+	if err := os.WriteFile(filename, []byte("not json\n"), 0644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+	if _, err := Verify(filename, VerifyOptions{}); err == nil {
+		t.Error("expected an error for an invalid record")
+	}
+}
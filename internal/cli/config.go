@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// redacted replaces option values that may hold credentials in
+// EffectiveOptions.
+const redacted = "xxxxx"
+
+// EffectiveOptions returns the options that shape the recording with their
+// effective values, including defaults, keyed by option name without the
+// leading dashes. Options that were not given and have no default are left
+// out. Values that may hold credentials are masked, so the result can be
+// written to the recording (see --record-config).
+func EffectiveOptions(opts *Options) map[string]any {
+	effective := map[string]any{
+		"max-line-length":         opts.MaxLineLength,
+		"truncate-binary":         opts.BinaryLimit,
+		"long-line-mode":          opts.LongLineMode,
+		"truncation-strategy":     opts.Truncation,
+		"max-seq":                 opts.MaxSeq,
+		"stdin-rate-limit":        opts.StdinRateLimit,
+		"keep-on-error":           opts.KeepOnError,
+		"output-format":           opts.OutputFormat,
+		"header":                  opts.Header,
+		"mark-bursts":             opts.MarkBursts,
+		"deltas":                  opts.Deltas,
+		"record-read-sizes":       opts.RecordReadSizes,
+		"record-timing-histogram": opts.TimingStats,
+		"grace-period":            opts.GracePeriod.String(),
+		"strict-order":            opts.StrictOrder,
+		"stdin-echo":              opts.StdinEcho,
+		"stdin-split-on-newline":  opts.SplitStdin,
+		"no-buffering":            opts.NoBuffering,
+		"flush-on-line":           opts.FlushOnLine,
+		"escape-html":             opts.EscapeHTML,
+		"json-raw-content":        opts.JSONRawContent,
+		"lossy-text":              opts.LossyText,
+		"record-signals":          opts.RecordSignals,
+		"consume-usr1":            opts.ConsumeUSR1,
+		"process-reap":            opts.ProcessReap,
+		"record-checksums":        opts.RecordChecksums,
+		"record-cwd":              opts.RecordCWD,
+		"record-command-path":     opts.RecordPath,
+		"record-identity":         opts.RecordIdentity,
+	}
+	// --out is a list if it was given more than once
+	var outs []string
+	if opts.OutputFile != "" {
+		outs = append(outs, opts.OutputFile)
+	}
+	for _, addr := range opts.RemoteOutputs {
+		outs = append(outs, remoteOutputScheme+addr)
+	}
+	for _, u := range opts.HTTPOutputs {
+		outs = append(outs, redactURL(u))
+	}
+	switch len(outs) {
+	case 0:
+	case 1:
+		effective["out"] = outs[0]
+	default:
+		effective["out"] = outs
+	}
+	if len(opts.RemoteOutputs) > 0 || len(opts.HTTPOutputs) > 0 {
+		effective["remote-on-error"] = opts.RemoteOnError
+	}
+	if len(opts.RemoteOutputs) > 0 {
+		if opts.RemoteOnError == RemoteOnErrorContinue {
+			retryInitial, retryCap := retryDelays(opts)
+			effective["sink-retry-initial"] = retryInitial.String()
+			effective["sink-retry-cap"] = retryCap.String()
+			effective["sink-retry-max"] = opts.RetryMax
+		}
+	}
+	if len(opts.HTTPOutputs) > 0 {
+		effective["http-keep-alive"] = opts.HTTPKeepAlive
+		idleConns := opts.HTTPIdleConns
+		if idleConns == 0 {
+			idleConns = recorder.DefaultHTTPMaxIdleConns
+		}
+		effective["http-max-idle-conns"] = idleConns
+	}
+	if opts.OutputFilePerm != nil {
+		effective["output-file-permissions"] = fmt.Sprintf("%04o", *opts.OutputFilePerm)
+	}
+	if len(opts.Rlimits) > 0 {
+		rlimits := make([]string, len(opts.Rlimits))
+		for i, rlimit := range opts.Rlimits {
+			rlimits[i] = fmt.Sprintf("%s=%d:%d", rlimit.Name, rlimit.Cur, rlimit.Max)
+		}
+		effective["rlimit"] = rlimits
+	}
+	if opts.StdinTimeout > 0 {
+		effective["stdin-timeout"] = opts.StdinTimeout.String()
+	}
+	if opts.AnnotatePrefix != "" {
+		effective["annotate-stdin"] = opts.AnnotatePrefix
+	}
+	for name, sig := range toggleSignals {
+		if sig == opts.ToggleSignal {
+			effective["toggle-signal"] = "SIG" + name
+		}
+	}
+	if opts.StdinBroadcast != "" {
+		effective["stdin-broadcast"] = opts.StdinBroadcast
+	}
+	if opts.CPUTimeRecord > 0 {
+		effective["cpu-time-record"] = opts.CPUTimeRecord.String()
+	}
+	if len(opts.PassFDs) > 0 {
+		effective["pass-fd"] = opts.PassFDs
+	}
+	if len(opts.Watch) > 0 {
+		effective["watch"] = opts.Watch
+	}
+	if opts.RetryCommand > 0 {
+		effective["retry-command"] = opts.RetryCommand
+		effective["retry-delay"] = opts.RetryDelay.String()
+	}
+	if len(opts.Fields) > 0 {
+		// Written to every record anyway
+		effective["field"] = opts.Fields
+	}
+	if len(opts.SourceWeights) > 0 {
+		effective["source-weight"] = opts.SourceWeights
+	}
+	if opts.QueueSize > 0 {
+		effective["queue-size"] = opts.QueueSize
+	}
+	if len(opts.ExitCodeMap) > 0 {
+		effective["exit-code-map"] = opts.ExitCodeMap
+	}
+	if opts.CommandLabel != "" {
+		effective["command-label"] = opts.CommandLabel
+	}
+	if opts.Comment != "" {
+		effective["comment"] = opts.Comment
+	}
+	if opts.CommentFile != "" {
+		effective["comment-file"] = opts.CommentFile
+	}
+	if opts.OTLPEndpoint != "" {
+		effective["otlp-endpoint"] = redactURL(opts.OTLPEndpoint)
+	}
+	if opts.S3Bucket != "" {
+		effective["s3-bucket"] = opts.S3Bucket
+		effective["s3-key"] = opts.S3Key
+	}
+	if opts.S3Region != "" {
+		effective["s3-region"] = opts.S3Region
+	}
+	if opts.S3Endpoint != "" {
+		effective["s3-endpoint"] = redactURL(opts.S3Endpoint)
+	}
+	if len(opts.KafkaBrokers) > 0 {
+		effective["kafka-broker"] = opts.KafkaBrokers
+		effective["kafka-topic"] = opts.KafkaTopic
+	}
+	if opts.MetricsAddr != "" {
+		effective["metrics-addr"] = opts.MetricsAddr
+	}
+	if opts.ControlSocket != "" {
+		effective["control-socket"] = opts.ControlSocket
+	}
+	if opts.ControlPerm != nil {
+		effective["control-socket-permissions"] = fmt.Sprintf("%04o", *opts.ControlPerm)
+	}
+	if opts.PprofAddr != "" {
+		effective["pprof"] = opts.PprofAddr
+	}
+	return effective
+}
+
+// redactURL masks the user info and the query of a URL, which may hold
+// credentials such as a password or an API key. A URL without a scheme is
+// taken as plain HTTP, like --otlp-endpoint does.
+func redactURL(s string) string {
+	withScheme := s
+	if !strings.Contains(s, "://") {
+		withScheme = "http://" + s
+	}
+	u, err := url.Parse(withScheme)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	if withScheme != s {
+		return strings.TrimPrefix(u.String(), "http://")
+	}
+	return u.String()
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/trustin/ioetap/internal/control"
+)
+
+// CtlOptions holds the parsed arguments of the ctl subcommand.
+type CtlOptions struct {
+	Socket  string // Control socket of the running ioetap (see --control-socket)
+	Command string // Command to send, e.g. control.CommandMark
+	Label   string // Label of the mark command
+}
+
+// ParseCtl parses the arguments of the ctl subcommand:
+//
+//	ioetap ctl <socket> pause|resume|flush|stats
+//	ioetap ctl <socket> mark <label>
+func ParseCtl(args []string) (*CtlOptions, error) {
+	_, positional, err := splitSubcommandArgs(args, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(positional) {
+	case 0:
+		return nil, errors.New("no control socket specified")
+	case 1:
+		return nil, errors.New("no command specified")
+	}
+	co := &CtlOptions{Socket: positional[0], Command: positional[1]}
+	rest := positional[2:]
+
+	switch co.Command {
+	case control.CommandMark:
+		if len(rest) == 0 || rest[0] == "" {
+			return nil, errors.New("mark requires a label")
+		}
+		co.Label, rest = rest[0], rest[1:]
+	case control.CommandPause, control.CommandResume, control.CommandFlush, control.CommandStats:
+	default:
+		return nil, fmt.Errorf("unknown command: %s (must be pause, resume, mark, flush or stats)", co.Command)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(rest, " "))
+	}
+
+	return co, nil
+}
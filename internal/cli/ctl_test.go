@@ -0,0 +1,51 @@
+package cli
+
+import "testing"
+
+func TestParseCtl(t *testing.T) {
+	opts, err := ParseCtl([]string{"/tmp/ioetap.sock", "mark", "phase 2"})
+	if err != nil {
+		t.Fatalf("ParseCtl() error = %v", err)
+	}
+	if opts.Socket != "/tmp/ioetap.sock" || opts.Command != "mark" || opts.Label != "phase 2" {
+		t.Errorf("ParseCtl() = %+v, want mark \"phase 2\" on /tmp/ioetap.sock", opts)
+	}
+
+	for _, command := range []string{"pause", "resume", "flush", "stats"} {
+		opts, err := ParseCtl([]string{"ctl.sock", command})
+		if err != nil {
+			t.Fatalf("ParseCtl(%s) error = %v", command, err)
+		}
+		if opts.Command != command || opts.Label != "" {
+			t.Errorf("ParseCtl(%s) = %+v", command, opts)
+		}
+	}
+}
+
+func TestParseCtl_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no socket", nil, "no control socket specified"},
+		{"no command", []string{"ctl.sock"}, "no command specified"},
+		{"unknown command", []string{"ctl.sock", "stop"}, "unknown command: stop"},
+		{"mark without label", []string{"ctl.sock", "mark"}, "mark requires a label"},
+		{"mark with empty label", []string{"ctl.sock", "mark", ""}, "mark requires a label"},
+		{"extra argument", []string{"ctl.sock", "pause", "now"}, "too many arguments: now"},
+		{"unknown option", []string{"--bogus", "ctl.sock", "pause"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCtl(tt.args)
+			if err == nil {
+				t.Fatalf("ParseCtl() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseCtl() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
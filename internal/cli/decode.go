@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DecodeOptions holds the parsed options of the decode subcommand.
+type DecodeOptions struct {
+	Source string // --source value (stdin, stdout or stderr)
+	Input  string // Recording file to decode
+}
+
+// ParseDecode parses the arguments of the decode subcommand:
+//
+//	ioetap decode --source=<source> <recording.jsonl>
+func ParseDecode(args []string) (*DecodeOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args, []string{"--source"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	do := &DecodeOptions{}
+	for _, opt := range opts {
+		switch opt.Value {
+		case "stdin", "stdout", "stderr":
+			do.Source = opt.Value
+		default:
+			return nil, fmt.Errorf("--source must be one of stdin, stdout, stderr: %s", opt.Value)
+		}
+	}
+	if do.Source == "" {
+		return nil, errors.New("--source is required")
+	}
+
+	switch len(positional) {
+	case 0:
+		return nil, errors.New("no recording file specified")
+	case 1:
+		do.Input = positional[0]
+	default:
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional[1:], " "))
+	}
+
+	return do, nil
+}
@@ -0,0 +1,39 @@
+package cli
+
+import "testing"
+
+func TestParseDecode(t *testing.T) {
+	opts, err := ParseDecode([]string{"--source", "stdout", "recording.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseDecode() error = %v", err)
+	}
+	if opts.Source != "stdout" || opts.Input != "recording.jsonl" {
+		t.Errorf("Source, Input = %q, %q, want stdout, recording.jsonl", opts.Source, opts.Input)
+	}
+}
+
+func TestParseDecode_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no source", []string{"a.jsonl"}, "--source is required"},
+		{"invalid source", []string{"--source=meta", "a.jsonl"}, "--source must be one of stdin, stdout, stderr"},
+		{"no input", []string{"--source=stdout"}, "no recording file specified"},
+		{"too many inputs", []string{"--source=stdout", "a.jsonl", "b.jsonl"}, "too many arguments: b.jsonl"},
+		{"unknown option", []string{"--bogus", "a.jsonl"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDecode(tt.args)
+			if err == nil {
+				t.Fatalf("ParseDecode() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseDecode() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
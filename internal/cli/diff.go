@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DiffOptions holds the parsed options of the diff subcommand.
+type DiffOptions struct {
+	Sources []string // --source values (default: stdin, stdout and stderr)
+	A       string   // First recording file
+	B       string   // Second recording file
+}
+
+// ParseDiff parses the arguments of the diff subcommand:
+//
+//	ioetap diff [--source=<list>] <a.jsonl> <b.jsonl>
+func ParseDiff(args []string) (*DiffOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args, []string{"--source"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	do := &DiffOptions{}
+	for _, opt := range opts {
+		for _, source := range splitList(opt.Value) {
+			switch source {
+			case "stdin", "stdout", "stderr":
+				do.Sources = append(do.Sources, source)
+			default:
+				return nil, fmt.Errorf("--source must be a list of stdin, stdout, stderr: %s", opt.Value)
+			}
+		}
+	}
+	if len(do.Sources) == 0 {
+		do.Sources = []string{"stdin", "stdout", "stderr"}
+	}
+
+	switch len(positional) {
+	case 0, 1:
+		return nil, errors.New("two recording files must be specified")
+	case 2:
+		do.A, do.B = positional[0], positional[1]
+	default:
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional[2:], " "))
+	}
+
+	return do, nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDiff(t *testing.T) {
+	opts, err := ParseDiff([]string{"a.jsonl", "b.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseDiff() error = %v", err)
+	}
+	if opts.A != "a.jsonl" || opts.B != "b.jsonl" || !slices.Equal(opts.Sources, []string{"stdin", "stdout", "stderr"}) {
+		t.Errorf("ParseDiff() = %+v, want all sources of a.jsonl and b.jsonl", opts)
+	}
+
+	opts, err = ParseDiff([]string{"--source=stdout,stderr", "a.jsonl", "b.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseDiff() error = %v", err)
+	}
+	if !slices.Equal(opts.Sources, []string{"stdout", "stderr"}) {
+		t.Errorf("Sources = %q, want stdout, stderr", opts.Sources)
+	}
+}
+
+func TestParseDiff_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"invalid source", []string{"--source=stdout,meta", "a.jsonl", "b.jsonl"}, "--source must be a list of stdin, stdout, stderr"},
+		{"one input", []string{"a.jsonl"}, "two recording files must be specified"},
+		{"too many inputs", []string{"a.jsonl", "b.jsonl", "c.jsonl"}, "too many arguments: c.jsonl"},
+		{"unknown option", []string{"--bogus", "a.jsonl", "b.jsonl"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDiff(tt.args)
+			if err == nil {
+				t.Fatalf("ParseDiff() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseDiff() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
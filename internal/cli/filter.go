@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterOptions holds the parsed options of the filter subcommand.
+type FilterOptions struct {
+	Sources   []string       // --source values (empty = any)
+	Encodings []string       // --encoding values (empty = any)
+	MinSeq    *uint64        // --min-seq or lower bound of --seq-range
+	MaxSeq    *uint64        // --max-seq or upper bound of --seq-range
+	From      time.Time      // lower bound of --time-range (zero = unbounded)
+	To        time.Time      // upper bound of --time-range (zero = unbounded)
+	Pattern   *regexp.Regexp // --pattern (nil = any)
+	Or        bool           // --or: combine criteria with OR instead of AND
+	Output    string         // --out (empty = stdout)
+	Input     string         // Recording file to filter
+}
+
+// ParseFilter parses the arguments of the filter subcommand:
+//
+//	ioetap filter [options] <recording.jsonl>
+func ParseFilter(args []string) (*FilterOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args,
+		[]string{"--source", "--encoding", "--min-seq", "--max-seq", "--seq-range", "--time-range", "--pattern", "--out"},
+		[]string{"--or"})
+	if err != nil {
+		return nil, err
+	}
+
+	fo := &FilterOptions{}
+	for _, opt := range opts {
+		switch opt.Key {
+		case "--source":
+			fo.Sources = append(fo.Sources, splitList(opt.Value)...)
+		case "--encoding":
+			fo.Encodings = append(fo.Encodings, splitList(opt.Value)...)
+		case "--min-seq":
+			n, err := parseSeq(opt.Key, opt.Value)
+			if err != nil {
+				return nil, err
+			}
+			fo.MinSeq = &n
+		case "--max-seq":
+			n, err := parseSeq(opt.Key, opt.Value)
+			if err != nil {
+				return nil, err
+			}
+			fo.MaxSeq = &n
+		case "--seq-range":
+			// <min>:<max>, either side may be empty
+			lo, hi, ok := strings.Cut(opt.Value, ":")
+			if !ok {
+				return nil, fmt.Errorf("--seq-range must be in <min>:<max> format: %s", opt.Value)
+			}
+			if lo != "" {
+				n, err := parseSeq(opt.Key, lo)
+				if err != nil {
+					return nil, err
+				}
+				fo.MinSeq = &n
+			}
+			if hi != "" {
+				n, err := parseSeq(opt.Key, hi)
+				if err != nil {
+					return nil, err
+				}
+				fo.MaxSeq = &n
+			}
+		case "--time-range":
+			// <from>/<to> in RFC 3339 format, either side may be empty
+			from, to, ok := strings.Cut(opt.Value, "/")
+			if !ok {
+				return nil, fmt.Errorf("--time-range must be in <from>/<to> format: %s", opt.Value)
+			}
+			if fo.From, err = parseTime(opt.Key, from); err != nil {
+				return nil, err
+			}
+			if fo.To, err = parseTime(opt.Key, to); err != nil {
+				return nil, err
+			}
+		case "--pattern":
+			re, err := regexp.Compile(opt.Value)
+			if err != nil {
+				return nil, fmt.Errorf("--pattern is not a valid regular expression: %w", err)
+			}
+			fo.Pattern = re
+		case "--or":
+			fo.Or = true
+		case "--out":
+			fo.Output = opt.Value
+		}
+	}
+
+	if fo.MinSeq != nil && fo.MaxSeq != nil && *fo.MinSeq > *fo.MaxSeq {
+		return nil, errors.New("minimum seq cannot be greater than maximum seq")
+	}
+
+	switch len(positional) {
+	case 0:
+		return nil, errors.New("no recording file specified")
+	case 1:
+		fo.Input = positional[0]
+	default:
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional[1:], " "))
+	}
+
+	return fo, nil
+}
+
+// splitList splits a comma-separated list, ignoring empty elements.
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseSeq parses a sequence number given to the named option.
+func parseSeq(name, value string) (uint64, error) {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s requires a non-negative integer value: %s", name, value)
+	}
+	return n, nil
+}
+
+// parseTime parses an RFC 3339 timestamp given to the named option.
+// An empty value yields the zero time.
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s requires RFC 3339 timestamps: %s", name, value)
+	}
+	return t, nil
+}
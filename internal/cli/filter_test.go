@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	opts, err := ParseFilter([]string{
+		"--source=stdout,stderr",
+		"--encoding", "text",
+		"--min-seq=100",
+		"--max-seq", "500",
+		"--time-range=2024-01-15T10:30:00Z/",
+		"--pattern=err(or)?",
+		"--or",
+		"--out=filtered.jsonl",
+		"recording.jsonl",
+	})
+	if err != nil {
+		t.Fatalf("ParseFilter() error = %v", err)
+	}
+
+	if len(opts.Sources) != 2 || opts.Sources[0] != "stdout" || opts.Sources[1] != "stderr" {
+		t.Errorf("Sources = %v, want [stdout stderr]", opts.Sources)
+	}
+	if len(opts.Encodings) != 1 || opts.Encodings[0] != "text" {
+		t.Errorf("Encodings = %v, want [text]", opts.Encodings)
+	}
+	if opts.MinSeq == nil || *opts.MinSeq != 100 {
+		t.Errorf("MinSeq = %v, want 100", opts.MinSeq)
+	}
+	if opts.MaxSeq == nil || *opts.MaxSeq != 500 {
+		t.Errorf("MaxSeq = %v, want 500", opts.MaxSeq)
+	}
+	if !opts.From.Equal(time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("From = %v", opts.From)
+	}
+	if !opts.To.IsZero() {
+		t.Errorf("To = %v, want zero", opts.To)
+	}
+	if opts.Pattern == nil || !opts.Pattern.MatchString("error") {
+		t.Errorf("Pattern = %v", opts.Pattern)
+	}
+	if !opts.Or {
+		t.Error("Or = false, want true")
+	}
+	if opts.Output != "filtered.jsonl" {
+		t.Errorf("Output = %q, want filtered.jsonl", opts.Output)
+	}
+	if opts.Input != "recording.jsonl" {
+		t.Errorf("Input = %q, want recording.jsonl", opts.Input)
+	}
+}
+
+func TestParseFilter_SeqRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantMin *uint64
+		wantMax *uint64
+	}{
+		{"10:20", u64(10), u64(20)},
+		{"10:", u64(10), nil},
+		{":20", nil, u64(20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			opts, err := ParseFilter([]string{"--seq-range=" + tt.value, "in.jsonl"})
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if !equalPtr(opts.MinSeq, tt.wantMin) || !equalPtr(opts.MaxSeq, tt.wantMax) {
+				t.Errorf("seq range = %v:%v, want %v:%v", opts.MinSeq, opts.MaxSeq, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no input", []string{"--source=stdout"}, "no recording file specified"},
+		{"too many inputs", []string{"a.jsonl", "b.jsonl"}, "too many arguments"},
+		{"unknown option", []string{"--bogus", "a.jsonl"}, "unknown option: --bogus"},
+		{"missing value", []string{"a.jsonl", "--source"}, "--source requires a value"},
+		{"flag with value", []string{"--or=yes", "a.jsonl"}, "--or does not take a value"},
+		{"negative seq", []string{"--min-seq=-1", "a.jsonl"}, "--min-seq requires a non-negative integer value"},
+		{"bad seq range", []string{"--seq-range=10", "a.jsonl"}, "--seq-range must be in <min>:<max> format"},
+		{"inverted seq range", []string{"--seq-range=20:10", "a.jsonl"}, "minimum seq cannot be greater than maximum seq"},
+		{"bad time range", []string{"--time-range=yesterday/", "a.jsonl"}, "--time-range requires RFC 3339 timestamps"},
+		{"bad pattern", []string{"--pattern=(", "a.jsonl"}, "--pattern is not a valid regular expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFilter(tt.args)
+			if err == nil {
+				t.Fatalf("ParseFilter() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseFilter() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func u64(n uint64) *uint64 {
+	return &n
+}
+
+func equalPtr(a, b *uint64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
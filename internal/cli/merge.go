@@ -0,0 +1,34 @@
+package cli
+
+import "errors"
+
+// MergeOptions holds the parsed options of the merge subcommand.
+type MergeOptions struct {
+	Output string   // --out (empty = stdout)
+	Inputs []string // Recording files to merge, in tie-breaking order
+}
+
+// ParseMerge parses the arguments of the merge subcommand:
+//
+//	ioetap merge [options] <recording.jsonl>...
+func ParseMerge(args []string) (*MergeOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args, []string{"--out"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mo := &MergeOptions{}
+	for _, opt := range opts {
+		switch opt.Key {
+		case "--out":
+			mo.Output = opt.Value
+		}
+	}
+
+	if len(positional) == 0 {
+		return nil, errors.New("no recording files specified")
+	}
+	mo.Inputs = positional
+
+	return mo, nil
+}
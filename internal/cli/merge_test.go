@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMerge(t *testing.T) {
+	opts, err := ParseMerge([]string{"--out", "merged.jsonl", "a.jsonl", "b.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseMerge() error = %v", err)
+	}
+	if opts.Output != "merged.jsonl" || !slices.Equal(opts.Inputs, []string{"a.jsonl", "b.jsonl"}) {
+		t.Errorf("ParseMerge() = %+v, want a.jsonl and b.jsonl to merged.jsonl", opts)
+	}
+
+	opts, err = ParseMerge([]string{"a.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseMerge() error = %v", err)
+	}
+	if opts.Output != "" {
+		t.Errorf("Output = %q by default, want stdout", opts.Output)
+	}
+}
+
+func TestParseMerge_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no input", []string{"--out=merged.jsonl"}, "no recording files specified"},
+		{"missing out value", []string{"a.jsonl", "--out"}, "--out requires a value"},
+		{"unknown option", []string{"--bogus", "a.jsonl"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMerge(tt.args)
+			if err == nil {
+				t.Fatalf("ParseMerge() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseMerge() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
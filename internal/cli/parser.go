@@ -3,69 +3,215 @@ package cli
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/recorder"
 )
 
 // DefaultMaxLineLength is the default maximum bytes per recorded line (16 MiB).
 const DefaultMaxLineLength = 16 * 1024 * 1024
 
+// DefaultGracePeriod is the default time the child has to exit after ioetap
+// receives SIGTERM or SIGHUP, before ioetap finalizes the recording and exits.
+const DefaultGracePeriod = 5 * time.Second
+
+// Output formats supported by --output-format.
+const (
+	FormatJSONL        = "jsonl"               // NDJSON records (default)
+	FormatNDJSONSchema = "ndjson-schema"       // NDJSON records preceded by a JSON Schema line
+	FormatHTML         = "html"                // Self-contained HTML session viewer
+	FormatSortedNDJSON = "newline-json-sorted" // NDJSON records with their fields in alphabetical order
+	FormatCompactJSONL = "jsonl-compact"       // Same as FormatJSONL, whose records have no whitespace already
+	FormatJSONArray    = "json-array"          // A single JSON array of the records
+)
+
+// Modes supported by --long-line-mode.
+const (
+	LongLineTruncate = "truncate" // Keep the first --max-line-length bytes of a long line (default)
+	LongLineSplit    = "split"    // Split a long line into records of up to --max-line-length bytes
+)
+
+// Strategies supported by --truncation-strategy.
+const (
+	TruncateHead = "head" // Keep the first bytes of a truncated line (default)
+	TruncateTail = "tail" // Keep the last bytes of a truncated line
+)
+
+// Policies supported by --remote-on-error.
+const (
+	RemoteOnErrorContinue = "continue" // Drop a failed remote destination with a warning (default)
+	RemoteOnErrorAbort    = "abort"    // Fail the recording like a failed file
+)
+
+// remoteOutputScheme prefixes the --out values of remote destinations.
+const remoteOutputScheme = "tcp://"
+
+// httpOutputSchemes prefix the --out values of HTTP destinations.
+var httpOutputSchemes = []string{"http://", "https://"}
+
+// Error formats supported by --error-format.
+const (
+	ErrorFormatHuman = "human" // "ioetap: <message>" lines (default)
+	ErrorFormatJSON  = "json"  // One {"code": ..., "message": ...} object per line
+)
+
 // Options holds the parsed command-line options.
 type Options struct {
-	OutputFile    string   // --out value (empty = default naming)
-	MaxLineLength int      // --max-line-length value (0 = unlimited, default: 16 MiB)
-	Command       string   // First arg after --
-	Args          []string // Remaining args after --
+	OutputFile      string            // --out value naming a file (empty = default naming)
+	RemoteOutputs   []string          // --out values of the form tcp://<host>:<port> (repeatable), as <host>:<port>
+	HTTPOutputs     []string          // --out values of the form http(s)://... (repeatable), as URLs
+	RemoteOnError   string            // --remote-on-error value (RemoteOnErrorContinue or RemoteOnErrorAbort)
+	RetryInitial    time.Duration     // --sink-retry-initial value (0 = recorder.DefaultRetryInitial)
+	RetryCap        time.Duration     // --sink-retry-cap value (0 = recorder.DefaultRetryCap)
+	RetryMax        int               // --sink-retry-max value (0 = never give up)
+	HTTPKeepAlive   bool              // --http-keep-alive value: reuse the connections to HTTP destinations (default: true)
+	HTTPIdleConns   int               // --http-max-idle-conns value (0 = recorder.HTTPSinkOptions default)
+	OutputFilePerm  *fs.FileMode      // --output-file-permissions value (nil = default, based on the umask)
+	MaxLineLength   int               // --max-line-length value (0 = unlimited, default: 16 MiB)
+	BinaryLimit     int               // --truncate-binary value (0 = use MaxLineLength)
+	LongLineMode    string            // --long-line-mode value (LongLineTruncate or LongLineSplit)
+	Truncation      string            // --truncation-strategy value (TruncateHead or TruncateTail)
+	MaxSeq          uint64            // --max-seq value (0 = no limit)
+	Rlimits         []Rlimit          // --rlimit values (repeatable)
+	StdinTimeout    time.Duration     // --stdin-timeout value (0 = disabled)
+	StdinRateLimit  int               // --stdin-rate-limit value in bytes per second (0 = unlimited)
+	KeepOnError     bool              // --keep-on-error: keep the recording only if the child fails
+	OutputFormat    string            // --output-format value (FormatJSONL, FormatNDJSONSchema, FormatHTML, FormatSortedNDJSON or FormatJSONArray)
+	Header          bool              // --header: write a header line describing the recording first
+	MarkBursts      bool              // --mark-bursts: mark records read back-to-back as burst
+	Deltas          bool              // --deltas: add the time since the previous record of the same source
+	RecordReadSizes bool              // --record-read-sizes: record a read meta record for every read
+	PassFDs         []int             // --pass-fd values (repeatable), passed to the child as fd 3, 4, ...
+	Watch           []string          // --watch values (repeatable), glob patterns of files whose changes re-run the command
+	RetryCommand    int               // --retry-command value, times to re-run the command while it fails (0 = never)
+	RetryDelay      time.Duration     // --retry-delay value, time to wait before re-running the command
+	TimingStats     bool              // --record-timing-histogram: append a latency histogram record
+	Fields          map[string]string // --field values (repeatable), added to every record
+	GracePeriod     time.Duration     // --grace-period value (default: 5s)
+	StrictOrder     bool              // --strict-order: record in read order across sources
+	StdinEcho       bool              // --stdin-echo: record stdin records again as stdout
+	SplitStdin      bool              // --stdin-split-on-newline value (default: true; false = record stdin read by read)
+	AnnotatePrefix  string            // --annotate-stdin value (empty = no annotations)
+	StdinBroadcast  string            // --stdin-broadcast value, a named pipe or file that gets a copy of the forwarded stdin (empty = none)
+	NoBuffering     bool              // --no-buffering: write every record to the file right away
+	FlushOnLine     bool              // --flush-on-line: write every complete line to the file right away
+	OutSlog         bool              // --out-slog: also log every record as JSON to stderr
+	Progress        bool              // --progress: show the progress of the recording on stderr if it is a terminal
+	EscapeHTML      bool              // --escape-html (default) or --no-escape-html: escape <, > and & in record strings
+	JSONRawContent  bool              // --json-raw-content: write the content of json records as a string
+	LossyText       bool              // --lossy-text: record invalid UTF-8 as text with replaced bytes instead of as base64
+	RecordSignals   bool              // --record-signals: record forwarded signals as meta records
+	ConsumeUSR1     bool              // --consume-usr1: only sync the recording on SIGUSR1 instead of also forwarding it
+	ToggleSignal    syscall.Signal    // --toggle-signal value, which pauses and resumes the recording (0 = none)
+	ProcessReap     bool              // --process-reap: reap orphaned descendants of the child
+	RecordChecksums bool              // --record-checksums: add a chain hash to every record
+	CommandLabel    string            // --command-label value, recorded in the start meta record
+	Comment         string            // --comment value, recorded in the start meta record
+	CommentFile     string            // --comment-file value, a file whose content is recorded like Comment
+	RecordCWD       bool              // --record-cwd: add the child's working directory to the start meta record
+	RecordPath      bool              // --record-command-path: add the resolved path of the command to the start meta record
+	RecordIdentity  bool              // --record-identity: add the child's uid, gid and umask to the start meta record
+	RecordConfig    bool              // --record-config: add ioetap's version and effective options to the start meta record
+	ExitCodeMap     map[int]int       // --exit-code-map values, translating the exit code returned to the shell
+	SourceWeights   map[string]int    // --source-weight values, keyed by source name
+	QueueSize       int               // --queue-size value (0 = record under a mutex)
+	OTLPEndpoint    string            // --otlp-endpoint value (empty = no OTLP export)
+	S3Bucket        string            // --s3-bucket value, the bucket to upload the recording to (empty = no upload)
+	S3Key           string            // --s3-key value, the key of the uploaded recording
+	S3Region        string            // --s3-region value (empty = $AWS_REGION, $AWS_DEFAULT_REGION or us-east-1)
+	S3Endpoint      string            // --s3-endpoint value, the URL of an S3-compatible service (empty = AWS)
+	KafkaBrokers    []string          // --kafka-broker values (repeatable), the <host>:<port> of bootstrap brokers (empty = no Kafka)
+	KafkaTopic      string            // --kafka-topic value, the topic to publish the records to
+	MetricsAddr     string            // --metrics-addr value (empty = no metrics server)
+	ControlSocket   string            // --control-socket value, the path of a unix socket for runtime commands (empty = none)
+	ControlPerm     *fs.FileMode      // --control-socket-permissions value (nil = control.DefaultPerm)
+	PprofAddr       string            // --pprof value (empty = no profiling server)
+	CPUTimeRecord   time.Duration     // --cpu-time-record value (0 = disabled)
+	ErrorFormat     string            // --error-format value (ErrorFormatHuman or ErrorFormatJSON)
+	LogLevel        logging.Level     // --log-level value, or LevelDebug with --debug (default: LevelWarn)
+	Command         string            // First arg after --
+	Args            []string          // Remaining args after --
 }
 
-// Parse parses command-line arguments and returns Options.
-// Supports two modes:
-//   - With options: ioetap [options] -- <command> [args...]
-//   - Without options (backward compatible): ioetap <command> [args...]
+// Parse parses command-line arguments and returns Options:
+//
+//	ioetap [options] [--] <command> [args...]
+//
+// Options are read from the front up to the first argument that does not
+// start with a dash, which is the command. The -- separator is only needed
+// if the command itself starts with a dash.
 func Parse(args []string) (*Options, error) {
 	if len(args) == 0 {
 		return nil, errors.New("no command specified")
 	}
 
-	// Find -- separator position
-	separatorIdx := -1
-	for i, arg := range args {
-		if arg == "--" {
-			separatorIdx = i
-			break
-		}
-	}
-
 	opts := &Options{
 		MaxLineLength: DefaultMaxLineLength,
+		OutputFormat:  FormatJSONL,
+		LongLineMode:  LongLineTruncate,
+		Truncation:    TruncateHead,
+		RemoteOnError: RemoteOnErrorContinue,
+		GracePeriod:   DefaultGracePeriod,
+		ErrorFormat:   ErrorFormatHuman,
+		EscapeHTML:    true,
+		SplitStdin:    true,
+		HTTPKeepAlive: true,
+		LogLevel:      logging.LevelWarn,
 	}
 
-	if separatorIdx == -1 {
-		// No separator found
-		// If first arg starts with -, it's an option and requires separator
-		if strings.HasPrefix(args[0], "-") {
-			// Check if it's a known option to give a better error message
-			if isKnownOption(args[0]) {
-				return nil, errors.New("use -- separator when specifying options")
-			}
-			return nil, fmt.Errorf("unknown option: %s", args[0])
-		}
-		// Backward compatible mode: treat all args as command and args
-		opts.Command = args[0]
-		if len(args) > 1 {
-			opts.Args = args[1:]
-		}
-		return opts, nil
-	}
-
-	// Parse options before --
-	optionArgs := args[:separatorIdx]
-	if err := parseOptions(opts, optionArgs); err != nil {
+	n, err := parseOptions(opts, args)
+	if err != nil {
 		return nil, err
 	}
+	if opts.Comment != "" && opts.CommentFile != "" {
+		return nil, errors.New("--comment cannot be used with --comment-file")
+	}
+	if retryInitial, retryCap := retryDelays(opts); retryInitial > retryCap {
+		return nil, fmt.Errorf("--sink-retry-initial (%v) cannot be greater than --sink-retry-cap (%v)", retryInitial, retryCap)
+	}
+	// The passed descriptors are closed once the first run has started
+	if len(opts.Watch) > 0 && len(opts.PassFDs) > 0 {
+		return nil, errors.New("--watch cannot be used with --pass-fd")
+	}
+	// Either line would have to come first
+	if opts.Header && opts.OutputFormat == FormatNDJSONSchema {
+		return nil, fmt.Errorf("--header cannot be used with --output-format=%s", FormatNDJSONSchema)
+	}
+	// The header line is not a record, so it cannot be an element of the array
+	if opts.Header && opts.OutputFormat == FormatJSONArray {
+		return nil, fmt.Errorf("--header cannot be used with --output-format=%s", FormatJSONArray)
+	}
+	if len(opts.Watch) > 0 && opts.RetryCommand > 0 {
+		return nil, errors.New("--watch cannot be used with --retry-command")
+	}
+	// Sources do not contend in queued mode
+	if opts.QueueSize > 0 && len(opts.SourceWeights) > 0 {
+		return nil, errors.New("--source-weight cannot be used with --queue-size")
+	}
+	if (opts.S3Bucket == "") != (opts.S3Key == "") {
+		return nil, errors.New("--s3-bucket and --s3-key must be used together")
+	}
+	if opts.S3Bucket == "" && (opts.S3Region != "" || opts.S3Endpoint != "") {
+		return nil, errors.New("--s3-region and --s3-endpoint require --s3-bucket")
+	}
+	if (len(opts.KafkaBrokers) == 0) != (opts.KafkaTopic == "") {
+		return nil, errors.New("--kafka-broker and --kafka-topic must be used together")
+	}
 
-	// Parse command and args after --
-	commandArgs := args[separatorIdx+1:]
+	// The command and its args follow the options and the optional --
+	commandArgs := args[n:]
+	if len(commandArgs) > 0 && commandArgs[0] == "--" {
+		commandArgs = commandArgs[1:]
+	}
 	if len(commandArgs) == 0 {
 		return nil, errors.New("no command specified")
 	}
@@ -78,92 +224,685 @@ func Parse(args []string) (*Options, error) {
 	return opts, nil
 }
 
-// parseOptions parses the options before the -- separator.
-func parseOptions(opts *Options, args []string) error {
+// valueOptions lists the options that take a value, given either as
+// --key=value or --key value.
+var valueOptions = []string{
+	"--out",
+	"--output-file-permissions",
+	"--max-line-length",
+	"--truncate-binary",
+	"--long-line-mode",
+	"--truncation-strategy",
+	"--remote-on-error",
+	"--sink-retry-initial",
+	"--sink-retry-cap",
+	"--sink-retry-max",
+	"--http-keep-alive",
+	"--http-max-idle-conns",
+	"--max-seq",
+	"--rlimit",
+	"--rlimit-as",
+	"--rlimit-core",
+	"--rlimit-cpu",
+	"--rlimit-data",
+	"--rlimit-fsize",
+	"--rlimit-nofile",
+	"--rlimit-stack",
+	"--stdin-timeout",
+	"--stdin-rate-limit",
+	"--stdin-split-on-newline",
+	"--annotate-stdin",
+	"--stdin-broadcast",
+	"--output-format",
+	"--pass-fd",
+	"--watch",
+	"--retry-command",
+	"--retry-delay",
+	"--field",
+	"--grace-period",
+	"--command-label",
+	"--comment",
+	"--comment-file",
+	"--exit-code-map",
+	"--source-weight",
+	"--queue-size",
+	"--otlp-endpoint",
+	"--s3-bucket",
+	"--s3-key",
+	"--s3-region",
+	"--s3-endpoint",
+	"--kafka-broker",
+	"--kafka-topic",
+	"--metrics-addr",
+	"--control-socket",
+	"--control-socket-permissions",
+	"--pprof",
+	"--cpu-time-record",
+	"--error-format",
+	"--log-level",
+	"--toggle-signal",
+}
+
+// toggleSignals are the signals --toggle-signal accepts, by name without
+// the SIG prefix. ioetap otherwise forwards them without handling them.
+var toggleSignals = map[string]syscall.Signal{
+	"USR2": syscall.SIGUSR2,
+	"QUIT": syscall.SIGQUIT,
+}
+
+// flagOptions lists the options that take no value.
+var flagOptions = []string{
+	"--keep-on-error",
+	"--header",
+	"--mark-bursts",
+	"--deltas",
+	"--record-read-sizes",
+	"--record-timing-histogram",
+	"--strict-order",
+	"--stdin-echo",
+	"--record-cwd",
+	"--record-command-path",
+	"--record-identity",
+	"--record-config",
+	"--no-buffering",
+	"--flush-on-line",
+	"--force-flush-on-newline",
+	"--flush-every-record",
+	"--out-slog",
+	"--progress",
+	"--escape-html",
+	"--no-escape-html",
+	"--json-raw-content",
+	"--lossy-text",
+	"--record-signals",
+	"--consume-usr1",
+	"--process-reap",
+	"--record-checksums",
+	"--debug",
+}
+
+// parseOptions parses the options at the front of args and returns the
+// number of arguments it consumed. It stops at the -- separator or at the
+// first argument that does not start with a dash.
+func parseOptions(opts *Options, args []string) (int, error) {
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 
-		if !strings.HasPrefix(arg, "-") {
-			// Non-option argument before -- means user forgot separator
-			return fmt.Errorf("use -- separator when specifying options (found: %s)", arg)
-		}
-
-		// Handle --key=value format
-		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
-			parts := strings.SplitN(arg, "=", 2)
-			key := parts[0]
-			value := parts[1]
-
-			switch key {
-			case "--out":
-				opts.OutputFile = value
-			case "--max-line-length":
-				n, err := strconv.Atoi(value)
-				if err != nil {
-					return fmt.Errorf("--max-line-length requires an integer value: %s", value)
-				}
-				if n < 0 {
-					return errors.New("--max-line-length cannot be negative")
-				}
-				opts.MaxLineLength = n
-			default:
-				return fmt.Errorf("unknown option: %s", key)
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			// The command (or the separator before it) ends the options
+			return i, nil
+		}
+
+		// Only long options support the --key=value format
+		key, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") {
+			key, value, hasValue = strings.Cut(arg, "=")
+		}
+
+		if slices.Contains(flagOptions, key) {
+			if hasValue {
+				return 0, fmt.Errorf("%s does not take a value", key)
 			}
+			setFlag(opts, key)
 			continue
 		}
 
+		if !slices.Contains(valueOptions, key) {
+			return 0, fmt.Errorf("unknown option: %s", key)
+		}
+
 		// Handle --key value format
-		switch arg {
-		case "--out":
+		if !hasValue {
 			if i+1 >= len(args) {
-				return errors.New("--out requires a value")
+				return 0, fmt.Errorf("%s requires a value", key)
 			}
 			nextArg := args[i+1]
-			// Check if next arg looks like another option or is the separator
-			if nextArg == "--" || (strings.HasPrefix(nextArg, "-") && !isPathLike(nextArg)) {
-				return errors.New("--out requires a value")
+			// Check if next arg looks like another option or is the separator.
+			// Output files may start with a dash if they look like a path.
+			if nextArg == "--" || (strings.HasPrefix(nextArg, "-") && !(key == "--out" && isPathLike(nextArg))) {
+				return 0, fmt.Errorf("%s requires a value", key)
 			}
-			opts.OutputFile = nextArg
+			value = nextArg
 			i++ // Skip the value
-		case "--max-line-length":
-			if i+1 >= len(args) {
-				return errors.New("--max-line-length requires a value")
+		}
+
+		if err := setOption(opts, key, value); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(args), nil
+}
+
+// setOption sets the option with the given key to the given value.
+func setOption(opts *Options, key, value string) error {
+	switch key {
+	case "--out":
+		if addr, ok := strings.CutPrefix(value, remoteOutputScheme); ok {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("--out requires %s<host>:<port> for a remote destination: %s", remoteOutputScheme, value)
 			}
-			nextArg := args[i+1]
-			if nextArg == "--" || strings.HasPrefix(nextArg, "-") {
-				return errors.New("--max-line-length requires a value")
+			opts.RemoteOutputs = append(opts.RemoteOutputs, addr)
+			break
+		}
+		if isHTTPOutput(value) {
+			if u, err := url.Parse(value); err != nil || u.Host == "" {
+				return fmt.Errorf("--out requires a URL with a host for an HTTP destination: %s", value)
 			}
-			n, err := strconv.Atoi(nextArg)
+			opts.HTTPOutputs = append(opts.HTTPOutputs, value)
+			break
+		}
+		if opts.OutputFile != "" {
+			return fmt.Errorf("--out can name only one file (other --out values must be %s<host>:<port> or http(s):// URLs): %s", remoteOutputScheme, value)
+		}
+		opts.OutputFile = value
+	case "--remote-on-error":
+		if value != RemoteOnErrorContinue && value != RemoteOnErrorAbort {
+			return fmt.Errorf("--remote-on-error must be one of %s, %s: %s", RemoteOnErrorContinue, RemoteOnErrorAbort, value)
+		}
+		opts.RemoteOnError = value
+	case "--sink-retry-initial", "--sink-retry-cap":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		if d == 0 {
+			return fmt.Errorf("%s must be greater than zero", key)
+		}
+		if key == "--sink-retry-initial" {
+			opts.RetryInitial = d
+		} else {
+			opts.RetryCap = d
+		}
+	case "--sink-retry-max":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--sink-retry-max requires an integer value: %s", value)
+		}
+		if n < 0 {
+			return errors.New("--sink-retry-max cannot be negative")
+		}
+		opts.RetryMax = n
+	case "--http-keep-alive":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("--http-keep-alive requires true or false: %s", value)
+		}
+		opts.HTTPKeepAlive = b
+	case "--http-max-idle-conns":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--http-max-idle-conns requires an integer value: %s", value)
+		}
+		if n <= 0 {
+			return errors.New("--http-max-idle-conns must be greater than zero")
+		}
+		opts.HTTPIdleConns = n
+	case "--output-file-permissions":
+		n, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return fmt.Errorf("--output-file-permissions requires an octal value: %s", value)
+		}
+		if n > 0o777 {
+			return fmt.Errorf("--output-file-permissions must be between 0000 and 0777: %s", value)
+		}
+		perm := fs.FileMode(n)
+		opts.OutputFilePerm = &perm
+	case "--max-line-length":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--max-line-length requires an integer value: %s", value)
+		}
+		if n < 0 {
+			return errors.New("--max-line-length cannot be negative")
+		}
+		opts.MaxLineLength = n
+	case "--truncate-binary":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--truncate-binary requires an integer value: %s", value)
+		}
+		if n <= 0 {
+			return errors.New("--truncate-binary must be positive")
+		}
+		opts.BinaryLimit = n
+	case "--long-line-mode":
+		if value != LongLineTruncate && value != LongLineSplit {
+			return fmt.Errorf("--long-line-mode must be one of %s, %s: %s", LongLineTruncate, LongLineSplit, value)
+		}
+		opts.LongLineMode = value
+	case "--truncation-strategy":
+		if value != TruncateHead && value != TruncateTail {
+			return fmt.Errorf("--truncation-strategy must be one of %s, %s: %s", TruncateHead, TruncateTail, value)
+		}
+		opts.Truncation = value
+	case "--max-seq":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("--max-seq requires a non-negative integer value: %s", value)
+		}
+		if n == 0 {
+			return errors.New("--max-seq must be positive")
+		}
+		opts.MaxSeq = n
+	case "--rlimit":
+		rlimit, err := parseRlimit(value)
+		if err != nil {
+			return err
+		}
+		opts.Rlimits = append(opts.Rlimits, rlimit)
+	case "--stdin-timeout":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		opts.StdinTimeout = d
+	case "--cpu-time-record":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		if d == 0 {
+			return errors.New("--cpu-time-record must be greater than zero")
+		}
+		opts.CPUTimeRecord = d
+	case "--stdin-rate-limit":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--stdin-rate-limit requires an integer value: %s", value)
+		}
+		if n <= 0 {
+			return errors.New("--stdin-rate-limit must be positive")
+		}
+		opts.StdinRateLimit = n
+	case "--stdin-split-on-newline":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("--stdin-split-on-newline requires true or false: %s", value)
+		}
+		opts.SplitStdin = b
+	case "--annotate-stdin":
+		if value == "" {
+			return errors.New("--annotate-stdin cannot be empty")
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("--annotate-stdin cannot contain a line ending: %q", value)
+		}
+		opts.AnnotatePrefix = value
+	case "--toggle-signal":
+		name := strings.TrimPrefix(strings.ToUpper(value), "SIG")
+		sig, ok := toggleSignals[name]
+		if !ok {
+			return fmt.Errorf("--toggle-signal must be one of USR2, QUIT: %s", value)
+		}
+		opts.ToggleSignal = sig
+	case "--stdin-broadcast":
+		if value == "" {
+			return errors.New("--stdin-broadcast cannot be empty")
+		}
+		opts.StdinBroadcast = value
+	case "--grace-period":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		opts.GracePeriod = d
+	case "--output-format":
+		if value == FormatCompactJSONL {
+			value = FormatJSONL
+		}
+		if value != FormatJSONL && value != FormatNDJSONSchema && value != FormatHTML && value != FormatSortedNDJSON && value != FormatJSONArray {
+			return fmt.Errorf("--output-format must be one of %s, %s, %s, %s, %s, %s: %s", FormatJSONL, FormatCompactJSONL, FormatNDJSONSchema, FormatHTML, FormatSortedNDJSON, FormatJSONArray, value)
+		}
+		opts.OutputFormat = value
+	case "--error-format":
+		if value != ErrorFormatHuman && value != ErrorFormatJSON {
+			return fmt.Errorf("--error-format must be one of %s, %s: %s", ErrorFormatHuman, ErrorFormatJSON, value)
+		}
+		opts.ErrorFormat = value
+	case "--log-level":
+		level, err := logging.ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("--log-level must be one of error, warn, info, debug: %s", value)
+		}
+		opts.LogLevel = level
+	case "--pass-fd":
+		fd, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--pass-fd requires an integer value: %s", value)
+		}
+		if fd < 3 {
+			return fmt.Errorf("--pass-fd must be 3 or greater (0-2 are the standard streams): %d", fd)
+		}
+		if slices.Contains(opts.PassFDs, fd) {
+			return fmt.Errorf("--pass-fd %d specified more than once", fd)
+		}
+		opts.PassFDs = append(opts.PassFDs, fd)
+	case "--watch":
+		if _, err := filepath.Match(value, ""); err != nil {
+			return fmt.Errorf("--watch has an invalid pattern: %s", value)
+		}
+		opts.Watch = append(opts.Watch, value)
+	case "--retry-command":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--retry-command requires an integer value: %s", value)
+		}
+		if n < 0 {
+			return errors.New("--retry-command cannot be negative")
+		}
+		opts.RetryCommand = n
+	case "--retry-delay":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		opts.RetryDelay = d
+	case "--field":
+		k, v, ok := strings.Cut(value, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("--field must be in <key>=<value> format: %s", value)
+		}
+		if slices.Contains(recorder.BuiltinFields, k) {
+			return fmt.Errorf("--field cannot use the built-in field name: %s", k)
+		}
+		if _, exists := opts.Fields[k]; exists {
+			return fmt.Errorf("--field %s specified more than once", k)
+		}
+		if opts.Fields == nil {
+			opts.Fields = make(map[string]string)
+		}
+		opts.Fields[k] = v
+	case "--command-label":
+		if value == "" {
+			return errors.New("--command-label cannot be empty")
+		}
+		opts.CommandLabel = value
+	case "--comment":
+		if value == "" {
+			return errors.New("--comment cannot be empty")
+		}
+		opts.Comment = value
+	case "--comment-file":
+		if value == "" {
+			return errors.New("--comment-file cannot be empty")
+		}
+		opts.CommentFile = value
+	case "--exit-code-map":
+		if err := parseExitCodeMap(opts, value); err != nil {
+			return err
+		}
+	case "--source-weight":
+		if err := parseSourceWeights(opts, value); err != nil {
+			return err
+		}
+	case "--queue-size":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--queue-size requires an integer value: %s", value)
+		}
+		if n <= 0 {
+			return errors.New("--queue-size must be greater than zero")
+		}
+		opts.QueueSize = n
+	case "--otlp-endpoint":
+		if value == "" {
+			return errors.New("--otlp-endpoint cannot be empty")
+		}
+		opts.OTLPEndpoint = value
+	case "--s3-bucket":
+		if value == "" {
+			return errors.New("--s3-bucket cannot be empty")
+		}
+		opts.S3Bucket = value
+	case "--s3-key":
+		if value == "" {
+			return errors.New("--s3-key cannot be empty")
+		}
+		opts.S3Key = value
+	case "--s3-region":
+		if value == "" {
+			return errors.New("--s3-region cannot be empty")
+		}
+		opts.S3Region = value
+	case "--s3-endpoint":
+		if value == "" {
+			return errors.New("--s3-endpoint cannot be empty")
+		}
+		opts.S3Endpoint = value
+	case "--kafka-broker":
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			return fmt.Errorf("--kafka-broker requires <host>:<port>: %s", value)
+		}
+		opts.KafkaBrokers = append(opts.KafkaBrokers, value)
+	case "--kafka-topic":
+		if value == "" {
+			return errors.New("--kafka-topic cannot be empty")
+		}
+		opts.KafkaTopic = value
+	case "--metrics-addr":
+		if value == "" {
+			return errors.New("--metrics-addr cannot be empty")
+		}
+		opts.MetricsAddr = value
+	case "--control-socket":
+		if value == "" {
+			return errors.New("--control-socket cannot be empty")
+		}
+		opts.ControlSocket = value
+	case "--control-socket-permissions":
+		n, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return fmt.Errorf("--control-socket-permissions requires an octal value: %s", value)
+		}
+		if n == 0 || n > 0o777 {
+			return fmt.Errorf("--control-socket-permissions must be between 0001 and 0777: %s", value)
+		}
+		perm := fs.FileMode(n)
+		opts.ControlPerm = &perm
+	case "--pprof":
+		if value == "" {
+			return errors.New("--pprof cannot be empty")
+		}
+		opts.PprofAddr = value
+	default:
+		// --rlimit-<name>=<value> is a shorthand for --rlimit=<name>=<value>
+		if name, ok := strings.CutPrefix(key, "--rlimit-"); ok {
+			rlimit, err := parseRlimit(name + "=" + value)
 			if err != nil {
-				return fmt.Errorf("--max-line-length requires an integer value: %s", nextArg)
-			}
-			if n < 0 {
-				return errors.New("--max-line-length cannot be negative")
+				return err
 			}
-			opts.MaxLineLength = n
-			i++ // Skip the value
-		default:
-			return fmt.Errorf("unknown option: %s", arg)
+			opts.Rlimits = append(opts.Rlimits, rlimit)
+		}
+	}
+	return nil
+}
+
+// setFlag sets the flag option with the given key.
+func setFlag(opts *Options, key string) {
+	switch key {
+	case "--keep-on-error":
+		opts.KeepOnError = true
+	case "--header":
+		opts.Header = true
+	case "--mark-bursts":
+		opts.MarkBursts = true
+	case "--deltas":
+		opts.Deltas = true
+	case "--record-read-sizes":
+		opts.RecordReadSizes = true
+	case "--record-timing-histogram":
+		opts.TimingStats = true
+	case "--strict-order":
+		opts.StrictOrder = true
+	case "--stdin-echo":
+		opts.StdinEcho = true
+	case "--record-cwd":
+		opts.RecordCWD = true
+	case "--record-command-path":
+		opts.RecordPath = true
+	case "--no-buffering", "--flush-every-record":
+		opts.NoBuffering = true
+	case "--flush-on-line", "--force-flush-on-newline":
+		opts.FlushOnLine = true
+	case "--lossy-text":
+		opts.LossyText = true
+	case "--record-signals":
+		opts.RecordSignals = true
+	case "--consume-usr1":
+		opts.ConsumeUSR1 = true
+	case "--process-reap":
+		opts.ProcessReap = true
+	case "--record-checksums":
+		opts.RecordChecksums = true
+	case "--debug":
+		opts.LogLevel = logging.LevelDebug
+	case "--out-slog":
+		opts.OutSlog = true
+	case "--progress":
+		opts.Progress = true
+	case "--escape-html":
+		opts.EscapeHTML = true
+	case "--no-escape-html":
+		opts.EscapeHTML = false
+	case "--json-raw-content":
+		opts.JSONRawContent = true
+	case "--record-identity":
+		opts.RecordIdentity = true
+	case "--record-config":
+		opts.RecordConfig = true
+	}
+}
+
+// parseExitCodeMap adds the comma-separated <src>:<dst> pairs of an
+// --exit-code-map value to opts.ExitCodeMap.
+func parseExitCodeMap(opts *Options, value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		src, dst, ok := strings.Cut(pair, ":")
+		if !ok {
+			return fmt.Errorf("--exit-code-map must be comma-separated <src>:<dst> pairs: %s", value)
 		}
+		from, err := parseExitCode(src)
+		if err != nil {
+			return err
+		}
+		to, err := parseExitCode(dst)
+		if err != nil {
+			return err
+		}
+		if _, exists := opts.ExitCodeMap[from]; exists {
+			return fmt.Errorf("--exit-code-map maps exit code %d more than once", from)
+		}
+		if opts.ExitCodeMap == nil {
+			opts.ExitCodeMap = make(map[int]int)
+		}
+		opts.ExitCodeMap[from] = to
+	}
+	return nil
+}
+
+// parseExitCode parses an exit code of an --exit-code-map pair.
+func parseExitCode(value string) (int, error) {
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 0 || code > 255 {
+		return 0, fmt.Errorf("--exit-code-map requires exit codes from 0 to 255: %s", value)
 	}
+	return code, nil
+}
 
+// parseSourceWeights adds the comma-separated <source>:<weight> pairs of a
+// --source-weight value to opts.SourceWeights.
+func parseSourceWeights(opts *Options, value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		source, w, ok := strings.Cut(pair, ":")
+		if !ok {
+			return fmt.Errorf("--source-weight must be comma-separated <source>:<weight> pairs: %s", value)
+		}
+		if source != "stdin" && source != "stdout" && source != "stderr" {
+			return fmt.Errorf("--source-weight requires stdin, stdout or stderr: %s", source)
+		}
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("--source-weight requires a positive integer weight: %s", w)
+		}
+		if _, exists := opts.SourceWeights[source]; exists {
+			return fmt.Errorf("--source-weight specifies %s more than once", source)
+		}
+		if opts.SourceWeights == nil {
+			opts.SourceWeights = make(map[string]int)
+		}
+		opts.SourceWeights[source] = weight
+	}
 	return nil
 }
 
-// isPathLike checks if a string looks like a file path rather than an option.
-// This allows values like "-output.jsonl" or "./--weird-file.jsonl".
-func isPathLike(s string) bool {
-	// If it contains a path separator or file extension, it's likely a path
-	return strings.Contains(s, "/") || strings.Contains(s, ".")
+// retryDelays returns the effective --sink-retry-initial and
+// --sink-retry-cap values, including the defaults.
+func retryDelays(opts *Options) (initial, limit time.Duration) {
+	initial, limit = opts.RetryInitial, opts.RetryCap
+	if initial == 0 {
+		initial = recorder.DefaultRetryInitial
+	}
+	if limit == 0 {
+		limit = recorder.DefaultRetryCap
+	}
+	return initial, limit
 }
 
-// isKnownOption checks if the argument is a known option (with or without value).
-func isKnownOption(arg string) bool {
-	if arg == "--out" || arg == "--max-line-length" {
-		return true
+// parseDuration parses a non-negative duration given to the named option,
+// either in Go duration format (e.g. "1m30s") or as a number of seconds.
+func parseDuration(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		n, atoiErr := strconv.Atoi(value)
+		if atoiErr != nil {
+			return 0, fmt.Errorf("%s requires a duration value (e.g. 5s): %s", name, value)
+		}
+		d = time.Duration(n) * time.Second
 	}
-	if strings.HasPrefix(arg, "--out=") || strings.HasPrefix(arg, "--max-line-length=") {
-		return true
+	if d < 0 {
+		return 0, fmt.Errorf("%s cannot be negative", name)
+	}
+	return d, nil
+}
+
+// ParseErrorFormat returns the --error-format value among the options before
+// the command, or ErrorFormatHuman if there is none or it is invalid. It
+// lets errors from Parse be reported in the requested format.
+func ParseErrorFormat(args []string) string {
+	format := ErrorFormatHuman
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		value, ok := strings.CutPrefix(arg, "--error-format=")
+		if arg == "--error-format" && i+1 < len(args) {
+			value, ok = args[i+1], true
+		}
+		if ok && (value == ErrorFormatHuman || value == ErrorFormatJSON) {
+			format = value
+		}
+		if slices.Contains(valueOptions, arg) {
+			i++ // Skip the value
+		}
+	}
+	return format
+}
+
+// isHTTPOutput reports whether an --out value names an HTTP destination.
+func isHTTPOutput(value string) bool {
+	for _, scheme := range httpOutputSchemes {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
 	}
 	return false
 }
+
+// isPathLike checks if a string looks like a file path rather than an option.
+// This allows values like "-output.jsonl" or "./--weird-file.jsonl".
+func isPathLike(s string) bool {
+	// If it contains a path separator or file extension, it's likely a path
+	return strings.Contains(s, "/") || strings.Contains(s, ".")
+}
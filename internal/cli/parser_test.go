@@ -1,7 +1,14 @@
 package cli
 
 import (
+	"io/fs"
+	"math"
+	"slices"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
 )
 
 func TestParse_CommandOnly(t *testing.T) {
@@ -41,6 +48,16 @@ func TestParse_CommandOnly(t *testing.T) {
 			args: []string{"--", "-c", "script.sh"},
 			want: &Options{Command: "-c", Args: []string{"script.sh"}},
 		},
+		{
+			name: "command named like an option after separator",
+			args: []string{"--", "--out", "x.jsonl"},
+			want: &Options{Command: "--out", Args: []string{"x.jsonl"}},
+		},
+		{
+			name: "option-like command args",
+			args: []string{"echo", "--out=x.jsonl", "--", "-n"},
+			want: &Options{Command: "echo", Args: []string{"--out=x.jsonl", "--", "-n"}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -114,6 +131,16 @@ func TestParse_WithOutOption(t *testing.T) {
 			args: []string{"--out", "-output.jsonl", "--", "ls"},
 			want: &Options{OutputFile: "-output.jsonl", Command: "ls"},
 		},
+		{
+			name: "out option without separator",
+			args: []string{"--out", "x.jsonl", "echo", "hi"},
+			want: &Options{OutputFile: "x.jsonl", Command: "echo", Args: []string{"hi"}},
+		},
+		{
+			name: "out option with equals without separator",
+			args: []string{"--out=x.jsonl", "grep", "--", "-v"},
+			want: &Options{OutputFile: "x.jsonl", Command: "grep", Args: []string{"--", "-v"}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,14 +189,14 @@ func TestParse_Errors(t *testing.T) {
 			wantErrMsg: "no command specified",
 		},
 		{
-			name:       "option without separator then command",
-			args:       []string{"--out=file.jsonl", "ls"},
-			wantErrMsg: "use -- separator when specifying options",
+			name:       "option without command",
+			args:       []string{"--keep-on-error"},
+			wantErrMsg: "no command specified",
 		},
 		{
-			name:       "option with space without separator then command",
-			args:       []string{"--out", "file.jsonl", "ls"},
-			wantErrMsg: "use -- separator when specifying options",
+			name:       "command named like an option without separator",
+			args:       []string{"--out=x.jsonl", "--out"},
+			wantErrMsg: "--out requires a value",
 		},
 		{
 			name:       "unknown option with separator",
@@ -189,7 +216,7 @@ func TestParse_Errors(t *testing.T) {
 		{
 			name:       "out option without value at end",
 			args:       []string{"--out"},
-			wantErrMsg: "use -- separator when specifying options",
+			wantErrMsg: "--out requires a value",
 		},
 		{
 			name:       "out option followed by separator",
@@ -283,6 +310,106 @@ func TestParse_EdgeCases(t *testing.T) {
 	}
 }
 
+func TestParse_TruncateBinary(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, 0, false},
+		{"with equals", []string{"--truncate-binary=1024", "--", "ls"}, 1024, false},
+		{"with space", []string{"--truncate-binary", "64", "--", "ls"}, 64, false},
+		{"zero", []string{"--truncate-binary=0", "--", "ls"}, 0, true},
+		{"negative", []string{"--truncate-binary=-1", "--", "ls"}, 0, true},
+		{"not a number", []string{"--truncate-binary=1k", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.BinaryLimit != tt.want {
+				t.Errorf("BinaryLimit = %v, want %v", got.BinaryLimit, tt.want)
+			}
+			if got.MaxLineLength != DefaultMaxLineLength {
+				t.Errorf("MaxLineLength = %v, want %v", got.MaxLineLength, DefaultMaxLineLength)
+			}
+		})
+	}
+}
+
+func TestParse_MaxSeq(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    uint64
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, 0, false},
+		{"with equals", []string{"--max-seq=1000", "--", "ls"}, 1000, false},
+		{"with space", []string{"--max-seq", "5", "--", "ls"}, 5, false},
+		{"max uint64", []string{"--max-seq=18446744073709551615", "--", "ls"}, math.MaxUint64, false},
+		{"zero", []string{"--max-seq=0", "--", "ls"}, 0, true},
+		{"negative", []string{"--max-seq=-1", "--", "ls"}, 0, true},
+		{"overflow", []string{"--max-seq=18446744073709551616", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.MaxSeq != tt.want {
+				t.Errorf("MaxSeq = %v, want %v", got.MaxSeq, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_StdinRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, 0, false},
+		{"with equals", []string{"--stdin-rate-limit=100", "--", "ls"}, 100, false},
+		{"with space", []string{"--stdin-rate-limit", "4096", "--", "ls"}, 4096, false},
+		{"zero", []string{"--stdin-rate-limit=0", "--", "ls"}, 0, true},
+		{"negative", []string{"--stdin-rate-limit=-1", "--", "ls"}, 0, true},
+		{"not a number", []string{"--stdin-rate-limit=fast", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.StdinRateLimit != tt.want {
+				t.Errorf("StdinRateLimit = %v, want %v", got.StdinRateLimit, tt.want)
+			}
+		})
+	}
+}
+
 func TestParse_MaxLineLengthOption(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -399,3 +526,1458 @@ func TestParse_DefaultMaxLineLength(t *testing.T) {
 		t.Errorf("DefaultMaxLineLength = %v, want 16 MiB (%v)", DefaultMaxLineLength, 16*1024*1024)
 	}
 }
+
+func TestParse_KeepOnError(t *testing.T) {
+	got, err := Parse([]string{"--keep-on-error", "--out=test.jsonl", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.KeepOnError {
+		t.Error("KeepOnError = false, want true")
+	}
+	if got.OutputFile != "test.jsonl" {
+		t.Errorf("OutputFile = %v, want test.jsonl", got.OutputFile)
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.KeepOnError {
+		t.Error("KeepOnError = true by default, want false")
+	}
+
+	_, err = Parse([]string{"--keep-on-error=yes", "--", "ls"})
+	if err == nil || !containsString(err.Error(), "--keep-on-error does not take a value") {
+		t.Errorf("Parse() error = %v, want error about value", err)
+	}
+
+	got, err = Parse([]string{"--keep-on-error", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.KeepOnError || got.Command != "ls" {
+		t.Errorf("Parse() = %+v, want KeepOnError with command ls", got)
+	}
+}
+
+func TestParse_OutputFilePerm(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		want       fs.FileMode
+		wantErrMsg string // empty = no error
+	}{
+		{"octal with leading zero", []string{"--output-file-permissions=0600", "ls"}, 0o600, ""},
+		{"octal without leading zero", []string{"--output-file-permissions", "640", "ls"}, 0o640, ""},
+		{"zero", []string{"--output-file-permissions=0000", "ls"}, 0, ""},
+		{"max", []string{"--output-file-permissions=0777", "ls"}, 0o777, ""},
+		{"not octal", []string{"--output-file-permissions=0680", "ls"}, 0, "requires an octal value"},
+		{"out of range", []string{"--output-file-permissions=1777", "ls"}, 0, "must be between 0000 and 0777"},
+		{"empty", []string{"--output-file-permissions=", "ls"}, 0, "requires an octal value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if tt.wantErrMsg != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+					t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.OutputFilePerm == nil || *got.OutputFilePerm != tt.want {
+				t.Errorf("OutputFilePerm = %v, want %v", got.OutputFilePerm, tt.want)
+			}
+		})
+	}
+
+	got, err := Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.OutputFilePerm != nil {
+		t.Errorf("OutputFilePerm = %v by default, want nil", *got.OutputFilePerm)
+	}
+}
+
+func TestParse_TruncationStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, TruncateHead, false},
+		{"head", []string{"--truncation-strategy=head", "ls"}, TruncateHead, false},
+		{"tail", []string{"--truncation-strategy", "tail", "ls"}, TruncateTail, false},
+		{"unsupported", []string{"--truncation-strategy=middle", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Truncation != tt.want {
+				t.Errorf("Truncation = %v, want %v", got.Truncation, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_OutputFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, FormatJSONL, false},
+		{"jsonl", []string{"--output-format=jsonl", "--", "ls"}, FormatJSONL, false},
+		{"ndjson-schema", []string{"--output-format=ndjson-schema", "--", "ls"}, FormatNDJSONSchema, false},
+		{"html", []string{"--output-format=html", "--", "ls"}, FormatHTML, false},
+		{"html with space", []string{"--output-format", "html", "--", "ls"}, FormatHTML, false},
+		{"newline-json-sorted", []string{"--output-format=newline-json-sorted", "--", "ls"}, FormatSortedNDJSON, false},
+		{"jsonl-compact", []string{"--output-format=jsonl-compact", "--", "ls"}, FormatJSONL, false},
+		{"json-array", []string{"--output-format=json-array", "--", "ls"}, FormatJSONArray, false},
+		{"unsupported", []string{"--output-format=xml", "--", "ls"}, "", true},
+		{"missing value", []string{"--output-format", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.OutputFormat != tt.want {
+				t.Errorf("OutputFormat = %v, want %v", got.OutputFormat, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_LongLineMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, LongLineTruncate, false},
+		{"truncate", []string{"--long-line-mode=truncate", "--", "ls"}, LongLineTruncate, false},
+		{"split", []string{"--long-line-mode=split", "--", "ls"}, LongLineSplit, false},
+		{"split with space", []string{"--long-line-mode", "split", "--", "ls"}, LongLineSplit, false},
+		{"unsupported", []string{"--long-line-mode=wrap", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.LongLineMode != tt.want {
+				t.Errorf("LongLineMode = %v, want %v", got.LongLineMode, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_ErrorFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, ErrorFormatHuman, false},
+		{"human", []string{"--error-format=human", "--", "ls"}, ErrorFormatHuman, false},
+		{"json", []string{"--error-format=json", "--", "ls"}, ErrorFormatJSON, false},
+		{"json with space", []string{"--error-format", "json", "--", "ls"}, ErrorFormatJSON, false},
+		{"unsupported", []string{"--error-format=xml", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.ErrorFormat != tt.want {
+				t.Errorf("ErrorFormat = %v, want %v", got.ErrorFormat, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrorFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"none", []string{"--bogus", "--", "ls"}, ErrorFormatHuman},
+		{"json", []string{"--bogus", "--error-format=json", "--", "ls"}, ErrorFormatJSON},
+		{"json with space", []string{"--error-format", "json", "--max-seq=x", "--", "ls"}, ErrorFormatJSON},
+		{"invalid", []string{"--error-format=xml", "--", "ls"}, ErrorFormatHuman},
+		{"after separator", []string{"--", "ls", "--error-format=json"}, ErrorFormatHuman},
+		{"before command", []string{"--error-format=json", "ls"}, ErrorFormatJSON},
+		{"after command", []string{"--out", "x.jsonl", "ls", "--error-format=json"}, ErrorFormatHuman},
+		{"missing value", []string{"--error-format"}, ErrorFormatHuman},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseErrorFormat(tt.args); got != tt.want {
+				t.Errorf("ParseErrorFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Deltas(t *testing.T) {
+	got, err := Parse([]string{"--deltas", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.Deltas {
+		t.Error("Deltas = false, want true")
+	}
+
+	got, err = Parse([]string{"--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Deltas {
+		t.Error("Deltas = true by default, want false")
+	}
+}
+
+func TestParse_Header(t *testing.T) {
+	got, err := Parse([]string{"--header", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.Header {
+		t.Error("Header = false, want true")
+	}
+
+	got, err = Parse([]string{"--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Header {
+		t.Error("Header = true by default, want false")
+	}
+
+	_, err = Parse([]string{"--header", "--output-format=ndjson-schema", "--", "ls"})
+	if want := "--header cannot be used with --output-format=ndjson-schema"; err == nil || err.Error() != want {
+		t.Errorf("Parse() error = %v, want %q", err, want)
+	}
+
+	_, err = Parse([]string{"--header", "--output-format=json-array", "--", "ls"})
+	if want := "--header cannot be used with --output-format=json-array"; err == nil || err.Error() != want {
+		t.Errorf("Parse() error = %v, want %q", err, want)
+	}
+}
+
+func TestParse_MarkBursts(t *testing.T) {
+	got, err := Parse([]string{"--mark-bursts", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.MarkBursts {
+		t.Error("MarkBursts = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.MarkBursts {
+		t.Error("MarkBursts = true by default, want false")
+	}
+}
+
+func TestParse_RecordReadSizes(t *testing.T) {
+	got, err := Parse([]string{"--record-read-sizes", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.RecordReadSizes {
+		t.Error("RecordReadSizes = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RecordReadSizes {
+		t.Error("RecordReadSizes = true by default, want false")
+	}
+}
+
+func TestParse_StdinEcho(t *testing.T) {
+	got, err := Parse([]string{"--stdin-echo", "--", "cat"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.StdinEcho {
+		t.Error("StdinEcho = false, want true")
+	}
+
+	got, err = Parse([]string{"cat"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.StdinEcho {
+		t.Error("StdinEcho = true by default, want false")
+	}
+}
+
+func TestParse_RecordCWD(t *testing.T) {
+	got, err := Parse([]string{"--record-cwd", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.RecordCWD {
+		t.Error("RecordCWD = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RecordCWD {
+		t.Error("RecordCWD = true by default, want false")
+	}
+}
+
+func TestParse_RecordCommandPath(t *testing.T) {
+	got, err := Parse([]string{"--record-command-path", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.RecordPath {
+		t.Error("RecordPath = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RecordPath {
+		t.Error("RecordPath = true by default, want false")
+	}
+}
+
+func TestParse_RecordIdentity(t *testing.T) {
+	got, err := Parse([]string{"--record-identity", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.RecordIdentity {
+		t.Error("RecordIdentity = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RecordIdentity {
+		t.Error("RecordIdentity = true by default, want false")
+	}
+}
+
+func TestParse_NoBuffering(t *testing.T) {
+	for _, flag := range []string{"--no-buffering", "--flush-every-record"} {
+		got, err := Parse([]string{flag, "--", "ls"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if !got.NoBuffering {
+			t.Errorf("%s: NoBuffering = false, want true", flag)
+		}
+	}
+
+	got, err := Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.NoBuffering {
+		t.Error("NoBuffering = true by default, want false")
+	}
+}
+
+func TestParse_ProcessReap(t *testing.T) {
+	got, err := Parse([]string{"--process-reap", "--", "make"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.ProcessReap {
+		t.Error("ProcessReap = false, want true")
+	}
+
+	got, err = Parse([]string{"make"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.ProcessReap {
+		t.Error("ProcessReap = true by default, want false")
+	}
+}
+
+func TestParse_FlushOnLine(t *testing.T) {
+	for _, flag := range []string{"--flush-on-line", "--force-flush-on-newline"} {
+		got, err := Parse([]string{flag, "--", "ls"})
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if !got.FlushOnLine {
+			t.Errorf("%s: FlushOnLine = false, want true", flag)
+		}
+	}
+
+	got, err := Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.FlushOnLine {
+		t.Error("FlushOnLine = true by default, want false")
+	}
+}
+
+func TestParse_OutSlog(t *testing.T) {
+	got, err := Parse([]string{"--out-slog", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.OutSlog {
+		t.Error("OutSlog = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.OutSlog {
+		t.Error("OutSlog = true by default, want false")
+	}
+}
+
+func TestParse_Progress(t *testing.T) {
+	got, err := Parse([]string{"--progress", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.Progress {
+		t.Error("Progress = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Progress {
+		t.Error("Progress = true by default, want false")
+	}
+}
+
+func TestParse_EscapeHTML(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"ls"}, true},
+		{[]string{"--escape-html", "--", "ls"}, true},
+		{[]string{"--no-escape-html", "--", "ls"}, false},
+		{[]string{"--no-escape-html", "--escape-html", "--", "ls"}, true},
+		{[]string{"--escape-html", "--no-escape-html", "--", "ls"}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.args)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.args, err)
+		}
+		if got.EscapeHTML != tt.want {
+			t.Errorf("Parse(%q).EscapeHTML = %v, want %v", tt.args, got.EscapeHTML, tt.want)
+		}
+	}
+}
+
+func TestParse_JSONRawContent(t *testing.T) {
+	got, err := Parse([]string{"--json-raw-content", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.JSONRawContent {
+		t.Error("JSONRawContent = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.JSONRawContent {
+		t.Error("JSONRawContent = true by default, want false")
+	}
+}
+
+func TestParse_LossyText(t *testing.T) {
+	got, err := Parse([]string{"--lossy-text", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.LossyText {
+		t.Error("LossyText = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.LossyText {
+		t.Error("LossyText = true by default, want false")
+	}
+}
+
+func TestParse_RecordSignals(t *testing.T) {
+	got, err := Parse([]string{"--record-signals", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.RecordSignals {
+		t.Error("RecordSignals = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RecordSignals {
+		t.Error("RecordSignals = true by default, want false")
+	}
+}
+
+func TestParse_ConsumeUSR1(t *testing.T) {
+	got, err := Parse([]string{"--consume-usr1", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.ConsumeUSR1 {
+		t.Error("ConsumeUSR1 = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.ConsumeUSR1 {
+		t.Error("ConsumeUSR1 = true by default, want false")
+	}
+}
+
+func TestParse_ToggleSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, 0, false},
+		{"name", []string{"--toggle-signal=USR2", "--", "ls"}, syscall.SIGUSR2, false},
+		{"with prefix", []string{"--toggle-signal=SIGQUIT", "--", "ls"}, syscall.SIGQUIT, false},
+		{"lower case", []string{"--toggle-signal", "usr2", "--", "ls"}, syscall.SIGUSR2, false},
+		{"forwarded signal", []string{"--toggle-signal=TERM", "--", "ls"}, 0, true},
+		{"number", []string{"--toggle-signal=12", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.ToggleSignal != tt.want {
+				t.Errorf("ToggleSignal = %v, want %v", got.ToggleSignal, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_RecordChecksums(t *testing.T) {
+	got, err := Parse([]string{"--record-checksums", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.RecordChecksums {
+		t.Error("RecordChecksums = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RecordChecksums {
+		t.Error("RecordChecksums = true by default, want false")
+	}
+}
+
+func TestParse_PassFD(t *testing.T) {
+	got, err := Parse([]string{"--pass-fd=3", "--pass-fd", "5", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(got.PassFDs) != 2 || got.PassFDs[0] != 3 || got.PassFDs[1] != 5 {
+		t.Errorf("PassFDs = %v, want [3 5]", got.PassFDs)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"not a number", []string{"--pass-fd=foo", "--", "ls"}, "--pass-fd requires an integer value: foo"},
+		{"standard stream", []string{"--pass-fd=1", "--", "ls"}, "--pass-fd must be 3 or greater"},
+		{"negative", []string{"--pass-fd=-1", "--", "ls"}, "--pass-fd must be 3 or greater"},
+		{"duplicate", []string{"--pass-fd=3", "--pass-fd=3", "--", "ls"}, "--pass-fd 3 specified more than once"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_RemoteOutputs(t *testing.T) {
+	got, err := Parse([]string{"--out=local.jsonl", "--out=tcp://logger:5170", "--out", "tcp://[::1]:5171", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.OutputFile != "local.jsonl" {
+		t.Errorf("OutputFile = %q, want local.jsonl", got.OutputFile)
+	}
+	if !slices.Equal(got.RemoteOutputs, []string{"logger:5170", "[::1]:5171"}) {
+		t.Errorf("RemoteOutputs = %v, want [logger:5170 [::1]:5171]", got.RemoteOutputs)
+	}
+	if got.RemoteOnError != RemoteOnErrorContinue {
+		t.Errorf("RemoteOnError = %q, want %q by default", got.RemoteOnError, RemoteOnErrorContinue)
+	}
+
+	got, err = Parse([]string{"--out=tcp://logger:5170", "--remote-on-error=abort", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.OutputFile != "" || got.RemoteOnError != RemoteOnErrorAbort {
+		t.Errorf("OutputFile = %q, RemoteOnError = %q, want default naming and abort", got.OutputFile, got.RemoteOnError)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"two files", []string{"--out=a.jsonl", "--out=b.jsonl", "--", "ls"}, "--out can name only one file"},
+		{"no port", []string{"--out=tcp://logger", "--", "ls"}, "--out requires tcp://<host>:<port> for a remote destination: tcp://logger"},
+		{"invalid policy", []string{"--remote-on-error=retry", "--", "ls"}, "--remote-on-error must be one of continue, abort: retry"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_SinkRetry(t *testing.T) {
+	got, err := Parse([]string{"--out=tcp://logger:5170", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RetryInitial != 0 || got.RetryCap != 0 || got.RetryMax != 0 {
+		t.Errorf("RetryInitial = %v, RetryCap = %v, RetryMax = %d, want the defaults", got.RetryInitial, got.RetryCap, got.RetryMax)
+	}
+
+	got, err = Parse([]string{"--sink-retry-initial=500ms", "--sink-retry-cap", "10", "--sink-retry-max=5", "--out=tcp://logger:5170", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RetryInitial != 500*time.Millisecond || got.RetryCap != 10*time.Second || got.RetryMax != 5 {
+		t.Errorf("RetryInitial = %v, RetryCap = %v, RetryMax = %d, want 500ms, 10s, 5", got.RetryInitial, got.RetryCap, got.RetryMax)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"zero initial", []string{"--sink-retry-initial=0", "--", "ls"}, "--sink-retry-initial must be greater than zero"},
+		{"invalid cap", []string{"--sink-retry-cap=soon", "--", "ls"}, "--sink-retry-cap requires a duration value (e.g. 5s): soon"},
+		{"negative max", []string{"--sink-retry-max=-1", "--", "ls"}, "--sink-retry-max cannot be negative"},
+		{"invalid max", []string{"--sink-retry-max=never", "--", "ls"}, "--sink-retry-max requires an integer value: never"},
+		{"initial over cap", []string{"--sink-retry-initial=1m", "--sink-retry-cap=30s", "--", "ls"}, "--sink-retry-initial (1m0s) cannot be greater than --sink-retry-cap (30s)"},
+		{"initial over default cap", []string{"--sink-retry-initial=1m", "--", "ls"}, "--sink-retry-initial (1m0s) cannot be greater than --sink-retry-cap (30s)"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_HTTPOutputs(t *testing.T) {
+	got, err := Parse([]string{"--out=http://collector:8080/records", "--out", "https://logs.example.com/", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.OutputFile != "" || !slices.Equal(got.HTTPOutputs, []string{"http://collector:8080/records", "https://logs.example.com/"}) {
+		t.Errorf("OutputFile = %q, HTTPOutputs = %v, want default naming and both URLs", got.OutputFile, got.HTTPOutputs)
+	}
+	if !got.HTTPKeepAlive || got.HTTPIdleConns != 0 {
+		t.Errorf("HTTPKeepAlive = %v, HTTPIdleConns = %d, want true and 0 by default", got.HTTPKeepAlive, got.HTTPIdleConns)
+	}
+
+	got, err = Parse([]string{"--http-keep-alive=false", "--http-max-idle-conns", "4", "--out=http://collector:8080", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.HTTPKeepAlive || got.HTTPIdleConns != 4 {
+		t.Errorf("HTTPKeepAlive = %v, HTTPIdleConns = %d, want false and 4", got.HTTPKeepAlive, got.HTTPIdleConns)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no host", []string{"--out=http://", "--", "ls"}, "--out requires a URL with a host for an HTTP destination"},
+		{"invalid keep-alive", []string{"--http-keep-alive=sometimes", "--", "ls"}, "--http-keep-alive requires true or false"},
+		{"zero idle conns", []string{"--http-max-idle-conns=0", "--", "ls"}, "--http-max-idle-conns must be greater than zero"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_Watch(t *testing.T) {
+	got, err := Parse([]string{"--watch=*.go", "--watch", "testdata/*.json", "--", "go", "test"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(got.Watch) != 2 || got.Watch[0] != "*.go" || got.Watch[1] != "testdata/*.json" {
+		t.Errorf("Watch = %v, want [*.go testdata/*.json]", got.Watch)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"invalid pattern", []string{"--watch=[a-", "--", "ls"}, "--watch has an invalid pattern: [a-"},
+		{"with pass-fd", []string{"--watch=*.go", "--pass-fd=3", "--", "ls"}, "--watch cannot be used with --pass-fd"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_RetryCommand(t *testing.T) {
+	got, err := Parse([]string{"--retry-command=3", "--retry-delay", "500ms", "--", "make", "test"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RetryCommand != 3 || got.RetryDelay != 500*time.Millisecond {
+		t.Errorf("RetryCommand = %d, RetryDelay = %v, want 3, 500ms", got.RetryCommand, got.RetryDelay)
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.RetryCommand != 0 || got.RetryDelay != 0 {
+		t.Errorf("RetryCommand = %d, RetryDelay = %v, want no retries by default", got.RetryCommand, got.RetryDelay)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"negative", []string{"--retry-command=-1", "--", "ls"}, "--retry-command cannot be negative"},
+		{"invalid", []string{"--retry-command=often", "--", "ls"}, "--retry-command requires an integer value: often"},
+		{"invalid delay", []string{"--retry-delay=soon", "--", "ls"}, "--retry-delay requires a duration value (e.g. 5s): soon"},
+		{"with watch", []string{"--retry-command=3", "--watch=*.go", "--", "ls"}, "--watch cannot be used with --retry-command"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_RecordTimingHistogram(t *testing.T) {
+	got, err := Parse([]string{"--record-timing-histogram", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.TimingStats {
+		t.Error("TimingStats = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.TimingStats {
+		t.Error("TimingStats = true by default, want false")
+	}
+}
+
+func TestParse_Field(t *testing.T) {
+	got, err := Parse([]string{"--field", "trace_id=abc", "--field=env=prod=1", "--field=empty=", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := map[string]string{"trace_id": "abc", "env": "prod=1", "empty": ""}
+	if len(got.Fields) != len(want) {
+		t.Fatalf("Fields = %v, want %v", got.Fields, want)
+	}
+	for k, v := range want {
+		if got.Fields[k] != v {
+			t.Errorf("Fields[%q] = %q, want %q", k, got.Fields[k], v)
+		}
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"missing equals", []string{"--field=trace_id", "--", "ls"}, "--field must be in <key>=<value> format"},
+		{"empty key", []string{"--field==abc", "--", "ls"}, "--field must be in <key>=<value> format"},
+		{"built-in name", []string{"--field=seq=1", "--", "ls"}, "--field cannot use the built-in field name: seq"},
+		{"nested name", []string{"--field=fields=x", "--", "ls"}, "--field cannot use the built-in field name: fields"},
+		{"duplicate", []string{"--field=a=1", "--field=a=2", "--", "ls"}, "--field a specified more than once"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_ExitCodeMap(t *testing.T) {
+	got, err := Parse([]string{"--exit-code-map=1:0,2:1", "--exit-code-map", "137:0", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := map[int]int{1: 0, 2: 1, 137: 0}
+	if len(got.ExitCodeMap) != len(want) {
+		t.Fatalf("ExitCodeMap = %v, want %v", got.ExitCodeMap, want)
+	}
+	for k, v := range want {
+		if got.ExitCodeMap[k] != v {
+			t.Errorf("ExitCodeMap[%d] = %d, want %d", k, got.ExitCodeMap[k], v)
+		}
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"missing colon", []string{"--exit-code-map=1", "--", "ls"}, "--exit-code-map must be comma-separated <src>:<dst> pairs"},
+		{"empty pair", []string{"--exit-code-map=1:0,", "--", "ls"}, "--exit-code-map must be comma-separated <src>:<dst> pairs"},
+		{"not a number", []string{"--exit-code-map=x:0", "--", "ls"}, "--exit-code-map requires exit codes from 0 to 255: x"},
+		{"out of range", []string{"--exit-code-map=1:256", "--", "ls"}, "--exit-code-map requires exit codes from 0 to 255: 256"},
+		{"negative", []string{"--exit-code-map=-1:0", "--", "ls"}, "--exit-code-map requires exit codes from 0 to 255: -1"},
+		{"duplicate", []string{"--exit-code-map=1:0", "--exit-code-map=1:2", "--", "ls"}, "--exit-code-map maps exit code 1 more than once"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_SourceWeight(t *testing.T) {
+	got, err := Parse([]string{"--source-weight=stdout:3,stderr:1", "--source-weight", "stdin:2", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := map[string]int{"stdout": 3, "stderr": 1, "stdin": 2}
+	if len(got.SourceWeights) != len(want) {
+		t.Fatalf("SourceWeights = %v, want %v", got.SourceWeights, want)
+	}
+	for k, v := range want {
+		if got.SourceWeights[k] != v {
+			t.Errorf("SourceWeights[%s] = %d, want %d", k, got.SourceWeights[k], v)
+		}
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"missing colon", []string{"--source-weight=stdout", "--", "ls"}, "--source-weight must be comma-separated <source>:<weight> pairs"},
+		{"unknown source", []string{"--source-weight=meta:1", "--", "ls"}, "--source-weight requires stdin, stdout or stderr: meta"},
+		{"not a number", []string{"--source-weight=stdout:x", "--", "ls"}, "--source-weight requires a positive integer weight: x"},
+		{"zero", []string{"--source-weight=stdout:0", "--", "ls"}, "--source-weight requires a positive integer weight: 0"},
+		{"duplicate", []string{"--source-weight=stdout:1", "--source-weight=stdout:2", "--", "ls"}, "--source-weight specifies stdout more than once"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_QueueSize(t *testing.T) {
+	got, err := Parse([]string{"--queue-size=256", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.QueueSize != 256 {
+		t.Errorf("QueueSize = %d, want 256", got.QueueSize)
+	}
+
+	errTests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"not a number", []string{"--queue-size=many", "--", "ls"}, "--queue-size requires an integer value: many"},
+		{"zero", []string{"--queue-size=0", "--", "ls"}, "--queue-size must be greater than zero"},
+		{"with source weights", []string{"--queue-size=16", "--source-weight=stdout:2", "--", "ls"}, "--source-weight cannot be used with --queue-size"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil || !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestParse_OTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, "", false},
+		{"with equals", []string{"--otlp-endpoint=http://localhost:4318", "--", "ls"}, "http://localhost:4318", false},
+		{"with space", []string{"--otlp-endpoint", "collector:4318", "--", "ls"}, "collector:4318", false},
+		{"empty", []string{"--otlp-endpoint=", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.OTLPEndpoint != tt.want {
+				t.Errorf("OTLPEndpoint = %q, want %q", got.OTLPEndpoint, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_MetricsAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, "", false},
+		{"with equals", []string{"--metrics-addr=:9151", "--", "ls"}, ":9151", false},
+		{"with space", []string{"--metrics-addr", "127.0.0.1:9151", "--", "ls"}, "127.0.0.1:9151", false},
+		{"empty", []string{"--metrics-addr=", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.MetricsAddr != tt.want {
+				t.Errorf("MetricsAddr = %q, want %q", got.MetricsAddr, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_ControlSocket(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		wantPerm fs.FileMode // 0 = not set
+		wantErr  string
+	}{
+		{"default", []string{"ls"}, "", 0, ""},
+		{"path", []string{"--control-socket=/tmp/ioetap.sock", "--", "ls"}, "/tmp/ioetap.sock", 0, ""},
+		{"permissions", []string{"--control-socket", "ctl.sock", "--control-socket-permissions=0660", "ls"}, "ctl.sock", 0o660, ""},
+		{"empty", []string{"--control-socket=", "--", "ls"}, "", 0, "--control-socket cannot be empty"},
+		{"not octal", []string{"--control-socket-permissions=0680", "ls"}, "", 0, "requires an octal value"},
+		{"zero", []string{"--control-socket-permissions=0", "ls"}, "", 0, "must be between 0001 and 0777"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.ControlSocket != tt.want {
+				t.Errorf("ControlSocket = %q, want %q", got.ControlSocket, tt.want)
+			}
+			if (got.ControlPerm == nil) != (tt.wantPerm == 0) || (got.ControlPerm != nil && *got.ControlPerm != tt.wantPerm) {
+				t.Errorf("ControlPerm = %v, want %o", got.ControlPerm, tt.wantPerm)
+			}
+		})
+	}
+}
+
+func TestParse_S3(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    Options
+		wantErr string
+	}{
+		{"default", []string{"ls"}, Options{}, ""},
+		{"bucket and key", []string{"--s3-bucket=recordings", "--s3-key", "ci/build.jsonl", "ls"},
+			Options{S3Bucket: "recordings", S3Key: "ci/build.jsonl"}, ""},
+		{"region and endpoint", []string{"--s3-bucket=b", "--s3-key=k", "--s3-region=eu-west-1", "--s3-endpoint=http://localhost:9000", "ls"},
+			Options{S3Bucket: "b", S3Key: "k", S3Region: "eu-west-1", S3Endpoint: "http://localhost:9000"}, ""},
+		{"empty", []string{"--s3-bucket=", "--s3-key=k", "ls"}, Options{}, "--s3-bucket cannot be empty"},
+		{"no key", []string{"--s3-bucket=b", "ls"}, Options{}, "must be used together"},
+		{"no bucket", []string{"--s3-key=k", "ls"}, Options{}, "must be used together"},
+		{"region without bucket", []string{"--s3-region=eu-west-1", "ls"}, Options{}, "require --s3-bucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.S3Bucket != tt.want.S3Bucket || got.S3Key != tt.want.S3Key || got.S3Region != tt.want.S3Region || got.S3Endpoint != tt.want.S3Endpoint {
+				t.Errorf("S3 options = %q %q %q %q, want %q %q %q %q", got.S3Bucket, got.S3Key, got.S3Region, got.S3Endpoint,
+					tt.want.S3Bucket, tt.want.S3Key, tt.want.S3Region, tt.want.S3Endpoint)
+			}
+		})
+	}
+}
+
+func TestParse_Kafka(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantBrokers []string
+		wantTopic   string
+		wantErr     string
+	}{
+		{"default", []string{"ls"}, nil, "", ""},
+		{"brokers and topic", []string{"--kafka-broker=k1:9092", "--kafka-broker", "k2:9092", "--kafka-topic=records", "ls"},
+			[]string{"k1:9092", "k2:9092"}, "records", ""},
+		{"no port", []string{"--kafka-broker=k1", "--kafka-topic=records", "ls"}, nil, "", "requires <host>:<port>"},
+		{"empty topic", []string{"--kafka-broker=k1:9092", "--kafka-topic=", "ls"}, nil, "", "--kafka-topic cannot be empty"},
+		{"no topic", []string{"--kafka-broker=k1:9092", "ls"}, nil, "", "must be used together"},
+		{"no broker", []string{"--kafka-topic=records", "ls"}, nil, "", "must be used together"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !slices.Equal(got.KafkaBrokers, tt.wantBrokers) || got.KafkaTopic != tt.wantTopic {
+				t.Errorf("Kafka options = %q %q, want %q %q", got.KafkaBrokers, got.KafkaTopic, tt.wantBrokers, tt.wantTopic)
+			}
+		})
+	}
+}
+
+func TestParse_PprofAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, "", false},
+		{"with equals", []string{"--pprof=:6060", "--", "ls"}, ":6060", false},
+		{"with space", []string{"--pprof", "127.0.0.1:6060", "--", "ls"}, "127.0.0.1:6060", false},
+		{"empty", []string{"--pprof=", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.PprofAddr != tt.want {
+				t.Errorf("PprofAddr = %q, want %q", got.PprofAddr, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_SplitStdin(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    bool
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, true, false},
+		{"false", []string{"--stdin-split-on-newline=false", "--", "ls"}, false, false},
+		{"true", []string{"--stdin-split-on-newline", "true", "--", "ls"}, true, false},
+		{"invalid", []string{"--stdin-split-on-newline=no", "--", "ls"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.SplitStdin != tt.want {
+				t.Errorf("SplitStdin = %v, want %v", got.SplitStdin, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_AnnotateStdin(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"default", []string{"ls"}, "", ""},
+		{"prefix", []string{"--annotate-stdin=# ", "--", "ls"}, "# ", ""},
+		{"separate value", []string{"--annotate-stdin", "//", "--", "ls"}, "//", ""},
+		{"empty", []string{"--annotate-stdin=", "--", "ls"}, "", "--annotate-stdin cannot be empty"},
+		{"newline", []string{"--annotate-stdin=#\n", "--", "ls"}, "", "--annotate-stdin cannot contain a line ending"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.AnnotatePrefix != tt.want {
+				t.Errorf("AnnotatePrefix = %q, want %q", got.AnnotatePrefix, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_StdinBroadcast(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"default", []string{"ls"}, "", ""},
+		{"path", []string{"--stdin-broadcast=/tmp/stdin.fifo", "--", "ls"}, "/tmp/stdin.fifo", ""},
+		{"separate value", []string{"--stdin-broadcast", "stdin.log", "--", "ls"}, "stdin.log", ""},
+		{"empty", []string{"--stdin-broadcast=", "--", "ls"}, "", "--stdin-broadcast cannot be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.StdinBroadcast != tt.want {
+				t.Errorf("StdinBroadcast = %q, want %q", got.StdinBroadcast, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_LogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    logging.Level
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, logging.LevelWarn, false},
+		{"error", []string{"--log-level=error", "--", "ls"}, logging.LevelError, false},
+		{"info", []string{"--log-level", "info", "--", "ls"}, logging.LevelInfo, false},
+		{"debug shorthand", []string{"--debug", "--", "ls"}, logging.LevelDebug, false},
+		{"last one wins", []string{"--debug", "--log-level=warn", "--", "ls"}, logging.LevelWarn, false},
+		{"invalid", []string{"--log-level=verbose", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.LogLevel != tt.want {
+				t.Errorf("LogLevel = %v, want %v", got.LogLevel, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_CommandLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, "", false},
+		{"with equals", []string{"--command-label=auth flow", "--", "ls"}, "auth flow", false},
+		{"with space", []string{"--command-label", "Integration test: \"auth\"\nflow", "--", "ls"}, "Integration test: \"auth\"\nflow", false},
+		{"empty", []string{"--command-label=", "--", "ls"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.CommandLabel != tt.want {
+				t.Errorf("CommandLabel = %q, want %q", got.CommandLabel, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Comment(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantText string
+		wantFile string
+		wantErr  bool
+	}{
+		{"default", []string{"ls"}, "", "", false},
+		{"comment", []string{"--comment=retrying after cache purge", "--", "ls"}, "retrying after cache purge", "", false},
+		{"multi-line comment", []string{"--comment", "first line\n\tsecond \"line\"", "--", "ls"}, "first line\n\tsecond \"line\"", "", false},
+		{"comment file", []string{"--comment-file", "notes.txt", "--", "ls"}, "", "notes.txt", false},
+		{"empty comment", []string{"--comment=", "--", "ls"}, "", "", true},
+		{"empty comment file", []string{"--comment-file=", "--", "ls"}, "", "", true},
+		{"both", []string{"--comment=note", "--comment-file=notes.txt", "--", "ls"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Comment != tt.wantText {
+				t.Errorf("Comment = %q, want %q", got.Comment, tt.wantText)
+			}
+			if got.CommentFile != tt.wantFile {
+				t.Errorf("CommentFile = %q, want %q", got.CommentFile, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestParse_GracePeriod(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, DefaultGracePeriod, false},
+		{"duration", []string{"--grace-period=500ms", "--", "ls"}, 500 * time.Millisecond, false},
+		{"seconds", []string{"--grace-period", "10", "--", "ls"}, 10 * time.Second, false},
+		{"zero", []string{"--grace-period=0", "--", "ls"}, 0, false},
+		{"negative", []string{"--grace-period=-1s", "--", "ls"}, 0, true},
+		{"invalid", []string{"--grace-period=soon", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.GracePeriod != tt.want {
+				t.Errorf("GracePeriod = %v, want %v", got.GracePeriod, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_CPUTimeRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", []string{"ls"}, 0, false},
+		{"duration", []string{"--cpu-time-record=500ms", "--", "ls"}, 500 * time.Millisecond, false},
+		{"seconds", []string{"--cpu-time-record", "2", "--", "ls"}, 2 * time.Second, false},
+		{"zero", []string{"--cpu-time-record=0", "--", "ls"}, 0, true},
+		{"negative", []string{"--cpu-time-record=-1s", "--", "ls"}, 0, true},
+		{"invalid", []string{"--cpu-time-record=often", "--", "ls"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.CPUTimeRecord != tt.want {
+				t.Errorf("CPUTimeRecord = %v, want %v", got.CPUTimeRecord, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_StrictOrder(t *testing.T) {
+	got, err := Parse([]string{"--strict-order", "--", "ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !got.StrictOrder {
+		t.Error("StrictOrder = false, want true")
+	}
+
+	got, err = Parse([]string{"ls"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.StrictOrder {
+		t.Error("StrictOrder = true by default, want false")
+	}
+}
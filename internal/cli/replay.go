@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReplayOptions holds the parsed options of the replay subcommand.
+type ReplayOptions struct {
+	Input   string   // Recording file whose stdin is replayed
+	Command []string // Command to run and its arguments
+}
+
+// ParseReplay parses the arguments of the replay subcommand:
+//
+//	ioetap replay <recording.jsonl> [--] <command> [args...]
+//
+// The subcommand has no options of its own, so everything after the
+// recording is the command, even if it looks like an option.
+func ParseReplay(args []string) (*ReplayOptions, error) {
+	if len(args) == 0 || args[0] == "--" {
+		return nil, errors.New("no recording file specified")
+	}
+	if strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		key, _, _ := strings.Cut(args[0], "=")
+		return nil, fmt.Errorf("unknown option: %s", key)
+	}
+
+	ro := &ReplayOptions{Input: args[0], Command: args[1:]}
+	if len(ro.Command) > 0 && ro.Command[0] == "--" {
+		ro.Command = ro.Command[1:]
+	}
+	if len(ro.Command) == 0 {
+		return nil, errors.New("no command specified")
+	}
+	return ro, nil
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseReplay(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantCommand []string
+	}{
+		{[]string{"recording.jsonl", "cat"}, []string{"cat"}},
+		{[]string{"recording.jsonl", "--", "grep", "-v", "x"}, []string{"grep", "-v", "x"}},
+		// The options of the command are not ioetap's
+		{[]string{"recording.jsonl", "grep", "--count", "x"}, []string{"grep", "--count", "x"}},
+	}
+	for _, tt := range tests {
+		opts, err := ParseReplay(tt.args)
+		if err != nil {
+			t.Fatalf("ParseReplay(%q) error = %v", tt.args, err)
+		}
+		if opts.Input != "recording.jsonl" || !slices.Equal(opts.Command, tt.wantCommand) {
+			t.Errorf("ParseReplay(%q) = %+v, want the command %q", tt.args, opts, tt.wantCommand)
+		}
+	}
+}
+
+func TestParseReplay_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no input", nil, "no recording file specified"},
+		{"no input before --", []string{"--", "cat"}, "no recording file specified"},
+		{"no command", []string{"a.jsonl"}, "no command specified"},
+		{"no command after --", []string{"a.jsonl", "--"}, "no command specified"},
+		{"unknown option", []string{"--bogus=1", "a.jsonl", "cat"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseReplay(tt.args)
+			if err == nil {
+				t.Fatalf("ParseReplay() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseReplay() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
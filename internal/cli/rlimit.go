@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RlimitUnlimited is the Rlimit value meaning no limit ("unlimited").
+const RlimitUnlimited = math.MaxUint64
+
+// Rlimit is a resource limit for the child process given with --rlimit.
+type Rlimit struct {
+	Name     string // Resource name (e.g. "nofile")
+	Resource int    // syscall.RLIMIT_* constant
+	Cur      uint64 // Soft limit
+	Max      uint64 // Hard limit
+}
+
+// rlimitResources maps --rlimit resource names to syscall.RLIMIT_* constants.
+var rlimitResources = map[string]int{
+	"as":     syscall.RLIMIT_AS,
+	"core":   syscall.RLIMIT_CORE,
+	"cpu":    syscall.RLIMIT_CPU,
+	"data":   syscall.RLIMIT_DATA,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"nofile": syscall.RLIMIT_NOFILE,
+	"stack":  syscall.RLIMIT_STACK,
+}
+
+// sizeSuffixes maps size suffixes to their multipliers (binary units).
+var sizeSuffixes = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// parseRlimit parses a --rlimit value in <name>=<soft>[:<hard>] format.
+// The hard limit defaults to the soft limit.
+func parseRlimit(value string) (Rlimit, error) {
+	name, limits, ok := strings.Cut(value, "=")
+	if !ok {
+		return Rlimit{}, fmt.Errorf("--rlimit must be in <name>=<value> format: %s", value)
+	}
+
+	name = strings.ToLower(name)
+	resource, ok := rlimitResources[name]
+	if !ok {
+		return Rlimit{}, fmt.Errorf("--rlimit: unknown resource: %s", name)
+	}
+
+	soft, hard, hasHard := strings.Cut(limits, ":")
+	cur, err := parseRlimitValue(soft)
+	if err != nil {
+		return Rlimit{}, fmt.Errorf("--rlimit %s: %w", name, err)
+	}
+	max := cur
+	if hasHard {
+		if max, err = parseRlimitValue(hard); err != nil {
+			return Rlimit{}, fmt.Errorf("--rlimit %s: %w", name, err)
+		}
+		if cur > max {
+			return Rlimit{}, fmt.Errorf("--rlimit %s: soft limit cannot exceed hard limit", name)
+		}
+	}
+
+	return Rlimit{Name: name, Resource: resource, Cur: cur, Max: max}, nil
+}
+
+// parseRlimitValue parses a limit value: a non-negative integer with an
+// optional K, M, G or T size suffix (binary units, optionally followed by
+// "B" or "iB"), or "unlimited".
+func parseRlimitValue(s string) (uint64, error) {
+	if strings.EqualFold(s, "unlimited") {
+		return RlimitUnlimited, nil
+	}
+
+	number := strings.ToUpper(s)
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "I")
+	multiplier := uint64(1)
+	for _, sfx := range sizeSuffixes {
+		if strings.HasSuffix(number, sfx.suffix) {
+			number = strings.TrimSuffix(number, sfx.suffix)
+			multiplier = sfx.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit: %s", s)
+	}
+	if n > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("limit out of range: %s", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParse_RlimitOption(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []Rlimit
+	}{
+		{
+			name: "rlimit with equals",
+			args: []string{"--rlimit=nofile=1024", "--", "ls"},
+			want: []Rlimit{{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 1024, Max: 1024}},
+		},
+		{
+			name: "rlimit with space",
+			args: []string{"--rlimit", "nofile=64", "--", "ls"},
+			want: []Rlimit{{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 64, Max: 64}},
+		},
+		{
+			name: "repeated rlimit",
+			args: []string{"--rlimit", "nofile=1024", "--rlimit", "as=2G", "--", "ls"},
+			want: []Rlimit{
+				{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 1024, Max: 1024},
+				{Name: "as", Resource: syscall.RLIMIT_AS, Cur: 2 << 30, Max: 2 << 30},
+			},
+		},
+		{
+			name: "soft and hard limits",
+			args: []string{"--rlimit=cpu=10:20", "--", "ls"},
+			want: []Rlimit{{Name: "cpu", Resource: syscall.RLIMIT_CPU, Cur: 10, Max: 20}},
+		},
+		{
+			name: "unlimited hard limit",
+			args: []string{"--rlimit=core=0:unlimited", "--", "ls"},
+			want: []Rlimit{{Name: "core", Resource: syscall.RLIMIT_CORE, Cur: 0, Max: RlimitUnlimited}},
+		},
+		{
+			name: "per-resource shorthands",
+			args: []string{"--rlimit-cpu=60", "--rlimit-as", "1GB", "--rlimit-nofile=100", "--", "ls"},
+			want: []Rlimit{
+				{Name: "cpu", Resource: syscall.RLIMIT_CPU, Cur: 60, Max: 60},
+				{Name: "as", Resource: syscall.RLIMIT_AS, Cur: 1 << 30, Max: 1 << 30},
+				{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 100, Max: 100},
+			},
+		},
+		{
+			name: "shorthand with hard limit",
+			args: []string{"--rlimit-fsize=1M:2M", "--", "ls"},
+			want: []Rlimit{{Name: "fsize", Resource: syscall.RLIMIT_FSIZE, Cur: 1 << 20, Max: 2 << 20}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.args)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(got.Rlimits) != len(tt.want) {
+				t.Fatalf("Rlimits = %+v, want %+v", got.Rlimits, tt.want)
+			}
+			for i, rlimit := range got.Rlimits {
+				if rlimit != tt.want[i] {
+					t.Errorf("Rlimits[%d] = %+v, want %+v", i, rlimit, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRlimitValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  uint64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"4K", 4 << 10},
+		{"4k", 4 << 10},
+		{"512M", 512 << 20},
+		{"2G", 2 << 30},
+		{"2GB", 2 << 30},
+		{"2GiB", 2 << 30},
+		{"1T", 1 << 40},
+		{"unlimited", RlimitUnlimited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRlimitValue(tt.value)
+			if err != nil {
+				t.Fatalf("parseRlimitValue() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseRlimitValue() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_RlimitErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{
+			name:       "missing equals",
+			args:       []string{"--rlimit=nofile", "--", "ls"},
+			wantErrMsg: "--rlimit must be in <name>=<value> format",
+		},
+		{
+			name:       "unknown resource",
+			args:       []string{"--rlimit=bogus=1", "--", "ls"},
+			wantErrMsg: "--rlimit: unknown resource: bogus",
+		},
+		{
+			name:       "invalid value",
+			args:       []string{"--rlimit=nofile=lots", "--", "ls"},
+			wantErrMsg: "--rlimit nofile: invalid limit: lots",
+		},
+		{
+			name:       "negative value",
+			args:       []string{"--rlimit=nofile=-1", "--", "ls"},
+			wantErrMsg: "--rlimit nofile: invalid limit: -1",
+		},
+		{
+			name:       "overflowing value",
+			args:       []string{"--rlimit=as=99999999999T", "--", "ls"},
+			wantErrMsg: "--rlimit as: limit out of range",
+		},
+		{
+			name:       "soft exceeds hard",
+			args:       []string{"--rlimit=nofile=20:10", "--", "ls"},
+			wantErrMsg: "--rlimit nofile: soft limit cannot exceed hard limit",
+		},
+		{
+			name:       "missing value",
+			args:       []string{"--rlimit", "--", "ls"},
+			wantErrMsg: "--rlimit requires a value",
+		},
+		{
+			name:       "shorthand invalid value",
+			args:       []string{"--rlimit-cpu=soon", "--", "ls"},
+			wantErrMsg: "--rlimit cpu: invalid limit: soon",
+		},
+		{
+			name:       "shorthand unknown resource",
+			args:       []string{"--rlimit-bogus=1", "--", "ls"},
+			wantErrMsg: "unknown option: --rlimit-bogus",
+		},
+		{
+			name:       "shorthand missing value",
+			args:       []string{"--rlimit-nofile", "--", "ls"},
+			wantErrMsg: "--rlimit-nofile requires a value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.args)
+			if err == nil {
+				t.Fatalf("Parse() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("Parse() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
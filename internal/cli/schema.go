@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// SchemaOptions holds the parsed options of the schema subcommand.
+type SchemaOptions struct {
+	Version int // --version: record format version (default: the current one)
+}
+
+// ParseSchema parses the arguments of the schema subcommand:
+//
+//	ioetap schema [--version=<n>]
+func ParseSchema(args []string) (*SchemaOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args, []string{"--version"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional, " "))
+	}
+
+	so := &SchemaOptions{Version: recorder.FormatVersion}
+	for _, opt := range opts {
+		switch opt.Key {
+		case "--version":
+			n, err := strconv.Atoi(opt.Value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid --version value: %s (must be a positive integer)", opt.Value)
+			}
+			so.Version = n
+		}
+	}
+
+	return so, nil
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+func TestParseSchema(t *testing.T) {
+	opts, err := ParseSchema(nil)
+	if err != nil {
+		t.Fatalf("ParseSchema() error = %v", err)
+	}
+	if opts.Version != recorder.FormatVersion {
+		t.Errorf("Version = %d by default, want %d", opts.Version, recorder.FormatVersion)
+	}
+
+	for _, args := range [][]string{{"--version=1"}, {"--version", "1"}} {
+		opts, err := ParseSchema(args)
+		if err != nil {
+			t.Fatalf("ParseSchema(%v) error = %v", args, err)
+		}
+		if opts.Version != 1 {
+			t.Errorf("ParseSchema(%v): Version = %d, want 1", args, opts.Version)
+		}
+	}
+}
+
+func TestParseSchema_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"zero version", []string{"--version=0"}, "invalid --version value: 0"},
+		{"non-numeric version", []string{"--version=v1"}, "invalid --version value: v1"},
+		{"missing version", []string{"--version"}, "--version requires a value"},
+		{"positional", []string{"record.jsonl"}, "too many arguments: record.jsonl"},
+		{"unknown option", []string{"--bogus"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchema(tt.args)
+			if err == nil {
+				t.Fatalf("ParseSchema() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseSchema() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ShowOptions holds the parsed options of the show subcommand.
+type ShowOptions struct {
+	Head  int    // --head value (0 = no limit)
+	Tail  int    // --tail value (0 = no limit)
+	Input string // Recording file to show
+}
+
+// ParseShow parses the arguments of the show subcommand:
+//
+//	ioetap show [options] <recording.jsonl>
+func ParseShow(args []string) (*ShowOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args, []string{"--head", "--tail"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	so := &ShowOptions{}
+	for _, opt := range opts {
+		n, err := strconv.Atoi(opt.Value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s requires a positive integer value: %s", opt.Key, opt.Value)
+		}
+		switch opt.Key {
+		case "--head":
+			so.Head = n
+		case "--tail":
+			so.Tail = n
+		}
+	}
+
+	switch len(positional) {
+	case 0:
+		return nil, errors.New("no recording file specified")
+	case 1:
+		so.Input = positional[0]
+	default:
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional[1:], " "))
+	}
+
+	return so, nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import "testing"
+
+func TestParseShow(t *testing.T) {
+	opts, err := ParseShow([]string{"--head=10", "--tail", "3", "recording.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseShow() error = %v", err)
+	}
+	if opts.Head != 10 || opts.Tail != 3 {
+		t.Errorf("Head, Tail = %d, %d, want 10, 3", opts.Head, opts.Tail)
+	}
+	if opts.Input != "recording.jsonl" {
+		t.Errorf("Input = %q, want recording.jsonl", opts.Input)
+	}
+}
+
+func TestParseShow_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no input", []string{"--head=1"}, "no recording file specified"},
+		{"zero head", []string{"--head=0", "a.jsonl"}, "--head requires a positive integer value"},
+		{"negative tail", []string{"--tail=-1", "a.jsonl"}, "--tail requires a positive integer value"},
+		{"non-integer", []string{"--tail=x", "a.jsonl"}, "--tail requires a positive integer value"},
+		{"unknown option", []string{"--bogus", "a.jsonl"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseShow(tt.args)
+			if err == nil {
+				t.Fatalf("ParseShow() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseShow() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// subcommandOption is a single option given to a subcommand.
+type subcommandOption struct {
+	Key   string // option name including leading dashes (e.g. "--source")
+	Value string // option value (empty for flags)
+}
+
+// splitSubcommandArgs splits subcommand arguments into options and positional
+// arguments. valueOptions accept a value in --key=value or --key value format;
+// flagOptions take no value. Arguments after -- are always positional.
+func splitSubcommandArgs(args []string, valueOptions, flagOptions []string) ([]subcommandOption, []string, error) {
+	var opts []subcommandOption
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		key, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case slices.Contains(flagOptions, key):
+			if hasValue {
+				return nil, nil, fmt.Errorf("%s does not take a value", key)
+			}
+			opts = append(opts, subcommandOption{Key: key})
+		case slices.Contains(valueOptions, key):
+			if !hasValue {
+				if i+1 >= len(args) || args[i+1] == "--" {
+					return nil, nil, fmt.Errorf("%s requires a value", key)
+				}
+				value = args[i+1]
+				i++ // Skip the value
+			}
+			opts = append(opts, subcommandOption{Key: key, Value: value})
+		default:
+			return nil, nil, fmt.Errorf("unknown option: %s", key)
+		}
+	}
+
+	return opts, positional, nil
+}
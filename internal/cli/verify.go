@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// VerifyOptions holds the parsed options of the verify subcommand.
+type VerifyOptions struct {
+	Chain bool   // --chain: verify the chain hashes of the records
+	Input string // Recording file to verify
+}
+
+// ParseVerify parses the arguments of the verify subcommand:
+//
+//	ioetap verify [options] <recording.jsonl>
+func ParseVerify(args []string) (*VerifyOptions, error) {
+	opts, positional, err := splitSubcommandArgs(args, nil, []string{"--chain"})
+	if err != nil {
+		return nil, err
+	}
+
+	vo := &VerifyOptions{}
+	for _, opt := range opts {
+		switch opt.Key {
+		case "--chain":
+			vo.Chain = true
+		}
+	}
+
+	switch len(positional) {
+	case 0:
+		return nil, errors.New("no recording file specified")
+	case 1:
+		vo.Input = positional[0]
+	default:
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional[1:], " "))
+	}
+
+	return vo, nil
+}
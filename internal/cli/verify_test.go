@@ -0,0 +1,45 @@
+package cli
+
+import "testing"
+
+func TestParseVerify(t *testing.T) {
+	opts, err := ParseVerify([]string{"--chain", "recording.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseVerify() error = %v", err)
+	}
+	if !opts.Chain || opts.Input != "recording.jsonl" {
+		t.Errorf("ParseVerify() = %+v, want --chain of recording.jsonl", opts)
+	}
+
+	opts, err = ParseVerify([]string{"recording.jsonl"})
+	if err != nil {
+		t.Fatalf("ParseVerify() error = %v", err)
+	}
+	if opts.Chain {
+		t.Error("Chain = true by default, want false")
+	}
+}
+
+func TestParseVerify_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantErrMsg string
+	}{
+		{"no input", []string{"--chain"}, "no recording file specified"},
+		{"too many inputs", []string{"a.jsonl", "b.jsonl"}, "too many arguments: b.jsonl"},
+		{"unknown option", []string{"--bogus", "a.jsonl"}, "unknown option: --bogus"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseVerify(tt.args)
+			if err == nil {
+				t.Fatalf("ParseVerify() expected error containing %q, got nil", tt.wantErrMsg)
+			}
+			if !containsString(err.Error(), tt.wantErrMsg) {
+				t.Errorf("ParseVerify() error = %q, want error containing %q", err.Error(), tt.wantErrMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// Package control implements the control socket of ioetap: a unix socket
+// that accepts commands for a running recording, one JSON object per line,
+// and answers each with one JSON object per line.
+//
+//	{"command":"pause"}
+//	{"command":"resume"}
+//	{"command":"mark","label":"phase 2"}
+//	{"command":"flush"}
+//	{"command":"stats"}
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// DefaultPerm is the permissions of the control socket unless given
+// otherwise, so that only its owner can control the recording.
+const DefaultPerm fs.FileMode = 0o600
+
+// Commands accepted by the control socket.
+const (
+	CommandPause  = "pause"  // Pause the recording (see recorder.Recorder.Pause)
+	CommandResume = "resume" // Resume the recording (see recorder.Recorder.Resume)
+	CommandMark   = "mark"   // Record a "mark" meta record with Request.Label
+	CommandFlush  = "flush"  // Write buffered records (see recorder.Recorder.FlushAll)
+	CommandStats  = "stats"  // Return the live counters in Response.Stats
+)
+
+// maxRequestSize limits the length of a request line.
+const maxRequestSize = 64 * 1024
+
+// Request is a command sent to the control socket.
+type Request struct {
+	Command string `json:"command"`
+	Label   string `json:"label,omitempty"` // Label of the mark command
+}
+
+// Response answers a Request.
+type Response struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"` // Why the command failed, if not OK
+	Stats *Stats `json:"stats,omitempty"` // Answer to the stats command
+}
+
+// Stats are the live counters of the recording, as returned by the stats
+// command (see recorder.Stats).
+type Stats struct {
+	Records         uint64            `json:"records"`
+	Bytes           map[string]uint64 `json:"bytes"`
+	Lines           map[string]uint64 `json:"lines"`
+	TruncatedLines  uint64            `json:"truncated_lines"`
+	Errors          uint64            `json:"errors"`
+	LastOutput      *time.Time        `json:"last_output,omitempty"`
+	SeqLimitReached bool              `json:"seq_limit_reached"`
+	Paused          bool              `json:"paused"`
+	SkippedBytes    map[string]uint64 `json:"skipped_bytes"`
+	SkippedLines    map[string]uint64 `json:"skipped_lines"`
+}
+
+// newStats converts the stats of a Recorder.
+func newStats(s recorder.Stats) *Stats {
+	stats := &Stats{
+		Records:         s.Records,
+		Bytes:           bySource(s.Bytes),
+		Lines:           bySource(s.Lines),
+		TruncatedLines:  s.TruncatedLines,
+		Errors:          s.Errors,
+		SeqLimitReached: s.SeqLimitReached,
+		Paused:          s.Paused,
+		SkippedBytes:    bySource(s.SkippedBytes),
+		SkippedLines:    bySource(s.SkippedLines),
+	}
+	if !s.LastOutput.IsZero() {
+		lastOutput := s.LastOutput.UTC()
+		stats.LastOutput = &lastOutput
+	}
+	return stats
+}
+
+// bySource keys counters indexed by recorder.Source by the source names.
+func bySource(counts [3]uint64) map[string]uint64 {
+	m := make(map[string]uint64, len(counts))
+	for source, n := range counts {
+		m[recorder.Source(source).String()] = n
+	}
+	return m
+}
+
+// Listen creates the control socket at path with the permissions perm
+// (0 = DefaultPerm). The socket is removed when the listener is closed.
+func Listen(path string, perm fs.FileMode) (net.Listener, error) {
+	if perm == 0 {
+		perm = DefaultPerm
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Server applies the commands received on a control socket to a Recorder.
+type Server struct {
+	listener net.Listener
+	rec      *recorder.Recorder
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Serve accepts connections on listener in the background and applies their
+// commands to rec until the returned server is closed.
+func Serve(listener net.Listener, rec *recorder.Recorder) *Server {
+	s := &Server{listener: listener, rec: rec, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close stops accepting connections, closes the open ones and waits for the
+// commands in progress, so that none is applied once Close returns.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+// serve answers the requests of a connection until it is closed.
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = Response{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			resp = s.apply(req)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// apply applies a command to the Recorder.
+func (s *Server) apply(req Request) Response {
+	var err error
+	switch req.Command {
+	case CommandPause:
+		if err = s.rec.Pause(); err == nil {
+			logging.Infof("recording paused")
+		}
+	case CommandResume:
+		if err = s.rec.Resume(); err == nil {
+			logging.Infof("recording resumed")
+		}
+	case CommandMark:
+		if req.Label == "" {
+			return Response{Error: "mark requires a label"}
+		}
+		err = s.rec.RecordMeta("mark", map[string]any{"label": req.Label})
+	case CommandFlush:
+		err = s.rec.FlushAll()
+	case CommandStats:
+		return Response{OK: true, Stats: newStats(s.rec.Stats())}
+	case "":
+		return Response{Error: "no command specified"}
+	default:
+		return Response{Error: fmt.Sprintf("unknown command: %s", req.Command)}
+	}
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{OK: true}
+}
+
+// Send sends a request to the control socket at path and returns the
+// response. A response that is not OK is returned along with an error.
+func Send(path string, req Request) (Response, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("failed to read the response: %w", err)
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,175 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// startServer serves a control socket for a new Recorder writing to buf.
+func startServer(t *testing.T, buf *bytes.Buffer) (string, *Server, *recorder.Recorder) {
+	t.Helper()
+	rec, err := recorder.NewRecorder(recorder.NewWriterSink(buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	listener, err := Listen(path, 0)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	return path, Serve(listener, rec), rec
+}
+
+func TestServer_Commands(t *testing.T) {
+	var buf bytes.Buffer
+	path, server, rec := startServer(t, &buf)
+
+	send := func(req Request) Response {
+		t.Helper()
+		resp, err := Send(path, req)
+		if err != nil {
+			t.Fatalf("Send(%+v) error = %v", req, err)
+		}
+		return resp
+	}
+
+	_ = rec.Record(recorder.Stdout, []byte("one\npart"))
+	send(Request{Command: CommandFlush})
+	_ = rec.Record(recorder.Stdout, []byte("ial\n"))
+	send(Request{Command: CommandMark, Label: "phase 2"})
+	send(Request{Command: CommandPause})
+	_ = rec.Record(recorder.Stdout, []byte("skipped\n"))
+
+	resp := send(Request{Command: CommandStats})
+	if resp.Stats == nil {
+		t.Fatal("stats response without stats")
+	}
+	if !resp.Stats.Paused || resp.Stats.SkippedLines["stdout"] != 1 || resp.Stats.Lines["stdout"] != 3 || resp.Stats.LastOutput == nil {
+		t.Errorf("unexpected stats %+v", resp.Stats)
+	}
+
+	send(Request{Command: CommandResume})
+	_ = rec.Record(recorder.Stdout, []byte("two\n"))
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var got []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record recorder.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		content := record.ContentString()
+		if record.Source == "meta" {
+			var meta map[string]any
+			_ = record.JSON(&meta)
+			content, _ = meta["event"].(string)
+			if label, ok := meta["label"].(string); ok {
+				content += " " + label
+			}
+		}
+		got = append(got, record.Source+":"+content)
+	}
+	// The flush splits the incomplete line
+	want := []string{
+		"stdout:one",
+		"stdout:part",
+		"stdout:ial",
+		"meta:mark phase 2",
+		"meta:pause-recording",
+		"meta:resume-recording",
+		"stdout:two",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected records\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	var buf bytes.Buffer
+	path, server, rec := startServer(t, &buf)
+	defer rec.Close()
+	defer server.Close()
+
+	tests := []struct {
+		req     Request
+		wantErr string
+	}{
+		{Request{}, "no command specified"},
+		{Request{Command: "bogus"}, "unknown command: bogus"},
+		{Request{Command: CommandMark}, "mark requires a label"},
+	}
+	for _, tt := range tests {
+		resp, err := Send(path, tt.req)
+		if err == nil || err.Error() != tt.wantErr || resp.OK {
+			t.Errorf("Send(%+v) = %+v, %v, want error %q", tt.req, resp, err, tt.wantErr)
+		}
+	}
+
+	// Several requests on one connection, including a malformed one
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("not json\n\n{\"command\":\"flush\"}\n"))
+	decoder := json.NewDecoder(conn)
+	var resp Response
+	if err := decoder.Decode(&resp); err != nil || resp.OK || !strings.HasPrefix(resp.Error, "invalid request") {
+		t.Errorf("expected an invalid request error, got %+v, %v", resp, err)
+	}
+	resp = Response{}
+	if err := decoder.Decode(&resp); err != nil || !resp.OK {
+		t.Errorf("expected flush to succeed, got %+v, %v", resp, err)
+	}
+}
+
+func TestListen_Permissions(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "default.sock")
+	listener, err := Listen(path, 0)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat socket: %v", err)
+	}
+	if info.Mode().Perm() != DefaultPerm {
+		t.Errorf("socket permissions = %o, want %o", info.Mode().Perm(), DefaultPerm)
+	}
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+
+	path = filepath.Join(dir, "group.sock")
+	listener, err = Listen(path, 0o660)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o660 {
+		t.Errorf("socket permissions = %v, %v, want 660", info, err)
+	}
+
+	// A socket that is in use is not replaced
+	if _, err := Listen(path, 0); err == nil {
+		t.Error("Listen() on a socket in use succeeded, want an error")
+	}
+}
@@ -0,0 +1,109 @@
+// Package logging prints the diagnostic messages of ioetap itself, as
+// "ioetap: <message>" lines on stderr, filtered by level (--log-level).
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level is the verbosity of the diagnostic messages. A message is printed if
+// its level is at most the level set with SetLevel.
+type Level int
+
+const (
+	LevelError Level = iota // Errors that end ioetap
+	LevelWarn               // Problems ioetap carries on with, e.g. recording errors (default)
+	LevelInfo               // Summary of the recording, e.g. record counts and throughput
+	LevelDebug              // Every record as it is written
+)
+
+// levelNames are the names of the levels, indexed by Level.
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+// String returns the name of the level, as accepted by ParseLevel.
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %s", name)
+}
+
+var (
+	mu     sync.Mutex // Guards level and output, and serializes the writes
+	level  = LevelWarn
+	output = io.Writer(os.Stderr)
+)
+
+// SetLevel sets the most verbose level that is printed.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetOutput sets where the messages are printed instead of stderr, and
+// returns the previous destination so it can be restored, e.g. in tests.
+func SetOutput(w io.Writer) io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := output
+	output = w
+	return prev
+}
+
+// Enabled reports whether messages of level l are printed, so that callers
+// can skip building costly messages.
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l <= level
+}
+
+// Errorf prints an error that ends ioetap. It is printed at every level.
+func Errorf(format string, args ...any) {
+	logf(LevelError, format, args...)
+}
+
+// Warnf prints a problem that ioetap carries on with.
+func Warnf(format string, args ...any) {
+	logf(LevelWarn, format, args...)
+}
+
+// Infof prints a summary message.
+func Infof(format string, args ...any) {
+	logf(LevelInfo, format, args...)
+}
+
+// Debugf prints a message for debugging ioetap or the recording.
+func Debugf(format string, args ...any) {
+	logf(LevelDebug, format, args...)
+}
+
+// logf prints a message of level l, if enabled, in a single write so that
+// concurrent messages are not interleaved. A newline is added if missing.
+func logf(l Level, format string, args ...any) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l > level {
+		return
+	}
+	msg := "ioetap: " + fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	_, _ = io.WriteString(output, msg)
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		level Level
+		want  string
+	}{
+		{LevelError, "ioetap: error 1\n"},
+		{LevelWarn, "ioetap: error 1\nioetap: warn 2\n"},
+		{LevelInfo, "ioetap: error 1\nioetap: warn 2\nioetap: info 3\n"},
+		{LevelDebug, "ioetap: error 1\nioetap: warn 2\nioetap: info 3\nioetap: debug 4\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			defer SetOutput(SetOutput(&buf))
+			defer SetLevel(LevelWarn)
+			SetLevel(tt.level)
+
+			Errorf("error %d", 1)
+			Warnf("warn %d", 2)
+			Infof("info %d", 3)
+			Debugf("debug %d\n", 4)
+			if buf.String() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, buf.String())
+			}
+			if !Enabled(tt.level) || Enabled(tt.level+1) {
+				t.Errorf("expected levels up to %v to be enabled", tt.level)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, want := range []Level{LevelError, LevelWarn, LevelInfo, LevelDebug} {
+		got, err := ParseLevel(want.String())
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", want.String(), got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
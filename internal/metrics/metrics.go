@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// sources are the I/O sources reported per series, indexed by
+// recorder.Source.
+var sources = [3]string{"stdin", "stdout", "stderr"}
+
+// Write writes stats in the Prometheus text exposition format, with uptime
+// as the time the child has been running.
+func Write(w io.Writer, stats recorder.Stats, uptime time.Duration) error {
+	ew := &errWriter{w: w}
+
+	ew.metric("ioetap_recorded_bytes_total", "counter", "Bytes of I/O data recorded.")
+	for i, source := range sources {
+		ew.printf("ioetap_recorded_bytes_total{source=%q} %d\n", source, stats.Bytes[i])
+	}
+	ew.metric("ioetap_recorded_lines_total", "counter", "Lines of I/O data recorded.")
+	for i, source := range sources {
+		ew.printf("ioetap_recorded_lines_total{source=%q} %d\n", source, stats.Lines[i])
+	}
+	ew.metric("ioetap_records_total", "counter", "Records written, including meta and stats records.")
+	ew.printf("ioetap_records_total %d\n", stats.Records)
+	ew.metric("ioetap_truncated_lines_total", "counter", "Lines recorded as truncated.")
+	ew.printf("ioetap_truncated_lines_total %d\n", stats.TruncatedLines)
+	ew.metric("ioetap_recording_errors_total", "counter", "Records that failed to be written.")
+	ew.printf("ioetap_recording_errors_total %d\n", stats.Errors)
+	ew.metric("ioetap_child_uptime_seconds", "gauge", "Time since the child started.")
+	ew.printf("ioetap_child_uptime_seconds %g\n", uptime.Seconds())
+	ew.metric("ioetap_last_output_timestamp_seconds", "gauge", "Unix time of the last stdout or stderr data (0 = none yet).")
+	lastOutput := 0.0
+	if !stats.LastOutput.IsZero() {
+		lastOutput = float64(stats.LastOutput.UnixMilli()) / 1000
+	}
+	ew.printf("ioetap_last_output_timestamp_seconds %g\n", lastOutput)
+
+	return ew.err
+}
+
+// Handler returns an http.Handler that serves the current stats at /metrics.
+// The child's uptime is measured from start.
+func Handler(stats func() recorder.Stats, start time.Time) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = Write(w, stats(), time.Since(start))
+	})
+	return mux
+}
+
+// Serve serves Handler on listener in the background until the returned
+// server is closed.
+func Serve(listener net.Listener, stats func() recorder.Stats, start time.Time) *http.Server {
+	server := &http.Server{
+		Handler:           Handler(stats, start),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server
+}
+
+// errWriter keeps the first error of a series of writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...any) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+// metric writes the HELP and TYPE lines of a metric.
+func (ew *errWriter) metric(name, typ, help string) {
+	ew.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+func TestWrite(t *testing.T) {
+	stats := recorder.Stats{
+		Records:        7,
+		Bytes:          [3]uint64{3, 120, 40},
+		Lines:          [3]uint64{1, 4, 2},
+		TruncatedLines: 1,
+		Errors:         2,
+		LastOutput:     time.UnixMilli(1705314645123),
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, stats, 90*time.Second); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"# TYPE ioetap_recorded_bytes_total counter\n",
+		`ioetap_recorded_bytes_total{source="stdout"} 120` + "\n",
+		`ioetap_recorded_lines_total{source="stderr"} 2` + "\n",
+		"ioetap_records_total 7\n",
+		"ioetap_truncated_lines_total 1\n",
+		"ioetap_recording_errors_total 2\n",
+		"# TYPE ioetap_child_uptime_seconds gauge\n",
+		"ioetap_child_uptime_seconds 90\n",
+		"ioetap_last_output_timestamp_seconds 1.705314645123e+09\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, buf.String())
+		}
+	}
+
+	// Every sample belongs to a metric declared before it
+	declared := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "# TYPE "):
+			declared[fields[2]] = true
+		case strings.HasPrefix(line, "#"):
+		case len(fields) != 2:
+			t.Errorf("malformed sample %q", line)
+		default:
+			name, _, _ := strings.Cut(fields[0], "{")
+			if !declared[name] {
+				t.Errorf("sample %q of an undeclared metric", line)
+			}
+		}
+	}
+}
+
+func TestWrite_NoOutputYet(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, recorder.Stats{}, 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "ioetap_last_output_timestamp_seconds 0\n") {
+		t.Errorf("expected a zero last output timestamp in:\n%s", buf.String())
+	}
+}
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stats := func() recorder.Stats { return recorder.Stats{Records: 3} }
+	server := Serve(listener, stats, time.Now())
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected response %s (%s)", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "ioetap_records_total 3\n") {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+
+	// Closing the server stops serving
+	if err := server.Close(); err != nil {
+		t.Fatalf("failed to close server: %v", err)
+	}
+	if _, err := http.Get("http://" + listener.Addr().String() + "/metrics"); err == nil {
+		t.Error("expected the server to be closed")
+	}
+}
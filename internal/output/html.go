@@ -0,0 +1,39 @@
+// Package output renders recordings in formats other than NDJSON.
+package output
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+//go:embed templates/session.html
+var sessionHTML string
+
+var sessionTemplate = template.Must(template.New("session").Parse(sessionHTML))
+
+// sessionData is the data passed to the session template.
+type sessionData struct {
+	Title   string
+	Records []recorder.Record
+}
+
+// WriteHTML writes a self-contained HTML session viewer for the given records.
+// The page has inline CSS and JavaScript only, so it works offline. The
+// records are embedded as a JSON array in a <script id="ioetap-records">
+// block, which also provides the timing data for playback.
+func WriteHTML(w io.Writer, title string, records []recorder.Record) error {
+	if records == nil {
+		// Render an empty array rather than null
+		records = []recorder.Record{}
+	}
+
+	data := sessionData{Title: title, Records: records}
+	if err := sessionTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("failed to write HTML: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// extractRecordsJSON returns the contents of the embedded records script block.
+func extractRecordsJSON(t *testing.T, html string) string {
+	t.Helper()
+
+	const open = `<script type="application/json" id="ioetap-records">`
+	start := strings.Index(html, open)
+	if start == -1 {
+		t.Fatal("records script block not found")
+	}
+	start += len(open)
+	end := strings.Index(html[start:], "</script>")
+	if end == -1 {
+		t.Fatal("records script block not closed")
+	}
+	return html[start : start+end]
+}
+
+func TestWriteHTML(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []recorder.Record{
+		recorder.NewRecord(0, now, "stdin", []byte("hello\n")),
+		recorder.NewRecord(1, now, "stdout", []byte(`{"key": "</script><b>"}`+"\n")),
+		recorder.NewRecord(2, now, "stderr", []byte("<error> & more\n")),
+		recorder.NewRecord(3, now, "stdout", []byte{0xff, 0xfe}),
+		recorder.NewMetaRecord(4, now, map[string]any{"event": "suspend"}),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, "echo <hello>", records); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	html := buf.String()
+
+	// Valid HTML5 document structure
+	if !strings.HasPrefix(html, "<!DOCTYPE html>\n") {
+		t.Error("output does not start with the HTML5 doctype")
+	}
+	for _, want := range []string{`<html lang="en">`, `<meta charset="utf-8">`, "<head>", "</head>", "<body>", "</body>", "</html>"} {
+		if strings.Count(html, want) != 1 {
+			t.Errorf("expected exactly one %q", want)
+		}
+	}
+
+	// Self-contained: no external resources
+	for _, unwanted := range []string{"http://", "https://", "<link", " src="} {
+		if strings.Contains(html, unwanted) {
+			t.Errorf("output references external resources: found %q", unwanted)
+		}
+	}
+
+	// The title is escaped
+	if !strings.Contains(html, "<title>ioetap: echo &lt;hello&gt;</title>") {
+		t.Error("title not found or not escaped")
+	}
+
+	// The record content cannot break out of the script block
+	data := extractRecordsJSON(t, html)
+	if strings.Contains(data, "<") {
+		t.Errorf("records JSON contains unescaped '<': %s", data)
+	}
+
+	var got []recorder.Record
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("records JSON is not parseable: %v", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("expected %d records, got %d", len(records), len(got))
+	}
+	for i, want := range records {
+		if got[i].Seq != want.Seq || got[i].Source != want.Source || got[i].Encoding != want.Encoding ||
+			got[i].Timestamp != want.Timestamp || got[i].ContentString() != want.ContentString() {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
+
+func TestWriteHTML_NoRecords(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, "true", nil); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+
+	data := strings.TrimSpace(extractRecordsJSON(t, buf.String()))
+	if data != "[]" {
+		t.Errorf("expected empty array, got %q", data)
+	}
+}
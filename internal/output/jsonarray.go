@@ -0,0 +1,84 @@
+package output
+
+import (
+	"bytes"
+	"io"
+)
+
+// JSONArrayWriter turns the NDJSON records written to it into a single JSON
+// array, for tools that expect one document rather than a record per line:
+//
+//	[
+//	{"seq":0,...},
+//	{"seq":1,...}
+//	]
+//
+// Every newline ends a record, so writes may split or join records. Since
+// the last record is only known when the writer is closed, the newline of a
+// record is held back until more data follows, which then starts with ",\n",
+// or until Close, which ends the array with "\n]\n". Nothing has to be
+// rewritten, so w need not be seekable. Close does not close w.
+type JSONArrayWriter struct {
+	w       io.Writer
+	started bool // "[\n" has been written
+	pending bool // A record has ended, but its separator is not written yet
+	err     error
+}
+
+// NewJSONArrayWriter returns a JSONArrayWriter that writes to w.
+func NewJSONArrayWriter(w io.Writer) *JSONArrayWriter {
+	return &JSONArrayWriter{w: w}
+}
+
+// Write writes the NDJSON data p as elements of the array.
+func (a *JSONArrayWriter) Write(p []byte) (int, error) {
+	for rest := p; len(rest) > 0; {
+		line, after, ended := bytes.Cut(rest, []byte("\n"))
+		if len(line) > 0 {
+			a.writePrefix()
+			a.write(line)
+		}
+		if ended {
+			a.pending = true
+		}
+		rest = after
+	}
+	if a.err != nil {
+		return 0, a.err
+	}
+	return len(p), nil
+}
+
+// Close ends the array. An array without records is written as "[\n]\n".
+func (a *JSONArrayWriter) Close() error {
+	if !a.started {
+		a.started = true
+		a.write([]byte("[\n"))
+	}
+	if a.pending {
+		a.pending = false
+		a.write([]byte("\n"))
+	}
+	a.write([]byte("]\n"))
+	return a.err
+}
+
+// writePrefix writes what comes before more data of a record: the start of
+// the array, or the separator after the previous record.
+func (a *JSONArrayWriter) writePrefix() {
+	if !a.started {
+		a.started = true
+		a.write([]byte("[\n"))
+	}
+	if a.pending {
+		a.pending = false
+		a.write([]byte(",\n"))
+	}
+}
+
+// write writes data to w unless an earlier write failed.
+func (a *JSONArrayWriter) write(data []byte) {
+	if a.err == nil {
+		_, a.err = a.w.Write(data)
+	}
+}
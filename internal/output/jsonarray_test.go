@@ -0,0 +1,98 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+func TestJSONArrayWriter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var ndjson bytes.Buffer
+	for i, data := range []string{"hello\n", `{"key": [1, 2]}` + "\n", "\xff\xfe", "line, with commas\n"} {
+		line, err := recorder.NewRecord(uint64(i), now, "stdout", []byte(data)).ToJSON()
+		if err != nil {
+			t.Fatalf("failed to serialize record: %v", err)
+		}
+		ndjson.Write(line)
+		ndjson.WriteByte('\n')
+	}
+	line, _ := recorder.NewMetaRecord(4, now, map[string]any{"event": "suspend"}).ToJSON()
+	ndjson.Write(line)
+	ndjson.WriteByte('\n')
+
+	// Records split across writes and several in one write are the same
+	for _, chunkSize := range []int{1, 7, ndjson.Len()} {
+		var buf bytes.Buffer
+		w := NewJSONArrayWriter(&buf)
+		for data := ndjson.Bytes(); len(data) > 0; {
+			n := min(chunkSize, len(data))
+			if written, err := w.Write(data[:n]); written != n || err != nil {
+				t.Fatalf("Write() = %d, %v, want %d, nil", written, err, n)
+			}
+			data = data[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		out := buf.String()
+		if !bytes.HasPrefix(buf.Bytes(), []byte("[\n{")) || !bytes.HasSuffix(buf.Bytes(), []byte("}\n]\n")) {
+			t.Errorf("chunk size %d: unexpected framing:\n%s", chunkSize, out)
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("},\n{")); n != 4 {
+			t.Errorf("chunk size %d: expected 4 separators, got %d:\n%s", chunkSize, n, out)
+		}
+
+		var records []recorder.Record
+		if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+			t.Fatalf("chunk size %d: invalid JSON array: %v\n%s", chunkSize, err, out)
+		}
+		if len(records) != 5 {
+			t.Fatalf("chunk size %d: expected 5 records, got %d", chunkSize, len(records))
+		}
+		for i, record := range records {
+			if record.Seq != uint64(i) {
+				t.Errorf("chunk size %d: element %d has seq %d", chunkSize, i, record.Seq)
+			}
+		}
+		if records[3].ContentString() != "line, with commas" || records[2].Encoding != "base64" {
+			t.Errorf("chunk size %d: unexpected records %+v", chunkSize, records)
+		}
+	}
+}
+
+func TestJSONArrayWriter_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewJSONArrayWriter(&buf).Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if buf.String() != "[\n]\n" {
+		t.Errorf("expected an empty array, got %q", buf.String())
+	}
+	var records []recorder.Record
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil || len(records) != 0 {
+		t.Errorf("expected no records, got %v, %v", records, err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestJSONArrayWriter_Error(t *testing.T) {
+	w := NewJSONArrayWriter(failingWriter{})
+	if _, err := w.Write([]byte("{}\n")); err == nil || err.Error() != "disk full" {
+		t.Errorf("Write() error = %v, want disk full", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close() after a failed write succeeded, want an error")
+	}
+}
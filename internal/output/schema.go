@@ -0,0 +1,126 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// RecordSchema returns a JSON Schema (draft-07) describing a record of the
+// given record format version (see recorder.FormatVersion). The properties
+// are generated from recorder.RecordFields, which the records are written
+// with, so the schema always matches the records.
+func RecordSchema(version int) (map[string]any, error) {
+	if version != recorder.FormatVersion {
+		return nil, fmt.Errorf("unsupported record format version: %d (supported: %d)", version, recorder.FormatVersion)
+	}
+
+	properties := make(map[string]any, len(recorder.RecordFields))
+	var required []string
+	for _, field := range recorder.RecordFields {
+		properties[field.Name] = field.Schema
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+
+	return map[string]any{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"$id":                  "https://github.com/trustin/ioetap/record-schema.json",
+		"$comment":             fmt.Sprintf("ioetap record format version %d", version),
+		"title":                "ioetap Record",
+		"description":          "A single record in an ioetap recording file (NDJSON format)",
+		"type":                 "object",
+		"required":             required,
+		"properties":           properties,
+		"additionalProperties": false,
+		"allOf":                contentVariants,
+		"examples":             schemaExamples,
+	}, nil
+}
+
+// contentVariants constrain the content of a record by its encoding and
+// source, matching recorder.NewRecord, NewMetaRecord, NewStatsRecord,
+// NewResourceRecord and NewAnnotationRecord.
+var contentVariants = []any{
+	variant("encoding", "text", map[string]any{
+		"content": map[string]any{"type": "string"},
+	}),
+	variant("encoding", "base64", map[string]any{
+		"content": map[string]any{"type": "string", "pattern": "^[A-Za-z0-9+/]*={0,2}$"},
+	}),
+	variant("source", recorder.MetaSource, map[string]any{
+		"encoding": map[string]any{"const": "json"},
+		"content":  map[string]any{"type": "object", "required": []string{"event"}},
+	}),
+	variant("source", recorder.StatsSource, map[string]any{
+		"encoding": map[string]any{"const": "json"},
+		"content":  map[string]any{"type": "object"},
+	}),
+	variant("source", recorder.ResourceSource, map[string]any{
+		"encoding": map[string]any{"const": "json"},
+		"content": map[string]any{
+			"type":     "object",
+			"required": []string{"cpu_user_ms", "cpu_sys_ms", "rss_bytes"},
+			"properties": map[string]any{
+				"cpu_user_ms": map[string]any{"type": "integer", "minimum": 0},
+				"cpu_sys_ms":  map[string]any{"type": "integer", "minimum": 0},
+				"rss_bytes":   map[string]any{"type": "integer", "minimum": 0},
+			},
+		},
+	}),
+	variant("source", recorder.AnnotationSource, map[string]any{
+		"encoding": map[string]any{"const": "text"},
+	}),
+}
+
+// variant returns a schema that applies properties to records whose field
+// name has the given value.
+func variant(name, value string, properties map[string]any) map[string]any {
+	return map[string]any{
+		"if": map[string]any{
+			"properties": map[string]any{name: map[string]any{"const": value}},
+			"required":   []string{name},
+		},
+		"then": map[string]any{"properties": properties},
+	}
+}
+
+var schemaExamples = []any{
+	map[string]any{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "content": "Hello, World!", "encoding": "text", "end": "\n"},
+	map[string]any{"seq": 1, "timestamp": "2024-01-15T10:30:45.456Z", "source": "stdout", "content": map[string]any{"key": "value", "count": 42}, "encoding": "json", "end": "\n"},
+	map[string]any{"seq": 2, "timestamp": "2024-01-15T10:30:45.789Z", "source": "stdout", "content": "//4AAQ==", "encoding": "base64"},
+	map[string]any{"seq": 3, "timestamp": "2024-01-15T10:30:46.000Z", "source": "stdout", "content": "This is a very long line that was trun", "encoding": "text", "end": "\n", "truncated": true},
+	map[string]any{"seq": 4, "timestamp": "2024-01-15T10:30:46.500Z", "source": "meta", "content": map[string]any{"event": "suspend"}, "encoding": "json"},
+}
+
+// GenerateRecordSchema returns the JSON Schema of a record of the current
+// record format version, marshalled as a single line of JSON.
+func GenerateRecordSchema() []byte {
+	schema, err := RecordSchema(recorder.FormatVersion)
+	if err != nil {
+		panic(err)
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		// The schema only contains JSON-compatible values
+		panic(err)
+	}
+	return data
+}
+
+// FormatRecordSchema returns the JSON Schema of a record of the given record
+// format version as indented JSON, as printed by ioetap schema and kept in
+// record-schema.json.
+func FormatRecordSchema(version int) ([]byte, error) {
+	schema, err := RecordSchema(version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,288 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// validate checks value against the subset of JSON Schema used by the
+// record schema: type, required, properties, additionalProperties, enum,
+// const, minimum, pattern, allOf and if/then.
+func validate(schema map[string]any, value any) error {
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, s := range allOf {
+			if err := validate(s.(map[string]any), value); err != nil {
+				return err
+			}
+		}
+	}
+	if cond, ok := schema["if"].(map[string]any); ok && validate(cond, value) == nil {
+		if err := validate(schema["then"].(map[string]any), value); err != nil {
+			return err
+		}
+	}
+	if typ, ok := schema["type"].(string); ok {
+		if err := checkType(typ, value); err != nil {
+			return err
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%v is not one of %v", value, enum)
+	}
+	if c, ok := schema["const"]; ok && c != value {
+		return fmt.Errorf("%v is not %v", value, c)
+	}
+	if min, ok := schema["minimum"].(float64); ok && value.(float64) < min {
+		return fmt.Errorf("%v is less than %v", value, min)
+	}
+	if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(value.(string)) {
+		return fmt.Errorf("%q does not match %s", value, pattern)
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("missing required property %q", name)
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	for name, v := range object {
+		var propSchema map[string]any
+		if s, ok := properties[name]; ok {
+			propSchema = s.(map[string]any)
+		} else {
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("unexpected property %q", name)
+				}
+				continue
+			case map[string]any:
+				propSchema = additional
+			default:
+				continue
+			}
+		}
+		if err := validate(propSchema, v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func checkType(typ string, value any) error {
+	ok := false
+	switch typ {
+	case "object":
+		_, ok = value.(map[string]any)
+	case "string":
+		_, ok = value.(string)
+	case "boolean":
+		_, ok = value.(bool)
+	case "integer":
+		f, isNumber := value.(float64)
+		ok = isNumber && f == float64(int64(f))
+	}
+	if !ok {
+		return fmt.Errorf("%v is not of type %s", value, typ)
+	}
+	return nil
+}
+
+func parseSchema(t *testing.T) map[string]any {
+	t.Helper()
+
+	data := GenerateRecordSchema()
+	if strings.Contains(string(data), "\n") {
+		t.Fatal("schema must fit on a single line")
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	return schema
+}
+
+func TestGenerateRecordSchema(t *testing.T) {
+	schema := parseSchema(t)
+
+	if schema["$schema"] != "http://json-schema.org/draft-07/schema#" {
+		t.Errorf("expected a draft-07 schema, got %v", schema["$schema"])
+	}
+	if schema["type"] != "object" || schema["additionalProperties"] != false {
+		t.Errorf("expected a closed object schema: %v", schema)
+	}
+
+	// Every field a record can have is described
+	record := recorder.NewRecord(0, time.Now(), "stdout", []byte("line\n"))
+	record.Truncated = true
+	record.Burst = true
+	record.Fields = map[string]string{"k": "v"}
+	record.ChainHash = strings.Repeat("0", 64)
+	data, err := record.ToJSON()
+	if err != nil {
+		t.Fatalf("failed to serialize record: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	properties := schema["properties"].(map[string]any)
+	for name := range fields {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("schema does not describe record field %q", name)
+		}
+	}
+
+	// The examples are valid records
+	for _, example := range schema["examples"].([]any) {
+		if err := validate(schema, example); err != nil {
+			t.Errorf("example %v does not validate: %v", example, err)
+		}
+	}
+}
+
+func TestGenerateRecordSchema_ValidatesRecords(t *testing.T) {
+	schema := parseSchema(t)
+	now := time.Now()
+
+	truncated := recorder.NewRecord(3, now, "stdout", []byte("trun\n"))
+	truncated.Truncated = true
+	withFields := recorder.NewRecord(4, now, "stderr", []byte("err\r\n"))
+	withFields.Fields = map[string]string{"trace_id": "abc"}
+	withFields.Burst = true
+
+	valid := []recorder.Record{
+		recorder.NewRecord(0, now, "stdin", []byte("hello\n")),
+		recorder.NewRecord(1, now, "stdout", []byte(`{"key": [1, 2, null]}`)),
+		recorder.NewRecord(2, now, "stdout", []byte{0xff, 0xfe}),
+		truncated,
+		withFields,
+		recorder.NewMetaRecord(5, now, map[string]any{"event": "suspend"}),
+		recorder.NewStatsRecord(6, now, map[string]any{"p50": 1, "max": 2}),
+		recorder.NewResourceRecord(7, now, map[string]any{"cpu_user_ms": 120, "cpu_sys_ms": 10, "rss_bytes": 4096}),
+	}
+	for _, record := range valid {
+		data, err := record.ToJSON()
+		if err != nil {
+			t.Fatalf("failed to serialize record: %v", err)
+		}
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if err := validate(schema, value); err != nil {
+			t.Errorf("record %s does not validate: %v", data, err)
+		}
+	}
+
+	invalid := []string{
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "encoding": "text"}`,
+		`{"seq": -1, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "content": "", "encoding": "text"}`,
+		`{"seq": 0, "timestamp": "yesterday", "source": "stdout", "content": "", "encoding": "text"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "fd3", "content": "", "encoding": "text"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "content": "", "encoding": "text", "extra": 1}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "content": "", "encoding": "text", "fields": {"n": 1}}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "content": {"a": 1}, "encoding": "text"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "stdout", "content": "not base64!", "encoding": "base64"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "meta", "content": "suspend", "encoding": "text"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "meta", "content": {"signal": 1}, "encoding": "json"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "resource", "content": {"cpu_user_ms": 1, "rss_bytes": 4096}, "encoding": "json"}`,
+		`{"seq": 0, "timestamp": "2024-01-15T10:30:45.123Z", "source": "resource", "content": {"cpu_user_ms": 1, "cpu_sys_ms": -1, "rss_bytes": 4096}, "encoding": "json"}`,
+	}
+	for _, data := range invalid {
+		var value any
+		if err := json.Unmarshal([]byte(data), &value); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if err := validate(schema, value); err == nil {
+			t.Errorf("record %s should not validate", data)
+		}
+	}
+}
+
+func TestGenerateRecordSchema_ValidatesRecordedRecords(t *testing.T) {
+	schema := parseSchema(t)
+
+	// Records as written by the recorder with every option that adds fields
+	var buf bytes.Buffer
+	rec, err := recorder.NewRecorder(recorder.NewWriterSink(&buf), 8,
+		recorder.WithFields(map[string]string{"job": "build"}),
+		recorder.WithChainHash(),
+		recorder.WithBurstDetection(),
+		recorder.WithTimingHistogram(),
+		recorder.WithEmptyReadMarker(),
+		recorder.WithReadSizes(),
+	)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	rec.Record(recorder.Stdin, []byte("hello\r\n"))
+	rec.Record(recorder.Stdout, []byte(`{"n": 1}`+"\n"))
+	rec.Record(recorder.Stdout, []byte("a line longer than eight bytes\n"))
+	rec.Record(recorder.Stderr, []byte{0xff, 0xfe, '\n'})
+	rec.Record(recorder.Stderr, nil)
+	rec.RecordMeta("suspend", nil)
+	rec.RecordResource(map[string]any{"cpu_user_ms": int64(10), "cpu_sys_ms": int64(0), "rss_bytes": uint64(4096)})
+	rec.CopyAndRecord(recorder.Stdout, strings.NewReader("partial"), io.Discard)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 8 {
+		t.Fatalf("expected at least 8 records, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var value any
+		if err := json.Unmarshal([]byte(line), &value); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if err := validate(schema, value); err != nil {
+			t.Errorf("record %s does not validate: %v", line, err)
+		}
+	}
+}
+
+func TestRecordSchema_Version(t *testing.T) {
+	if _, err := RecordSchema(recorder.FormatVersion); err != nil {
+		t.Errorf("unexpected error for the current version: %v", err)
+	}
+	for _, version := range []int{0, recorder.FormatVersion + 1} {
+		if _, err := RecordSchema(version); err == nil {
+			t.Errorf("expected an error for version %d", version)
+		}
+	}
+}
+
+func TestFormatRecordSchema_MatchesFile(t *testing.T) {
+	// record-schema.json is the output of "ioetap schema"; regenerate it with
+	// go run ./cmd/ioetap schema > record-schema.json
+	want, err := FormatRecordSchema(recorder.FormatVersion)
+	if err != nil {
+		t.Fatalf("FormatRecordSchema failed: %v", err)
+	}
+	got, err := os.ReadFile("../../record-schema.json")
+	if err != nil {
+		t.Fatalf("failed to read record-schema.json: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("record-schema.json is out of date; regenerate it with: go run ./cmd/ioetap schema > record-schema.json")
+	}
+}
@@ -0,0 +1,89 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// BroadcastWriter copies the data forwarded to the child's stdin to a named
+// pipe or file, so that it can be watched while the child runs, e.g. with
+// "cat <fifo>".
+//
+// Writing never blocks or fails: the descriptor is opened and written in
+// non-blocking mode, and data a named pipe does not accept, because it has
+// no reader or its buffer is full, is dropped. A named pipe without a reader
+// is opened again on the next Write, so a reader can attach at any time.
+type BroadcastWriter struct {
+	path string
+
+	mu sync.Mutex
+	fd int // -1 if not open
+}
+
+// OpenBroadcast opens the named pipe or file at path for a BroadcastWriter,
+// creating a regular file if nothing exists at path. A named pipe without a
+// reader is not an error.
+func OpenBroadcast(path string) (*BroadcastWriter, error) {
+	w := &BroadcastWriter{path: path, fd: -1}
+	if err := w.open(); err != nil && !errors.Is(err, syscall.ENXIO) {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return w, nil
+}
+
+// open opens the path for writing. Opening a named pipe fails with ENXIO if
+// it has no reader.
+func (w *BroadcastWriter) open() error {
+	fd, err := syscall.Open(w.path, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_APPEND|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0o666)
+	if err != nil {
+		return err
+	}
+	w.fd = fd
+	return nil
+}
+
+// Write writes p to the named pipe or file, as far as it accepts it without
+// blocking. It always returns len(p) and no error.
+func (w *BroadcastWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fd < 0 && w.open() != nil {
+		return len(p), nil
+	}
+	for written := 0; written < len(p); {
+		n, err := syscall.Write(w.fd, p[written:])
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.EPIPE) {
+			// The reader has gone; wait for the next one
+			syscall.Close(w.fd)
+			w.fd = -1
+		}
+		if err != nil || n <= 0 {
+			break
+		}
+		written += n
+	}
+	return len(p), nil
+}
+
+// Close closes the named pipe or file. It does not remove it.
+func (w *BroadcastWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(w.fd)
+	w.fd = -1
+	if err != nil {
+		return os.NewSyscallError("close", err)
+	}
+	return nil
+}
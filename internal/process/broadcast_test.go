@@ -0,0 +1,115 @@
+package process
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBroadcastWriter_FIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "stdin.fifo")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatalf("failed to create fifo: %v", err)
+	}
+
+	// Attach the reader first, since data without a reader is dropped.
+	// Opening a fifo for reading does not block in non-blocking mode.
+	fd, err := syscall.Open(fifo, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("failed to open fifo for reading: %v", err)
+	}
+	r := os.NewFile(uintptr(fd), fifo)
+	defer r.Close()
+
+	w, err := OpenBroadcast(fifo)
+	if err != nil {
+		t.Fatalf("OpenBroadcast() error = %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(r)
+		received <- data
+	}()
+
+	want := "hello\nworld\n\x00\xff"
+	for _, chunk := range []string{"hello\n", "world\n", "\x00\xff"} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v, want %d, nil", chunk, n, err, len(chunk))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if string(data) != want {
+			t.Errorf("fifo received %q, want %q", data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fifo reader did not finish")
+	}
+}
+
+func TestBroadcastWriter_FIFOWithoutReader(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "stdin.fifo")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatalf("failed to create fifo: %v", err)
+	}
+
+	w, err := OpenBroadcast(fifo)
+	if err != nil {
+		t.Fatalf("OpenBroadcast() error = %v", err)
+	}
+	defer w.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// More than a pipe buffer, which would block a blocking write
+		chunk := make([]byte, 64*1024)
+		for i := 0; i < 4; i++ {
+			if n, err := w.Write(chunk); n != len(chunk) || err != nil {
+				t.Errorf("Write() = %d, %v, want %d, nil", n, err, len(chunk))
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked without a reader")
+	}
+}
+
+func TestBroadcastWriter_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin.log")
+
+	w, err := OpenBroadcast(path)
+	if err != nil {
+		t.Fatalf("OpenBroadcast() error = %v", err)
+	}
+	_, _ = w.Write([]byte("one\n"))
+	_, _ = w.Write([]byte("two\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("file content = %q, want %q", data, "one\ntwo\n")
+	}
+}
+
+func TestOpenBroadcast_Error(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "stdin.log")
+	if _, err := OpenBroadcast(path); err == nil {
+		t.Error("OpenBroadcast() with a missing directory succeeded, want an error")
+	}
+}
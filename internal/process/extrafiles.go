@@ -0,0 +1,20 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// InheritedFile returns an *os.File for the descriptor fd that ioetap
+// inherited from its parent, e.g. a socket for systemd-style socket
+// activation. It returns an error if fd is not open.
+//
+// Call it before ioetap opens any files of its own; otherwise fd may refer
+// to one of them rather than an inherited descriptor.
+func InheritedFile(fd int) (*os.File, error) {
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0); errno != 0 {
+		return nil, fmt.Errorf("fd %d is not open: %w", fd, errno)
+	}
+	return os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd)), nil
+}
@@ -0,0 +1,76 @@
+package process
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// socketpair returns both ends of a connected Unix socket pair.
+func socketpair(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("failed to create socketpair: %v", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "sock0"), os.NewFile(uintptr(fds[1]), "sock1")
+}
+
+func TestStartWithOptions_ExtraFiles(t *testing.T) {
+	ctx := context.Background()
+
+	local, remote := socketpair(t)
+	defer local.Close()
+
+	opts := ProcessOptions{ExtraFiles: []*os.File{remote}}
+	proc, err := StartWithOptions(ctx, "sh", []string{"-c", "echo hello >&3"}, opts)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	// The child has its own copy now
+	remote.Close()
+
+	proc.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stdout) }()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	output, err := io.ReadAll(local)
+	if err != nil {
+		t.Fatalf("failed to read socket: %v", err)
+	}
+	if exitCode := proc.Wait(); exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+
+	if strings.TrimSpace(string(output)) != "hello" {
+		t.Errorf("expected %q from fd 3, got %q", "hello", output)
+	}
+}
+
+func TestInheritedFile(t *testing.T) {
+	local, remote := socketpair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	fd, err := syscall.Dup(int(remote.Fd()))
+	if err != nil {
+		t.Fatalf("failed to dup: %v", err)
+	}
+
+	file, err := InheritedFile(fd)
+	if err != nil {
+		t.Fatalf("InheritedFile failed: %v", err)
+	}
+	if int(file.Fd()) != fd {
+		t.Errorf("expected fd %d, got %d", fd, file.Fd())
+	}
+
+	file.Close()
+	if _, err := InheritedFile(fd); err == nil || !strings.Contains(err.Error(), "is not open") {
+		t.Errorf("expected 'not open' error, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package process
+
+import "syscall"
+
+// Identity is the user context a child process inherits from ioetap.
+type Identity struct {
+	UID   int // Effective user ID
+	GID   int // Effective group ID
+	Umask int // File mode creation mask
+}
+
+// CurrentIdentity returns the identity of the current process, which
+// children started by it inherit.
+func CurrentIdentity() Identity {
+	return Identity{
+		UID:   syscall.Geteuid(),
+		GID:   syscall.Getegid(),
+		Umask: currentUmask(),
+	}
+}
+
+// swapUmask reads the umask by setting it and restoring it right away,
+// since umask(2) has no pure getter. Files created by other goroutines in
+// between would get a umask of 0, so 0o077 is set instead, which errs on the
+// side of restrictive permissions.
+func swapUmask() int {
+	umask := syscall.Umask(0o077)
+	syscall.Umask(umask)
+	return umask
+}
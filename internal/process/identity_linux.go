@@ -0,0 +1,30 @@
+package process
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// currentUmask returns the umask of the current process. It is read from
+// /proc/self/status (Linux 4.7 or later) without changing it, falling back
+// to swapUmask.
+func currentUmask() int {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return swapUmask()
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "Umask:"); ok {
+			if umask, err := strconv.ParseInt(strings.TrimSpace(value), 8, 32); err == nil {
+				return int(umask)
+			}
+			break
+		}
+	}
+	return swapUmask()
+}
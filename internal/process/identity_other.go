@@ -0,0 +1,8 @@
+//go:build !linux
+
+package process
+
+// currentUmask returns the umask of the current process.
+func currentUmask() int {
+	return swapUmask()
+}
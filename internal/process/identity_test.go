@@ -0,0 +1,30 @@
+package process
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestCurrentIdentity(t *testing.T) {
+	for _, umask := range []int{0o022, 0o027, 0o077} {
+		old := syscall.Umask(umask)
+		identity := CurrentIdentity()
+		syscall.Umask(old)
+
+		if identity.Umask != umask {
+			t.Errorf("Umask = %#o, want %#o", identity.Umask, umask)
+		}
+		if identity.UID != os.Geteuid() || identity.GID != os.Getegid() {
+			t.Errorf("UID/GID = %d/%d, want %d/%d", identity.UID, identity.GID, os.Geteuid(), os.Getegid())
+		}
+	}
+
+	// Reading the umask does not change it
+	old := syscall.Umask(0o027)
+	CurrentIdentity()
+	swapUmask()
+	if got := syscall.Umask(old); got != 0o027 {
+		t.Errorf("umask changed to %#o", got)
+	}
+}
@@ -0,0 +1,16 @@
+package process
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// poll waits without a timeout until one of fds is ready. ppoll(2) is used
+// since not every architecture has poll(2).
+func poll(fds []pollFd) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
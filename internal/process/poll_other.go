@@ -0,0 +1,18 @@
+//go:build !linux
+
+package process
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// poll waits without a timeout until one of fds is ready.
+func poll(fds []pollFd) error {
+	timeout := -1
+	_, _, errno := syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), uintptr(timeout))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -8,6 +8,9 @@ import (
 	"os/exec"
 	"os/signal"
 	"syscall"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
 )
 
 // Process wraps an exec.Cmd with stdin/stdout/stderr pipes.
@@ -16,43 +19,133 @@ type Process struct {
 	Stdin  io.WriteCloser
 	Stdout io.ReadCloser
 	Stderr io.ReadCloser
+
+	done   chan struct{} // closed when the process has exited
+	status ExitStatus    // valid once done is closed
+}
+
+// ExitStatus describes how the child process exited.
+type ExitStatus struct {
+	Code   int       // Exit code, or -1 if it could not be determined (e.g. killed by a signal)
+	Signal os.Signal // Signal that terminated the process (nil if it exited normally)
+}
+
+// ProcessOptions configures a child process started with StartWithOptions.
+type ProcessOptions struct {
+	Rlimits    []Rlimit   // Resource limits applied to the child (Linux only)
+	ExtraFiles []*os.File // Open files passed to the child as fd 3, 4, ... in order
+}
+
+// Rlimit is a resource limit for the child process.
+type Rlimit struct {
+	Name     string // Resource name used in error messages (e.g. "nofile")
+	Resource int    // syscall.RLIMIT_* constant
+	Cur      uint64 // Soft limit
+	Max      uint64 // Hard limit
+}
+
+// ShellCode returns the exit code as reported by a shell: the exit code if
+// the process exited normally, or 128 plus the signal number if it was
+// terminated by a signal.
+func (s ExitStatus) ShellCode() int {
+	if sig, ok := s.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return s.Code
 }
 
 // Start creates and starts a new child process with the given command and arguments.
+// The process is waited for internally; use Done, Wait or WaitTimeout to
+// observe its exit.
 func Start(ctx context.Context, name string, args []string) (*Process, error) {
-	cmd := exec.CommandContext(ctx, name, args...)
+	return StartWithOptions(ctx, name, args, ProcessOptions{})
+}
+
+// StartWithOptions is like Start, but configures the child with opts.
+// If a resource limit cannot be applied, the command is not run and an error
+// is returned.
+func StartWithOptions(ctx context.Context, name string, args []string, opts ProcessOptions) (*Process, error) {
+	return start(exec.CommandContext(ctx, name, args...), opts, (*Process).wait)
+}
 
-	stdin, err := cmd.StdinPipe()
+// start starts cmd as configured by opts and calls wait in a new goroutine,
+// which must record the exit status of the process and close done.
+func start(cmd *exec.Cmd, opts ProcessOptions, wait func(*Process)) (*Process, error) {
+	cmd.ExtraFiles = opts.ExtraFiles
+	setSysProcAttr(cmd)
+
+	// Use our own pipes rather than cmd.StdoutPipe() and friends, which are
+	// closed by cmd.Wait as soon as the process exits. This allows waiting
+	// for the process while output is still being read.
+	stdinR, stdinW, err := os.Pipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
 	}
 
-	stdout, err := cmd.StdoutPipe()
+	stdoutR, stdoutW, err := os.Pipe()
 	if err != nil {
-		stdin.Close()
+		closeAll(stdinR, stdinW)
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
-	stderr, err := cmd.StderrPipe()
+	stderrR, stderrW, err := os.Pipe()
 	if err != nil {
-		stdin.Close()
-		stdout.Close()
+		closeAll(stdinR, stdinW, stdoutR, stdoutW)
 		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
-	if err := cmd.Start(); err != nil {
-		stdin.Close()
-		stdout.Close()
-		stderr.Close()
-		return nil, fmt.Errorf("failed to start process: %w", err)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	// Resource limits are applied before the command runs
+	err = startCmd(cmd, opts.Rlimits)
+
+	// The child has its own copies of these ends now
+	closeAll(stdinR, stdoutW, stderrW)
+
+	if err != nil {
+		closeAll(stdinW, stdoutR, stderrR)
+		return nil, err
 	}
 
-	return &Process{
+	p := &Process{
 		cmd:    cmd,
-		Stdin:  stdin,
-		Stdout: stdout,
-		Stderr: stderr,
-	}, nil
+		Stdin:  stdinW,
+		Stdout: stdoutR,
+		Stderr: stderrR,
+		done:   make(chan struct{}),
+	}
+	go wait(p)
+
+	return p, nil
+}
+
+// wait waits for the process to exit, records its exit status and closes done.
+// It is the only caller of cmd.Wait once the process has started.
+func (p *Process) wait() {
+	err := p.cmd.Wait()
+
+	p.status = ExitStatus{Code: 0}
+	if err != nil {
+		// If we can't determine the exit code, use -1
+		p.status.Code = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			p.status.Code = exitErr.ExitCode()
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				p.status.Signal = ws.Signal()
+			}
+		}
+	}
+
+	close(p.done)
+}
+
+// closeAll closes all the given files, ignoring errors.
+func closeAll(files ...*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
 }
 
 // PID returns the process ID of the child process.
@@ -65,22 +158,64 @@ func (p *Process) Signal(sig os.Signal) error {
 	return p.cmd.Process.Signal(sig)
 }
 
+// KillGroup kills the child with SIGKILL. If the child leads its own process
+// group, the whole group is killed; otherwise the group is shared with ioetap
+// and only the child is killed.
+func (p *Process) KillGroup() error {
+	pid := p.PID()
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid && pgid != syscall.Getpgrp() {
+		return syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+	return p.Signal(syscall.SIGKILL)
+}
+
+// Done returns a channel that is closed when the process has exited.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
 // Wait waits for the process to exit and returns the exit code.
+// It is safe to call Wait from multiple goroutines.
 func (p *Process) Wait() int {
-	err := p.cmd.Wait()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return exitErr.ExitCode()
-		}
-		// If we can't determine the exit code, return -1
-		return -1
+	<-p.done
+	return p.status.Code
+}
+
+// WaitStatus waits for the process to exit and returns its exit status.
+// It is safe to call WaitStatus from multiple goroutines.
+func (p *Process) WaitStatus() ExitStatus {
+	<-p.done
+	return p.status
+}
+
+// WaitTimeout waits up to d for the process to exit. It returns the exit
+// status and true if the process exited in time, or false otherwise.
+// It is safe to call WaitTimeout from multiple goroutines.
+func (p *Process) WaitTimeout(d time.Duration) (ExitStatus, bool) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+		return p.status, true
+	case <-timer.C:
+		return ExitStatus{}, false
 	}
-	return 0
 }
 
+// InterruptKillWindow is the time within which a second SIGINT kills the
+// child instead of being forwarded.
+const InterruptKillWindow = 2 * time.Second
+
 // ForwardSignals sets up signal forwarding to the child process.
+// If onSignal is not nil, it is called with each received signal before the
+// signal is forwarded, so the caller can e.g. flush state while the child is
+// still running. The signal is not forwarded if onSignal returns false.
+// A SIGINT received within InterruptKillWindow after the previous one is not
+// forwarded; the child (and its process group, see KillGroup) is killed with
+// SIGKILL instead, so that repeated Ctrl-C stops a child that traps SIGINT.
 // It returns a channel that will receive signals, allowing the caller to stop forwarding.
-func ForwardSignals(proc *Process) chan os.Signal {
+func ForwardSignals(proc *Process, onSignal func(os.Signal) bool) chan os.Signal {
 	sigChan := make(chan os.Signal, 1)
 
 	// Forward common signals
@@ -91,11 +226,44 @@ func ForwardSignals(proc *Process) chan os.Signal {
 		syscall.SIGQUIT,
 		syscall.SIGUSR1,
 		syscall.SIGUSR2,
+		syscall.SIGTSTP,
+		syscall.SIGCONT,
 	)
 
 	go func() {
+		var lastInterrupt time.Time // zero = no pending interrupt
+
 		for sig := range sigChan {
+			if onSignal != nil && !onSignal(sig) {
+				continue
+			}
+
+			if sig == syscall.SIGINT {
+				select {
+				case <-proc.Done():
+					// Start over if the child has exited
+					lastInterrupt = time.Time{}
+				default:
+				}
+
+				now := time.Now()
+				if !lastInterrupt.IsZero() && now.Sub(lastInterrupt) < InterruptKillWindow {
+					logging.Warnf("interrupted again, killing the child (pid %d)", proc.PID())
+					_ = proc.KillGroup()
+					lastInterrupt = time.Time{}
+					continue
+				}
+				lastInterrupt = now
+			}
+
 			_ = proc.Signal(sig)
+			if sig == syscall.SIGTSTP {
+				// Catching SIGTSTP prevents the default stop action, so stop
+				// ioetap itself after suspending the child. This lets the
+				// shell's job control see the job as stopped. Execution
+				// continues here on SIGCONT, which is then forwarded.
+				_ = syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+			}
 		}
 	}()
 
@@ -107,3 +275,11 @@ func StopForwardingSignals(sigChan chan os.Signal) {
 	signal.Stop(sigChan)
 	close(sigChan)
 }
+
+// IgnoreBrokenPipe makes writes to a broken pipe on stdout or stderr fail
+// with EPIPE instead of killing the process with SIGPIPE, e.g. when piped
+// into "head -1". Unlike signal.Ignore, it does not make children ignore
+// SIGPIPE too, since they still get the default disposition on exec.
+func IgnoreBrokenPipe() {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+}
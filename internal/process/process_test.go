@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -203,7 +204,7 @@ func TestForwardSignals(t *testing.T) {
 	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
 
 	// Set up signal forwarding
-	sigChan := ForwardSignals(proc)
+	sigChan := ForwardSignals(proc, nil)
 
 	// Send SIGTERM to the child process directly
 	// (we can't easily test signal forwarding from parent to child in a unit test)
@@ -219,3 +220,185 @@ func TestForwardSignals(t *testing.T) {
 	// Kill the process to clean up
 	_ = proc.Signal(nil)
 }
+
+func TestProcess_ConcurrentWaiters(t *testing.T) {
+	ctx := context.Background()
+
+	proc, err := Start(ctx, "sh", []string{"-c", "sleep 0.1; exit 7"})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+
+	// Multiple goroutines wait using all waiting APIs
+	results := make(chan int, 6)
+	for i := 0; i < 2; i++ {
+		go func() { results <- proc.Wait() }()
+		go func() {
+			status, ok := proc.WaitTimeout(5 * time.Second)
+			if !ok {
+				results <- -100
+				return
+			}
+			results <- status.Code
+		}()
+		go func() {
+			<-proc.Done()
+			results <- proc.Wait()
+		}()
+	}
+
+	for i := 0; i < 6; i++ {
+		select {
+		case code := <-results:
+			if code != 7 {
+				t.Errorf("expected exit code 7, got %d", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiters did not return")
+		}
+	}
+}
+
+func TestProcess_WaitTimeoutExpires(t *testing.T) {
+	ctx := context.Background()
+
+	proc, err := Start(ctx, "sleep", []string{"10"})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+
+	start := time.Now()
+	if _, ok := proc.WaitTimeout(100 * time.Millisecond); ok {
+		t.Fatal("expected WaitTimeout to time out")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("unexpected WaitTimeout duration: %v", elapsed)
+	}
+
+	select {
+	case <-proc.Done():
+		t.Fatal("Done closed while the process is still running")
+	default:
+	}
+
+	if err := proc.Signal(syscall.SIGKILL); err != nil {
+		t.Fatalf("failed to kill process: %v", err)
+	}
+
+	status, ok := proc.WaitTimeout(5 * time.Second)
+	if !ok {
+		t.Fatal("process did not exit after SIGKILL")
+	}
+	if status.Code != -1 {
+		t.Errorf("expected exit code -1, got %d", status.Code)
+	}
+	if status.Signal != syscall.SIGKILL {
+		t.Errorf("expected signal SIGKILL, got %v", status.Signal)
+	}
+}
+
+func TestProcess_WaitWhileReadingOutput(t *testing.T) {
+	ctx := context.Background()
+
+	// Output is still readable after the process has been waited for
+	proc, err := Start(ctx, "echo", []string{"still here"})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+
+	if code := proc.Wait(); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	output, err := io.ReadAll(proc.Stdout)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	if string(output) != "still here\n" {
+		t.Errorf("expected output %q, got %q", "still here\n", output)
+	}
+}
+
+func TestProcess_WaitStatusSignaled(t *testing.T) {
+	ctx := context.Background()
+
+	proc, err := Start(ctx, "sleep", []string{"10"})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to send signal: %v", err)
+	}
+
+	status := proc.WaitStatus()
+	if status.Signal != syscall.SIGTERM {
+		t.Errorf("expected signal SIGTERM, got %v", status.Signal)
+	}
+	if code := status.ShellCode(); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("expected shell code %d, got %d", 128+int(syscall.SIGTERM), code)
+	}
+}
+
+func TestExitStatus_ShellCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		status ExitStatus
+		want   int
+	}{
+		{"success", ExitStatus{Code: 0}, 0},
+		{"failure", ExitStatus{Code: 3}, 3},
+		{"killed", ExitStatus{Code: -1, Signal: syscall.SIGKILL}, 137},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.ShellCode(); got != tt.want {
+				t.Errorf("ShellCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardSignals_SecondInterruptKills(t *testing.T) {
+	ctx := context.Background()
+
+	// A child that ignores SIGINT
+	proc, err := Start(ctx, "sh", []string{"-c", `trap "" INT; echo ready; exec sleep 10`})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	// Wait until the trap is installed
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(proc.Stdout, buf); err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, proc.Stdout) }()
+
+	sigChan := ForwardSignals(proc, nil)
+	defer StopForwardingSignals(sigChan)
+
+	// The first interrupt is forwarded and ignored
+	sigChan <- syscall.SIGINT
+	if _, exited := proc.WaitTimeout(200 * time.Millisecond); exited {
+		t.Fatal("child exited after the first interrupt")
+	}
+
+	// The second one kills the child
+	sigChan <- syscall.SIGINT
+	status, exited := proc.WaitTimeout(2 * time.Second)
+	if !exited {
+		_ = proc.Signal(syscall.SIGKILL)
+		t.Fatal("child was not killed after the second interrupt")
+	}
+	if status.Signal != syscall.SIGKILL {
+		t.Errorf("expected child to be killed by SIGKILL, got %+v", status)
+	}
+}
@@ -0,0 +1,51 @@
+package process
+
+import (
+	"io"
+	"time"
+)
+
+// RateLimitedWriter wraps a writer (typically the child's stdin) and limits
+// the bytes written to it per second. A Write is split into pieces of a tenth
+// of the rate, each written once the previous ones are due at that rate, so
+// the reader sees a steady trickle rather than a burst followed by a pause.
+// Time spent idle between Writes is not saved up for later.
+type RateLimitedWriter struct {
+	w     io.Writer
+	rate  int       // bytes per second
+	piece int       // bytes written at once
+	next  time.Time // when the next piece is due
+}
+
+// NewRateLimitedWriter creates a new RateLimitedWriter that writes at most
+// bytesPerSecond bytes per second to w.
+func NewRateLimitedWriter(w io.Writer, bytesPerSecond int) *RateLimitedWriter {
+	return &RateLimitedWriter{
+		w:     w,
+		rate:  bytesPerSecond,
+		piece: max(bytesPerSecond/10, 1),
+	}
+}
+
+// Write writes p to the underlying writer at the limited rate. It returns
+// early with the bytes written so far if the underlying writer fails.
+func (rw *RateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		now := time.Now()
+		if rw.next.Before(now) {
+			rw.next = now
+		} else {
+			time.Sleep(rw.next.Sub(now))
+		}
+
+		n := min(rw.piece, len(p)-written)
+		m, err := rw.w.Write(p[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		rw.next = rw.next.Add(time.Duration(n) * time.Second / time.Duration(rw.rate))
+	}
+	return written, nil
+}
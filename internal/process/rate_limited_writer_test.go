@@ -0,0 +1,74 @@
+package process
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRateLimitedWriter(&buf, 1000)
+
+	data := bytes.Repeat([]byte("x"), 300)
+	start := time.Now()
+	n, err := w.Write(data)
+	elapsed := time.Since(start)
+	if err != nil || n != len(data) {
+		t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(data))
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("expected all bytes to be written, got %d", buf.Len())
+	}
+
+	// 300 bytes at 1000 B/s, the first 100-byte piece right away
+	if elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected the write to take about 200ms, took %v", elapsed)
+	}
+}
+
+func TestRateLimitedWriter_AcrossWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRateLimitedWriter(&buf, 1000)
+
+	// Small writes are limited as a whole
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if _, err := w.Write(bytes.Repeat([]byte("x"), 10)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected 300 bytes to take about 200ms, took %v", elapsed)
+	}
+	if buf.Len() != 300 {
+		t.Errorf("expected 300 bytes, got %d", buf.Len())
+	}
+}
+
+// failingWriter accepts limit bytes, then fails.
+type failingWriter struct {
+	limit int
+	n     int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > w.limit {
+		return 0, errors.New("broken pipe")
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestRateLimitedWriter_Error(t *testing.T) {
+	w := NewRateLimitedWriter(&failingWriter{limit: 20}, 100)
+
+	n, err := w.Write(bytes.Repeat([]byte("x"), 50))
+	if err == nil {
+		t.Fatal("expected the write error")
+	}
+	if n != 20 {
+		t.Errorf("expected 20 bytes written before the error, got %d", n)
+	}
+}
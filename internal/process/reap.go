@@ -0,0 +1,86 @@
+//go:build linux
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER of prctl(2).
+const prSetChildSubreaper = 36
+
+// StartWithReaping is like StartWithOptions, but also reaps the descendants
+// of the child that are orphaned while it runs, e.g. the processes left
+// behind by a daemonizing grandchild, so that they do not accumulate as
+// zombies under ioetap.
+//
+// ioetap is made a child subreaper, so that orphaned descendants are
+// reparented to it rather than to init, and every SIGCHLD is answered with
+// wait4(-1, WNOHANG) until no exited child is left. The child itself is
+// waited for the same way, so StartWithReaping reaps any other child of the
+// calling process as well; it must not be used in a process that starts and
+// waits for children of its own. Zombies whose parent is still running can
+// only be reaped by that parent, and descendants orphaned after the child
+// has exited are no longer reaped.
+func StartWithReaping(ctx context.Context, name string, args []string, opts ProcessOptions) (*Process, error) {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return nil, fmt.Errorf("failed to become a child subreaper: %w", errno)
+	}
+
+	// Notify before starting, so that an early exit of the child is not missed
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGCHLD)
+
+	p, err := start(exec.Command(name, args...), opts, func(p *Process) {
+		p.reap(ctx, sigChan)
+	})
+	if err != nil {
+		signal.Stop(sigChan)
+		return nil, err
+	}
+	return p, nil
+}
+
+// reap reaps exited children on every SIGCHLD until the child itself has
+// exited, then records its exit status and closes done. Like
+// exec.CommandContext, it kills the child when ctx is done.
+func (p *Process) reap(ctx context.Context, sigChan chan os.Signal) {
+	defer signal.Stop(sigChan)
+
+	pid := p.PID()
+	ctxDone := ctx.Done()
+	for {
+		select {
+		case <-sigChan:
+		case <-ctxDone:
+			_ = p.Signal(os.Kill)
+			ctxDone = nil
+			continue
+		}
+
+		for {
+			var ws syscall.WaitStatus
+			wpid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || wpid <= 0 {
+				break
+			}
+			if wpid == pid {
+				p.status = ExitStatus{Code: ws.ExitStatus()}
+				if ws.Signaled() {
+					p.status.Signal = ws.Signal()
+				}
+				_ = p.cmd.Process.Release()
+				close(p.done)
+				return
+			}
+		}
+	}
+}
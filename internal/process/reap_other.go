@@ -0,0 +1,16 @@
+//go:build !linux
+
+package process
+
+import (
+	"context"
+	"errors"
+	"runtime"
+)
+
+// StartWithReaping is like StartWithOptions, but also reaps orphaned
+// descendants of the child. It is only supported on Linux, which provides
+// child subreapers.
+func StartWithReaping(ctx context.Context, name string, args []string, opts ProcessOptions) (*Process, error) {
+	return nil, errors.New("process reaping is not supported on " + runtime.GOOS)
+}
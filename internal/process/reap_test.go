@@ -0,0 +1,120 @@
+//go:build linux
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processState returns the state of the process with the given pid as shown
+// in /proc (e.g. "S" or "Z"), or "" if there is no such process.
+func processState(pid int) string {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return ""
+	}
+	// The state follows the command name in parentheses
+	_, rest, _ := strings.Cut(string(data), ") ")
+	state, _, _ := strings.Cut(rest, " ")
+	return state
+}
+
+func TestStartWithReaping_ReapsOrphans(t *testing.T) {
+	// The subshell forks a grandchild and exits, so the grandchild is
+	// orphaned and reparented to us. It then exits while the child still runs.
+	proc, err := StartWithReaping(context.Background(), "sh", []string{"-c", "(sleep 0.2 & echo $!); sleep 2"}, ProcessOptions{})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer func() {
+		_ = proc.Signal(syscall.SIGKILL)
+		proc.Wait()
+	}()
+	proc.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	line, err := bufio.NewReader(proc.Stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the grandchild's pid: %v", err)
+	}
+	grandchild, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("invalid pid %q: %v", line, err)
+	}
+
+	// The grandchild disappears instead of staying a zombie
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for {
+		state := processState(grandchild)
+		if state == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the grandchild to be reaped, but it is in state %q", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-proc.Done():
+		t.Error("expected the child to be still running")
+	default:
+	}
+}
+
+func TestStartWithReaping_ExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   ExitStatus
+	}{
+		{"exit code", "exit 3", ExitStatus{Code: 3}},
+		{"signal", "kill -TERM $$", ExitStatus{Code: -1, Signal: syscall.SIGTERM}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc, err := StartWithReaping(context.Background(), "sh", []string{"-c", tt.script}, ProcessOptions{})
+			if err != nil {
+				t.Fatalf("failed to start process: %v", err)
+			}
+			proc.Stdin.Close()
+			go func() { _, _ = io.Copy(io.Discard, proc.Stdout) }()
+			go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+			status, ok := proc.WaitTimeout(5 * time.Second)
+			if !ok {
+				t.Fatal("expected the child to exit")
+			}
+			if status != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, status)
+			}
+		})
+	}
+}
+
+func TestStartWithReaping_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	proc, err := StartWithReaping(ctx, "sleep", []string{"10"}, ProcessOptions{})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+
+	cancel()
+	status, ok := proc.WaitTimeout(5 * time.Second)
+	if !ok {
+		t.Fatal("expected the child to be killed when the context is canceled")
+	}
+	if status.Signal != syscall.SIGKILL {
+		t.Errorf("expected the child to be killed, got %+v", status)
+	}
+}
@@ -0,0 +1,10 @@
+package process
+
+import "time"
+
+// ResourceUsage is the cumulative resource usage of a child process.
+type ResourceUsage struct {
+	UserTime   time.Duration // CPU time spent in user mode
+	SystemTime time.Duration // CPU time spent in kernel mode
+	RSS        uint64        // Resident set size in bytes
+}
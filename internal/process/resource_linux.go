@@ -0,0 +1,68 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTick is the unit of the CPU times in /proc/<pid>/stat. Linux reports
+// them in USER_HZ, which is 100 on all architectures.
+const clockTick = time.Second / 100
+
+// ResourceUsage returns the resource usage of the running child process,
+// read from /proc/<pid>/stat. The CPU times include those of the children
+// the process has waited for, so that the usage of a shell running a
+// pipeline grows as the pipeline's commands exit. Once the process has
+// exited, it returns os.ErrProcessDone.
+func (p *Process) ResourceUsage() (ResourceUsage, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", p.PID()))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ResourceUsage{}, os.ErrProcessDone
+	}
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("failed to read resource usage: %w", err)
+	}
+	usage, err := parseStat(string(data), os.Getpagesize())
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("failed to read resource usage: %w", err)
+	}
+	return usage, nil
+}
+
+// parseStat parses the content of /proc/<pid>/stat (see proc(5)).
+func parseStat(stat string, pageSize int) (ResourceUsage, error) {
+	// The command name in parentheses may contain spaces and parentheses
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return ResourceUsage{}, errors.New("malformed stat: no command name")
+	}
+	// fields[0] is the state, the 3rd field of the line
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return ResourceUsage{}, fmt.Errorf("malformed stat: %d fields", len(fields)+2)
+	}
+	if state := fields[0]; state == "Z" || state == "X" {
+		// A zombie has released its memory and is about to be reaped
+		return ResourceUsage{}, os.ErrProcessDone
+	}
+
+	// utime, stime, cutime, cstime and rss are fields 14 to 17 and 24
+	var values [5]uint64
+	for i, field := range []int{14, 15, 16, 17, 24} {
+		value, err := strconv.ParseUint(fields[field-3], 10, 64)
+		if err != nil {
+			return ResourceUsage{}, fmt.Errorf("malformed stat field %d: %w", field, err)
+		}
+		values[i] = value
+	}
+	return ResourceUsage{
+		UserTime:   time.Duration(values[0]+values[2]) * clockTick,
+		SystemTime: time.Duration(values[1]+values[3]) * clockTick,
+		RSS:        values[4] * uint64(pageSize),
+	}, nil
+}
@@ -0,0 +1,62 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	stat := "1234 (my (odd) cmd) R 1 1234 1234 0 -1 4194304 100 0 0 0 250 30 50 20 20 0 1 0 100 10000000 300 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n"
+	got, err := parseStat(stat, 4096)
+	if err != nil {
+		t.Fatalf("parseStat failed: %v", err)
+	}
+	want := ResourceUsage{UserTime: 3 * time.Second, SystemTime: 500 * time.Millisecond, RSS: 300 * 4096}
+	if got != want {
+		t.Errorf("parseStat() = %+v, want %+v", got, want)
+	}
+
+	zombie := strings.Replace(stat, ") R ", ") Z ", 1)
+	if _, err := parseStat(zombie, 4096); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("expected os.ErrProcessDone for a zombie, got %v", err)
+	}
+
+	for _, stat := range []string{"1234 cmd R 1", "1234 (cmd) R 1 2 3", "1234 (cmd) R 1 1234 1234 0 -1 4194304 100 0 0 0 x 30 50 20 20 0 1 0 100 10000000 300"} {
+		if _, err := parseStat(stat, 4096); err == nil {
+			t.Errorf("expected an error for %q", stat)
+		}
+	}
+}
+
+func TestProcess_ResourceUsage(t *testing.T) {
+	proc, err := Start(context.Background(), "sh", []string{"-c", "i=0; while [ $i -lt 200000 ]; do i=$((i+1)); done; read x"})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, proc.Stdout) }()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	// Wait until the loop has used some CPU time
+	var usage ResourceUsage
+	deadline := time.Now().Add(10 * time.Second)
+	for usage.UserTime+usage.SystemTime == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if usage, err = proc.ResourceUsage(); err != nil {
+			t.Fatalf("ResourceUsage failed: %v", err)
+		}
+	}
+	if usage.UserTime+usage.SystemTime == 0 || usage.RSS == 0 {
+		t.Errorf("expected CPU time and RSS, got %+v", usage)
+	}
+
+	proc.Stdin.Close()
+	proc.Wait()
+	if _, err := proc.ResourceUsage(); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("expected os.ErrProcessDone after the process exited, got %v", err)
+	}
+}
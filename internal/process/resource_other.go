@@ -0,0 +1,14 @@
+//go:build !linux
+
+package process
+
+import (
+	"errors"
+	"runtime"
+)
+
+// ResourceUsage returns the resource usage of the running child process. It
+// is only supported on Linux, which provides /proc/<pid>/stat.
+func (p *Process) ResourceUsage() (ResourceUsage, error) {
+	return ResourceUsage{}, errors.New("resource usage is not supported on " + runtime.GOOS)
+}
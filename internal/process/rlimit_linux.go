@@ -0,0 +1,137 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// rlimitHelperEnv marks a process started by startCmd as the rlimit helper,
+// and holds the descriptor to report failures to and the limits to apply,
+// as "<fd> <resource>:<cur>:<max>...".
+const rlimitHelperEnv = "_IOETAP_RLIMIT_HELPER"
+
+// rlimitHelperPath is the executable of the rlimit helper: the running
+// program itself.
+const rlimitHelperPath = "/proc/self/exe"
+
+// init turns the process into the rlimit helper if startCmd started it.
+// It runs before the main package, but packages that this one does not
+// depend on may be initialized first, in the helper as well, so their init
+// functions must not have side effects the command would see (see
+// ioetap.RunOptions.Rlimits).
+func init() {
+	if spec, ok := os.LookupEnv(rlimitHelperEnv); ok {
+		runRlimitHelper(spec)
+	}
+}
+
+// startCmd starts cmd with the given resource limits. Go cannot run
+// setrlimit between fork and exec, so with limits, cmd is started as a
+// re-execution of the running program, the rlimit helper, which applies
+// them with setrlimit(2) and then execs the command in its place. The
+// process keeps its PID, and the limits hold before the command runs a
+// single instruction. The helper reports a failure through a pipe that
+// closes when the command is executed, so that startCmd returns the error
+// as if the command had not started.
+func startCmd(cmd *exec.Cmd, rlimits []Rlimit) error {
+	if len(rlimits) == 0 || cmd.Err != nil {
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start process: %w", err)
+		}
+		return nil
+	}
+
+	reportR, reportW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create rlimit pipe: %w", err)
+	}
+	defer reportR.Close()
+
+	spec := []string{strconv.Itoa(3 + len(cmd.ExtraFiles))}
+	for _, rlimit := range rlimits {
+		spec = append(spec, fmt.Sprintf("%d:%d:%d", rlimit.Resource, rlimit.Cur, rlimit.Max))
+	}
+	path, args := cmd.Path, cmd.Args
+	cmd.Env = append(cmd.Environ(), rlimitHelperEnv+"="+strings.Join(spec, " "))
+	cmd.ExtraFiles = append(slices.Clip(cmd.ExtraFiles), reportW)
+	cmd.Path = rlimitHelperPath
+	cmd.Args = append([]string{path}, args...)
+
+	err = cmd.Start()
+	reportW.Close()
+	cmd.Path, cmd.Args = path, args
+	if err != nil {
+		return fmt.Errorf("failed to start rlimit helper: %w", err)
+	}
+
+	report, _ := io.ReadAll(reportR)
+	if len(report) == 0 {
+		return nil
+	}
+	_ = cmd.Wait()
+
+	var index int
+	var errno syscall.Errno
+	if _, err := fmt.Sscanf(string(report), "%d %d", &index, &errno); err != nil || index >= len(rlimits) {
+		return fmt.Errorf("rlimit helper failed: %q", report)
+	}
+	if index < 0 {
+		return fmt.Errorf("failed to start process: %w", &os.PathError{Op: "exec", Path: path, Err: errno})
+	}
+	return fmt.Errorf("rlimit %s: failed to set resource limit: %w", rlimits[index].Name, errno)
+}
+
+// runRlimitHelper applies the limits in spec (see rlimitHelperEnv) and execs
+// the command in os.Args: the path, then the arguments. On a failure, it
+// reports the index of the limit, or -1 for exec, and the errno, and exits.
+func runRlimitHelper(spec string) {
+	os.Unsetenv(rlimitHelperEnv)
+	fields := strings.Fields(spec)
+	fd, err := strconv.Atoi(fields[0])
+	if err != nil || len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "ioetap: invalid rlimit helper invocation: %q\n", spec)
+		os.Exit(127)
+	}
+	syscall.CloseOnExec(fd)
+
+	fail := func(index int, err error) {
+		errno, ok := err.(syscall.Errno)
+		if !ok {
+			errno = syscall.EINVAL
+		}
+		_, _ = syscall.Write(fd, []byte(fmt.Sprintf("%d %d", index, errno)))
+		os.Exit(127)
+	}
+	for i, field := range fields[1:] {
+		var resource int
+		var rlimit syscall.Rlimit
+		if _, err := fmt.Sscanf(field, "%d:%d:%d", &resource, &rlimit.Cur, &rlimit.Max); err != nil {
+			fail(i, syscall.EINVAL)
+		}
+		if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+			fail(i, err)
+		}
+	}
+	fail(-1, syscall.Exec(os.Args[0], os.Args[1:], os.Environ()))
+}
+
+// SetRlimit sets a resource limit (syscall.RLIMIT_*) of the running child
+// process using prlimit(2). Unlike ProcessOptions.Rlimits, which are applied
+// before the command runs, it takes effect at some point after the child has
+// started.
+func (p *Process) SetRlimit(resource int, cur, max uint64) error {
+	rlimit := syscall.Rlimit{Cur: cur, Max: max}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64,
+		uintptr(p.PID()), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to set resource limit: %w", errno)
+	}
+	return nil
+}
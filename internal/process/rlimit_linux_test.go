@@ -0,0 +1,140 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestProcess_SetRlimit(t *testing.T) {
+	ctx := context.Background()
+
+	// Sleep first so the limit is applied before ulimit runs
+	proc, err := Start(ctx, "sh", []string{"-c", "sleep 0.2; ulimit -n"})
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+
+	if err := proc.SetRlimit(syscall.RLIMIT_NOFILE, 64, 64); err != nil {
+		t.Fatalf("SetRlimit failed: %v", err)
+	}
+
+	proc.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	output, err := io.ReadAll(proc.Stdout)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	proc.Wait()
+
+	if strings.TrimSpace(string(output)) != "64" {
+		t.Errorf("expected nofile limit 64, got %q", output)
+	}
+}
+
+func TestStartWithOptions_Rlimits(t *testing.T) {
+	ctx := context.Background()
+
+	opts := ProcessOptions{
+		Rlimits: []Rlimit{{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 32, Max: 32}},
+	}
+	// The limit holds from the start, without waiting for it to be applied
+	proc, err := StartWithOptions(ctx, "sh", []string{"-c", "ulimit -n"}, opts)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+
+	proc.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	output, err := io.ReadAll(proc.Stdout)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	proc.Wait()
+
+	if strings.TrimSpace(string(output)) != "32" {
+		t.Errorf("expected nofile limit 32, got %q", output)
+	}
+}
+
+func TestStartWithOptions_RlimitFailure(t *testing.T) {
+	ctx := context.Background()
+
+	// A soft limit above the hard limit is rejected by the kernel
+	opts := ProcessOptions{
+		Rlimits: []Rlimit{{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 64, Max: 32}},
+	}
+	_, err := StartWithOptions(ctx, "sleep", []string{"10"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "rlimit nofile") {
+		t.Errorf("expected rlimit error, got %v", err)
+	}
+}
+
+func TestStartWithOptions_RlimitsExtraFiles(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+
+	// fd 3 is passed on, and the pipe of the rlimit helper, fd 4, is not
+	opts := ProcessOptions{
+		Rlimits:    []Rlimit{{Name: "core", Resource: syscall.RLIMIT_CORE, Cur: 0, Max: 0}},
+		ExtraFiles: []*os.File{w},
+	}
+	proc, err := StartWithOptions(context.Background(), "sh", []string{"-c", "echo extra >&3; ulimit -c; ls /proc/$$/fd"}, opts)
+	w.Close()
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	proc.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, proc.Stderr) }()
+
+	output, err := io.ReadAll(proc.Stdout)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	if code := proc.Wait(); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	extra, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read pipe: %v", err)
+	}
+
+	if string(extra) != "extra\n" {
+		t.Errorf("expected the output on fd 3, got %q", extra)
+	}
+	lines := strings.Fields(string(output))
+	if len(lines) == 0 || lines[0] != "0" {
+		t.Fatalf("expected core limit 0, got %q", output)
+	}
+	if fds := lines[1:]; !slices.Contains(fds, "3") || slices.Contains(fds, "4") {
+		t.Errorf("expected fd 3 and no fd 4 in the shell, got %q", fds)
+	}
+}
+
+func TestStartWithOptions_RlimitsExecFailure(t *testing.T) {
+	// An executable file the kernel cannot run, so exec fails in the helper,
+	// after the limits are set
+	script := filepath.Join(t.TempDir(), "script")
+	if err := os.WriteFile(script, []byte{0, 0, 0, 0}, 0o755); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	opts := ProcessOptions{
+		Rlimits: []Rlimit{{Name: "nofile", Resource: syscall.RLIMIT_NOFILE, Cur: 32, Max: 32}},
+	}
+	_, err := StartWithOptions(context.Background(), script, nil, opts)
+	if !errors.Is(err, syscall.ENOEXEC) || !strings.Contains(err.Error(), "failed to start process") {
+		t.Errorf("expected ENOEXEC, got %v", err)
+	}
+}
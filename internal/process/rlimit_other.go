@@ -0,0 +1,31 @@
+//go:build !linux
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// errRlimitUnsupported is returned for resource limits outside Linux.
+var errRlimitUnsupported = errors.New("resource limits are not supported on " + runtime.GOOS)
+
+// startCmd starts cmd. Resource limits are only supported on Linux, so cmd
+// is not started if any are given.
+func startCmd(cmd *exec.Cmd, rlimits []Rlimit) error {
+	if len(rlimits) > 0 {
+		return fmt.Errorf("rlimit %s: %w", rlimits[0].Name, errRlimitUnsupported)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start process: %w", err)
+	}
+	return nil
+}
+
+// SetRlimit sets a resource limit (syscall.RLIMIT_*) of the running child
+// process. It is only supported on Linux, which provides prlimit(2).
+func (p *Process) SetRlimit(resource int, cur, max uint64) error {
+	return errRlimitUnsupported
+}
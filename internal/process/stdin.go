@@ -0,0 +1,161 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// StdinReader reads ioetap's stdin in a way that can be interrupted, so that
+// forwarding stops when the child exits instead of blocking in Read and
+// consuming input meant for whoever reads the terminal or pipe next.
+//
+// It waits with poll(2) until stdin or a private pipe is readable, and
+// Interrupt writes to the pipe. Stdin itself is only read once poll reports
+// data, and its flags are never changed: O_NONBLOCK would apply to the open
+// file description, which a terminal shares with ioetap's stdout and stderr
+// and with the other processes on it. Stdin is never put into raw mode
+// either, so the terminal settings are left untouched. Where poll does not
+// support stdin (e.g. terminals on macOS), it is read without interruption.
+type StdinReader struct {
+	file        *os.File
+	fd          int         // descriptor of stdin
+	wakeR       *os.File    // read end of the pipe Interrupt writes to, nil if unsupported
+	wakeW       *os.File    // write end of the pipe
+	wakeFd      int         // descriptor of wakeR
+	interrupt   sync.Once   // writes to the pipe once
+	interrupted atomic.Bool // set by Interrupt
+}
+
+// ErrStdinNull is returned by CheckStdin if stdin is /dev/null. This is
+// also the case if ioetap was started with stdin closed, since the Go
+// runtime opens /dev/null in place of a closed stdin.
+var ErrStdinNull = errors.New("stdin is " + os.DevNull)
+
+// CheckStdin checks whether there is anything to forward from stdin. It
+// returns ErrStdinNull if stdin is /dev/null, which would only return EOF,
+// and another error if stdin cannot be read at all, e.g. because it was
+// opened for writing only.
+func CheckStdin(stdin *os.File) error {
+	info, err := stdin.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat stdin: %w", err)
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return ErrStdinNull
+	}
+
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, stdin.Fd(), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to get the flags of stdin: %w", errno)
+	}
+	if flags&syscall.O_ACCMODE == syscall.O_WRONLY {
+		return errors.New("stdin is not open for reading")
+	}
+	return nil
+}
+
+// NewStdinReader creates a new StdinReader reading from stdin.
+func NewStdinReader(stdin *os.File) *StdinReader {
+	r := &StdinReader{file: stdin, fd: int(stdin.Fd())}
+	wakeR, wakeW, err := os.Pipe()
+	if err != nil {
+		// Fall back to uninterruptible reads
+		return r
+	}
+	r.wakeR, r.wakeW, r.wakeFd = wakeR, wakeW, int(wakeR.Fd())
+	return r
+}
+
+// Read reads from stdin. After Interrupt, Read returns io.EOF so that
+// callers such as Recorder.CopyAndRecord treat it as the end of the stream
+// and flush any incomplete line. Data that arrives after Interrupt is left
+// for the next reader of stdin.
+func (r *StdinReader) Read(p []byte) (int, error) {
+	for {
+		if r.interrupted.Load() {
+			return 0, io.EOF
+		}
+		if r.wakeR != nil {
+			readable, err := waitReadable(r.fd, r.wakeFd)
+			if err != nil {
+				return 0, &os.PathError{Op: "poll", Path: r.file.Name(), Err: err}
+			}
+			if !readable {
+				continue
+			}
+		}
+		n, err := syscall.Read(r.fd, p)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			// Stdin was non-blocking to begin with, and another reader of
+			// it was faster
+			if r.wakeR == nil {
+				return 0, &os.PathError{Op: "read", Path: r.file.Name(), Err: err}
+			}
+			continue
+		case err != nil:
+			return 0, &os.PathError{Op: "read", Path: r.file.Name(), Err: err}
+		case n == 0 && len(p) > 0:
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+// waitReadable waits until a read from fd would not block or until wakeFd
+// is readable, and reports whether it was fd. It returns true if poll does
+// not support fd, so that fd is read without interruption.
+func waitReadable(fd, wakeFd int) (bool, error) {
+	fds := []pollFd{{fd: int32(fd), events: pollIn}, {fd: int32(wakeFd), events: pollIn}}
+	for {
+		err := poll(fds)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if fds[1].revents != 0 {
+			// Interrupted; Read checks the flag
+			return false, nil
+		}
+		return fds[0].revents != 0, nil
+	}
+}
+
+// pollFd is struct pollfd of poll(2).
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// pollIn is POLLIN, which has the same value on all Unix systems.
+const pollIn = 0x1
+
+// Interrupt stops reading: a pending or future Read returns io.EOF.
+// A pending Read is only woken up on descriptors poll supports.
+func (r *StdinReader) Interrupt() {
+	r.interrupted.Store(true)
+	if r.wakeW != nil {
+		r.interrupt.Do(func() {
+			_, _ = r.wakeW.Write([]byte{0})
+		})
+	}
+}
+
+// Close releases the pipe of Interrupt. It does not close stdin itself,
+// and must not be called during Read.
+func (r *StdinReader) Close() error {
+	if r.wakeR == nil {
+		return nil
+	}
+	return errors.Join(r.wakeR.Close(), r.wakeW.Close())
+}
@@ -0,0 +1,119 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens a pseudo-terminal and returns its master and slave. The
+// descriptors are opened in blocking mode, as a terminal ioetap is started
+// on would be.
+func openPty(t *testing.T) (master, slave *os.File) {
+	t.Helper()
+	masterFd, err := syscall.Open("/dev/ptmx", syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	master = os.NewFile(uintptr(masterFd), "/dev/ptmx")
+	t.Cleanup(func() { master.Close() })
+
+	var unlock, number uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatalf("failed to unlock pty: %v", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+		t.Fatalf("failed to get pty number: %v", errno)
+	}
+	name := fmt.Sprintf("/dev/pts/%d", number)
+	slaveFd, err := syscall.Open(name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", name, err)
+	}
+	slave = os.NewFile(uintptr(slaveFd), name)
+	t.Cleanup(func() { slave.Close() })
+	return master, slave
+}
+
+// isNonblock reports whether O_NONBLOCK is set on the open file description
+// of f.
+func isNonblock(t *testing.T, f *os.File) bool {
+	t.Helper()
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_GETFL, 0)
+	if errno != 0 {
+		t.Fatalf("failed to get the flags of %s: %v", f.Name(), errno)
+	}
+	return flags&syscall.O_NONBLOCK != 0
+}
+
+func TestStdinReader_Terminal(t *testing.T) {
+	master, slave := openPty(t)
+	if !IsTerminal(slave) {
+		t.Fatalf("expected %s to be a terminal", slave.Name())
+	}
+
+	stdin := NewStdinReader(slave)
+	if isNonblock(t, slave) {
+		t.Error("expected the terminal to stay in blocking mode")
+	}
+
+	if _, err := master.WriteString("hello\n"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := stdin.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello\n" {
+		t.Errorf("expected %q, got %q", "hello\n", buf[:n])
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdin.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	// Output to the terminal, which shares the open file description with
+	// stdin, is written as usual while a Read is pending
+	time.Sleep(50 * time.Millisecond)
+	if isNonblock(t, slave) {
+		t.Error("expected the terminal to stay in blocking mode during Read")
+	}
+	if _, err := slave.WriteString("output\r\n"); err != nil {
+		t.Errorf("failed to write to the terminal: %v", err)
+	}
+
+	stdin.Interrupt()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("expected io.EOF after Interrupt, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Read was not interrupted")
+	}
+	if err := stdin.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if isNonblock(t, slave) {
+		t.Error("expected the terminal to stay in blocking mode after Close")
+	}
+
+	// Input typed after the interruption is left for the next reader
+	if _, err := master.WriteString("next\n"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	n, err = syscall.Read(int(slave.Fd()), buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "next\n" {
+		t.Errorf("expected %q, got %q", "next\n", buf[:n])
+	}
+}
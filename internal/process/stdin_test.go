@@ -0,0 +1,122 @@
+package process
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", os.DevNull, err)
+	}
+	defer null.Close()
+
+	writeOnly, err := os.Create(filepath.Join(t.TempDir(), "write-only"))
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer writeOnly.Close()
+	if writeOnly, err = os.OpenFile(writeOnly.Name(), os.O_WRONLY, 0); err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer writeOnly.Close()
+
+	closed, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", os.DevNull, err)
+	}
+	closed.Close()
+
+	if err := CheckStdin(r); err != nil {
+		t.Errorf("CheckStdin(pipe) = %v, want nil", err)
+	}
+	if err := CheckStdin(null); !errors.Is(err, ErrStdinNull) {
+		t.Errorf("CheckStdin(%s) = %v, want ErrStdinNull", os.DevNull, err)
+	}
+	if err := CheckStdin(writeOnly); err == nil || errors.Is(err, ErrStdinNull) {
+		t.Errorf("CheckStdin(write-only file) = %v, want an error", err)
+	}
+	if err := CheckStdin(closed); err == nil || errors.Is(err, ErrStdinNull) {
+		t.Errorf("CheckStdin(closed file) = %v, want an error", err)
+	}
+}
+
+func TestStdinReader_Read(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	stdin := NewStdinReader(r)
+	defer stdin.Close()
+
+	if _, err := w.WriteString("hello"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	buf := make([]byte, 16)
+	n, err := stdin.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", buf[:n])
+	}
+}
+
+func TestStdinReader_Interrupt(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	stdin := NewStdinReader(r)
+	defer stdin.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdin.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	stdin.Interrupt()
+
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("expected io.EOF after Interrupt, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Read was not interrupted")
+	}
+
+	// Data written after the interruption is left for the next reader
+	if _, err := w.WriteString("next"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "next" {
+		t.Errorf("expected %q, got %q", "next", buf[:n])
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package process
+
+import "os/exec"
+
+// setSysProcAttr is a no-op on platforms without a parent death signal.
+func setSysProcAttr(cmd *exec.Cmd) {}
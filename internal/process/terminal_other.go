@@ -0,0 +1,12 @@
+//go:build !linux
+
+package process
+
+import "os"
+
+// IsTerminal reports whether f is a terminal. Outside Linux, any character
+// device counts as one.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,45 @@
+package process
+
+import (
+	"io"
+	"time"
+)
+
+// TimeoutWriter wraps a writer (typically the child's stdin) and calls
+// onTimeout if a single Write does not complete within the timeout, i.e. the
+// reader is not consuming the data.
+//
+// Note that a pipe accepts writes until its buffer (typically 64 KiB) is
+// full, so data is considered consumed as soon as the pipe accepts it.
+type TimeoutWriter struct {
+	w         io.Writer
+	timeout   time.Duration
+	onTimeout func()
+}
+
+// NewTimeoutWriter creates a new TimeoutWriter.
+func NewTimeoutWriter(w io.Writer, timeout time.Duration, onTimeout func()) *TimeoutWriter {
+	return &TimeoutWriter{
+		w:         w,
+		timeout:   timeout,
+		onTimeout: onTimeout,
+	}
+}
+
+// Write writes p to the underlying writer, calling onTimeout if the write
+// blocks for longer than the timeout. Write still returns the result of the
+// underlying write, which usually fails once onTimeout kills the reader.
+func (tw *TimeoutWriter) Write(p []byte) (int, error) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(tw.timeout):
+			tw.onTimeout()
+		}
+	}()
+
+	n, err := tw.w.Write(p)
+	close(done)
+	return n, err
+}
@@ -0,0 +1,55 @@
+package process
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeoutWriter_CompletesInTime(t *testing.T) {
+	var buf bytes.Buffer
+	var timedOut atomic.Bool
+
+	w := NewTimeoutWriter(&buf, 50*time.Millisecond, func() { timedOut.Store(true) })
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if timedOut.Load() {
+		t.Error("onTimeout called for a write that completed in time")
+	}
+	if buf.String() != "hello" {
+		t.Errorf("expected 'hello', got %q", buf.String())
+	}
+}
+
+func TestTimeoutWriter_BlockedWrite(t *testing.T) {
+	pr, pw := io.Pipe()
+	timedOut := make(chan struct{})
+
+	// Nobody reads from the pipe until the timeout fires
+	w := NewTimeoutWriter(pw, 50*time.Millisecond, func() {
+		close(timedOut)
+		pr.Close()
+	})
+
+	start := time.Now()
+	_, err := w.Write([]byte("hello"))
+	if err == nil {
+		t.Error("expected write error after the reader was closed")
+	}
+
+	select {
+	case <-timedOut:
+	default:
+		t.Fatal("onTimeout was not called")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("write took too long: %v", elapsed)
+	}
+}
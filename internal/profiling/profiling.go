@@ -0,0 +1,36 @@
+// Package profiling serves the runtime profiles of ioetap itself over HTTP
+// (--pprof).
+package profiling
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// Handler returns an http.Handler that serves the profiles at /debug/pprof/,
+// like importing net/http/pprof does for http.DefaultServeMux, which ioetap
+// never serves.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Serve serves Handler on listener in the background until the returned
+// server is closed.
+func Serve(listener net.Listener) *http.Server {
+	server := &http.Server{
+		Handler:           Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server
+}
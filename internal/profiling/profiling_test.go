@@ -0,0 +1,63 @@
+package profiling
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/debug/pprof/", http.StatusOK, "goroutine"},
+		{"/debug/pprof/goroutine?debug=1", http.StatusOK, "goroutine profile:"},
+		{"/debug/pprof/cmdline", http.StatusOK, ""},
+		{"/debug/pprof/nonexistent", http.StatusNotFound, ""},
+		{"/metrics", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := Serve(listener)
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/debug/pprof/goroutine")
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response %s", resp.Status)
+	}
+
+	// Closing the server stops serving
+	if err := server.Close(); err != nil {
+		t.Fatalf("failed to close server: %v", err)
+	}
+	if _, err := http.Get("http://" + listener.Addr().String() + "/debug/pprof/"); err == nil {
+		t.Error("expected the server to be closed")
+	}
+}
@@ -0,0 +1,133 @@
+// Package progress reports the progress of a recording on a terminal.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// Reporter draws a line with the records and bytes recorded so far and the
+// elapsed time on a terminal, redrawing it in place at an interval. The
+// terminal is shared with the child's output forwarded through the writers
+// returned by Writer: the line is cleared before any output is written, and
+// it is not redrawn while the output ends with an incomplete line, such as
+// a prompt.
+type Reporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	drawn   bool // whether the line is on the terminal
+	midLine bool // whether the output written last did not end with a newline
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a Reporter that draws on w.
+func New(w io.Writer) *Reporter {
+	return &Reporter{w: w}
+}
+
+// Writer returns a writer that writes to w, which must write to the same
+// terminal as the Reporter, after clearing the line.
+func (p *Reporter) Writer(w io.Writer) io.Writer {
+	return &writer{p: p, w: w}
+}
+
+// Start redraws the line with stats every interval, with the elapsed time
+// measured from start, until Stop is called.
+func (p *Reporter) Start(stats func() recorder.Stats, start time.Time, interval time.Duration) {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case now := <-ticker.C:
+				p.draw(Format(stats(), now.Sub(start)))
+			}
+		}
+	}()
+}
+
+// Stop stops redrawing the line and clears it.
+func (p *Reporter) Stop() {
+	p.stopOnce.Do(func() {
+		if p.stop != nil {
+			close(p.stop)
+			<-p.done
+		}
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clearLocked()
+}
+
+// draw replaces the line with line.
+func (p *Reporter) draw(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.midLine {
+		return
+	}
+	// Errors are ignored; the progress is only informational
+	_, _ = io.WriteString(p.w, "\r"+line+"\x1b[K")
+	p.drawn = true
+}
+
+// clearLocked clears the line if it is drawn. Must be called with mu held.
+func (p *Reporter) clearLocked() {
+	if p.drawn {
+		_, _ = io.WriteString(p.w, "\r\x1b[K")
+		p.drawn = false
+	}
+}
+
+// writer writes output to the terminal of a Reporter.
+type writer struct {
+	p *Reporter
+	w io.Writer
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+	w.p.clearLocked()
+	n, err := w.w.Write(b)
+	if n > 0 {
+		w.p.midLine = b[n-1] != '\n'
+	}
+	return n, err
+}
+
+// Format returns the progress line for stats after elapsed.
+func Format(stats recorder.Stats, elapsed time.Duration) string {
+	var bytes uint64
+	for _, n := range stats.Bytes {
+		bytes += n
+	}
+	return fmt.Sprintf("ioetap: %d records, %s, %s elapsed", stats.Records, formatBytes(bytes), elapsed.Truncate(time.Second))
+}
+
+// formatBytes formats n in binary units, e.g. "1.5 KiB".
+func formatBytes(n uint64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		value /= 1024
+		if value < 1024 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return fmt.Sprintf("%.1f TiB", value/1024)
+}
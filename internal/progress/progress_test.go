@@ -0,0 +1,107 @@
+package progress
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		stats   recorder.Stats
+		elapsed time.Duration
+		want    string
+	}{
+		{"empty", recorder.Stats{}, 0, "ioetap: 0 records, 0 B, 0s elapsed"},
+		{"bytes", recorder.Stats{Records: 3, Bytes: [3]uint64{1, 10, 2}}, 1500 * time.Millisecond, "ioetap: 3 records, 13 B, 1s elapsed"},
+		{"KiB", recorder.Stats{Records: 42, Bytes: [3]uint64{0, 1536, 0}}, 83 * time.Second, "ioetap: 42 records, 1.5 KiB, 1m23s elapsed"},
+		{"MiB", recorder.Stats{Records: 1000, Bytes: [3]uint64{0, 5 << 20, 1 << 19}}, time.Hour, "ioetap: 1000 records, 5.5 MiB, 1h0m0s elapsed"},
+		{"TiB", recorder.Stats{Bytes: [3]uint64{0, 3 << 40, 0}}, 0, "ioetap: 0 records, 3.0 TiB, 0s elapsed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(tt.stats, tt.elapsed); got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReporter(t *testing.T) {
+	var terminal bytes.Buffer
+	p := New(&terminal)
+	out := p.Writer(&terminal)
+
+	p.draw("progress 1")
+	if _, err := out.Write([]byte("line\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	p.draw("progress 2")
+
+	// Not redrawn while the output ends with an incomplete line
+	if _, err := out.Write([]byte("prompt> ")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	p.draw("progress 3")
+	if _, err := out.Write([]byte("input\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	p.draw("progress 4")
+	p.Stop()
+
+	want := "\rprogress 1\x1b[K\r\x1b[Kline\n" +
+		"\rprogress 2\x1b[K\r\x1b[Kprompt> input\n" +
+		"\rprogress 4\x1b[K\r\x1b[K"
+	if terminal.String() != want {
+		t.Errorf("expected terminal output %q, got %q", want, terminal.String())
+	}
+}
+
+func TestReporter_Start(t *testing.T) {
+	terminal := &syncBuffer{}
+	p := New(terminal)
+	p.Start(func() recorder.Stats { return recorder.Stats{Records: 7} }, time.Now(), 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Contains(terminal.Bytes(), []byte("ioetap: 7 records")) {
+		if time.Now().After(deadline) {
+			t.Fatalf("progress was not drawn, got %q", terminal.Bytes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Stop()
+	p.Stop()
+
+	// The line is cleared and no longer redrawn
+	got := terminal.Bytes()
+	if !bytes.HasSuffix(got, []byte("\r\x1b[K")) {
+		t.Errorf("expected the line to be cleared, got %q", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !bytes.Equal(terminal.Bytes(), got) {
+		t.Errorf("expected no output after Stop, got %q", terminal.Bytes()[len(got):])
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
@@ -0,0 +1,141 @@
+package recorder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// States of an annotationReader at the current line.
+const (
+	lineUndecided  = iota // the line may still turn out to be an annotation
+	lineData              // the line is data, passed through up to its newline
+	lineAnnotation        // the line is an annotation, held until its newline
+)
+
+// annotationReader is the io.Reader returned by Recorder.AnnotationReader.
+type annotationReader struct {
+	recorder *Recorder
+	prefix   []byte
+	reader   io.Reader
+
+	state int    // state of the current line
+	line  []byte // the current line so far while undecided or an annotation
+	buf   []byte // buffer for reading from reader
+	in    []byte // data read from reader, not filtered yet
+	out   []byte // filtered data not returned by Read yet
+	err   error  // error of reader, returned once in and out are drained
+}
+
+// AnnotationReader returns an io.Reader that reads from reader, e.g. the
+// stdin forwarded to the child, leaving out the lines that start with prefix
+// and recording each of them as an annotation (see RecordAnnotation) with
+// the prefix and line ending removed, once its newline is read and the data
+// before it was returned, so that a caller that records what it reads, like
+// CopyAndRecord, records it in order. The other lines are read as is. To tell, the start of every line is held back until
+// it no longer matches prefix, which is at most len(prefix) bytes. prefix
+// must not contain a newline.
+func (r *Recorder) AnnotationReader(prefix string, reader io.Reader) io.Reader {
+	return &annotationReader{recorder: r, prefix: []byte(prefix), reader: reader}
+}
+
+// Read implements io.Reader.
+func (a *annotationReader) Read(p []byte) (int, error) {
+	for len(a.out) == 0 {
+		switch {
+		case len(a.in) > 0:
+			a.filter()
+		case a.err != nil:
+			a.end()
+			if len(a.out) == 0 {
+				return 0, a.err
+			}
+		default:
+			if a.buf == nil {
+				a.buf = make([]byte, 32*1024)
+			}
+			n, err := a.reader.Read(a.buf)
+			a.in, a.err = a.buf[:n], err
+		}
+	}
+
+	n := copy(p, a.out)
+	a.out = a.out[n:]
+	if len(a.out) == 0 {
+		a.out = nil
+	}
+	return n, nil
+}
+
+// filter moves the data to return from in to out, recording the
+// annotations it completes. It stops before completing an annotation until
+// the data before it was returned.
+func (a *annotationReader) filter() {
+	data := a.in
+	defer func() {
+		a.in = data
+	}()
+	for len(data) > 0 {
+		eol := bytes.IndexByte(data, '\n') + 1
+		if eol == 0 {
+			eol = len(data)
+		}
+
+		switch a.state {
+		case lineUndecided:
+			n := min(eol, len(a.prefix)-len(a.line))
+			a.line = append(a.line, data[:n]...)
+			data = data[n:]
+			switch {
+			case bytes.HasPrefix(a.line, a.prefix):
+				a.state = lineAnnotation
+			case !bytes.HasPrefix(a.prefix, a.line) || a.line[len(a.line)-1] == '\n':
+				a.out = append(a.out, a.line...)
+				a.state = lineData
+				if a.line[len(a.line)-1] == '\n' {
+					a.state = lineUndecided
+				}
+				a.line = a.line[:0]
+			}
+			continue
+		case lineData:
+			a.out = append(a.out, data[:eol]...)
+		case lineAnnotation:
+			if data[eol-1] == '\n' && len(a.out) > 0 {
+				return
+			}
+			a.line = append(a.line, data[:eol]...)
+		}
+		if data[eol-1] == '\n' {
+			if a.state == lineAnnotation {
+				a.annotate()
+			}
+			a.state = lineUndecided
+		}
+		data = data[eol:]
+	}
+}
+
+// end handles the last line when reader returned an error: an annotation
+// without newline is recorded, and the start of an undecided line is data.
+func (a *annotationReader) end() {
+	switch a.state {
+	case lineAnnotation:
+		a.annotate()
+	case lineUndecided:
+		a.out = append(a.out, a.line...)
+		a.line = a.line[:0]
+	}
+	a.state = lineUndecided
+}
+
+// annotate records the annotation in line.
+func (a *annotationReader) annotate() {
+	text, _ := splitTrailingCRLF(a.line[len(a.prefix):])
+	if err := a.recorder.RecordAnnotation(string(text)); err != nil && !errors.Is(err, ErrClosed) {
+		logging.Warnf("recording error: %v", err)
+	}
+	a.line = a.line[:0]
+}
@@ -0,0 +1,142 @@
+package recorder
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRecorder_AnnotationReader(t *testing.T) {
+	tests := []struct {
+		name            string
+		prefix          string
+		input           string
+		wantData        string
+		wantAnnotations []string
+	}{
+		{
+			name:            "annotations between data",
+			prefix:          "# ",
+			input:           "one\n# starting phase 2\ntwo\n",
+			wantData:        "one\ntwo\n",
+			wantAnnotations: []string{"starting phase 2"},
+		},
+		{
+			name:            "crlf",
+			prefix:          "# ",
+			input:           "# note\r\none\r\n",
+			wantData:        "one\r\n",
+			wantAnnotations: []string{"note"},
+		},
+		{
+			name:     "prefix not at line start",
+			prefix:   "# ",
+			input:    "echo # not a note\n",
+			wantData: "echo # not a note\n",
+		},
+		{
+			name:     "line shorter than prefix",
+			prefix:   "###",
+			input:    "#\n##\n",
+			wantData: "#\n##\n",
+		},
+		{
+			name:            "empty annotation",
+			prefix:          "#",
+			input:           "#\n\n",
+			wantData:        "\n",
+			wantAnnotations: []string{""},
+		},
+		{
+			name:            "annotation at EOF",
+			prefix:          "#",
+			input:           "one\n#last",
+			wantData:        "one\n",
+			wantAnnotations: []string{"last"},
+		},
+		{
+			name:     "partial prefix at EOF",
+			prefix:   "##",
+			input:    "one\n#",
+			wantData: "one\n#",
+		},
+	}
+
+	readers := []struct {
+		name   string
+		reader func(string) io.Reader
+	}{
+		{"one read", func(s string) io.Reader { return strings.NewReader(s) }},
+		{"fragmented reads", func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) }},
+		{"data with EOF", func(s string) io.Reader { return iotest.DataErrReader(iotest.HalfReader(strings.NewReader(s))) }},
+	}
+
+	for _, tt := range tests {
+		for _, rr := range readers {
+			t.Run(tt.name+"/"+rr.name, func(t *testing.T) {
+				sink := &memorySink{}
+				rec, err := NewRecorder(sink, 0)
+				if err != nil {
+					t.Fatalf("failed to create recorder: %v", err)
+				}
+
+				data, err := io.ReadAll(rec.AnnotationReader(tt.prefix, rr.reader(tt.input)))
+				if err != nil {
+					t.Fatalf("failed to read: %v", err)
+				}
+				if string(data) != tt.wantData {
+					t.Errorf("expected data %q, got %q", tt.wantData, data)
+				}
+
+				var annotations []string
+				for _, record := range sinkRecords(t, sink) {
+					if record.Source != AnnotationSource || record.Encoding != "text" {
+						t.Errorf("unexpected record %+v", record)
+					}
+					annotations = append(annotations, record.ContentString())
+				}
+				if strings.Join(annotations, "|") != strings.Join(tt.wantAnnotations, "|") || len(annotations) != len(tt.wantAnnotations) {
+					t.Errorf("expected annotations %q, got %q", tt.wantAnnotations, annotations)
+				}
+			})
+		}
+	}
+}
+
+func TestRecorder_AnnotationReaderOrder(t *testing.T) {
+	// Annotations are recorded among the stdin records of the lines around
+	// them, even when they are read together
+	readers := []struct {
+		name   string
+		reader func(string) io.Reader
+	}{
+		{"one read", func(s string) io.Reader { return strings.NewReader(s) }},
+		{"fragmented reads", func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) }},
+	}
+
+	for _, rr := range readers {
+		t.Run(rr.name, func(t *testing.T) {
+			sink := &memorySink{}
+			rec, err := NewRecorder(sink, 0)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+
+			input := "one\n# phase 2\n# phase 3\ntwo\n"
+			reader := rec.AnnotationReader("# ", rr.reader(input))
+			if err := rec.CopyAndRecord(Stdin, reader, io.Discard); err != nil {
+				t.Fatalf("failed to copy: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			want := []string{"stdin:one:\n", "annotation:phase 2:", "annotation:phase 3:", "stdin:two:\n"}
+			if got := recordedContents(t, bytes.Join(sink.writes, nil)); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// WithChainHash adds a chain_hash field to every record, linking it to the
+// record written before it (see ChainHash), so that changing, removing or
+// reordering records of the recording breaks the chain from that point on.
+func WithChainHash() Option {
+	return func(r *Recorder) {
+		r.chainHash = make([]byte, sha256.Size)
+	}
+}
+
+// ChainHash returns the chain hash of record: the SHA-256 of prev, the
+// record's seq as 8 big-endian bytes, and the record itself as sorted JSON
+// (see Record.ToSortedJSON) without its chain_hash. prev is the chain hash
+// of the previous record, or 32 zero bytes for the first record.
+//
+// The whole record is hashed rather than only its content, so that a changed
+// source, timestamp or line ending breaks the chain as well.
+func ChainHash(prev []byte, record Record) ([]byte, error) {
+	record.ChainHash = ""
+	jsonData, err := record.ToSortedJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize record: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write(prev)
+	binary.Write(h, binary.BigEndian, record.Seq)
+	h.Write(jsonData)
+	return h.Sum(nil), nil
+}
+
+// applyChainHash sets the chain hash of record and makes it the previous
+// hash of the next record. Must be called with mu held.
+func (r *Recorder) applyChainHash(record *Record) error {
+	hash, err := ChainHash(r.chainHash, *record)
+	if err != nil {
+		return err
+	}
+	record.ChainHash = hex.EncodeToString(hash)
+	r.chainHash = hash
+	return nil
+}
+
+// ErrChainBroken is returned by ChainVerifier when a record does not link
+// to the record before it.
+var ErrChainBroken = errors.New("chain hash does not match")
+
+// ChainVerifier checks the chain hashes of the records of a recording
+// written with WithChainHash, in file order.
+type ChainVerifier struct {
+	prev []byte
+}
+
+// NewChainVerifier returns a ChainVerifier for the first record of a
+// recording.
+func NewChainVerifier() *ChainVerifier {
+	return &ChainVerifier{prev: make([]byte, sha256.Size)}
+}
+
+// Verify checks that record links to the records verified before it. It
+// returns ErrChainBroken, possibly wrapped, if the record has no chain hash
+// or a wrong one. After an error, the record is taken as is, so that
+// Verify can go on with the following records.
+func (v *ChainVerifier) Verify(record Record) error {
+	want, err := ChainHash(v.prev, record)
+	if err != nil {
+		return err
+	}
+
+	got, err := hex.DecodeString(record.ChainHash)
+	v.prev = got
+	switch {
+	case record.ChainHash == "":
+		return fmt.Errorf("%w: no chain hash", ErrChainBroken)
+	case err != nil || !bytes.Equal(got, want):
+		return ErrChainBroken
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// chainedRecords records a few lines with WithChainHash and returns the
+// records written.
+func chainedRecords(t *testing.T) []Record {
+	t.Helper()
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithChainHash(), WithFields(map[string]string{"job": "build"}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.Record(Stdin, []byte("input\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte(`{"level":"info","n":1}`+"\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.RecordMeta("suspend", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if err := rec.Record(Stderr, []byte{0xff, 0xfe, '\n'}); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	return sinkRecords(t, sink)
+}
+
+func TestRecorder_WithChainHash(t *testing.T) {
+	records := chainedRecords(t)
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(records))
+	}
+
+	// The first record links to 32 zero bytes, every other one to the record
+	// before it
+	prev := make([]byte, sha256.Size)
+	for i, record := range records {
+		want, err := ChainHash(prev, record)
+		if err != nil {
+			t.Fatalf("ChainHash failed: %v", err)
+		}
+		if record.ChainHash != hex.EncodeToString(want) {
+			t.Errorf("record %d: expected chain hash %x, got %s", i, want, record.ChainHash)
+		}
+		prev = want
+	}
+}
+
+func TestChainHash(t *testing.T) {
+	record := Record{Seq: 1, Timestamp: "2024-01-15T10:30:45.123Z", Source: "stdout", Content: "hello", Encoding: "text", End: "\n"}
+	prev := bytes.Repeat([]byte{0xab}, sha256.Size)
+
+	// SHA-256 of prev || seq || record without chain hash
+	h := sha256.New()
+	h.Write(prev)
+	h.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1})
+	h.Write([]byte(`{"content":"hello","encoding":"text","end":"\n","seq":1,"source":"stdout","timestamp":"2024-01-15T10:30:45.123Z"}`))
+	want := h.Sum(nil)
+
+	record.ChainHash = "ignored"
+	got, err := ChainHash(prev, record)
+	if err != nil {
+		t.Fatalf("ChainHash failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected %x, got %x", want, got)
+	}
+}
+
+func TestChainVerifier(t *testing.T) {
+	// Records are verified as they are read back from the recording
+	var parsed []Record
+	for _, record := range chainedRecords(t) {
+		data, err := record.ToJSON()
+		if err != nil {
+			t.Fatalf("failed to serialize record: %v", err)
+		}
+		var read Record
+		if err := json.Unmarshal(data, &read); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		parsed = append(parsed, read)
+	}
+
+	verifier := NewChainVerifier()
+	for i, record := range parsed {
+		if err := verifier.Verify(record); err != nil {
+			t.Errorf("record %d: unexpected error %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(records []Record) []Record
+		broken uint64 // seq of the first record that fails to verify
+	}{
+		{"content", func(r []Record) []Record { r[1].Content = map[string]any{"level": "error"}; return r }, 1},
+		{"source", func(r []Record) []Record { r[0].Source = "stdout"; return r }, 0},
+		{"timestamp", func(r []Record) []Record { r[3].Timestamp = "2000-01-01T00:00:00.000Z"; return r }, 3},
+		{"removed", func(r []Record) []Record { return append(r[:1], r[2:]...) }, 2},
+		{"reordered", func(r []Record) []Record { r[1], r[2] = r[2], r[1]; return r }, 2},
+		{"no chain hash", func(r []Record) []Record { r[2].ChainHash = ""; return r }, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := tt.tamper(append([]Record(nil), parsed...))
+			verifier := NewChainVerifier()
+			var broken []uint64
+			for _, record := range records {
+				if err := verifier.Verify(record); err != nil {
+					if !errors.Is(err, ErrChainBroken) {
+						t.Fatalf("expected ErrChainBroken, got %v", err)
+					}
+					broken = append(broken, record.Seq)
+				}
+			}
+			if len(broken) == 0 || broken[0] != tt.broken {
+				t.Errorf("expected the chain to break at seq %d, got %v", tt.broken, broken)
+			}
+		})
+	}
+}
+
+func TestRecord_ChainHashJSON(t *testing.T) {
+	record := NewRecord(0, time.Now(), "stdout", []byte("line\n"))
+	data, _ := record.ToJSON()
+	if bytes.Contains(data, []byte("chain_hash")) {
+		t.Errorf("expected no chain_hash without a chain hash, got %s", data)
+	}
+
+	record.ChainHash = "abc"
+	data, _ = record.ToJSON()
+	var parsed Record
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if parsed.ChainHash != "abc" {
+		t.Errorf("expected the chain hash to survive a round trip, got %q", parsed.ChainHash)
+	}
+}
@@ -0,0 +1,59 @@
+package recorder
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// HeaderType is the type of a Header, which tells a recording apart from
+// other NDJSON.
+const HeaderType = "ioetap"
+
+// Header is a line that describes the recording, written before the first
+// record with --header (see WithHeader). Unlike a record, it has a type and
+// no seq, so that readers can tell it apart.
+type Header struct {
+	Type         string   `json:"type"`                    // Always HeaderType
+	Version      int      `json:"version"`                 // Record format version (see FormatVersion)
+	Command      string   `json:"command"`                 // Command that was recorded
+	Args         []string `json:"args,omitempty"`          // Arguments of the command
+	Fields       []string `json:"fields"`                  // Built-in fields the records may have (see BuiltinFields)
+	CustomFields []string `json:"custom_fields,omitempty"` // Keys of the "fields" object of every record (see WithFields)
+}
+
+// NewHeader returns the header of a recording of command with args, whose
+// records have the given custom fields (see WithFields).
+func NewHeader(command string, args []string, customFields map[string]string) Header {
+	var custom []string
+	for name := range customFields {
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+
+	return Header{
+		Type:         HeaderType,
+		Version:      FormatVersion,
+		Command:      command,
+		Args:         args,
+		Fields:       BuiltinFields,
+		CustomFields: custom,
+	}
+}
+
+// ToJSON returns the header as a single line of JSON, without newline.
+func (h Header) ToJSON() ([]byte, error) {
+	return json.Marshal(h)
+}
+
+// ParseHeader parses line as a Header. It reports false if line is not a
+// header, e.g. a record.
+func ParseHeader(line []byte) (Header, bool) {
+	var header struct {
+		Header
+		Seq *uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(line, &header); err != nil || header.Type != HeaderType || header.Seq != nil {
+		return Header{}, false
+	}
+	return header.Header, true
+}
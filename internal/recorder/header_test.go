@@ -0,0 +1,45 @@
+package recorder
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewHeader(t *testing.T) {
+	header := NewHeader("ls", []string{"-l"}, map[string]string{"team": "a", "env": "ci"})
+	data, err := header.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+
+	got, ok := ParseHeader(data)
+	if !ok {
+		t.Fatalf("ParseHeader(%s) did not recognize the header", data)
+	}
+	if got.Type != HeaderType || got.Version != FormatVersion || got.Command != "ls" || !slices.Equal(got.Args, []string{"-l"}) {
+		t.Errorf("unexpected header %+v", got)
+	}
+	if !slices.Equal(got.Fields, BuiltinFields) || !slices.Equal(got.CustomFields, []string{"env", "team"}) {
+		t.Errorf("Fields = %q, CustomFields = %q, want the built-in fields and [env team]", got.Fields, got.CustomFields)
+	}
+}
+
+func TestParseHeader_NotHeader(t *testing.T) {
+	record, err := NewRecord(0, time.Now(), Stdout.String(), []byte("hello\n")).ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+
+	for _, line := range []string{
+		string(record),
+		`{"seq":0,"type":"ioetap"}`,
+		`{"type":"other","version":1}`,
+		`{"$schema":"http://json-schema.org/draft-07/schema#"}`,
+		`not json`,
+	} {
+		if _, ok := ParseHeader([]byte(line)); ok {
+			t.Errorf("ParseHeader(%s) recognized a header", line)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package recorder
+
+import (
+	"math"
+	"slices"
+)
+
+// timingHistogram returns the p50, p90, p99 and p99.9 percentiles and the
+// maximum of the given latencies in milliseconds, using the nearest-rank
+// method. All values are 0 if there are no latencies.
+func timingHistogram(timings []int64) map[string]any {
+	sorted := slices.Clone(timings)
+	slices.Sort(sorted)
+
+	return map[string]any{
+		"p50":  percentile(sorted, 0.5),
+		"p90":  percentile(sorted, 0.9),
+		"p99":  percentile(sorted, 0.99),
+		"p999": percentile(sorted, 0.999),
+		"max":  percentile(sorted, 1),
+	}
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
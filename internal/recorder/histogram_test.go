@@ -0,0 +1,50 @@
+package recorder
+
+import (
+	"testing"
+)
+
+func TestTimingHistogram(t *testing.T) {
+	hundred := make([]int64, 0, 100)
+	for i := int64(100); i >= 1; i-- {
+		hundred = append(hundred, i)
+	}
+
+	tests := []struct {
+		name    string
+		timings []int64
+		want    map[string]int64
+	}{
+		{
+			name:    "empty",
+			timings: nil,
+			want:    map[string]int64{"p50": 0, "p90": 0, "p99": 0, "p999": 0, "max": 0},
+		},
+		{
+			name:    "single",
+			timings: []int64{7},
+			want:    map[string]int64{"p50": 7, "p90": 7, "p99": 7, "p999": 7, "max": 7},
+		},
+		{
+			name:    "one to hundred unsorted",
+			timings: hundred,
+			want:    map[string]int64{"p50": 50, "p90": 90, "p99": 99, "p999": 100, "max": 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := timingHistogram(tt.timings)
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("%s = %v, want %d", key, got[key], want)
+				}
+			}
+		})
+	}
+
+	// The input must not be reordered
+	if hundred[0] != 100 {
+		t.Error("timingHistogram sorted its input")
+	}
+}
@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// RecordAction tells the Recorder what to do with a record passed to the
+// callback of WithOnRecord.
+type RecordAction int
+
+const (
+	// RecordPass writes the record, including any changes the callback made.
+	RecordPass RecordAction = iota
+	// RecordDrop skips the record.
+	RecordDrop
+)
+
+// WithOnRecord calls fn for every record, including meta and stats records,
+// before it is serialized. fn may change the record through its argument,
+// e.g. to redact content, and returns whether to write or drop it. A dropped
+// record still uses up its sequence number, so drops show as gaps in seq.
+//
+// fn only changes the content of a record, never its line ending: End is
+// restored after fn returns, and a line ending fn appends to text content is
+// removed, so that content plus End still reconstructs a single line.
+//
+// fn is called by whichever goroutine writes the record while the
+// Recorder's lock is held, so it must not call the Recorder. Use WithQueue
+// to move it off the goroutines that read the child's output. If fn panics,
+// the panic is logged and the record is written unchanged.
+func WithOnRecord(fn func(*Record) RecordAction) Option {
+	return func(r *Recorder) {
+		r.onRecord = fn
+	}
+}
+
+// applyOnRecord runs the WithOnRecord callback on record and returns the
+// record to write, or false if it was dropped.
+func (r *Recorder) applyOnRecord(record Record) (result Record, write bool) {
+	modified := record
+	modified.Fields = maps.Clone(record.Fields)
+
+	defer func() {
+		if p := recover(); p != nil {
+			logging.Warnf("record callback panicked: %v", p)
+			result, write = record, true
+		}
+	}()
+
+	if r.onRecord(&modified) == RecordDrop {
+		return Record{}, false
+	}
+	modified.End = record.End
+	if s, ok := modified.Content.(string); ok && modified.Encoding == "text" {
+		modified.Content = strings.TrimRight(s, "\r\n")
+	}
+	return modified, true
+}
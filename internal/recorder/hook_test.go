@@ -0,0 +1,183 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// parseWrites parses the records written to a memorySink.
+func parseWrites(t *testing.T, sink *memorySink) []Record {
+	t.Helper()
+	var records []Record
+	for _, data := range sink.writes {
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRecorder_OnRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       func(*Record) RecordAction
+		wantSeqs []uint64
+		want     []string // source:content of the written records
+	}{
+		{
+			name:     "pass",
+			fn:       func(*Record) RecordAction { return RecordPass },
+			wantSeqs: []uint64{0, 1, 2, 3},
+			want:     []string{"stdin:password: hunter2", "stdout:ok", "stderr:warning", `meta:{"event":"suspend"}`},
+		},
+		{
+			name: "drop",
+			fn: func(record *Record) RecordAction {
+				if record.Source == "stderr" {
+					return RecordDrop
+				}
+				return RecordPass
+			},
+			wantSeqs: []uint64{0, 1, 3},
+			want:     []string{"stdin:password: hunter2", "stdout:ok", `meta:{"event":"suspend"}`},
+		},
+		{
+			name: "mutate",
+			fn: func(record *Record) RecordAction {
+				if s, ok := record.Content.(string); ok && strings.HasPrefix(s, "password: ") {
+					record.Content = "password: [redacted]"
+				}
+				record.Fields["checked"] = "yes"
+				return RecordPass
+			},
+			wantSeqs: []uint64{0, 1, 2, 3},
+			want:     []string{"stdin:password: [redacted]", "stdout:ok", "stderr:warning", `meta:{"event":"suspend"}`},
+		},
+		{
+			name: "panic",
+			fn: func(record *Record) RecordAction {
+				record.Content = "changed before panic"
+				if record.Source == "stdout" {
+					panic("callback bug")
+				}
+				return RecordDrop
+			},
+			wantSeqs: []uint64{1},
+			want:     []string{"stdout:ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			rec, err := NewRecorder(sink, 0, WithOnRecord(tt.fn), WithFields(map[string]string{"env": "test"}))
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+
+			rec.Record(Stdin, []byte("password: hunter2\n"))
+			rec.Record(Stdout, []byte("ok\n"))
+			rec.Record(Stderr, []byte("warning\n"))
+			rec.RecordMeta("suspend", nil)
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			records := parseWrites(t, sink)
+			if len(records) != len(tt.want) {
+				t.Fatalf("expected %d records, got %d", len(tt.want), len(records))
+			}
+			for i, record := range records {
+				if got := record.Source + ":" + record.ContentString(); got != tt.want[i] || record.Seq != tt.wantSeqs[i] {
+					t.Errorf("record %d: expected seq %d %q, got seq %d %q", i, tt.wantSeqs[i], tt.want[i], record.Seq, got)
+				}
+				if record.Fields["env"] != "test" {
+					t.Errorf("record %d: expected the custom fields, got %v", i, record.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestRecorder_OnRecordPreservesEnd(t *testing.T) {
+	redact := func(record *Record) RecordAction {
+		if s, ok := record.Content.(string); ok {
+			record.Content = strings.ReplaceAll(s, "hunter2", "[redacted]")
+		}
+		return RecordPass
+	}
+
+	tests := []struct {
+		name string
+		fn   func(*Record) RecordAction
+	}{
+		{"redact", redact},
+		{"redact and change end", func(record *Record) RecordAction {
+			record.End = "\n"
+			return redact(record)
+		}},
+		{"redact and append a newline", func(record *Record) RecordAction {
+			redact(record)
+			record.Content = record.ContentString() + "\r\n"
+			return RecordPass
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			rec, err := NewRecorder(sink, 0, WithOnRecord(tt.fn))
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			rec.Record(Stdin, []byte("login\r\npassword: hunter2\r\nno line ending"))
+			if err := rec.FlushAll(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			// Content and End reconstruct the lines, with only the content redacted
+			var got string
+			for _, record := range parseWrites(t, sink) {
+				if strings.ContainsAny(record.ContentString(), "\r\n") {
+					t.Errorf("seq %d: expected no line ending in the content, got %q", record.Seq, record.ContentString())
+				}
+				got += record.ContentString() + record.End
+			}
+			if want := "login\r\npassword: [redacted]\r\nno line ending"; got != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestRecorder_OnRecordDoesNotChangeSharedFields(t *testing.T) {
+	fields := map[string]string{"env": "test"}
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithFields(fields), WithOnRecord(func(record *Record) RecordAction {
+		record.Fields["seq"] = string(rune('0' + record.Seq))
+		return RecordPass
+	}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	rec.Record(Stdout, []byte("a\nb\n"))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	if len(fields) != 1 {
+		t.Errorf("expected the fields option to be unchanged, got %v", fields)
+	}
+	for i, data := range sink.writes {
+		if want := `"fields":{"env":"test","seq":"` + string(rune('0'+i)) + `"}`; !bytes.Contains(data, []byte(want)) {
+			t.Errorf("record %d: expected %s in %s", i, want, data)
+		}
+	}
+}
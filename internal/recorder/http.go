@@ -0,0 +1,247 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// HTTPSinkOptions configures an HTTP sink.
+type HTTPSinkOptions struct {
+	BatchSize         int  // Records per request (0 = DefaultHTTPBatchSize)
+	DisableKeepAlives bool // Open a new connection for every request
+	MaxIdleConns      int  // Idle connections kept per host (0 = DefaultHTTPMaxIdleConns)
+
+	// Retry keeps a batch that fails and sends it again with backoff
+	// (nil = drop it and return the error)
+	Retry *RetryOptions
+}
+
+// HTTPSinkStats summarizes what an HTTP sink has sent.
+type HTTPSinkStats struct {
+	RequestsSent uint64 // Requests that were answered with a 2xx status
+	BytesSent    uint64 // Bytes of records in those requests
+	Errors       uint64 // Requests that failed or were answered with another status
+}
+
+// Defaults of HTTPSinkOptions.
+const (
+	DefaultHTTPBatchSize    = 100
+	DefaultHTTPMaxIdleConns = 10
+)
+
+// httpSink is a Sink that POSTs batches of records to a URL.
+type httpSink struct {
+	url       string
+	client    *http.Client
+	batchSize int
+	retry     *RetryOptions
+
+	batch      bytes.Buffer
+	records    int       // records in batch
+	retries    int       // failed retries of batch in a row
+	retryAt    time.Time // when to send batch again after it failed (zero = not failed)
+	dropped    uint64    // records dropped since batch failed
+	droppedSeq uint64    // seq of the first of them
+
+	requestsSent atomic.Uint64
+	bytesSent    atomic.Uint64
+	errors       atomic.Uint64
+}
+
+// NewHTTPSink returns a Sink that POSTs the records to url as NDJSON
+// (application/x-ndjson), in batches of opts.BatchSize records and whenever
+// the Recorder flushes. Requests share a single client whose connections
+// are kept alive and reused unless opts.DisableKeepAlives is set.
+// A header line (see WithHeader) is sent like a record.
+//
+// With opts.Retry, a batch that fails is kept and sent again, with the
+// records written since, on the first Write or Flush after a delay that
+// doubles after every failed retry, so that recording never waits for the
+// backoff. Records written beyond RetryOptions.MaxBuffered bytes meanwhile
+// are dropped, and the batch that gets through starts with a
+// "records-dropped" meta record with their number and the seq of the first
+// of them. After RetryOptions.MaxAttempts failed retries, the batch is
+// dropped and the error returned.
+func NewHTTPSink(url string, opts HTTPSinkOptions) Sink {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultHTTPBatchSize
+	}
+	maxIdleConns := opts.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = DefaultHTTPMaxIdleConns
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		DisableKeepAlives:   opts.DisableKeepAlives,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	s := &httpSink{
+		url:       url,
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		batchSize: batchSize,
+	}
+	if opts.Retry != nil {
+		retry := opts.Retry.withDefaults()
+		s.retry = &retry
+	}
+	return s
+}
+
+// Write implements Sink.
+func (s *httpSink) Write(p []byte) (int, error) {
+	if !s.retryAt.IsZero() && s.batch.Len()+len(p) > s.retry.MaxBuffered {
+		var record struct {
+			Seq uint64 `json:"seq"`
+		}
+		json.Unmarshal(p, &record)
+		if s.dropped == 0 {
+			s.droppedSeq = record.Seq
+		}
+		s.dropped++
+	} else {
+		s.batch.Write(p)
+		s.records++
+	}
+	if s.records >= s.batchSize {
+		if err := s.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements Sink. Without RetryOptions, the batch is dropped if it
+// cannot be delivered, so a failing server does not make the sink buffer
+// without bound. With them, a failed batch is sent again only once its
+// retry is due.
+func (s *httpSink) Flush() error {
+	if !s.retryAt.IsZero() && time.Now().Before(s.retryAt) {
+		return nil
+	}
+	err := s.send()
+	if err == nil || s.retry == nil {
+		return err
+	}
+
+	if s.retryAt.IsZero() {
+		logging.Warnf("%s: %v; retrying", s.url, err)
+	} else {
+		s.retries++
+	}
+	if s.retry.giveUp(s.retries) {
+		err = fmt.Errorf("gave up after %d retries: %w", s.retries, err)
+		s.reset()
+		return err
+	}
+	s.retryAt = time.Now().Add(s.retry.delay(s.retries))
+	return nil
+}
+
+// send sends the batch, preceded by a "records-dropped" meta record if
+// records were dropped. Unless it fails with RetryOptions, the batch is
+// emptied.
+func (s *httpSink) send() error {
+	if s.records == 0 && s.dropped == 0 {
+		return nil
+	}
+
+	body := s.batch.Bytes()
+	if s.dropped > 0 {
+		notice, err := NewMetaRecord(s.droppedSeq, time.Now(), map[string]any{
+			"event":   "records-dropped",
+			"records": s.dropped,
+		}).ToJSON()
+		if err == nil {
+			body = append(append(notice, '\n'), body...)
+		}
+	}
+	if err := s.post(body); err != nil {
+		s.errors.Add(1)
+		if s.retry == nil {
+			s.reset()
+		}
+		return err
+	}
+	if !s.retryAt.IsZero() {
+		logging.Infof("%s: recovered", s.url)
+	}
+	s.requestsSent.Add(1)
+	s.bytesSent.Add(uint64(len(body)))
+	s.reset()
+	return nil
+}
+
+// reset empties the batch after it was sent or dropped.
+func (s *httpSink) reset() {
+	s.batch.Reset()
+	s.records = 0
+	s.retries = 0
+	s.retryAt = time.Time{}
+	s.dropped = 0
+}
+
+// post sends body in a single request.
+func (s *httpSink) post(body []byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send records: %w", err)
+	}
+	// Drain the body so that the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to send records: %s", resp.Status)
+	}
+	return nil
+}
+
+// Close implements Sink. A failed batch gets a last attempt without waiting
+// for its retry, and is dropped if that fails too.
+func (s *httpSink) Close() error {
+	err := s.send()
+	s.reset()
+	s.client.CloseIdleConnections()
+	return err
+}
+
+// httpStatser is implemented by the sinks that know what HTTP sinks have
+// sent: an HTTP sink, and a multi-sink, which adds up its destinations.
+type httpStatser interface {
+	httpStats() HTTPSinkStats
+}
+
+// httpStats implements httpStatser.
+func (s *httpSink) httpStats() HTTPSinkStats {
+	return HTTPSinkStats{
+		RequestsSent: s.requestsSent.Load(),
+		BytesSent:    s.bytesSent.Load(),
+		Errors:       s.errors.Load(),
+	}
+}
+
+// HTTPStats returns what the Recorder's HTTP sinks (see NewHTTPSink) have
+// sent so far, including those among the destinations of a multi-sink, or
+// zero stats if it writes to no HTTP sink. This method is thread-safe.
+func (r *Recorder) HTTPStats() HTTPSinkStats {
+	if sink, ok := r.sink.(httpStatser); ok {
+		return sink.httpStats()
+	}
+	return HTTPSinkStats{}
+}
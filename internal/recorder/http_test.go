@@ -0,0 +1,287 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ndjsonServer is a test server that keeps the NDJSON bodies posted to it
+// and counts the connections it accepted.
+type ndjsonServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies [][]byte
+	conns  atomic.Int64
+	status int // response status (0 = 200)
+}
+
+func newNDJSONServer(t testing.TB) *ndjsonServer {
+	s := &ndjsonServer{}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		if s.status != 0 {
+			w.WriteHeader(s.status)
+		}
+	}))
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.conns.Add(1)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestHTTPSink(t *testing.T) {
+	server := newNDJSONServer(t)
+	rec, err := NewRecorder(NewHTTPSink(server.URL, HTTPSinkOptions{BatchSize: 2}), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if err := rec.Record(Stdout, []byte(line)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// Two full batches and the rest on Close
+	if len(server.bodies) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(server.bodies))
+	}
+	var contents []string
+	var bytesSent int
+	for _, body := range server.bodies {
+		bytesSent += len(body)
+		for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+			var record Record
+			if err := json.Unmarshal(line, &record); err != nil {
+				t.Fatalf("failed to parse record: %v", err)
+			}
+			contents = append(contents, record.ContentString())
+		}
+	}
+	if len(contents) != 5 || contents[0] != "one" || contents[4] != "five" {
+		t.Errorf("unexpected records %q", contents)
+	}
+
+	stats := rec.HTTPStats()
+	if stats.RequestsSent != 3 || stats.BytesSent != uint64(bytesSent) || stats.Errors != 0 {
+		t.Errorf("unexpected stats %+v (bytes sent: %d)", stats, bytesSent)
+	}
+	if conns := server.conns.Load(); conns != 1 {
+		t.Errorf("expected the requests to share 1 connection, got %d", conns)
+	}
+}
+
+func TestHTTPSink_DisableKeepAlives(t *testing.T) {
+	server := newNDJSONServer(t)
+	sink := NewHTTPSink(server.URL, HTTPSinkOptions{BatchSize: 1, DisableKeepAlives: true})
+	for i := 0; i < 3; i++ {
+		if _, err := sink.Write([]byte("{}\n")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+
+	if conns := server.conns.Load(); conns != 3 {
+		t.Errorf("expected a connection per request, got %d", conns)
+	}
+}
+
+func TestHTTPSink_Error(t *testing.T) {
+	server := newNDJSONServer(t)
+	server.status = http.StatusServiceUnavailable
+	rec, err := NewRecorder(NewHTTPSink(server.URL, HTTPSinkOptions{}), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("line\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.FlushAll(); err == nil {
+		t.Error("expected an error for a failed request")
+	}
+	stats := rec.HTTPStats()
+	if stats.RequestsSent != 0 || stats.BytesSent != 0 || stats.Errors != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// The failed batch is dropped
+	server.status = 0
+	if err := rec.Close(); err != nil {
+		t.Errorf("failed to close recorder: %v", err)
+	}
+	if stats := rec.HTTPStats(); stats.RequestsSent != 0 {
+		t.Errorf("expected nothing to be resent, got %+v", stats)
+	}
+}
+
+func TestHTTPSink_Retry(t *testing.T) {
+	server := newNDJSONServer(t)
+	server.status = http.StatusServiceUnavailable
+	initial := 20 * time.Millisecond
+	sink := NewHTTPSink(server.URL, HTTPSinkOptions{
+		BatchSize: 2,
+		Retry:     &RetryOptions{Initial: initial, MaxBuffered: 3 * len(recordLine(1))},
+	})
+	write := func(seq int) {
+		t.Helper()
+		if _, err := sink.Write([]byte(recordLine(seq))); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	// A failed batch is kept without failing the sink, and not sent again
+	// before its retry is due
+	write(1)
+	write(2)
+	write(3)
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	// Records beyond MaxBuffered are dropped meanwhile
+	write(4)
+	write(5)
+	if len(server.bodies) != 1 {
+		t.Fatalf("expected 1 request before the retry is due, got %d", len(server.bodies))
+	}
+
+	server.status = 0
+	time.Sleep(initial)
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if len(server.bodies) != 2 {
+		t.Fatalf("expected the batch to be sent again, got %d requests", len(server.bodies))
+	}
+	lines := bytes.SplitAfter(server.bodies[1], []byte("\n"))
+	if len(lines) != 5 || string(lines[1]) != recordLine(1) || string(lines[3]) != recordLine(3) {
+		t.Fatalf("expected a meta record and records 1 to 3, got %q", server.bodies[1])
+	}
+	var notice Record
+	if err := json.Unmarshal(lines[0], &notice); err != nil {
+		t.Fatalf("failed to parse meta record: %v", err)
+	}
+	content, _ := notice.Content.(map[string]any)
+	if notice.Seq != 4 || content["event"] != "records-dropped" || content["records"] != float64(2) {
+		t.Errorf("expected a records-dropped meta record for 2 records from seq 4, got %s", lines[0])
+	}
+	stats := sink.(httpStatser).httpStats()
+	if stats.RequestsSent != 1 || stats.Errors != 1 {
+		t.Errorf("expected 1 request sent and 1 error, got %+v", stats)
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("failed to close sink: %v", err)
+	}
+}
+
+func TestHTTPSink_RetryGiveUp(t *testing.T) {
+	server := newNDJSONServer(t)
+	server.status = http.StatusServiceUnavailable
+	initial := 10 * time.Millisecond
+	sink := NewHTTPSink(server.URL, HTTPSinkOptions{Retry: &RetryOptions{Initial: initial, MaxAttempts: 1}})
+
+	if _, err := sink.Write([]byte(recordLine(1))); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	time.Sleep(initial)
+	if err := sink.Flush(); err == nil || !strings.Contains(err.Error(), "gave up after 1 retries") {
+		t.Errorf("expected to give up after 1 retry, got %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("expected the batch to be dropped, got %v", err)
+	}
+	if len(server.bodies) != 2 {
+		t.Errorf("expected 2 requests, got %d", len(server.bodies))
+	}
+}
+
+func TestRecorder_HTTPStatsWithoutHTTPSink(t *testing.T) {
+	rec, err := NewRecorder(NewWriterSink(io.Discard), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	if stats := rec.HTTPStats(); stats != (HTTPSinkStats{}) {
+		t.Errorf("expected zero stats, got %+v", stats)
+	}
+}
+
+func TestRecorder_HTTPStatsBehindMultiSink(t *testing.T) {
+	server := newNDJSONServer(t)
+	failing := newNDJSONServer(t)
+	failing.status = http.StatusServiceUnavailable
+	sink := NewMultiSink(
+		Destination{Sink: NewWriterSink(io.Discard)},
+		Destination{Name: server.URL, Sink: NewHTTPSink(server.URL, HTTPSinkOptions{BatchSize: 1})},
+		Destination{Name: failing.URL, Sink: NewHTTPSink(failing.URL, HTTPSinkOptions{BatchSize: 1}), Continue: true},
+	)
+	rec, err := NewRecorder(sink, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	for _, line := range []string{"one\n", "two\n"} {
+		if err := rec.Record(Stdout, []byte(line)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+
+	// The failing sink is dropped after its first request
+	stats := rec.HTTPStats()
+	if stats.RequestsSent != 2 || stats.BytesSent == 0 || stats.Errors != 1 {
+		t.Errorf("expected 2 requests sent and 1 error, got %+v", stats)
+	}
+}
+
+func benchmarkHTTPSink(b *testing.B, opts HTTPSinkOptions) {
+	server := newNDJSONServer(b)
+	opts.BatchSize = 1
+	sink := NewHTTPSink(server.URL, opts)
+	defer sink.Close()
+
+	line := []byte(`{"seq":0,"timestamp":"2024-01-15T10:30:45.123Z","source":"stdout","content":"line","encoding":"text","end":"\n"}` + "\n")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sink.Write(line); err != nil {
+			b.Fatalf("failed to write: %v", err)
+		}
+	}
+}
+
+func BenchmarkHTTPSink_KeepAlive(b *testing.B) {
+	benchmarkHTTPSink(b, HTTPSinkOptions{})
+}
+
+func BenchmarkHTTPSink_FreshConnections(b *testing.B) {
+	benchmarkHTTPSink(b, HTTPSinkOptions{DisableKeepAlives: true})
+}
@@ -0,0 +1,280 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// KafkaMessage is a message published by a Kafka sink.
+type KafkaMessage struct {
+	Key   []byte    // Source of the record ("stdin", "stdout", "stderr", "meta" or "stats")
+	Value []byte    // The record as JSON, without trailing newline
+	Time  time.Time // Timestamp of the record
+}
+
+// KafkaProducer publishes messages to a Kafka topic, e.g. a KafkaClient or
+// an adapter for the Writer of a Kafka client library. The topic and brokers
+// are configured by the producer.
+type KafkaProducer interface {
+	// Produce publishes msgs in order and returns once they are delivered.
+	Produce(ctx context.Context, msgs []KafkaMessage) error
+	// Close releases the producer.
+	Close() error
+}
+
+// KafkaSinkOptions configures a Kafka sink.
+type KafkaSinkOptions struct {
+	BatchSize     int           // Records per produce request (0 = 100)
+	QueueSize     int           // Records waiting to be published before new ones are dropped (0 = 2048)
+	FlushInterval time.Duration // Publish a partial batch after this long (0 = 1s)
+	Retry         *RetryOptions // Retry a failed batch with backoff (nil = drop it)
+}
+
+// Kafka sink defaults.
+const (
+	defaultKafkaBatchSize     = 100
+	defaultKafkaQueueSize     = 2048
+	defaultKafkaFlushInterval = time.Second
+	kafkaProduceTimeout       = 30 * time.Second
+	kafkaShutdownTimeout      = 5 * time.Second
+)
+
+// kafkaRecord is a record waiting to be published by a Kafka sink.
+type kafkaRecord struct {
+	seq uint64
+	msg KafkaMessage
+}
+
+// kafkaSink is a Sink that publishes each record as a Kafka message.
+type kafkaSink struct {
+	producer KafkaProducer
+	batch    int
+	interval time.Duration
+	retry    *RetryOptions
+
+	queue chan kafkaRecord // records waiting to be published
+	flush chan struct{}    // requests the pending records to be published
+	stop  chan struct{}    // closed by Close to stop retrying
+	done  chan struct{}    // closed when the publishing goroutine exits
+
+	mu         sync.Mutex
+	closed     bool
+	dropped    uint64 // Records not delivered since the last delivery
+	droppedSeq uint64 // Seq of the first of them
+	err        error  // Why the last of them were not delivered
+	failing    bool   // The last batch was not delivered
+}
+
+// NewKafkaSink returns a Sink that publishes each record as a message keyed
+// by its source, so records of the same source stay in one partition, with
+// the record as the value. A header line (see WithHeader) is not published.
+//
+// Records are published in batches by a background goroutine, whenever a
+// batch is full, the Recorder flushes, or opts.FlushInterval passes, so
+// recording never waits for the brokers. With opts.Retry, a batch that
+// fails is produced again with backoff while the queue fills up. Records
+// that cannot be delivered are dropped, as are records written while the
+// queue is full, and the next
+// batch that is delivered starts with a "records-dropped" meta record with
+// their number, the seq of the first of them and the error, so that
+// consumers of the topic know what they missed. Close stops retrying,
+// publishes the remaining records, waiting up to 5 seconds, and closes
+// producer.
+func NewKafkaSink(producer KafkaProducer, opts KafkaSinkOptions) Sink {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultKafkaBatchSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultKafkaQueueSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultKafkaFlushInterval
+	}
+	s := &kafkaSink{
+		producer: producer,
+		batch:    opts.BatchSize,
+		interval: opts.FlushInterval,
+		retry:    opts.Retry,
+		queue:    make(chan kafkaRecord, opts.QueueSize),
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.publish()
+	return s
+}
+
+// Write implements Sink.
+func (s *kafkaSink) Write(p []byte) (int, error) {
+	value := bytes.TrimSuffix(p, []byte("\n"))
+	var record Record
+	if err := json.Unmarshal(value, &record); err != nil || record.Source == "" {
+		// Not a record, e.g. a JSON Schema header
+		return len(p), nil
+	}
+	t, err := record.Time()
+	if err != nil {
+		t = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	select {
+	case s.queue <- kafkaRecord{seq: record.Seq, msg: KafkaMessage{
+		Key:   []byte(record.Source),
+		Value: bytes.Clone(value),
+		Time:  t,
+	}}:
+	default:
+		s.dropLocked(record.Seq, 1, errKafkaQueueFull)
+	}
+	return len(p), nil
+}
+
+// errKafkaQueueFull is why records written while the queue of a Kafka sink
+// is full are dropped.
+var errKafkaQueueFull = errors.New("queue full")
+
+// Flush implements Sink. It does not wait for the records to be published.
+func (s *kafkaSink) Flush() error {
+	select {
+	case s.flush <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close implements Sink. It returns an error if records were dropped since
+// the last delivery.
+func (s *kafkaSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	close(s.stop)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(kafkaShutdownTimeout):
+		return fmt.Errorf("failed to publish records to Kafka: timed out after %v", kafkaShutdownTimeout)
+	}
+	err := s.producer.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropped > 0 {
+		return fmt.Errorf("failed to publish %d records to Kafka: %w", s.dropped, s.err)
+	}
+	return err
+}
+
+// publish runs in the background and publishes the queued records in
+// batches until the queue is closed.
+func (s *kafkaSink) publish() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var pending []kafkaRecord
+	send := func() {
+		if len(pending) == 0 {
+			return
+		}
+		s.send(pending)
+		pending = nil
+	}
+	for {
+		select {
+		case record, ok := <-s.queue:
+			if !ok {
+				send()
+				return
+			}
+			pending = append(pending, record)
+			if len(pending) >= s.batch {
+				send()
+			}
+		case <-s.flush:
+			// Take what is queued already, so that a flush covers it
+			for n := len(s.queue); n > 0; n-- {
+				record, ok := <-s.queue
+				if !ok {
+					break
+				}
+				pending = append(pending, record)
+			}
+			send()
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+// send publishes records, preceded by a "records-dropped" meta record if
+// records were dropped, and drops them if they cannot be delivered.
+func (s *kafkaSink) send(records []kafkaRecord) {
+	msgs := make([]KafkaMessage, 0, len(records)+1)
+	s.mu.Lock()
+	dropped, droppedSeq, dropErr := s.dropped, s.droppedSeq, s.err
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		now := time.Now()
+		notice, err := NewMetaRecord(droppedSeq, now, map[string]any{
+			"event":   "records-dropped",
+			"records": dropped,
+			"error":   dropErr.Error(),
+		}).ToJSON()
+		if err == nil {
+			msgs = append(msgs, KafkaMessage{Key: []byte(MetaSource), Value: notice, Time: now})
+		}
+	}
+	for _, record := range records {
+		msgs = append(msgs, record.msg)
+	}
+
+	err := retry(s.retry, "Kafka", s.stop, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), kafkaProduceTimeout)
+		defer cancel()
+		return s.producer.Produce(ctx, msgs)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failing = false
+		return
+	}
+	if !s.failing {
+		logging.Warnf("failed to publish records to Kafka: %v; dropping records until it recovers", err)
+		s.failing = true
+	}
+	if dropped > 0 {
+		s.dropLocked(droppedSeq, dropped, err)
+	}
+	s.dropLocked(records[0].seq, uint64(len(records)), err)
+}
+
+// dropLocked counts n records from the one with the given seq on as dropped
+// because of err. Must be called with mu held.
+func (s *kafkaSink) dropLocked(seq, n uint64, err error) {
+	if s.dropped == 0 || seq < s.droppedSeq {
+		s.droppedSeq = seq
+	}
+	s.dropped += n
+	s.err = err
+}
@@ -0,0 +1,209 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryProducer keeps the messages produced to it in memory.
+type memoryProducer struct {
+	mu       sync.Mutex
+	batches  [][]KafkaMessage
+	closed   bool
+	err      error               // returned by Produce if set
+	produced chan []KafkaMessage // gets every batch, delivered or not
+}
+
+func newMemoryProducer() *memoryProducer {
+	return &memoryProducer{produced: make(chan []KafkaMessage, 16)}
+}
+
+func (p *memoryProducer) Produce(ctx context.Context, msgs []KafkaMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer func() { p.produced <- msgs }()
+	if p.err != nil {
+		return p.err
+	}
+	p.batches = append(p.batches, msgs)
+	return nil
+}
+
+func (p *memoryProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *memoryProducer) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// wait returns the next batch passed to Produce.
+func (p *memoryProducer) wait(t *testing.T) []KafkaMessage {
+	t.Helper()
+	select {
+	case msgs := <-p.produced:
+		return msgs
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a batch to be produced")
+		return nil
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	producer := newMemoryProducer()
+	sink := NewKafkaSink(producer, KafkaSinkOptions{BatchSize: 2, FlushInterval: time.Hour})
+	rec, err := NewRecorder(sink, 0, WithHeader([]byte(`{"$schema":"x"}`)))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdin, []byte("in\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("one\ntwo\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	// A full batch is published without a flush
+	if msgs := producer.wait(t); len(msgs) != 2 {
+		t.Fatalf("expected a full batch of 2 messages, got %d", len(msgs))
+	}
+
+	if err := rec.Record(Stderr, []byte("err\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	// FlushAll publishes the partial batch
+	if msgs := producer.wait(t); len(msgs) != 2 {
+		t.Fatalf("expected the flushed batch of 2 messages, got %d", len(msgs))
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	if !producer.closed {
+		t.Error("expected Close to close the producer")
+	}
+
+	// One message per record, without the header
+	var msgs []KafkaMessage
+	for _, batch := range producer.batches {
+		msgs = append(msgs, batch...)
+	}
+	want := []struct{ key, content string }{
+		{"stdin", "in"},
+		{"stdout", "one"},
+		{"stdout", "two"},
+		{"stderr", "err"},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(msgs))
+	}
+	for i, msg := range msgs {
+		var record Record
+		if err := json.Unmarshal(msg.Value, &record); err != nil {
+			t.Fatalf("failed to parse message value: %v", err)
+		}
+		if string(msg.Key) != want[i].key || record.Seq != uint64(i) || record.ContentString() != want[i].content {
+			t.Errorf("message %d: expected key %s with %q, got key %s with %s", i, want[i].key, want[i].content, msg.Key, msg.Value)
+		}
+		if recordTime, _ := record.Time(); !msg.Time.Equal(recordTime) {
+			t.Errorf("message %d: expected the time of the record %v, got %v", i, recordTime, msg.Time)
+		}
+	}
+}
+
+func TestKafkaSink_DeliveryError(t *testing.T) {
+	producerErr := errors.New("broker unavailable")
+	producer := newMemoryProducer()
+	producer.setErr(producerErr)
+	sink := NewKafkaSink(producer, KafkaSinkOptions{FlushInterval: time.Hour})
+
+	write := func(seq int) {
+		t.Helper()
+		line := `{"seq":` + strconv.Itoa(seq) + `,"timestamp":"2024-01-15T10:30:45.000Z","source":"stdout","content":"x","encoding":"text"}` + "\n"
+		if _, err := sink.Write([]byte(line)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	// The failed batch is dropped without failing the sink
+	write(0)
+	write(1)
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	producer.wait(t)
+
+	// The next batch delivered tells what was dropped
+	producer.setErr(nil)
+	write(2)
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	msgs := producer.wait(t)
+	if len(msgs) != 2 || string(msgs[0].Key) != MetaSource {
+		t.Fatalf("expected a meta record and the new record, got %d messages", len(msgs))
+	}
+	var notice Record
+	if err := json.Unmarshal(msgs[0].Value, &notice); err != nil {
+		t.Fatalf("failed to parse message value: %v", err)
+	}
+	content, _ := notice.Content.(map[string]any)
+	if notice.Seq != 0 || content["event"] != "records-dropped" || content["records"] != float64(2) || content["error"] != producerErr.Error() {
+		t.Errorf("expected a records-dropped meta record for 2 records from seq 0, got %s", msgs[0].Value)
+	}
+
+	// Records that are still not delivered on Close are reported
+	producer.setErr(producerErr)
+	write(3)
+	if err := sink.Close(); !errors.Is(err, producerErr) {
+		t.Errorf("expected the producer error from Close, got %v", err)
+	}
+}
+
+func TestKafkaSink_Retry(t *testing.T) {
+	producer := newMemoryProducer()
+	producer.setErr(errors.New("broker unavailable"))
+	sink := NewKafkaSink(producer, KafkaSinkOptions{
+		FlushInterval: time.Hour,
+		Retry:         &RetryOptions{Initial: 10 * time.Millisecond},
+	})
+
+	line := `{"seq":0,"timestamp":"2024-01-15T10:30:45.000Z","source":"stdout","content":"x","encoding":"text"}` + "\n"
+	if _, err := sink.Write([]byte(line)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	// The failed batch is produced again, as is, once the brokers recover
+	producer.wait(t)
+	producer.setErr(nil)
+	for {
+		producer.wait(t)
+		producer.mu.Lock()
+		delivered := len(producer.batches)
+		producer.mu.Unlock()
+		if delivered > 0 {
+			break
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	if len(producer.batches) != 1 || len(producer.batches[0]) != 1 {
+		t.Errorf("expected the record to be delivered once, without a notice, got %d batches", len(producer.batches))
+	}
+}
@@ -0,0 +1,526 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KafkaClientOptions configures a KafkaClient.
+type KafkaClientOptions struct {
+	Brokers []string      // Addresses of the bootstrap brokers, e.g. "localhost:9092"
+	Topic   string        // Topic the messages are published to
+	Timeout time.Duration // Timeout of a request, including connecting (0 = 10s)
+}
+
+// defaultKafkaTimeout is the timeout of a Kafka request unless given
+// otherwise.
+const defaultKafkaTimeout = 10 * time.Second
+
+// Kafka API keys and the versions of them a KafkaClient speaks. Produce v3
+// is the first version with record batches (message format v2), which
+// brokers from 0.11 on accept.
+const (
+	kafkaProduceKey      int16 = 0
+	kafkaProduceVersion  int16 = 3
+	kafkaMetadataKey     int16 = 3
+	kafkaMetadataVersion int16 = 1
+)
+
+// kafkaClientID identifies ioetap in the requests, e.g. in broker logs.
+const kafkaClientID = "ioetap"
+
+// KafkaClient is a KafkaProducer that speaks the Kafka protocol directly, so
+// that ioetap does not depend on a Kafka client library. It publishes to the
+// leaders of the partitions of one topic without compression, transactions
+// or authentication, which is enough for a broker on a trusted network.
+// Messages are assigned to partitions by the murmur2 hash of their key, like
+// the default partitioner of the Java client, so messages with the same key
+// keep their order.
+type KafkaClient struct {
+	opts KafkaClientOptions
+
+	mu            sync.Mutex
+	conns         map[string]net.Conn // Connections by broker address
+	leaders       []string            // Address of the leader of each partition (nil = unknown)
+	correlationID int32
+}
+
+// NewKafkaClient returns a KafkaClient for the topic and brokers in opts.
+// It connects on the first Produce call.
+func NewKafkaClient(opts KafkaClientOptions) (*KafkaClient, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, errors.New("no brokers specified")
+	}
+	if opts.Topic == "" {
+		return nil, errors.New("no topic specified")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultKafkaTimeout
+	}
+	return &KafkaClient{opts: opts, conns: make(map[string]net.Conn)}, nil
+}
+
+// Produce implements KafkaProducer. The messages of each partition are sent
+// to its leader as a single record batch. If a broker reports that the
+// partitions moved, the leaders are looked up again on the next call.
+func (c *KafkaClient) Produce(ctx context.Context, msgs []KafkaMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leaders == nil {
+		if err := c.refreshMetadata(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Group the messages by partition, then the partitions by leader
+	partitions := make(map[int32][]KafkaMessage)
+	for _, msg := range msgs {
+		partition := kafkaPartition(msg.Key, len(c.leaders))
+		partitions[partition] = append(partitions[partition], msg)
+	}
+	byLeader := make(map[string]map[int32][]KafkaMessage)
+	for partition, partitionMsgs := range partitions {
+		leader := c.leaders[partition]
+		if byLeader[leader] == nil {
+			byLeader[leader] = make(map[int32][]KafkaMessage)
+		}
+		byLeader[leader][partition] = partitionMsgs
+	}
+
+	var errs []error
+	for leader, leaderPartitions := range byLeader {
+		if err := c.produce(ctx, leader, leaderPartitions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		c.leaders = nil
+		return err
+	}
+	return nil
+}
+
+// Close implements KafkaProducer.
+func (c *KafkaClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+	return nil
+}
+
+// refreshMetadata looks up the leaders of the partitions of the topic,
+// asking the bootstrap brokers in turn. Must be called with mu held.
+func (c *KafkaClient) refreshMetadata(ctx context.Context) error {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(c.opts.Topic)
+
+	var errs []error
+	for _, addr := range c.opts.Brokers {
+		resp, err := c.roundTrip(ctx, addr, kafkaMetadataKey, kafkaMetadataVersion, req.Bytes())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		leaders, err := parseKafkaMetadata(resp, c.opts.Topic)
+		if err != nil {
+			return fmt.Errorf("failed to look up topic %s: %w", c.opts.Topic, err)
+		}
+		c.leaders = leaders
+		return nil
+	}
+	return fmt.Errorf("failed to look up topic %s: %w", c.opts.Topic, errors.Join(errs...))
+}
+
+// parseKafkaMetadata returns the address of the leader of each partition of
+// topic in a Metadata v1 response.
+func parseKafkaMetadata(resp []byte, topic string) ([]string, error) {
+	d := kafkaDecoder{b: resp}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // Rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // Controller ID
+
+	var leaders []string
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		topicErr := d.int16()
+		name := d.string()
+		d.int8() // Is internal
+		var partitionLeaders []string
+		var partitionErr error
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			code := d.int16()
+			index := d.int32()
+			leader := d.int32()
+			d.int32s() // Replicas
+			d.int32s() // In-sync replicas
+			if code != 0 && partitionErr == nil {
+				partitionErr = fmt.Errorf("partition %d: %w", index, kafkaError(code))
+			}
+			if index < 0 {
+				continue
+			}
+			for int(index) >= len(partitionLeaders) {
+				partitionLeaders = append(partitionLeaders, "")
+			}
+			partitionLeaders[index] = brokers[leader]
+		}
+		if name != topic {
+			continue
+		}
+		if topicErr != 0 {
+			return nil, kafkaError(topicErr)
+		}
+		if partitionErr != nil {
+			return nil, partitionErr
+		}
+		leaders = partitionLeaders
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid Metadata response: %w", d.err)
+	}
+	if len(leaders) == 0 {
+		return nil, kafkaError(3)
+	}
+	for i, leader := range leaders {
+		if leader == "" {
+			return nil, fmt.Errorf("partition %d: %w", i, kafkaError(5))
+		}
+	}
+	return leaders, nil
+}
+
+// produce sends the messages of the given partitions to their leader in a
+// Produce v3 request. Must be called with mu held.
+func (c *KafkaClient) produce(ctx context.Context, leader string, partitions map[int32][]KafkaMessage) error {
+	var req kafkaEncoder
+	req.int16(-1) // No transactional ID
+	req.int16(-1) // Acknowledged by all in-sync replicas
+	req.int32(int32(c.opts.Timeout / time.Millisecond))
+	req.int32(1)
+	req.string(c.opts.Topic)
+	req.int32(int32(len(partitions)))
+	for partition, msgs := range partitions {
+		req.int32(partition)
+		req.bytes(kafkaRecordBatch(msgs))
+	}
+
+	resp, err := c.roundTrip(ctx, leader, kafkaProduceKey, kafkaProduceVersion, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{b: resp}
+	var errs []error
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.string() // Topic
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // Base offset
+			d.int64() // Log append time
+			if code != 0 {
+				errs = append(errs, fmt.Errorf("partition %d: %w", partition, kafkaError(code)))
+			}
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("invalid Produce response: %w", d.err)
+	}
+	return errors.Join(errs...)
+}
+
+// roundTrip sends a request to the broker at addr and returns the body of
+// its response. The connection is closed if anything goes wrong, so that the
+// next request starts on a fresh one. Must be called with mu held.
+func (c *KafkaClient) roundTrip(ctx context.Context, addr string, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	conn := c.conns[addr]
+	if conn == nil {
+		dialer := net.Dialer{Timeout: c.opts.Timeout}
+		var err error
+		if conn, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+			return nil, err
+		}
+		c.conns[addr] = conn
+	}
+
+	resp, err := c.exchange(ctx, conn, apiKey, apiVersion, body)
+	if err != nil {
+		conn.Close()
+		delete(c.conns, addr)
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return resp, nil
+}
+
+// exchange writes a request over conn and reads its response.
+func (c *KafkaClient) exchange(ctx context.Context, conn net.Conn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	deadline := time.Now().Add(c.opts.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	c.correlationID++
+	var req kafkaEncoder
+	req.int32(0) // Size, set below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(c.correlationID)
+	req.string(kafkaClientID)
+	req.Write(body)
+	frame := req.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	if _, err := conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != c.correlationID {
+		return nil, errors.New("response out of order")
+	}
+	return resp[4:], nil
+}
+
+// kafkaRecordBatch encodes msgs as a record batch (message format v2).
+func kafkaRecordBatch(msgs []KafkaMessage) []byte {
+	first := msgs[0].Time.UnixMilli()
+	last := first
+	var records kafkaEncoder
+	for i, msg := range msgs {
+		timestamp := msg.Time.UnixMilli()
+		if timestamp > last {
+			last = timestamp
+		}
+		var record kafkaEncoder
+		record.int8(0) // Attributes
+		record.varint(timestamp - first)
+		record.varint(int64(i))
+		record.varint(int64(len(msg.Key)))
+		record.Write(msg.Key)
+		record.varint(int64(len(msg.Value)))
+		record.Write(msg.Value)
+		record.varint(0) // Headers
+		records.varint(int64(record.Len()))
+		records.Write(record.Bytes())
+	}
+
+	// The CRC covers everything from the attributes on
+	var tail kafkaEncoder
+	tail.int16(0) // Attributes: no compression, create time
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(first)
+	tail.int64(last)
+	tail.int64(-1) // No producer ID
+	tail.int16(-1) // No producer epoch
+	tail.int32(-1) // No base sequence
+	tail.int32(int32(len(msgs)))
+	tail.Write(records.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0)                             // Base offset, assigned by the broker
+	batch.int32(int32(tail.Len() + 4 + 1 + 4)) // Length after this field
+	batch.int32(-1)                            // Partition leader epoch
+	batch.int8(2)                              // Magic
+	batch.int32(int32(crc32.Checksum(tail.Bytes(), kafkaCRCTable)))
+	batch.Write(tail.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaCRCTable is the CRC-32C table of record batch checksums.
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaPartition returns the partition of a message with the given key among
+// n partitions, like the default partitioner of the Java client.
+func kafkaPartition(key []byte, n int) int32 {
+	return int32(uint32(murmur2(key)&0x7fffffff) % uint32(n))
+}
+
+// murmur2 returns the 32-bit MurmurHash2 of data with the seed of the Java
+// client.
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+	)
+	h := uint32(seed) ^ uint32(len(data))
+	n := len(data) &^ 3
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch tail := data[n:]; len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaError is an error code in a Kafka response.
+type kafkaError int16
+
+// kafkaErrorNames names the errors a producer commonly gets.
+var kafkaErrorNames = map[kafkaError]string{
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	10: "MESSAGE_TOO_LARGE",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+}
+
+func (e kafkaError) Error() string {
+	if name, ok := kafkaErrorNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kafka error %d", int16(e))
+}
+
+// kafkaEncoder encodes the big-endian fields of Kafka requests.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.Write(binary.BigEndian.AppendUint64(nil, uint64(v)))
+}
+
+// varint writes a zigzag-encoded variable-length integer, as in records.
+func (e *kafkaEncoder) varint(v int64) {
+	e.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// kafkaDecoder decodes the big-endian fields of Kafka responses. After the
+// first field that is cut short, err is set and all fields are zero.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes, or nil if there are not as many.
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, returning "" for null.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// int32s reads an array of int32s.
+func (d *kafkaDecoder) int32s() []int32 {
+	var values []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		values = append(values, d.int32())
+	}
+	return values
+}
@@ -0,0 +1,311 @@
+package recorder
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMurmur2(t *testing.T) {
+	// The test vectors of the Java client's Utils.murmur2
+	tests := []struct {
+		in   string
+		want int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+	for _, tt := range tests {
+		if got := murmur2([]byte(tt.in)); got != tt.want {
+			t.Errorf("murmur2(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// fakeKafka is a minimal Kafka broker that leads every partition of one
+// topic and keeps the messages produced to it in memory.
+type fakeKafka struct {
+	listener   net.Listener
+	topic      string
+	partitions int
+
+	mu       sync.Mutex
+	messages map[int32][]KafkaMessage // by partition
+	fail     int16                    // error code to answer the next Produce request with
+	requests []int16                  // API key of every request
+}
+
+func newFakeKafka(t *testing.T, topic string, partitions int) *fakeKafka {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	b := &fakeKafka{listener: listener, topic: topic, partitions: partitions, messages: map[int32][]KafkaMessage{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeKafka) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := kafkaDecoder{b: req}
+		apiKey := d.int16()
+		d.int16() // API version
+		correlationID := d.int32()
+		d.string() // Client ID
+
+		var resp kafkaEncoder
+		resp.int32(0) // Size, set below
+		resp.int32(correlationID)
+		switch apiKey {
+		case kafkaMetadataKey:
+			b.metadata(&resp)
+		case kafkaProduceKey:
+			b.produce(&d, &resp)
+		default:
+			return
+		}
+		b.mu.Lock()
+		b.requests = append(b.requests, apiKey)
+		b.mu.Unlock()
+
+		frame := resp.Bytes()
+		binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// metadata writes a Metadata v1 response.
+func (b *fakeKafka) metadata(resp *kafkaEncoder) {
+	host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	resp.int32(1)
+	resp.int32(1) // Node ID
+	resp.string(host)
+	resp.int32(int32(portNum))
+	resp.int16(-1) // No rack
+	resp.int32(1)  // Controller ID
+	resp.int32(1)
+	resp.int16(0)
+	resp.string(b.topic)
+	resp.int8(0)
+	resp.int32(int32(b.partitions))
+	for i := b.partitions - 1; i >= 0; i-- {
+		resp.int16(0)
+		resp.int32(int32(i))
+		resp.int32(1) // Leader
+		resp.int32(1)
+		resp.int32(1) // Replicas
+		resp.int32(1)
+		resp.int32(1) // In-sync replicas
+	}
+}
+
+// produce decodes a Produce v3 request and writes its response.
+func (b *fakeKafka) produce(d *kafkaDecoder, resp *kafkaEncoder) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d.string() // Transactional ID
+	d.int16()  // Acks
+	d.int32()  // Timeout
+	type result struct {
+		partition int32
+		code      int16
+	}
+	var results []result
+	for n := d.int32(); n > 0; n-- {
+		d.string() // Topic
+		for m := d.int32(); m > 0; m-- {
+			partition := d.int32()
+			batch := d.next(int(d.int32()))
+			msgs, err := decodeKafkaRecordBatch(batch)
+			code := b.fail
+			if err != nil {
+				code = 2 // CORRUPT_MESSAGE
+			}
+			if code == 0 {
+				b.messages[partition] = append(b.messages[partition], msgs...)
+			}
+			results = append(results, result{partition, code})
+		}
+	}
+	b.fail = 0
+
+	resp.int32(1)
+	resp.string(b.topic)
+	resp.int32(int32(len(results)))
+	for _, r := range results {
+		resp.int32(r.partition)
+		resp.int16(r.code)
+		resp.int64(0)  // Base offset
+		resp.int64(-1) // Log append time
+	}
+	resp.int32(0) // Throttle time
+}
+
+// decodeKafkaRecordBatch decodes a record batch, checking its CRC.
+func decodeKafkaRecordBatch(batch []byte) ([]KafkaMessage, error) {
+	d := kafkaDecoder{b: batch}
+	d.int64() // Base offset
+	if int(d.int32()) != len(d.b) {
+		return nil, errors.New("wrong batch length")
+	}
+	d.int32() // Partition leader epoch
+	if d.int8() != 2 {
+		return nil, errors.New("wrong magic")
+	}
+	if uint32(d.int32()) != crc32.Checksum(d.b, kafkaCRCTable) {
+		return nil, errors.New("wrong CRC")
+	}
+	d.int16() // Attributes
+	d.int32() // Last offset delta
+	first := d.int64()
+	d.int64() // Max timestamp
+	d.int64() // Producer ID
+	d.int16() // Producer epoch
+	d.int32() // Base sequence
+
+	var msgs []KafkaMessage
+	varint := func() int64 {
+		v, n := binary.Varint(d.b)
+		if n <= 0 {
+			d.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		d.b = d.b[n:]
+		return v
+	}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		varint() // Length
+		d.int8() // Attributes
+		delta := varint()
+		varint() // Offset delta
+		key := d.next(int(varint()))
+		value := d.next(int(varint()))
+		varint() // Headers
+		msgs = append(msgs, KafkaMessage{Key: key, Value: value, Time: time.UnixMilli(first + delta)})
+	}
+	return msgs, d.err
+}
+
+func TestKafkaClient_Produce(t *testing.T) {
+	broker := newFakeKafka(t, "records", 3)
+	client, err := NewKafkaClient(KafkaClientOptions{Brokers: []string{broker.listener.Addr().String()}, Topic: "records"})
+	if err != nil {
+		t.Fatalf("NewKafkaClient() error = %v", err)
+	}
+	defer client.Close()
+
+	start := time.UnixMilli(1700000000000)
+	msgs := []KafkaMessage{
+		{Key: []byte("stdout"), Value: []byte(`{"seq":0}`), Time: start},
+		{Key: []byte("stderr"), Value: []byte(`{"seq":1}`), Time: start.Add(time.Millisecond)},
+		{Key: []byte("stdout"), Value: []byte(`{"seq":2}`), Time: start.Add(2 * time.Millisecond)},
+	}
+	if err := client.Produce(context.Background(), msgs); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+
+	// Messages with the same key are in the same partition, in order
+	broker.mu.Lock()
+	stdout := broker.messages[kafkaPartition([]byte("stdout"), 3)]
+	stderr := broker.messages[kafkaPartition([]byte("stderr"), 3)]
+	broker.mu.Unlock()
+	var got []string
+	for _, msg := range append(stdout, stderr...) {
+		got = append(got, string(msg.Key)+" "+string(msg.Value)+" "+strconv.FormatInt(msg.Time.Sub(start).Milliseconds(), 10))
+	}
+	want := []string{`stdout {"seq":0} 0`, `stdout {"seq":2} 2`, `stderr {"seq":1} 1`}
+	if len(got) != len(want) {
+		t.Fatalf("got messages %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestKafkaClient_ProduceError(t *testing.T) {
+	broker := newFakeKafka(t, "records", 1)
+	client, err := NewKafkaClient(KafkaClientOptions{Brokers: []string{broker.listener.Addr().String()}, Topic: "records"})
+	if err != nil {
+		t.Fatalf("NewKafkaClient() error = %v", err)
+	}
+	defer client.Close()
+
+	msgs := []KafkaMessage{{Key: []byte("stdout"), Value: []byte("{}"), Time: time.Now()}}
+	broker.mu.Lock()
+	broker.fail = 6 // NOT_LEADER_OR_FOLLOWER
+	broker.mu.Unlock()
+	if err := client.Produce(context.Background(), msgs); !errors.Is(err, kafkaError(6)) {
+		t.Fatalf("Produce() error = %v, want NOT_LEADER_OR_FOLLOWER", err)
+	}
+
+	// The leaders are looked up again before the next request
+	if err := client.Produce(context.Background(), msgs); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	want := []int16{kafkaMetadataKey, kafkaProduceKey, kafkaMetadataKey, kafkaProduceKey}
+	if len(broker.requests) != len(want) {
+		t.Fatalf("requests = %v, want %v", broker.requests, want)
+	}
+	for i := range want {
+		if broker.requests[i] != want[i] {
+			t.Errorf("requests = %v, want %v", broker.requests, want)
+			break
+		}
+	}
+}
+
+func TestKafkaClient_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client, err := NewKafkaClient(KafkaClientOptions{Brokers: []string{addr}, Topic: "records"})
+	if err != nil {
+		t.Fatalf("NewKafkaClient() error = %v", err)
+	}
+	msgs := []KafkaMessage{{Key: []byte("stdout"), Value: []byte("{}"), Time: time.Now()}}
+	if err := client.Produce(context.Background(), msgs); err == nil {
+		t.Error("expected an error from an unreachable broker")
+	}
+}
@@ -0,0 +1,123 @@
+package recorder
+
+import (
+	"errors"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// Destination is one of the sinks of a multi-sink (see NewMultiSink).
+type Destination struct {
+	Name string // Names the destination when its failure is reported with Continue, e.g. its address
+	Sink Sink
+	// Continue drops the destination with a warning on stderr when it
+	// fails, and the records keep going to the others without an error.
+	// Otherwise, its error is returned from every later call, while the
+	// records still go to the others.
+	Continue bool
+	// KeepOpen flushes the sink instead of closing it when the multi-sink
+	// is closed, for a sink that its owner closes.
+	KeepOpen bool
+}
+
+// multiSink is a Sink that writes every record to several destinations in
+// sequence, keeping track of which of them failed.
+type multiSink struct {
+	dests []Destination
+	errs  []error // First error of each destination (nil = healthy)
+}
+
+// NewMultiSink returns a Sink that writes every record to each of dests in
+// turn. Unlike io.MultiWriter, a destination that fails does not keep the
+// records from the destinations after it: it is skipped from then on, and
+// its error is handled as set by Destination.Continue. Close closes every
+// destination, including those that failed, except those with KeepOpen.
+func NewMultiSink(dests ...Destination) Sink {
+	return &multiSink{dests: dests, errs: make([]error, len(dests))}
+}
+
+// Write implements Sink.
+func (s *multiSink) Write(p []byte) (int, error) {
+	err := s.each(func(sink Sink) error {
+		_, err := sink.Write(p)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements Sink.
+func (s *multiSink) Flush() error {
+	return s.each(Sink.Flush)
+}
+
+// Sync implements Syncer. Destinations that are not Syncers are flushed.
+func (s *multiSink) Sync() error {
+	return s.each(func(sink Sink) error {
+		if syncer, ok := sink.(Syncer); ok {
+			return syncer.Sync()
+		}
+		return sink.Flush()
+	})
+}
+
+// Close implements Sink.
+func (s *multiSink) Close() error {
+	var errs []error
+	for i, dest := range s.dests {
+		var err error
+		if dest.KeepOpen {
+			err = dest.Sink.Flush()
+		} else {
+			err = dest.Sink.Close()
+		}
+		if dest.Continue {
+			continue
+		}
+		if s.errs[i] != nil {
+			err = s.errs[i]
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// httpStats implements httpStatser. It adds up the stats of the HTTP sinks
+// among the destinations.
+func (s *multiSink) httpStats() HTTPSinkStats {
+	var stats HTTPSinkStats
+	for _, dest := range s.dests {
+		if statser, ok := dest.Sink.(httpStatser); ok {
+			destStats := statser.httpStats()
+			stats.RequestsSent += destStats.RequestsSent
+			stats.BytesSent += destStats.BytesSent
+			stats.Errors += destStats.Errors
+		}
+	}
+	return stats
+}
+
+// each calls fn with every destination that has not failed, recording the
+// destinations for which it fails. It returns the errors of the failed
+// destinations without Continue, including those that failed before.
+func (s *multiSink) each(fn func(Sink) error) error {
+	var errs []error
+	for i, dest := range s.dests {
+		if s.errs[i] == nil {
+			if err := fn(dest.Sink); err != nil {
+				s.errs[i] = err
+				if dest.Continue {
+					logging.Warnf("%s: %v; recording continues without it", dest.Name, err)
+				}
+			}
+		}
+		if s.errs[i] != nil && !dest.Continue {
+			errs = append(errs, s.errs[i])
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package recorder
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMultiSink(t *testing.T) {
+	writeErr := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		keepGoing bool
+		wantErr   bool
+	}{
+		{"continue", true, false},
+		{"abort", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := &memorySink{}
+			healthy := &memorySink{}
+			sink := NewMultiSink(
+				Destination{Name: "tcp://logger:5170", Sink: failing, Continue: tt.keepGoing},
+				Destination{Name: "local.jsonl", Sink: healthy},
+			)
+
+			if _, err := sink.Write([]byte("one\n")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			// A failure is sticky, but does not keep records from the other
+			failing.err = writeErr
+			for _, line := range []string{"two\n", "three\n"} {
+				_, err := sink.Write([]byte(line))
+				if gotErr := errors.Is(err, writeErr); gotErr != tt.wantErr {
+					t.Errorf("Write(%q) error = %v, want error %v", line, err, tt.wantErr)
+				}
+			}
+			failing.err = nil
+			if err := sink.Flush(); (err != nil) != tt.wantErr {
+				t.Errorf("Flush() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err := sink.Close(); (err != nil) != tt.wantErr {
+				t.Errorf("Close() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if len(healthy.writes) != 3 || healthy.flushes != 1 || !healthy.closed {
+				t.Errorf("healthy destination: %d writes, %d flushes, closed %v; want 3, 1, true", len(healthy.writes), healthy.flushes, healthy.closed)
+			}
+			if len(failing.writes) != 1 || failing.flushes != 0 || !failing.closed {
+				t.Errorf("failed destination: %d writes, %d flushes, closed %v; want 1, 0, true", len(failing.writes), failing.flushes, failing.closed)
+			}
+		})
+	}
+}
+
+func TestRecorder_MultiSinkFailure(t *testing.T) {
+	remote := &memorySink{err: errors.New("broken pipe")}
+	local := &memorySink{}
+	rec, err := NewRecorder(NewMultiSink(
+		Destination{Name: "tcp://logger:5170", Sink: remote, Continue: true},
+		Destination{Name: "local.jsonl", Sink: local},
+	), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("one\ntwo\n")); err != nil {
+		t.Errorf("expected no error from the remote failure, got %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	if len(local.writes) != 2 {
+		t.Errorf("expected both records in the local destination, got %d", len(local.writes))
+	}
+	if stats := rec.Stats(); stats.Errors != 0 {
+		t.Errorf("expected no recording errors, got %d", stats.Errors)
+	}
+}
+
+func TestMultiSink_KeepOpen(t *testing.T) {
+	owned := &memorySink{}
+	kept := &memorySink{}
+	sink := NewMultiSink(Destination{Sink: owned}, Destination{Sink: kept, KeepOpen: true})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !owned.closed {
+		t.Error("expected the destination to be closed")
+	}
+	if kept.closed || kept.flushes != 1 {
+		t.Errorf("destination with KeepOpen: %d flushes, closed %v; want 1, false", kept.flushes, kept.closed)
+	}
+}
@@ -0,0 +1,383 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// OTLPSinkOptions configures an OTLP sink.
+type OTLPSinkOptions struct {
+	BatchSize     int           // Log records per export request (0 = 512)
+	QueueSize     int           // Log records waiting for export before new ones are dropped (0 = 2048)
+	FlushInterval time.Duration // Export a partial batch after this long (0 = 1s)
+	Traceparent   string        // W3C trace context of the log records, e.g. $TRACEPARENT (empty = none)
+	Client        *http.Client  // Client for export requests (nil = a client with a 10s timeout)
+	Retry         *RetryOptions // Retry a failed export request with backoff (nil = drop its records)
+}
+
+// OTLP sink defaults.
+const (
+	defaultOTLPBatchSize     = 512
+	defaultOTLPQueueSize     = 2048
+	defaultOTLPFlushInterval = time.Second
+	otlpShutdownTimeout      = 5 * time.Second
+)
+
+// otlpSink is a Sink that exports records as OpenTelemetry log records.
+type otlpSink struct {
+	url      string
+	client   *http.Client
+	traceID  string
+	spanID   string
+	batch    int
+	interval time.Duration
+	retry    *RetryOptions
+
+	queue chan otlpLogRecord // log records waiting for export
+	flush chan struct{}      // requests an export of the pending records
+	stop  chan struct{}      // closed by Close to stop retrying
+	done  chan struct{}      // closed when the export goroutine exits
+
+	mu      sync.Mutex
+	partial []byte // incomplete line of the last Write
+	closed  bool
+	err     error // last export error
+
+	dropped atomic.Uint64
+}
+
+// NewOTLPSink returns a Sink that exports each record as an OpenTelemetry
+// log record to the OTLP/HTTP endpoint (e.g. "http://localhost:4318"), using
+// the JSON encoding of the logs signal at <endpoint>/v1/logs. An endpoint
+// without a scheme, e.g. "collector:4318", is taken as plain HTTP.
+//
+// The content is the body, the source determines the severity (Debug for
+// stdin, Warn for stderr, Info for the rest), and seq, source and truncated
+// are attributes. With opts.Traceparent, the log records belong to that
+// trace, so they show up in the trace of e.g. a CI job.
+//
+// Records are exported in batches by a background goroutine, so recording
+// never waits for the collector: when the queue is full, records are dropped
+// and counted. With opts.Retry, a failed export request is sent again with
+// backoff while the queue fills up. Flush requests an export without
+// waiting for it. Close stops retrying and exports the remaining records,
+// waiting up to 5 seconds.
+//
+// Unlike other sinks, it accepts records split across several writes, so it
+// can also be used as a plain io.Writer behind a buffer.
+func NewOTLPSink(endpoint string, opts OTLPSinkOptions) Sink {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultOTLPBatchSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultOTLPQueueSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultOTLPFlushInterval
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	s := &otlpSink{
+		url:      otlpLogsURL(endpoint),
+		client:   opts.Client,
+		batch:    opts.BatchSize,
+		interval: opts.FlushInterval,
+		retry:    opts.Retry,
+		queue:    make(chan otlpLogRecord, opts.QueueSize),
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.traceID, s.spanID, _ = ParseTraceparent(opts.Traceparent)
+	go s.export()
+	return s
+}
+
+// otlpLogsURL returns the URL of the logs signal of an OTLP/HTTP endpoint.
+func otlpLogsURL(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v1/logs"
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+// ("00-<trace-id>-<parent-id>-<flags>") and returns the trace ID and the
+// parent span ID in hex.
+func ParseTraceparent(value string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	for _, part := range parts[:4] {
+		if _, err := hex.DecodeString(part); err != nil || strings.ToLower(part) != part {
+			return "", "", false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// Write implements Sink.
+func (s *otlpSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	data := append(s.partial, p...)
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			break
+		}
+		s.enqueue(data[:idx])
+		data = data[idx+1:]
+	}
+	s.partial = bytes.Clone(data)
+	return len(p), nil
+}
+
+// enqueue converts an NDJSON line to a log record and queues it for export
+// unless the queue is full.
+func (s *otlpSink) enqueue(line []byte) {
+	var record Record
+	if err := json.Unmarshal(line, &record); err != nil || record.Source == "" {
+		// Not a record, e.g. a JSON Schema header
+		return
+	}
+
+	select {
+	case s.queue <- s.logRecord(record):
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Flush implements Sink. It does not wait for the export.
+func (s *otlpSink) Flush() error {
+	select {
+	case s.flush <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (s *otlpSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	close(s.stop)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(otlpShutdownTimeout):
+		return fmt.Errorf("failed to export records: timed out after %v", otlpShutdownTimeout)
+	}
+
+	if dropped := s.dropped.Load(); dropped > 0 {
+		logging.Warnf("dropped %d records while the OTLP endpoint was busy", dropped)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// export runs in the background and exports the queued log records in
+// batches until the queue is closed.
+func (s *otlpSink) export() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var pending []otlpLogRecord
+	send := func() {
+		if len(pending) == 0 {
+			return
+		}
+		err := retry(s.retry, s.url, s.stop, func() error {
+			return s.post(pending)
+		})
+		pending = nil
+
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		if err != nil {
+			logging.Warnf("%v", err)
+		}
+	}
+
+	for {
+		select {
+		case logRecord, ok := <-s.queue:
+			if !ok {
+				send()
+				return
+			}
+			pending = append(pending, logRecord)
+			if len(pending) >= s.batch {
+				send()
+			}
+		case <-s.flush:
+			// Take what is queued already, so that a flush covers it
+			for n := len(s.queue); n > 0; n-- {
+				logRecord, ok := <-s.queue
+				if !ok {
+					break
+				}
+				pending = append(pending, logRecord)
+			}
+			send()
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+// post exports logRecords in a single request.
+func (s *otlpSink) post(logRecords []otlpLogRecord) error {
+	body, err := json.Marshal(otlpExportRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			Resource: otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", "ioetap")}},
+			ScopeLogs: []otlpScopeLogs{{
+				Scope:      otlpScope{Name: "github.com/trustin/ioetap"},
+				LogRecords: logRecords,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize records: %w", err)
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to export records: %w", err)
+	}
+	// Drain the body so that the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to export records: %s", resp.Status)
+	}
+	return nil
+}
+
+// logRecord converts a record to an OTLP log record.
+func (s *otlpSink) logRecord(record Record) otlpLogRecord {
+	t, err := record.Time()
+	if err != nil {
+		t = time.Now()
+	}
+	severityNumber, severityText := otlpSeverity(record.Source)
+
+	logRecord := otlpLogRecord{
+		TimeUnixNano:   strconv.FormatInt(t.UnixNano(), 10),
+		SeverityNumber: severityNumber,
+		SeverityText:   severityText,
+		Body:           otlpValue{StringValue: ptr(record.ContentString())},
+		Attributes: []otlpAttribute{
+			{Key: "ioetap.seq", Value: otlpValue{IntValue: ptr(strconv.FormatUint(record.Seq, 10))}},
+			stringAttribute("ioetap.source", record.Source),
+			{Key: "ioetap.truncated", Value: otlpValue{BoolValue: ptr(record.Truncated)}},
+		},
+		TraceID: s.traceID,
+		SpanID:  s.spanID,
+	}
+	keys := make([]string, 0, len(record.Fields))
+	for k := range record.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		logRecord.Attributes = append(logRecord.Attributes, stringAttribute(k, record.Fields[k]))
+	}
+	return logRecord
+}
+
+// otlpSeverity returns the OTLP severity of records from the given source,
+// matching SlogLevel.
+func otlpSeverity(source string) (number int, text string) {
+	switch SlogLevel(source) {
+	case slog.LevelDebug:
+		return 5, "DEBUG"
+	case slog.LevelWarn:
+		return 13, "WARN"
+	default:
+		return 9, "INFO"
+	}
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: ptr(value)}}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// The OTLP/JSON encoding of an ExportLogsServiceRequest, limited to the
+// fields the sink uses.
+type (
+	otlpExportRequest struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		TimeUnixNano   string          `json:"timeUnixNano"`
+		SeverityNumber int             `json:"severityNumber"`
+		SeverityText   string          `json:"severityText"`
+		Body           otlpValue       `json:"body"`
+		Attributes     []otlpAttribute `json:"attributes"`
+		TraceID        string          `json:"traceId,omitempty"`
+		SpanID         string          `json:"spanId,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"` // int64 as a decimal string, as in OTLP/JSON
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
@@ -0,0 +1,234 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otlpCollector is a test OTLP/HTTP endpoint that keeps the log records
+// exported to it.
+type otlpCollector struct {
+	*httptest.Server
+	mu         sync.Mutex
+	logRecords []otlpLogRecord
+	resource   []otlpAttribute
+	block      chan struct{} // if set, requests wait until it is closed
+}
+
+func newOTLPCollector(t *testing.T) *otlpCollector {
+	c := &otlpCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if c.block != nil {
+			<-c.block
+		}
+		if req.URL.Path != "/v1/logs" || req.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var exportReq otlpExportRequest
+		if err := json.NewDecoder(req.Body).Decode(&exportReq); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, resourceLogs := range exportReq.ResourceLogs {
+			c.resource = resourceLogs.Resource.Attributes
+			for _, scopeLogs := range resourceLogs.ScopeLogs {
+				c.logRecords = append(c.logRecords, scopeLogs.LogRecords...)
+			}
+		}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// exported returns the number of log records exported so far.
+func (c *otlpCollector) exported() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.logRecords)
+}
+
+// otlpAttributes returns the attributes of a log record by key, as strings.
+func otlpAttributes(logRecord otlpLogRecord) map[string]string {
+	values := map[string]string{}
+	for _, attr := range logRecord.Attributes {
+		switch {
+		case attr.Value.StringValue != nil:
+			values[attr.Key] = *attr.Value.StringValue
+		case attr.Value.IntValue != nil:
+			values[attr.Key] = *attr.Value.IntValue
+		case attr.Value.BoolValue != nil && *attr.Value.BoolValue:
+			values[attr.Key] = "true"
+		case attr.Value.BoolValue != nil:
+			values[attr.Key] = "false"
+		}
+	}
+	return values
+}
+
+func TestOTLPSink(t *testing.T) {
+	collector := newOTLPCollector(t)
+	sink := NewOTLPSink(collector.URL, OTLPSinkOptions{
+		BatchSize:   2,
+		Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	rec, err := NewRecorder(sink, 4, WithHeader([]byte(`{"$schema":"x"}`)), WithFields(map[string]string{"job": "build"}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	rec.Record(Stdin, []byte("in\n"))
+	rec.Record(Stdout, []byte("out\n"))
+
+	// A full batch is exported without a flush
+	deadline := time.Now().Add(5 * time.Second)
+	for collector.exported() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("a full batch was not exported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rec.Record(Stderr, []byte("too long\n"))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// Close exports everything before returning; the header is not a record
+	want := []struct {
+		severity  int
+		body      string
+		source    string
+		truncated string
+	}{
+		{5, "in", "stdin", "false"},
+		{9, "out", "stdout", "false"},
+		{13, "too ", "stderr", "true"},
+	}
+	if len(collector.logRecords) != len(want) {
+		t.Fatalf("expected %d log records, got %d", len(want), len(collector.logRecords))
+	}
+	for i, logRecord := range collector.logRecords {
+		w := want[i]
+		values := otlpAttributes(logRecord)
+		if logRecord.SeverityNumber != w.severity || logRecord.Body.StringValue == nil || *logRecord.Body.StringValue != w.body {
+			t.Errorf("log record %d: expected severity %d with %q, got %+v", i, w.severity, w.body, logRecord)
+		}
+		if values["ioetap.seq"] != string(rune('0'+i)) || values["ioetap.source"] != w.source || values["ioetap.truncated"] != w.truncated || values["job"] != "build" {
+			t.Errorf("log record %d: unexpected attributes %v", i, values)
+		}
+		if logRecord.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || logRecord.SpanID != "00f067aa0ba902b7" {
+			t.Errorf("log record %d: unexpected trace context %s/%s", i, logRecord.TraceID, logRecord.SpanID)
+		}
+		if logRecord.TimeUnixNano == "" || logRecord.TimeUnixNano == "0" {
+			t.Errorf("log record %d: expected a timestamp", i)
+		}
+	}
+	if len(collector.resource) != 1 || collector.resource[0].Key != "service.name" {
+		t.Errorf("unexpected resource attributes %+v", collector.resource)
+	}
+}
+
+func TestOTLPSink_SplitWrites(t *testing.T) {
+	collector := newOTLPCollector(t)
+	sink := NewOTLPSink(collector.URL, OTLPSinkOptions{})
+
+	line := `{"seq":7,"timestamp":"2024-01-15T10:30:45.123Z","source":"stdout","content":"x","encoding":"text"}` + "\n"
+	for _, part := range []string{line[:10], line[10:50], line[50:] + line[:20], line[20:]} {
+		if _, err := sink.Write([]byte(part)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+
+	if len(collector.logRecords) != 2 || otlpAttributes(collector.logRecords[1])["ioetap.seq"] != "7" {
+		t.Errorf("expected 2 log records with seq 7, got %+v", collector.logRecords)
+	}
+}
+
+func TestOTLPSink_DoesNotBlock(t *testing.T) {
+	collector := newOTLPCollector(t)
+	collector.block = make(chan struct{})
+	sink := NewOTLPSink(collector.URL, OTLPSinkOptions{BatchSize: 1, QueueSize: 4})
+	rec, err := NewRecorder(sink, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// The collector does not answer, but recording goes on
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := rec.Record(Stdout, []byte("line\n")); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+		if err := rec.FlushAll(); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("recording waited for the collector for %v", elapsed)
+	}
+
+	close(collector.block)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	if dropped := sink.(*otlpSink).dropped.Load(); dropped == 0 || len(collector.logRecords)+int(dropped) != 100 {
+		t.Errorf("expected the records to be exported or dropped: %d exported, %d dropped", len(collector.logRecords), dropped)
+	}
+}
+
+func TestOTLPLogsURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/logs"},
+		{"https://otel.example.com/", "https://otel.example.com/v1/logs"},
+		{"collector:4318", "http://collector:4318/v1/logs"},
+	}
+
+	for _, tt := range tests {
+		if got := otlpLogsURL(tt.endpoint); got != tt.want {
+			t.Errorf("otlpLogsURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value  string
+		wantOK bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future", true},
+		{"", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			traceID, spanID, ok := ParseTraceparent(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7") {
+				t.Errorf("unexpected IDs %s/%s", traceID, spanID)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package recorder
+
+import (
+	"bytes"
+	"time"
+)
+
+// Pause stops recording I/O until Resume, e.g. during a phase whose output
+// is not worth keeping. While paused, Record only counts the bytes and lines
+// it gets, and CopyAndRecord still forwards all data. Incomplete lines
+// buffered so far are written first, and a "pause-recording" meta record
+// marks where the gap starts. Meta, resource and annotation records are
+// still recorded. Pausing a paused Recorder does nothing.
+// This method is thread-safe.
+func (r *Recorder) Pause() error {
+	now := r.now()
+	return r.run(func() error {
+		if r.paused.Load() {
+			return nil
+		}
+		for source := range r.buffers {
+			if err := r.flushLocked(now, Source(source)); err != nil {
+				return err
+			}
+		}
+		r.paused.Store(true)
+		r.pausedAt = now
+		r.pauseBytes = [3]uint64{}
+		r.pauseLines = [3]uint64{}
+		return r.writeNext(now, func(seq uint64) Record {
+			return NewMetaRecord(seq, now, map[string]any{"event": "pause-recording"})
+		})
+	})
+}
+
+// Resume starts recording I/O again after Pause, with a "resume-recording"
+// meta record that summarizes the gap: when it started, how long it lasted
+// and the lines and bytes of each source that were not recorded. Data that
+// arrives after Resume in the middle of a line is recorded from there on.
+// Resuming a Recorder that is not paused does nothing.
+// This method is thread-safe.
+func (r *Recorder) Resume() error {
+	now := r.now()
+	return r.run(func() error {
+		return r.resumeLocked(now)
+	})
+}
+
+// Paused reports whether recording is paused (see Pause).
+// This method is thread-safe.
+func (r *Recorder) Paused() bool {
+	return r.paused.Load()
+}
+
+// resumeLocked resumes recording if it is paused. Must be called with mu held.
+func (r *Recorder) resumeLocked(now time.Time) error {
+	if !r.paused.Load() {
+		return nil
+	}
+	r.paused.Store(false)
+
+	skipped := make(map[string]any, len(r.pauseBytes))
+	for source := range r.pauseBytes {
+		skipped[Source(source).String()] = map[string]any{
+			"lines": r.pauseLines[source],
+			"bytes": r.pauseBytes[source],
+		}
+	}
+	content := map[string]any{
+		"event":     "resume-recording",
+		"paused_at": r.pausedAt.UTC().Format(timestampFormat),
+		"paused_ms": max(now.Sub(r.pausedAt).Milliseconds(), 0),
+		"skipped":   skipped,
+	}
+	return r.writeNext(now, func(seq uint64) Record {
+		return NewMetaRecord(seq, now, content)
+	})
+}
+
+// skipLocked counts data of source that is not recorded because recording
+// is paused. Every newline ends a line, or in ChunkMode, every chunk is one.
+// Must be called with mu held.
+func (r *Recorder) skipLocked(source Source, data []byte) {
+	lines := uint64(bytes.Count(data, []byte("\n")))
+	if r.sourceMode[source] == ChunkMode && len(data) > 0 {
+		lines = 1
+	}
+	r.pauseBytes[source] += uint64(len(data))
+	r.pauseLines[source] += lines
+	r.skippedBytes[source] += uint64(len(data))
+	r.skippedLines[source] += lines
+}
@@ -0,0 +1,118 @@
+package recorder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorder_PauseResume(t *testing.T) {
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	now := base
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// The incomplete line is written when recording pauses
+	_ = rec.Record(Stdout, []byte("before\npartial"))
+	if err := rec.Pause(); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if err := rec.Pause(); err != nil {
+		t.Fatalf("second Pause() error = %v", err)
+	}
+	if !rec.Paused() {
+		t.Error("Paused() = false after Pause")
+	}
+	_ = rec.Record(Stdout, []byte("skipped 1\nskipped 2\n"))
+	_ = rec.Record(Stderr, []byte("skipped 3\n"))
+	_ = rec.RecordMeta("note", nil)
+
+	now = base.Add(1500 * time.Millisecond)
+	stats := rec.Stats()
+	if !stats.Paused || stats.SkippedBytes[Stdout] != 20 || stats.SkippedLines[Stdout] != 2 || stats.SkippedLines[Stderr] != 1 {
+		t.Errorf("unexpected stats while paused: %+v", stats)
+	}
+	if err := rec.Resume(); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if rec.Paused() {
+		t.Error("Paused() = true after Resume")
+	}
+	_ = rec.Record(Stdout, []byte("after\n"))
+	rec.Close()
+
+	records := sinkRecords(t, sink)
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Source+":"+r.ContentString())
+	}
+	want := []string{
+		`stdout:before`,
+		`stdout:partial`,
+		`meta:{"event":"pause-recording"}`,
+		`meta:{"event":"note"}`,
+		`meta:{"event":"resume-recording","paused_at":"2024-01-15T10:30:00.000Z","paused_ms":1500,` +
+			`"skipped":{"stderr":{"bytes":10,"lines":1},"stdin":{"bytes":0,"lines":0},"stdout":{"bytes":20,"lines":2}}}`,
+		`stdout:after`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected records %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d: expected %s, got %s", i, want[i], got[i])
+		}
+	}
+
+	// Skipped data is not counted as recorded
+	if stats := rec.Stats(); stats.Bytes[Stdout] != uint64(len("before\npartialafter\n")) || stats.Paused {
+		t.Errorf("unexpected stats after resume: %+v", stats)
+	}
+}
+
+func TestRecorder_CloseWhilePaused(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithQueue(0))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	_ = rec.Pause()
+	_ = rec.Record(Stdout, []byte("skipped\n"))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The gap is summarized even though recording was never resumed
+	records := sinkRecords(t, sink)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 meta records, got %d", len(records))
+	}
+	var content map[string]any
+	if err := records[1].JSON(&content); err != nil {
+		t.Fatalf("failed to parse content: %v", err)
+	}
+	skipped, _ := content["skipped"].(map[string]any)
+	stdout, _ := skipped["stdout"].(map[string]any)
+	if content["event"] != "resume-recording" || stdout["lines"] != 1.0 || stdout["bytes"] != 8.0 {
+		t.Errorf("unexpected summary %v", content)
+	}
+}
+
+func TestRecorder_PauseChunkMode(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithSourceMode(Stdin, ChunkMode))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	_ = rec.Pause()
+	_ = rec.Record(Stdin, []byte("\x00\x01"))
+	_ = rec.Record(Stdin, []byte("a\nb\n"))
+
+	// Every chunk counts as a line
+	if stats := rec.Stats(); stats.SkippedLines[Stdin] != 2 || stats.SkippedBytes[Stdin] != 6 {
+		t.Errorf("expected 2 lines and 6 bytes skipped, got %+v", stats)
+	}
+	rec.Close()
+}
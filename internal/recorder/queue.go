@@ -0,0 +1,108 @@
+package recorder
+
+import "github.com/trustin/ioetap/internal/logging"
+
+// DefaultQueueSize is the default number of pending operations in queued mode.
+const DefaultQueueSize = 1024
+
+// queuedOp is an operation processed by the writer goroutine in queued mode.
+type queuedOp struct {
+	fn   func() error // run with mu held
+	done chan error   // receives the result of fn (nil = fire and forget)
+}
+
+// WithQueue enables queued mode, where a single writer goroutine owns the
+// recording file and all sources hand their data to it through a buffered
+// channel of the given size. Record only copies the data and sends it, so
+// producers never contend on the Recorder's mutex. Sequence numbers are
+// assigned by the writer goroutine in the order operations are received.
+//
+// In queued mode, errors from Record are not returned to the caller but
+// reported on stderr by the writer goroutine, except ErrClosed once Close
+// was called. A size <= 0 uses DefaultQueueSize.
+func WithQueue(size int) Option {
+	return func(r *Recorder) {
+		if size <= 0 {
+			size = DefaultQueueSize
+		}
+		r.queueSize = size
+	}
+}
+
+// startQueue starts the writer goroutine.
+func (r *Recorder) startQueue() {
+	r.queue = make(chan queuedOp, r.queueSize)
+	r.queueDone = make(chan struct{})
+
+	go func() {
+		defer close(r.queueDone)
+
+		for op := range r.queue {
+			// mu is uncontended here; it is only held to keep direct callers
+			// such as Close safe.
+			r.mu.Lock()
+			err := op.fn()
+			r.mu.Unlock()
+
+			if op.done != nil {
+				op.done <- err
+			} else if err != nil {
+				logging.Warnf("recording error: %v", err)
+			}
+		}
+	}()
+}
+
+// stopQueue processes all pending operations and stops the writer goroutine.
+// Operations sent after it was called fail with ErrClosed. It does nothing
+// in direct mode.
+func (r *Recorder) stopQueue() {
+	if r.queue == nil {
+		return
+	}
+	// Senders hold queueMu for reading, so none is sending when the queue is
+	// closed
+	r.queueMu.Lock()
+	if !r.queueClosed {
+		r.queueClosed = true
+		close(r.queue)
+	}
+	r.queueMu.Unlock()
+	<-r.queueDone
+}
+
+// send hands op to the writer goroutine, or returns ErrClosed if the queue
+// was stopped.
+func (r *Recorder) send(op queuedOp) error {
+	r.queueMu.RLock()
+	defer r.queueMu.RUnlock()
+	if r.queueClosed {
+		return ErrClosed
+	}
+	r.queue <- op
+	return nil
+}
+
+// enqueue hands fn to the writer goroutine without waiting for its result.
+func (r *Recorder) enqueue(fn func() error) error {
+	return r.send(queuedOp{fn: fn})
+}
+
+// run runs fn with mu held and returns its result. In queued mode, fn runs
+// on the writer goroutine after all previously queued operations.
+func (r *Recorder) run(fn func() error) error {
+	if r.queue == nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.closed {
+			return ErrClosed
+		}
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	if err := r.send(queuedOp{fn: fn, done: done}); err != nil {
+		return err
+	}
+	return <-done
+}
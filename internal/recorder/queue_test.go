@@ -0,0 +1,215 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRecorder_QueuedConcurrentRecording(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, WithQueue(16))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// Each source writes numbered lines, split across Record calls
+	sources := []Source{Stdin, Stdout, Stderr}
+	linesPerSource := 200
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			buf := make([]byte, 0, 64)
+			for i := 0; i < linesPerSource; i++ {
+				// Reuse the buffer to make sure Record copies the data
+				buf = append(buf[:0], fmt.Sprintf("%s %d", source, i)...)
+				if err := rec.Record(source, buf); err != nil {
+					t.Errorf("failed to record: %v", err)
+				}
+				buf = append(buf[:0], '\n')
+				if err := rec.Record(source, buf); err != nil {
+					t.Errorf("failed to record: %v", err)
+				}
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != len(sources)*linesPerSource {
+		t.Fatalf("expected %d records, got %d", len(sources)*linesPerSource, len(lines))
+	}
+
+	next := make(map[string]int)
+	for i, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+
+		// Seq is assigned by the writer goroutine in file order
+		if record.Seq != uint64(i) {
+			t.Errorf("expected seq %d, got %d", i, record.Seq)
+		}
+
+		// Lines of each source keep their order
+		expected := fmt.Sprintf("%s %d", record.Source, next[record.Source])
+		if record.ContentString() != expected {
+			t.Errorf("expected content %q, got %q", expected, record.ContentString())
+		}
+		next[record.Source]++
+	}
+}
+
+func TestRecorder_QueuedFlushAndMeta(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, WithQueue(0))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	if err := rec.Record(Stdout, []byte("partial")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.RecordMeta("mark", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+
+	// FlushAll waits for queued operations, so everything is on disk after it
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("FlushAll failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d: %q", len(lines), content)
+	}
+
+	var meta, partial Record
+	if err := json.Unmarshal(lines[0], &meta); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if err := json.Unmarshal(lines[1], &partial); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+
+	if meta.Source != MetaSource || meta.Seq != 0 {
+		t.Errorf("expected meta record with seq 0, got %+v", meta)
+	}
+	if partial.ContentString() != "partial" || partial.Seq != 1 {
+		t.Errorf("expected flushed partial line with seq 1, got %+v", partial)
+	}
+}
+
+// benchmarkConcurrentRecording records complete lines from all three sources
+// concurrently, simulating a child with busy stdout/stderr and stdin.
+func TestRecorder_QueuedRecordDuringClose(t *testing.T) {
+	// Run with -race: calls racing Close must neither panic on the closed
+	// queue nor race on it, and those after Close fail with ErrClosed
+	for i := 0; i < 20; i++ {
+		rec, err := NewRecorder(NewWriterSink(io.Discard), 0, WithQueue(4))
+		if err != nil {
+			t.Fatalf("failed to create recorder: %v", err)
+		}
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, source := range []Source{Stdin, Stdout, Stderr} {
+			wg.Add(1)
+			go func(source Source) {
+				defer wg.Done()
+				<-start
+				for j := 0; j < 100; j++ {
+					if err := rec.Record(source, []byte("line\n")); err != nil && !errors.Is(err, ErrClosed) {
+						t.Errorf("unexpected error %v", err)
+					}
+					if err := rec.FlushAll(); err != nil && !errors.Is(err, ErrClosed) {
+						t.Errorf("unexpected error %v", err)
+					}
+				}
+			}(source)
+		}
+		close(start)
+		if err := rec.Close(); err != nil {
+			t.Fatalf("failed to close recorder: %v", err)
+		}
+		wg.Wait()
+
+		if err := rec.Record(Stdout, []byte("late\n")); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed after Close, got %v", err)
+		}
+		if err := rec.RecordMeta("late", nil); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed after Close, got %v", err)
+		}
+	}
+}
+
+func benchmarkConcurrentRecording(b *testing.B, opts ...Option) {
+	filename := filepath.Join(b.TempDir(), "bench.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, opts...)
+	if err != nil {
+		b.Fatalf("failed to create recorder: %v", err)
+	}
+
+	line := []byte("the quick brown fox jumps over the lazy dog\n")
+	sources := []Source{Stdin, Stdout, Stderr}
+
+	var mu sync.Mutex
+	next := 0
+
+	b.SetBytes(int64(len(line)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		source := sources[next%len(sources)]
+		next++
+		mu.Unlock()
+
+		for pb.Next() {
+			if err := rec.Record(source, line); err != nil {
+				b.Errorf("failed to record: %v", err)
+			}
+		}
+	})
+
+	if err := rec.Close(); err != nil {
+		b.Fatalf("failed to close recorder: %v", err)
+	}
+}
+
+func BenchmarkRecorder_Mutex(b *testing.B) {
+	benchmarkConcurrentRecording(b)
+}
+
+func BenchmarkRecorder_Queue(b *testing.B) {
+	benchmarkConcurrentRecording(b, WithQueue(DefaultQueueSize))
+}
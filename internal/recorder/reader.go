@@ -0,0 +1,39 @@
+package recorder
+
+import (
+	"errors"
+	"io"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// teeReader is the io.Reader returned by Recorder.TeeReader.
+type teeReader struct {
+	recorder *Recorder
+	source   Source
+	reader   io.Reader
+}
+
+// TeeReader returns an io.Reader that reads from reader and records
+// everything read as data from source, for read loops the caller drives
+// instead of CopyAndRecord. When reader returns io.EOF, any incomplete line
+// of source is flushed. Errors from reader are returned unchanged; recording
+// errors are logged like in CopyAndRecord.
+func (r *Recorder) TeeReader(source Source, reader io.Reader) io.Reader {
+	return &teeReader{recorder: r, source: source, reader: reader}
+}
+
+// Read implements io.Reader.
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		t.recorder.recordRead(t.source, n)
+		t.recorder.recordChunk(t.source, p[:n], false)
+	}
+	if err == io.EOF {
+		if flushErr := t.recorder.Flush(t.source); flushErr != nil && !errors.Is(flushErr, ErrClosed) {
+			logging.Warnf("flush error: %v", flushErr)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// recordedContents returns the source and content of the records in an
+// NDJSON recording.
+func recordedContents(t *testing.T, recording []byte) []string {
+	t.Helper()
+	var contents []string
+	for _, line := range bytes.Split(bytes.TrimSpace(recording), []byte("\n")) {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		contents = append(contents, record.Source+":"+record.ContentString()+":"+record.End)
+	}
+	return contents
+}
+
+func TestRecorder_TeeReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		reader func(string) io.Reader
+	}{
+		{"one read", func(s string) io.Reader { return strings.NewReader(s) }},
+		{"fragmented reads", func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) }},
+		{"data with EOF", func(s string) io.Reader { return iotest.DataErrReader(strings.NewReader(s)) }},
+		{"fragmented data with EOF", func(s string) io.Reader {
+			return iotest.DataErrReader(iotest.HalfReader(strings.NewReader(s)))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			rec, err := NewRecorder(sink, 0)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+
+			input := "first\nsecond\r\npartial"
+			data, err := io.ReadAll(rec.TeeReader(Stdin, tt.reader(input)))
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if string(data) != input {
+				t.Errorf("expected %q, got %q", input, data)
+			}
+
+			// The incomplete line is flushed at EOF, before Close
+			want := []string{"stdin:first:\n", "stdin:second:\r\n", "stdin:partial:"}
+			if got := recordedContents(t, bytes.Join(sink.writes, nil)); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("expected records %q, got %q", want, got)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecorder_TeeReaderError(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	readErr := errors.New("connection reset")
+	reader := rec.TeeReader(Stdout, io.MultiReader(strings.NewReader("line\npart"), iotest.ErrReader(readErr)))
+	data, err := io.ReadAll(reader)
+	if err != readErr {
+		t.Errorf("expected the reader error unchanged, got %v", err)
+	}
+	if string(data) != "line\npart" {
+		t.Errorf("unexpected data %q", data)
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	// Data read before the error is recorded, and the incomplete line is
+	// kept until flushed
+	want := []string{"stdout:line:\n", "stdout:part:"}
+	if got := recordedContents(t, buf.Bytes()); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected records %q, got %q", want, got)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+}
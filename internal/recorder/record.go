@@ -4,29 +4,233 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 // Record represents a single I/O record in the recording file.
 type Record struct {
-	Seq       uint64 `json:"seq"`       // Sequence number, starts from 0
-	Timestamp string `json:"timestamp"` // UTC timestamp with ms precision
-	Source    string `json:"source"`    // "stdin", "stdout", or "stderr"
-	Content   any    `json:"-"`         // Content value (varies by encoding)
-	Encoding  string `json:"encoding"`  // "text", "base64", or "json"
-	End       string `json:"-"`         // Trailing CR/LF for text encoding (omitted if empty)
-	Truncated bool   `json:"-"`         // true if line was truncated due to max length
+	Seq       uint64            `json:"seq"`       // Sequence number, starts from 0
+	Timestamp string            `json:"timestamp"` // UTC timestamp with ms precision
+	Source    string            `json:"source"`    // "stdin", "stdout", "stderr", "meta", "stats", "resource" or "annotation"
+	Content   any               `json:"-"`         // Content value (varies by encoding)
+	Encoding  string            `json:"encoding"`  // "text", "base64", or "json"
+	End       string            `json:"-"`         // Trailing CR/LF, never part of Content (omitted if empty)
+	Len       int               `json:"-"`         // Bytes of the line the record was made from, content plus End, including those cut by truncation (omitted if 0)
+	Truncated bool              `json:"-"`         // true if line was truncated due to max length
+	Lossy     bool              `json:"-"`         // true if invalid UTF-8 in text content was replaced with U+FFFD (see NewLossyRecord)
+	Continued bool              `json:"-"`         // true if the record continues the line of the previous one (see WithSplitLongLines)
+	Burst     bool              `json:"-"`         // true if data was read back-to-back (see WithBurstDetection)
+	DeltaMS   *int64            `json:"-"`         // Milliseconds since the previous record of the same source (nil = omitted, see WithDeltas)
+	Fields    map[string]string `json:"-"`         // Custom fields (see WithFields)
+	ChainHash string            `json:"-"`         // Hex SHA-256 linking to the previous record (see WithChainHash)
 }
 
+// FormatVersion is the version of the record format described by
+// RecordFields. It changes only when a field changes incompatibly; new
+// optional fields do not change it.
+const FormatVersion = 1
+
+// RecordField describes a built-in field of a serialized record.
+type RecordField struct {
+	Name     string         // JSON name of the field
+	Required bool           // true if always written, false if omitted when empty
+	Schema   map[string]any // JSON Schema (draft-07) of the field's value
+
+	value func(r Record) (value any, empty bool)
+}
+
+// RecordFields lists the built-in record fields in the order they are
+// written. Record.MarshalJSON, Record.ToSortedJSON, BuiltinFields and the
+// record schema are all derived from it, so that they cannot drift apart.
+var RecordFields = []RecordField{
+	{
+		Name:     "seq",
+		Required: true,
+		Schema: map[string]any{
+			"type":        "integer",
+			"minimum":     0,
+			"description": "Sequence number, starts from 0 and is incremented for each record",
+		},
+		value: func(r Record) (any, bool) { return r.Seq, false },
+	},
+	{
+		Name:     "timestamp",
+		Required: true,
+		Schema: map[string]any{
+			"type":        "string",
+			"format":      "date-time",
+			"pattern":     `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z$`,
+			"description": "UTC timestamp with millisecond precision (ISO 8601 format)",
+			"examples":    []string{"2024-01-15T10:30:45.123Z"},
+		},
+		value: func(r Record) (any, bool) { return r.Timestamp, false },
+	},
+	{
+		Name:     "source",
+		Required: true,
+		Schema: map[string]any{
+			"type":        "string",
+			"enum":        []string{"stdin", "stdout", "stderr", MetaSource, StatsSource, ResourceSource, AnnotationSource},
+			"description": "The I/O source of the recorded data, 'meta' for session events (content is an object with an 'event' field), 'stats' for session statistics (content is an object, e.g. the timing histogram), 'resource' for samples of the child's resource usage, or 'annotation' for notes typed into stdin with --annotate-stdin (content is the text of the note)",
+		},
+		value: func(r Record) (any, bool) { return r.Source, false },
+	},
+	{
+		Name:     "content",
+		Required: true,
+		Schema: map[string]any{
+			"description": "The recorded content. Type depends on the 'encoding' field: string for 'text' and 'base64', any JSON value for 'json'",
+			"examples":    []any{"Hello, World!", map[string]any{"key": "value"}, []int{1, 2, 3}, 42, true, nil},
+		},
+		value: func(r Record) (any, bool) { return r.Content, false },
+	},
+	{
+		Name:     "encoding",
+		Required: true,
+		Schema: map[string]any{
+			"type":        "string",
+			"enum":        []string{"text", "json", "base64"},
+			"description": "Content encoding type. 'json': content is a native JSON value; 'text': content is a UTF-8 string; 'base64': content is base64-encoded binary data",
+		},
+		value: func(r Record) (any, bool) { return r.Encoding, false },
+	},
+	{
+		Name: "end",
+		Schema: map[string]any{
+			"type":        "string",
+			"pattern":     `^(\r?\n|\r)+$`,
+			"description": "Line ending characters (\\n or \\r\\n), for every encoding. Never part of content, so content (decoded, for base64) followed by end is the line. Omitted if the line has no trailing newline (e.g., final incomplete line at EOF)",
+			"examples":    []string{"\n", "\r\n"},
+		},
+		value: func(r Record) (any, bool) { return r.End, r.End == "" },
+	},
+	{
+		Name: "len",
+		Schema: map[string]any{
+			"type":        "integer",
+			"minimum":     1,
+			"description": "Number of bytes of the line the record was made from, i.e. of its raw content followed by end, whatever the encoding. For a truncated record, the length of the whole line, including the bytes that were not kept. Omitted for records without data (see WithEmptyReadMarker) and for meta, stats and resource records",
+		},
+		value: func(r Record) (any, bool) { return r.Len, r.Len == 0 },
+	},
+	{
+		Name: "truncated",
+		Schema: map[string]any{
+			"type":        "boolean",
+			"const":       true,
+			"description": "Present and true only when the line was truncated due to --max-line-length limit. Omitted when not truncated",
+		},
+		value: func(r Record) (any, bool) { return r.Truncated, !r.Truncated },
+	},
+	{
+		Name: "lossy",
+		Schema: map[string]any{
+			"type":        "boolean",
+			"const":       true,
+			"description": "Present and true only with --lossy-text when the line was not valid UTF-8 and is recorded as text with every invalid byte replaced by U+FFFD instead of as base64, so the original bytes are lost. Omitted otherwise",
+		},
+		value: func(r Record) (any, bool) { return r.Lossy, !r.Lossy },
+	},
+	{
+		Name: "continued",
+		Schema: map[string]any{
+			"type":        "boolean",
+			"const":       true,
+			"description": "Present and true only with --long-line-mode=split when the record continues the line of the previous record of the same source, which was split at --max-line-length. Omitted otherwise",
+		},
+		value: func(r Record) (any, bool) { return r.Continued, !r.Continued },
+	},
+	{
+		Name: "burst",
+		Schema: map[string]any{
+			"type":        "boolean",
+			"const":       true,
+			"description": "Present and true only with --mark-bursts when the data was read back-to-back without waiting for the child, so the timestamp reflects when ioetap drained the pipe rather than when the child wrote it. Omitted otherwise",
+		},
+		value: func(r Record) (any, bool) { return r.Burst, !r.Burst },
+	},
+	{
+		Name: "delta_ms",
+		Schema: map[string]any{
+			"type":        "integer",
+			"minimum":     0,
+			"description": "Present only with --deltas: milliseconds since the previous record of the same source, for spotting stalls in the output. Omitted for the first record of each source and otherwise",
+		},
+		value: func(r Record) (any, bool) {
+			if r.DeltaMS == nil {
+				return nil, true
+			}
+			return *r.DeltaMS, false
+		},
+	},
+	{
+		Name: "fields",
+		Schema: map[string]any{
+			"type":                 "object",
+			"additionalProperties": map[string]any{"type": "string"},
+			"description":          "Custom fields given with --field, present on every record. Omitted when no custom fields are given",
+			"examples":             []any{map[string]string{"trace_id": "abc"}},
+		},
+		value: func(r Record) (any, bool) { return r.Fields, len(r.Fields) == 0 },
+	},
+	{
+		Name: "chain_hash",
+		Schema: map[string]any{
+			"type":        "string",
+			"pattern":     "^[0-9a-f]{64}$",
+			"description": "Present only with --record-checksums: hex SHA-256 of the previous record's chain hash (32 zero bytes for the first record), the seq as 8 big-endian bytes and the record as JSON with sorted fields and without chain_hash. Changing, removing or reordering records breaks the chain; see ioetap verify --chain. Omitted otherwise",
+		},
+		value: func(r Record) (any, bool) { return r.ChainHash, r.ChainHash == "" },
+	},
+}
+
+// sortedRecordFields is RecordFields in alphabetical order (see ToSortedJSON).
+var sortedRecordFields = func() []RecordField {
+	fields := slices.Clone(RecordFields)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}()
+
+// BuiltinFields lists the names of the built-in record fields, which custom
+// fields must not use.
+var BuiltinFields = func() []string {
+	names := make([]string, len(RecordFields))
+	for i, field := range RecordFields {
+		names[i] = field.Name
+	}
+	return names
+}()
+
 const timestampFormat = "2006-01-02T15:04:05.000Z"
 
 // NewRecord creates a new Record with automatic encoding detection.
 // Priority: JSON > text > base64
-// For text content, trailing CR/LF is extracted into the End field.
+// For every encoding, trailing CR/LF is extracted into the End field, so the
+// content never contains the line ending and content plus End is the line.
 func NewRecord(seq uint64, timestamp time.Time, source string, data []byte) Record {
+	return newRecord(seq, timestamp, source, data, false)
+}
+
+// NewLossyRecord is like NewRecord, but records data that is not valid UTF-8
+// as text with every invalid byte replaced by U+FFFD and Lossy set, instead
+// of as base64. This keeps the record readable at the cost of the original
+// bytes.
+func NewLossyRecord(seq uint64, timestamp time.Time, source string, data []byte) Record {
+	return newRecord(seq, timestamp, source, data, true)
+}
+
+// newRecord creates a new Record for NewRecord and NewLossyRecord.
+func newRecord(seq uint64, timestamp time.Time, source string, data []byte, lossy bool) Record {
+	content, trailing := splitTrailingCRLF(data)
+
 	// Try JSON first (trim whitespace for lenient parsing)
-	trimmed := bytes.TrimSpace(data)
+	trimmed := bytes.TrimSpace(content)
 	if len(trimmed) > 0 && json.Valid(trimmed) {
 		// json.Valid ensures ENTIRE content is valid JSON (no trailing data)
 		// This rejects: {"a":1}blah, {"a":1}{"b":2}, etc.
@@ -38,13 +242,14 @@ func NewRecord(seq uint64, timestamp time.Time, source string, data []byte) Reco
 				Source:    source,
 				Content:   parsed,
 				Encoding:  "json",
+				End:       string(trailing),
+				Len:       len(data),
 			}
 		}
 	}
 
-	// Then UTF-8 text (extract trailing CR/LF)
-	if utf8.Valid(data) {
-		content, trailing := splitTrailingCRLF(data)
+	// Then UTF-8 text
+	if utf8.Valid(content) {
 		return Record{
 			Seq:       seq,
 			Timestamp: timestamp.UTC().Format(timestampFormat),
@@ -52,6 +257,20 @@ func NewRecord(seq uint64, timestamp time.Time, source string, data []byte) Reco
 			Content:   string(content),
 			Encoding:  "text",
 			End:       string(trailing),
+			Len:       len(data),
+		}
+	}
+
+	if lossy {
+		return Record{
+			Seq:       seq,
+			Timestamp: timestamp.UTC().Format(timestampFormat),
+			Source:    source,
+			Content:   replaceInvalidUTF8(content),
+			Encoding:  "text",
+			End:       string(trailing),
+			Len:       len(data),
+			Lossy:     true,
 		}
 	}
 
@@ -60,8 +279,84 @@ func NewRecord(seq uint64, timestamp time.Time, source string, data []byte) Reco
 		Seq:       seq,
 		Timestamp: timestamp.UTC().Format(timestampFormat),
 		Source:    source,
-		Content:   base64.StdEncoding.EncodeToString(data),
+		Content:   base64.StdEncoding.EncodeToString(content),
 		Encoding:  "base64",
+		End:       string(trailing),
+		Len:       len(data),
+	}
+}
+
+// replaceInvalidUTF8 returns data as a string with every byte that is not
+// part of a valid UTF-8 sequence replaced by U+FFFD.
+func replaceInvalidUTF8(data []byte) string {
+	var b strings.Builder
+	b.Grow(len(data))
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		b.WriteRune(r) // utf8.RuneError for an invalid byte
+		data = data[size:]
+	}
+	return b.String()
+}
+
+// MetaSource is the source name of meta records, which describe events in
+// the recording session (e.g. suspend/resume) rather than I/O data.
+const MetaSource = "meta"
+
+// NewMetaRecord creates a new meta Record with JSON-encoded content.
+func NewMetaRecord(seq uint64, timestamp time.Time, content map[string]any) Record {
+	return Record{
+		Seq:       seq,
+		Timestamp: timestamp.UTC().Format(timestampFormat),
+		Source:    MetaSource,
+		Content:   content,
+		Encoding:  "json",
+	}
+}
+
+// StatsSource is the source name of stats records, which summarize the
+// recording session (e.g. the timing histogram).
+const StatsSource = "stats"
+
+// NewStatsRecord creates a new stats Record with JSON-encoded content.
+func NewStatsRecord(seq uint64, timestamp time.Time, content map[string]any) Record {
+	return Record{
+		Seq:       seq,
+		Timestamp: timestamp.UTC().Format(timestampFormat),
+		Source:    StatsSource,
+		Content:   content,
+		Encoding:  "json",
+	}
+}
+
+// ResourceSource is the source name of resource records, which sample the
+// resource usage of the child (e.g. its CPU time).
+const ResourceSource = "resource"
+
+// NewResourceRecord creates a new resource Record with JSON-encoded content.
+func NewResourceRecord(seq uint64, timestamp time.Time, content map[string]any) Record {
+	return Record{
+		Seq:       seq,
+		Timestamp: timestamp.UTC().Format(timestampFormat),
+		Source:    ResourceSource,
+		Content:   content,
+		Encoding:  "json",
+	}
+}
+
+// AnnotationSource is the source name of annotation records, which hold
+// notes typed into ioetap's stdin instead of data for the child (see
+// Recorder.AnnotationReader).
+const AnnotationSource = "annotation"
+
+// NewAnnotationRecord creates a new annotation Record with the given text.
+func NewAnnotationRecord(seq uint64, timestamp time.Time, text string) Record {
+	return Record{
+		Seq:       seq,
+		Timestamp: timestamp.UTC().Format(timestampFormat),
+		Source:    AnnotationSource,
+		Content:   text,
+		Encoding:  "text",
 	}
 }
 
@@ -74,9 +369,10 @@ type Line struct {
 // SplitLines splits data into lines, preserving line endings.
 // Each line includes its terminating \n or \r\n in the End field.
 // The last line may have an empty End if it doesn't end with a newline.
+// Empty data has no lines, just like Recorder.Record writes no records for it.
 func SplitLines(data []byte) []Line {
 	if len(data) == 0 {
-		return []Line{{Content: nil, End: nil}}
+		return nil
 	}
 
 	var lines []Line
@@ -115,31 +411,29 @@ func SplitLines(data []byte) []Line {
 	return lines
 }
 
-// MarshalJSON implements custom JSON serialization for Record.
+// MarshalJSON implements custom JSON serialization for Record, writing the
+// fields in the order of RecordFields.
 func (r Record) MarshalJSON() ([]byte, error) {
-	type recordAlias struct {
-		Seq       uint64 `json:"seq"`
-		Timestamp string `json:"timestamp"`
-		Source    string `json:"source"`
-		Content   any    `json:"content"`
-		Encoding  string `json:"encoding"`
-		End       string `json:"end,omitempty"`
-		Truncated bool   `json:"truncated,omitempty"`
-	}
-
-	return json.Marshal(recordAlias(r))
+	return marshalFields(r, RecordFields, true)
 }
 
 // UnmarshalJSON implements custom JSON deserialization for Record.
 func (r *Record) UnmarshalJSON(data []byte) error {
 	type recordAlias struct {
-		Seq       uint64          `json:"seq"`
-		Timestamp string          `json:"timestamp"`
-		Source    string          `json:"source"`
-		Content   json.RawMessage `json:"content"`
-		Encoding  string          `json:"encoding"`
-		End       string          `json:"end,omitempty"`
-		Truncated bool            `json:"truncated,omitempty"`
+		Seq       uint64            `json:"seq"`
+		Timestamp string            `json:"timestamp"`
+		Source    string            `json:"source"`
+		Content   json.RawMessage   `json:"content"`
+		Encoding  string            `json:"encoding"`
+		End       string            `json:"end,omitempty"`
+		Len       int               `json:"len,omitempty"`
+		Truncated bool              `json:"truncated,omitempty"`
+		Lossy     bool              `json:"lossy,omitempty"`
+		Continued bool              `json:"continued,omitempty"`
+		Burst     bool              `json:"burst,omitempty"`
+		DeltaMS   *int64            `json:"delta_ms,omitempty"`
+		Fields    map[string]string `json:"fields,omitempty"`
+		ChainHash string            `json:"chain_hash,omitempty"`
 	}
 
 	var alias recordAlias
@@ -152,7 +446,14 @@ func (r *Record) UnmarshalJSON(data []byte) error {
 	r.Source = alias.Source
 	r.Encoding = alias.Encoding
 	r.End = alias.End
+	r.Len = alias.Len
 	r.Truncated = alias.Truncated
+	r.Lossy = alias.Lossy
+	r.Continued = alias.Continued
+	r.Burst = alias.Burst
+	r.DeltaMS = alias.DeltaMS
+	r.Fields = alias.Fields
+	r.ChainHash = alias.ChainHash
 
 	// Parse content based on encoding
 	switch alias.Encoding {
@@ -190,7 +491,80 @@ func (r *Record) UnmarshalJSON(data []byte) error {
 
 // ToJSON serializes the record to JSON bytes.
 func (r Record) ToJSON() ([]byte, error) {
-	return json.Marshal(r)
+	return r.encode(encodeOptions{escapeHTML: true})
+}
+
+// ToSortedJSON serializes the record to JSON bytes with the fields in
+// alphabetical order, so that recordings of the same input diff cleanly.
+func (r Record) ToSortedJSON() ([]byte, error) {
+	return r.encode(encodeOptions{sorted: true, escapeHTML: true})
+}
+
+// encodeOptions controls how a record is serialized to JSON.
+type encodeOptions struct {
+	sorted     bool // write the fields in alphabetical order
+	escapeHTML bool // escape <, > and & in strings as json.Marshal does
+}
+
+// encode serializes the record to JSON bytes with the given options.
+func (r Record) encode(opts encodeOptions) ([]byte, error) {
+	fields := RecordFields
+	if opts.sorted {
+		fields = sortedRecordFields
+	}
+	return marshalFields(r, fields, opts.escapeHTML)
+}
+
+// marshalFields serializes r by writing each of fields explicitly, in the
+// given order, leaving out optional fields that are empty. The keys of
+// Fields and of JSON content are sorted by the encoder already. Unless
+// escapeHTML is true, <, > and & in strings are written as is.
+func marshalFields(r Record, fields []RecordField, escapeHTML bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(escapeHTML)
+	buf.WriteByte('{')
+	for _, field := range fields {
+		v, empty := field.value(r)
+		if empty && !field.Required {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"` + field.Name + `":`)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		// Encode terminates every value with a newline
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// withRawJSONContent returns the record with the content of a json record
+// replaced by its JSON text (see WithRawJSONContent). Other records are
+// returned as is. The text is not HTML-escaped; the string is escaped when
+// the record is serialized instead.
+func (r Record) withRawJSONContent() (Record, error) {
+	if r.Encoding != "json" {
+		return r, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r.Content); err != nil {
+		return r, err
+	}
+	// Encode terminates the value with a newline
+	r.Content = string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return r, nil
+}
+
+// Time parses the record's timestamp.
+func (r Record) Time() (time.Time, error) {
+	return time.Parse(timestampFormat, r.Timestamp)
 }
 
 // ContentString returns the content as a string.
@@ -212,6 +586,90 @@ func (r Record) ContentString() string {
 	return ""
 }
 
+// Text returns the content of a text record. ok is false for any other
+// encoding.
+func (r Record) Text() (text string, ok bool) {
+	if r.Encoding != "text" {
+		return "", false
+	}
+	text, ok = r.Content.(string)
+	return text, ok
+}
+
+// Bytes returns the content as bytes, without the line ending: the text of a
+// text record, the decoded data of a base64 record, or the compact JSON of a
+// json record.
+func (r Record) Bytes() ([]byte, error) {
+	switch r.Encoding {
+	case "text":
+		if s, ok := r.Content.(string); ok {
+			return []byte(s), nil
+		}
+		return nil, fmt.Errorf("text content is not a string: %T", r.Content)
+	case "base64":
+		s, ok := r.Content.(string)
+		if !ok {
+			return nil, fmt.Errorf("base64 content is not a string: %T", r.Content)
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 content: %w", err)
+		}
+		return data, nil
+	case "json":
+		return json.Marshal(r.Content)
+	default:
+		return nil, fmt.Errorf("unknown encoding: %q", r.Encoding)
+	}
+}
+
+// JSON stores the content of a json record in the value pointed to by v, as
+// json.Unmarshal does.
+func (r Record) JSON(v any) error {
+	if r.Encoding != "json" {
+		return fmt.Errorf("content is not json but %s", r.Encoding)
+	}
+	data, err := json.Marshal(r.Content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ErrTruncated is returned by Record.Raw when the record was truncated, so
+// the original bytes are only partially known.
+var ErrTruncated = errors.New("record content was truncated")
+
+// ErrLossy is returned by Record.Raw when invalid UTF-8 in the record was
+// replaced (see NewLossyRecord), so the original bytes are not known.
+var ErrLossy = errors.New("record content is lossy")
+
+// ErrNotRecoverable is returned by Record.Raw for json records, whose
+// original whitespace is not kept.
+var ErrNotRecoverable = errors.New("original bytes of json content are not recoverable")
+
+// Raw returns the bytes the record was made from, i.e. the content and the
+// line ending, for text and base64 records. For a truncated record, it
+// returns the bytes that were kept along with ErrTruncated, and for a lossy
+// record, the bytes with the replacement characters along with ErrLossy.
+func (r Record) Raw() ([]byte, error) {
+	if r.Encoding == "json" {
+		return nil, ErrNotRecoverable
+	}
+	data, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, r.End...)
+	if r.Truncated {
+		return data, ErrTruncated
+	}
+	if r.Lossy {
+		return data, ErrLossy
+	}
+	return data, nil
+}
+
 // splitTrailingCRLF splits data into content and trailing CR/LF.
 // Returns (content, trailing) where trailing contains only CR and LF characters.
 func splitTrailingCRLF(data []byte) ([]byte, []byte) {
@@ -1,7 +1,11 @@
 package recorder
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -58,6 +62,58 @@ func TestNewRecord_NonUTF8Content(t *testing.T) {
 	}
 }
 
+func TestNewLossyRecord(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	// A stray byte and a truncated 3-byte sequence, each replaced on its own
+	record := NewLossyRecord(1, timestamp, "stdout", []byte("a\xffb\xe2\x82 ok\r\n"))
+
+	if record.Encoding != "text" {
+		t.Errorf("expected encoding text, got %s", record.Encoding)
+	}
+	if want := "a\uFFFDb\uFFFD\uFFFD ok"; record.Content != want {
+		t.Errorf("expected content %q, got %q", want, record.Content)
+	}
+	if !record.Lossy {
+		t.Error("expected Lossy to be true")
+	}
+	if record.End != "\r\n" {
+		t.Errorf("expected end \\r\\n, got %q", record.End)
+	}
+	if record.Len != 10 {
+		t.Errorf("expected len of the original line 10, got %d", record.Len)
+	}
+	if _, err := record.Raw(); !errors.Is(err, ErrLossy) {
+		t.Errorf("expected ErrLossy from Raw, got %v", err)
+	}
+
+	data, err := record.ToJSON()
+	if err != nil {
+		t.Fatalf("failed to serialize: %v", err)
+	}
+	if !strings.Contains(string(data), `"lossy":true`) {
+		t.Errorf("expected lossy field in %s", data)
+	}
+	var decoded Record
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if !decoded.Lossy || decoded.ContentString() != "a\uFFFDb\uFFFD\uFFFD ok" {
+		t.Errorf("unexpected round trip: %+v", decoded)
+	}
+}
+
+func TestNewLossyRecord_ValidContent(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	// Valid UTF-8 is encoded as usual and not marked
+	for _, line := range []string{"plain \u00e9\n", `{"key":1}` + "\n"} {
+		record := NewLossyRecord(1, timestamp, "stdout", []byte(line))
+		want := NewRecord(1, timestamp, "stdout", []byte(line))
+		if record.Lossy || record.Encoding != want.Encoding {
+			t.Errorf("NewLossyRecord(%q) = %s lossy=%v, want %s", line, record.Encoding, record.Lossy, want.Encoding)
+		}
+	}
+}
+
 func TestNewRecord_EmptyContent(t *testing.T) {
 	timestamp := time.Now()
 	data := []byte{}
@@ -153,7 +209,7 @@ func TestRecord_JSONFieldNames(t *testing.T) {
 		t.Fatalf("failed to parse JSON: %v", err)
 	}
 
-	expectedFields := []string{"seq", "timestamp", "source", "content", "encoding"}
+	expectedFields := []string{"seq", "timestamp", "source", "content", "encoding", "len"}
 	for _, field := range expectedFields {
 		if _, ok := m[field]; !ok {
 			t.Errorf("expected field %s not found in JSON", field)
@@ -165,6 +221,177 @@ func TestRecord_JSONFieldNames(t *testing.T) {
 	}
 }
 
+func TestRecord_Len(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		name     string
+		data     string
+		encoding string
+	}{
+		{"text", "hello\n", "text"},
+		{"multi-byte text", "h\u00e9llo\r\n", "text"},
+		{"json with spaces", ` {"a": 1} `, "json"},
+		{"base64", "\xff\xfe\n", "base64"},
+		{"no line ending", "partial", "text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := NewRecord(0, timestamp, "stdout", []byte(tt.data))
+			if record.Encoding != tt.encoding {
+				t.Fatalf("expected encoding %s, got %s", tt.encoding, record.Encoding)
+			}
+			if record.Len != len(tt.data) {
+				t.Errorf("expected len %d, got %d", len(tt.data), record.Len)
+			}
+
+			jsonData, err := record.ToJSON()
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			var parsed Record
+			if err := json.Unmarshal(jsonData, &parsed); err != nil {
+				t.Fatalf("failed to parse JSON: %v", err)
+			}
+			if parsed.Len != len(tt.data) {
+				t.Errorf("expected len %d after a round trip, got %d", len(tt.data), parsed.Len)
+			}
+		})
+	}
+
+	// Records without data have no len
+	meta := NewMetaRecord(1, timestamp, map[string]any{"event": "exit"})
+	empty := NewRecord(2, timestamp, "stdout", nil)
+	for _, record := range []Record{meta, empty} {
+		jsonData, err := record.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		if strings.Contains(string(jsonData), `"len"`) {
+			t.Errorf("expected no len, got %s", jsonData)
+		}
+	}
+}
+
+func TestRecord_ToSortedJSON(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 123000000, time.UTC)
+	tests := []struct {
+		name   string
+		record Record
+		want   string
+	}{
+		{
+			name:   "text",
+			record: NewRecord(7, timestamp, "stdout", []byte("hello\n")),
+			want:   `{"content":"hello","encoding":"text","end":"\n","len":6,"seq":7,"source":"stdout","timestamp":"2024-01-15T10:30:45.123Z"}`,
+		},
+		{
+			name: "all fields",
+			record: Record{
+				Seq: 1, Timestamp: "2024-01-15T10:30:45.123Z", Source: "stderr", Content: "x", Encoding: "text",
+				Len: 100, Truncated: true, Burst: true, Fields: map[string]string{"z": "1", "a": "2"},
+			},
+			want: `{"burst":true,"content":"x","encoding":"text","fields":{"a":"2","z":"1"},"len":100,"seq":1,"source":"stderr","timestamp":"2024-01-15T10:30:45.123Z","truncated":true}`,
+		},
+		{
+			name:   "json content",
+			record: NewRecord(0, timestamp, "stdout", []byte(`{"b":1,"a":[true,null]}`)),
+			want:   `{"content":{"a":[true,null],"b":1},"encoding":"json","len":23,"seq":0,"source":"stdout","timestamp":"2024-01-15T10:30:45.123Z"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The output is identical across runs, since map iteration is random
+			for i := 0; i < 20; i++ {
+				jsonData, err := tt.record.ToSortedJSON()
+				if err != nil {
+					t.Fatalf("ToSortedJSON failed: %v", err)
+				}
+				if string(jsonData) != tt.want {
+					t.Fatalf("run %d: expected %s, got %s", i, tt.want, jsonData)
+				}
+			}
+
+			// The output is valid JSON that reads back as the same record
+			jsonData, _ := tt.record.ToSortedJSON()
+			var parsed Record
+			if err := json.Unmarshal(jsonData, &parsed); err != nil {
+				t.Fatalf("failed to parse JSON: %v", err)
+			}
+			roundTrip, _ := tt.record.ToJSON()
+			parsedJSON, _ := parsed.ToJSON()
+			if string(parsedJSON) != string(roundTrip) {
+				t.Errorf("expected %s after a round trip, got %s", roundTrip, parsedJSON)
+			}
+		})
+	}
+}
+
+func TestRecord_EncodeHTMLEscaping(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 123000000, time.UTC)
+	tests := []struct {
+		name      string
+		record    Record
+		escaped   string
+		unescaped string
+	}{
+		{
+			name:      "text",
+			record:    NewRecord(0, timestamp, "stdout", []byte("<html> & </html>\n")),
+			escaped:   `"content":"\u003chtml\u003e \u0026 \u003c/html\u003e"`,
+			unescaped: `"content":"<html> & </html>"`,
+		},
+		{
+			name:      "json content",
+			record:    NewRecord(0, timestamp, "stdout", []byte(`{"tag":"<b>"}`)),
+			escaped:   `"content":{"tag":"\u003cb\u003e"}`,
+			unescaped: `"content":{"tag":"<b>"}`,
+		},
+		{
+			name: "custom fields",
+			record: Record{
+				Timestamp: "2024-01-15T10:30:45.123Z", Source: "stdout", Content: "", Encoding: "text",
+				Fields: map[string]string{"cmd": "a && b"},
+			},
+			escaped:   `"fields":{"cmd":"a \u0026\u0026 b"}`,
+			unescaped: `"fields":{"cmd":"a && b"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			escaped, err := tt.record.ToJSON()
+			if err != nil {
+				t.Fatalf("ToJSON failed: %v", err)
+			}
+			if !strings.Contains(string(escaped), tt.escaped) {
+				t.Errorf("expected %s in %s", tt.escaped, escaped)
+			}
+
+			for _, sorted := range []bool{false, true} {
+				unescaped, err := tt.record.encode(encodeOptions{sorted: sorted})
+				if err != nil {
+					t.Fatalf("encode failed: %v", err)
+				}
+				if !strings.Contains(string(unescaped), tt.unescaped) {
+					t.Errorf("expected %s in %s (sorted: %v)", tt.unescaped, unescaped, sorted)
+				}
+
+				// Both read back as the same record
+				var parsed Record
+				if err := json.Unmarshal(unescaped, &parsed); err != nil {
+					t.Fatalf("failed to parse JSON: %v", err)
+				}
+				parsedJSON, _ := parsed.ToJSON()
+				if string(parsedJSON) != string(escaped) {
+					t.Errorf("expected %s after a round trip, got %s", escaped, parsedJSON)
+				}
+			}
+		})
+	}
+}
+
 // JSON encoding tests
 
 func TestNewRecord_JSONObject(t *testing.T) {
@@ -499,6 +726,166 @@ func TestRecord_ContentString(t *testing.T) {
 	}
 }
 
+func TestRecord_ContentAccessors(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	truncated := NewRecord(0, timestamp, "stdout", []byte("trun\n"))
+	truncated.Truncated = true
+
+	tests := []struct {
+		name      string
+		record    Record
+		text      string
+		textOK    bool
+		bytes     string
+		raw       string
+		rawErr    error
+		jsonValue any // nil = JSON fails
+	}{
+		{
+			name:   "text",
+			record: NewRecord(0, timestamp, "stdout", []byte("hello\r\n")),
+			text:   "hello",
+			textOK: true,
+			bytes:  "hello",
+			raw:    "hello\r\n",
+		},
+		{
+			name:   "text without end",
+			record: NewRecord(0, timestamp, "stdout", []byte("partial")),
+			text:   "partial",
+			textOK: true,
+			bytes:  "partial",
+			raw:    "partial",
+		},
+		{
+			name:   "empty text",
+			record: NewRecord(0, timestamp, "stdout", nil),
+			textOK: true,
+		},
+		{
+			name:   "base64",
+			record: NewRecord(0, timestamp, "stdout", []byte{0xff, 0xfe, '\n'}),
+			bytes:  "\xff\xfe",
+			raw:    "\xff\xfe\n",
+		},
+		{
+			name:      "json",
+			record:    NewRecord(0, timestamp, "stdout", []byte(`{ "a": [1, 2] }`+"\n")),
+			bytes:     `{"a":[1,2]}`,
+			rawErr:    ErrNotRecoverable,
+			jsonValue: map[string][]int{"a": {1, 2}},
+		},
+		{
+			name:   "truncated",
+			record: truncated,
+			text:   "trun",
+			textOK: true,
+			bytes:  "trun",
+			raw:    "trun\n",
+			rawErr: ErrTruncated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// The accessors work the same on a record read back from JSON
+			data, err := tc.record.ToJSON()
+			if err != nil {
+				t.Fatalf("failed to serialize record: %v", err)
+			}
+			var parsed Record
+			if err := json.Unmarshal(data, &parsed); err != nil {
+				t.Fatalf("failed to parse record: %v", err)
+			}
+
+			for _, record := range []Record{tc.record, parsed} {
+				if text, ok := record.Text(); text != tc.text || ok != tc.textOK {
+					t.Errorf("Text() = %q, %v, want %q, %v", text, ok, tc.text, tc.textOK)
+				}
+				if b, err := record.Bytes(); err != nil || string(b) != tc.bytes {
+					t.Errorf("Bytes() = %q, %v, want %q", b, err, tc.bytes)
+				}
+				raw, err := record.Raw()
+				if !errors.Is(err, tc.rawErr) || string(raw) != tc.raw {
+					t.Errorf("Raw() = %q, %v, want %q, %v", raw, err, tc.raw, tc.rawErr)
+				}
+
+				var value map[string][]int
+				err = record.JSON(&value)
+				if tc.jsonValue == nil {
+					if err == nil {
+						t.Errorf("JSON() expected an error for %s content", record.Encoding)
+					}
+				} else if err != nil || !reflect.DeepEqual(value, tc.jsonValue) {
+					t.Errorf("JSON() = %v, %v, want %v", value, err, tc.jsonValue)
+				}
+			}
+		})
+	}
+}
+
+func TestRecord_BytesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		record Record
+	}{
+		{"invalid base64", Record{Encoding: "base64", Content: "not base64!"}},
+		{"non-string text", Record{Encoding: "text", Content: 42.0}},
+		{"unknown encoding", Record{Encoding: "hex", Content: "ff"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.record.Bytes(); err == nil {
+				t.Error("Bytes() expected an error")
+			}
+			if _, err := tc.record.Raw(); err == nil {
+				t.Error("Raw() expected an error")
+			}
+		})
+	}
+}
+
+func TestNewRecord_EndForAllEncodings(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		data         string
+		wantEncoding string
+		wantEnd      string
+	}{
+		{"text", "hello\r\n", "text", "\r\n"},
+		{"json", `{"a": 1}` + "\r\n", "json", "\r\n"},
+		{"json with trailing spaces", `[1, 2]  ` + "\n", "json", "\n"},
+		{"base64", "\xff\xfe\r\n", "base64", "\r\n"},
+		{"base64 ending with CR", "\xff\r", "base64", "\r"},
+		{"no line ending", "\xff\xfe", "base64", ""},
+		{"empty line", "\r\n", "text", "\r\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			record := NewRecord(0, timestamp, "stdout", []byte(tc.data))
+			if record.Encoding != tc.wantEncoding || record.End != tc.wantEnd {
+				t.Fatalf("expected %s with end %q, got %s with end %q", tc.wantEncoding, tc.wantEnd, record.Encoding, record.End)
+			}
+
+			// The content never contains the line ending
+			content, err := record.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes failed: %v", err)
+			}
+			if bytes.ContainsAny(content, "\r\n") {
+				t.Errorf("expected no line ending in the content, got %q", content)
+			}
+			if raw, err := record.Raw(); err == nil && string(raw) != tc.data {
+				t.Errorf("expected %q to be reconstructed, got %q", tc.data, raw)
+			}
+		})
+	}
+}
+
 func TestNewRecord_TextWithEnd(t *testing.T) {
 	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
 
@@ -688,12 +1075,9 @@ func TestSplitLines(t *testing.T) {
 		{
 			name: "empty data",
 			data: []byte{},
-			expected: []struct {
-				content string
-				end     string
-			}{
-				{"", ""},
-			},
+		},
+		{
+			name: "nil data",
 		},
 	}
 
@@ -805,3 +1189,102 @@ func TestRecord_TruncatedDeserialization(t *testing.T) {
 		t.Error("expected Truncated to be false")
 	}
 }
+
+// jsonHeavyLines returns n lines of JSON output written with whitespace, as
+// by a child that pretty-prints its log entries on a single line.
+func jsonHeavyLines(n int) [][]byte {
+	lines := make([][]byte, n)
+	for i := range lines {
+		lines[i] = []byte(fmt.Sprintf(`{ "level" : "info", "n" : %d, "tags" : [ "a", "b c" ], "ctx" : { "user" : "u%d", "ok" : true } }`+"\n", i, i))
+	}
+	return lines
+}
+
+func TestRecord_ToJSONCompact(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 123000000, time.UTC)
+
+	var compactSize, indentedSize int
+	for i, line := range jsonHeavyLines(100) {
+		record := NewRecord(uint64(i), timestamp, "stdout", line)
+		record.Fields = map[string]string{"job": "build"}
+
+		for _, marshal := range []func() ([]byte, error){record.ToJSON, record.ToSortedJSON} {
+			data, err := marshal()
+			if err != nil {
+				t.Fatalf("failed to serialize record: %v", err)
+			}
+			// No whitespace outside of strings, including in the content
+			var compacted bytes.Buffer
+			if err := json.Compact(&compacted, data); err != nil {
+				t.Fatalf("invalid JSON %s: %v", data, err)
+			}
+			if !bytes.Equal(compacted.Bytes(), data) {
+				t.Errorf("expected compact JSON, got %s", data)
+			}
+		}
+
+		data, _ := record.ToJSON()
+		indented, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			t.Fatalf("failed to indent record: %v", err)
+		}
+		compactSize += len(data)
+		indentedSize += len(indented)
+	}
+
+	// Pretty-printed records of the same workload are much larger
+	if compactSize*4 > indentedSize*3 {
+		t.Errorf("expected compact records to be at least 25%% smaller than indented ones: %d vs %d bytes", compactSize, indentedSize)
+	}
+}
+
+func BenchmarkRecord_HTMLEscaping(b *testing.B) {
+	line := []byte(strings.Repeat("<div class=\"row\"><span>a &amp; b</span></div>", 10))
+	record := NewRecord(0, time.Now(), "stdout", line)
+
+	for _, bm := range []struct {
+		name       string
+		escapeHTML bool
+	}{
+		{"escaped", true},
+		{"unescaped", false},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(line)))
+			for i := 0; i < b.N; i++ {
+				if _, err := record.encode(encodeOptions{escapeHTML: bm.escapeHTML}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRecord_ToJSON(b *testing.B) {
+	lines := jsonHeavyLines(100)
+	timestamp := time.Now()
+	records := make([]Record, len(lines))
+	for i, line := range lines {
+		records[i] = NewRecord(uint64(i), timestamp, "stdout", line)
+	}
+
+	for _, bm := range []struct {
+		name    string
+		marshal func(r Record) ([]byte, error)
+	}{
+		{"compact", Record.ToJSON},
+		{"indented", func(r Record) ([]byte, error) { return json.MarshalIndent(r, "", "  ") }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			size := 0
+			for i := 0; i < b.N; i++ {
+				data, err := bm.marshal(records[i%len(records)])
+				if err != nil {
+					b.Fatal(err)
+				}
+				size += len(data)
+			}
+			b.ReportMetric(float64(size)/float64(b.N), "bytes/record")
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package recorder
 
 import (
-	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/trustin/ioetap/internal/logging"
 )
 
 // Source represents the I/O source type.
@@ -34,55 +38,447 @@ func (s Source) String() string {
 	}
 }
 
+// RecordMode tells how the data of a source is divided into records.
+type RecordMode int
+
+const (
+	// LineMode records every line as a record, buffering incomplete lines
+	// until their newline arrives (default).
+	LineMode RecordMode = iota
+	// ChunkMode records the data of every Record call, e.g. every read of
+	// CopyAndRecord, as one record as is, for data that is not made of
+	// lines, e.g. a binary protocol. Newlines may appear anywhere in the
+	// content, and only a trailing one is recorded as the line ending.
+	ChunkMode
+)
+
 // Recorder handles thread-safe recording of I/O to an NDJSON file.
 // It buffers incomplete lines until a newline is received.
 type Recorder struct {
 	seq           atomic.Uint64
-	file          *os.File
-	writer        *bufio.Writer
+	sink          Sink
 	mu            sync.Mutex
 	buffers       [3][]byte // line buffers indexed by Source (Stdin, Stdout, Stderr)
 	truncated     [3]bool   // true if current buffer was truncated
+	burst         [3]bool   // true if the chunk being recorded was read back-to-back
+	binary        [3]bool   // true if the current buffer is not valid UTF-8 (see WithBinaryLimit)
+	continued     [3]bool   // true if the next record continues a split line (see WithSplitLongLines)
+	lineLen       [3]int    // bytes of the current line read so far, including those cut by truncation
 	maxLineLength int       // 0 = unlimited
+	binaryLimit   int       // 0 = same as maxLineLength (see WithBinaryLimit)
+
+	burstDetection bool // see WithBurstDetection
+	strictOrder    bool // see WithStrictOrder
+	stdinEcho      bool // see WithStdinEcho
+	flushEvery     bool // see WithFlushEveryRecord
+	flushOnLine    bool // see WithFlushOnLine
+	sortedFields   bool // see WithSortedFields
+	noEscapeHTML   bool // see WithoutHTMLEscaping
+	rawJSONContent bool // see WithRawJSONContent
+	emptyMarker    bool // see WithEmptyReadMarker
+	readSizes      bool // see WithReadSizes
+	splitLines     bool // see WithSplitLongLines
+	truncateTail   bool // see WithTailTruncation
+	lossyText      bool // see WithLossyText
+
+	fields map[string]string // custom fields added to every record (see WithFields)
+	header []byte            // line written before the first record (see WithHeader)
+
+	onRecord    func(*Record) RecordAction // see WithOnRecord
+	slogHandler slog.Handler               // see WithSlogHandler
+	chainHash   []byte                     // chain hash of the last record (nil = see WithChainHash)
+
+	collectTimings bool      // see WithTimingHistogram
+	timings        []int64   // inter-record latencies in milliseconds
+	lastRecordTime time.Time // time of the last I/O record (zero = none yet)
+
+	deltas      bool         // see WithDeltas
+	sourceTimes [3]time.Time // time of the last record of each source (zero = none yet)
+
+	now func() time.Time // returns the time of new records (see WithClock)
+
+	sourceMode [3]RecordMode // how each source is divided into records (see WithSourceMode)
+
+	gate *sourceGate // admits contending sources by weight (nil = see WithSourceWeights)
+
+	queueSize int           // > 0 enables queued mode (see WithQueue)
+	queue     chan queuedOp // operations for the writer goroutine (nil = direct mode); set once in NewRecorder
+	queueDone chan struct{} // closed when the writer goroutine exits
+
+	queueMu     sync.RWMutex // held for reading while sending to queue, and for writing to close it
+	queueClosed bool         // queue is closed; guarded by queueMu
+
+	bytes      [3]uint64 // bytes of data recorded, indexed by Source (see Stats)
+	lines      [3]uint64 // lines of data recorded, indexed by Source
+	truncLines uint64    // lines recorded as truncated
+	writeErrs  uint64    // records that failed to be serialized or written
+	lastOutput time.Time // when stdout or stderr data was last recorded
+
+	paused       atomic.Bool // true while recording is paused (see Pause)
+	pausedAt     time.Time   // when recording was paused last
+	pauseBytes   [3]uint64   // bytes not recorded since pausedAt, indexed by Source
+	pauseLines   [3]uint64   // lines not recorded since pausedAt, indexed by Source
+	skippedBytes [3]uint64   // bytes not recorded in all pauses, indexed by Source (see Stats)
+	skippedLines [3]uint64   // lines not recorded in all pauses, indexed by Source
+
+	maxSeq       uint64 // sequence number of the last record (see WithMaxSeq)
+	seqExhausted bool   // true once the record with maxSeq has been written
+
+	closed bool // true once Close has been called
 }
 
-// NewRecorder creates a new Recorder that writes to the specified file.
-// maxLineLength limits the maximum bytes per recorded line (0 = unlimited).
-func NewRecorder(filename string, maxLineLength int) (*Recorder, error) {
-	file, err := os.Create(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create recording file: %w", err)
+// ErrClosed is returned when recording after the Recorder has been closed.
+var ErrClosed = errors.New("recorder is closed")
+
+// Option configures optional Recorder behavior.
+type Option func(*Recorder)
+
+// burstReadThreshold is the read duration below which CopyAndRecord assumes
+// the data was already pending, i.e. the read did not wait for the child.
+const burstReadThreshold = 100 * time.Microsecond
+
+// WithBurstDetection marks records as "burst" when the data they were
+// recorded from was read back-to-back by CopyAndRecord, without waiting for
+// the child. Timestamps of such records reflect when ioetap drained the
+// pipe rather than when the child wrote the data.
+func WithBurstDetection() Option {
+	return func(r *Recorder) {
+		r.burstDetection = true
+	}
+}
+
+// WithTimingHistogram collects the latencies between consecutive I/O records
+// and appends a stats record with their histogram when the Recorder is closed.
+func WithTimingHistogram() Option {
+	return func(r *Recorder) {
+		r.collectTimings = true
+	}
+}
+
+// WithDeltas adds the milliseconds since the previous record of the same
+// source to every I/O record (see Record.DeltaMS), so that stalls in the
+// output stand out without diffing timestamps. The first record of each
+// source has none, and a stdin echo (see WithStdinEcho) has none either.
+func WithDeltas() Option {
+	return func(r *Recorder) {
+		r.deltas = true
+	}
+}
+
+// WithClock makes the Recorder take the time of its records from now
+// instead of time.Now, e.g. to test timing-related output.
+func WithClock(now func() time.Time) Option {
+	return func(r *Recorder) {
+		r.now = now
+	}
+}
+
+// WithStrictOrder makes the recording order follow the order in which data
+// was read across sources as closely as possible, at some cost in throughput:
+//   - CopyAndRecord records each chunk as soon as it is read, before
+//     forwarding it, so a slow destination does not delay its record.
+//   - When a source records data while another source has an incomplete line
+//     buffered, that line is written first (as a record without line ending)
+//     instead of waiting for its newline. A line may therefore be split
+//     across several records.
+func WithStrictOrder() Option {
+	return func(r *Recorder) {
+		r.strictOrder = true
+	}
+}
+
+// WithStdinEcho records every stdin record a second time as a stdout record
+// with identical content, as if a terminal echoed the input. The echo only
+// appears in the recording; it is not counted in Stats.Bytes and does not
+// affect the timing histogram.
+func WithStdinEcho() Option {
+	return func(r *Recorder) {
+		r.stdinEcho = true
+	}
+}
+
+// WithEmptyReadMarker makes Record write a record with empty content and no
+// line ending when it gets empty data, and CopyAndRecord do so for a read
+// that returned no data without an error. Without it, empty data is ignored.
+// A buffered incomplete line of the source stays buffered, so the marker
+// precedes it. An empty line is still told apart by its "end".
+func WithEmptyReadMarker() Option {
+	return func(r *Recorder) {
+		r.emptyMarker = true
+	}
+}
+
+// WithReadSizes records a "read" meta record with the source and the number
+// of bytes of every read by CopyAndRecord (and TeeReader) that returned data,
+// before the records of that data. It shows how the child's output arrives,
+// e.g. many small writes or a few pipe-buffer-sized ones.
+func WithReadSizes() Option {
+	return func(r *Recorder) {
+		r.readSizes = true
+	}
+}
+
+// WithSortedFields writes the fields of every record in alphabetical order
+// (see Record.ToSortedJSON) instead of the default order, for recordings
+// that are kept in version control and compared with diff.
+func WithSortedFields() Option {
+	return func(r *Recorder) {
+		r.sortedFields = true
+	}
+}
+
+// WithoutHTMLEscaping writes <, > and & in the strings of every record as
+// is, instead of as \u003c, \u003e and \u0026 as json.Marshal does, which
+// keeps recordings of HTML or shell output readable. The recording is then
+// unsafe to embed in HTML as is.
+func WithoutHTMLEscaping() Option {
+	return func(r *Recorder) {
+		r.noEscapeHTML = true
+	}
+}
+
+// WithRawJSONContent writes the content of json records as a string holding
+// the JSON text, e.g. "content":"{\"key\":\"value\"}", instead of as an
+// embedded JSON value, so that content is always a string whatever the
+// encoding. Consumers parse the string of json records themselves.
+func WithRawJSONContent() Option {
+	return func(r *Recorder) {
+		r.rawJSONContent = true
+	}
+}
+
+// WithFlushEveryRecord flushes the sink after every record, so that a
+// process tailing the recording sees each record as soon as it is written,
+// at the cost of a write system call per record.
+func WithFlushEveryRecord() Option {
+	return func(r *Recorder) {
+		r.flushEvery = true
+	}
+}
+
+// WithFlushOnLine flushes the sink after every record of a complete line,
+// i.e. one that ends with a newline, so that a process tailing the recording
+// sees each line promptly. Unlike WithFlushEveryRecord, records of
+// incomplete lines and meta records stay buffered.
+func WithFlushOnLine() Option {
+	return func(r *Recorder) {
+		r.flushOnLine = true
+	}
+}
+
+// WithFields adds the given custom fields to every record, under a nested
+// "fields" object. Keys must not be one of BuiltinFields.
+func WithFields(fields map[string]string) Option {
+	return func(r *Recorder) {
+		r.fields = fields
+	}
+}
+
+// WithBinaryLimit limits binary lines, i.e. lines that are not valid UTF-8
+// and are therefore recorded with base64 encoding, to n raw bytes instead of
+// maxLineLength. Text and JSON lines are still limited by maxLineLength.
+// A line that is cut to n bytes may turn out to be valid UTF-8 and then be
+// recorded as text.
+func WithBinaryLimit(n int) Option {
+	return func(r *Recorder) {
+		r.binaryLimit = n
+	}
+}
+
+// WithSplitLongLines splits lines longer than the line length limit (see
+// NewRecorder and WithBinaryLimit) into records of up to the limit instead of
+// truncating them, so that no data is lost. Every record but the first of a
+// split line is marked as continued, and only the last one has the line
+// ending. Text lines are split at a rune boundary.
+func WithSplitLongLines() Option {
+	return func(r *Recorder) {
+		r.splitLines = true
+	}
+}
+
+// WithTailTruncation keeps the last bytes of a line longer than the line
+// length limit (see NewRecorder and WithBinaryLimit) instead of the first
+// ones, for lines whose important part is at the end, e.g. an error message
+// after a long prefix. The record is still marked as truncated, and the
+// line ending is kept. While the line is read, only the last limit bytes of
+// it are buffered. WithSplitLongLines takes precedence.
+func WithTailTruncation() Option {
+	return func(r *Recorder) {
+		r.truncateTail = true
+	}
+}
+
+// WithLossyText records lines that are not valid UTF-8 as text with every
+// invalid byte replaced by U+FFFD and marked as lossy (see NewLossyRecord),
+// instead of as base64, to keep the recording human-readable at the cost of
+// the original bytes. WithBinaryLimit still applies to such lines.
+func WithLossyText() Option {
+	return func(r *Recorder) {
+		r.lossyText = true
+	}
+}
+
+// WithSourceMode sets how the data of source is divided into records. In
+// ChunkMode, the line length limit (see NewRecorder and WithBinaryLimit)
+// applies to each chunk, which is truncated or split like a line, and each
+// chunk counts as a line in Stats.
+func WithSourceMode(source Source, mode RecordMode) Option {
+	return func(r *Recorder) {
+		r.sourceMode[source] = mode
+	}
+}
+
+// WithMaxSeq stops recording once the sequence numbers reach max. Records
+// get sequence numbers up to max-1 as usual; the record with sequence number
+// max is a meta record with event "max-seq-reached", and nothing is recorded
+// after it. Without WithMaxSeq, max is math.MaxUint64, so sequence numbers
+// never wrap around.
+func WithMaxSeq(max uint64) Option {
+	return func(r *Recorder) {
+		r.maxSeq = max
 	}
+}
 
-	return &Recorder{
-		file:          file,
-		writer:        bufio.NewWriter(file),
+// WithHeader writes the given line at the beginning of the recording file,
+// before any record. A trailing newline is added if missing.
+func WithHeader(line []byte) Option {
+	return func(r *Recorder) {
+		r.header = line
+	}
+}
+
+// NewRecorder creates a new Recorder that writes the records to sink.
+// The Recorder owns sink from now on and closes it in Close, even if
+// NewRecorder fails.
+// maxLineLength limits the maximum bytes per recorded line (0 = unlimited).
+func NewRecorder(sink Sink, maxLineLength int, opts ...Option) (*Recorder, error) {
+	r := &Recorder{
+		sink:          sink,
 		maxLineLength: maxLineLength,
-	}, nil
+		maxSeq:        math.MaxUint64,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if len(r.header) > 0 {
+		header := r.header
+		if header[len(header)-1] != '\n' {
+			header = append(bytes.Clone(header), '\n')
+		}
+		if _, err := sink.Write(header); err != nil {
+			sink.Close()
+			return nil, fmt.Errorf("failed to write recording header: %w", err)
+		}
+	}
+
+	if r.queueSize > 0 {
+		r.startQueue()
+	}
+
+	return r, nil
+}
+
+// NewFileRecorder creates a new Recorder that writes to the specified file.
+// maxLineLength limits the maximum bytes per recorded line (0 = unlimited).
+func NewFileRecorder(filename string, maxLineLength int, opts ...Option) (*Recorder, error) {
+	sink, err := NewFileSink(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(sink, maxLineLength, opts...)
 }
 
 // Record records data from the given source.
 // Incomplete lines are buffered until a newline is received.
 // Complete lines (ending with \n or \r\n) are written as separate records.
 // Lines exceeding maxLineLength are truncated and marked as truncated.
+// Empty data is ignored unless WithEmptyReadMarker is set.
 // This method is thread-safe.
 func (r *Recorder) Record(source Source, data []byte) error {
-	if len(data) == 0 {
+	return r.record(source, data, false)
+}
+
+// record records data from the given source, marking the resulting records
+// as burst if requested.
+func (r *Recorder) record(source Source, data []byte, burst bool) error {
+	if len(data) == 0 && !r.emptyMarker {
 		return nil
 	}
 
-	now := time.Now()
+	now := r.now()
+
+	if r.queue != nil {
+		// Copy since the caller may reuse data after Record returns
+		data = bytes.Clone(data)
+		return r.enqueue(func() error {
+			if r.paused.Load() {
+				r.skipLocked(source, data)
+				return nil
+			}
+			r.burst[source] = burst
+			return r.recordLocked(now, source, data)
+		})
+	}
 
+	if r.gate != nil {
+		r.gate.acquire(source)
+		defer r.gate.release()
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.closed {
+		return ErrClosed
+	}
+	if r.paused.Load() {
+		r.skipLocked(source, data)
+		return nil
+	}
+	r.burst[source] = burst
+	return r.recordLocked(now, source, data)
+}
+
+// recordLocked records data from the given source. Must be called with mu held.
+func (r *Recorder) recordLocked(now time.Time, source Source, data []byte) error {
+	if len(data) == 0 {
+		// Only with WithEmptyReadMarker; see record
+		return r.writeRecord(now, source, nil, false)
+	}
+
+	r.bytes[source] += uint64(len(data))
+	if source != Stdin {
+		r.lastOutput = now
+	}
+
+	if r.strictOrder {
+		// Incomplete lines of other sources were read before this data
+		for other := range r.buffers {
+			if Source(other) != source {
+				if err := r.flushLocked(now, Source(other)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if r.sourceMode[source] == ChunkMode {
+		return r.writeChunkLocked(now, source, data)
+	}
+
 	buf := r.buffers[source]
 	isTruncated := r.truncated[source]
 
 	for len(data) > 0 {
 		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			r.lineLen[source] += len(data)
+		} else {
+			r.lineLen[source] += idx + 1
+		}
 
-		if isTruncated {
+		if isTruncated && !r.truncateTail {
 			// Currently in truncation mode - skip until newline
 			if idx == -1 {
 				// No newline, skip all remaining data
@@ -96,6 +492,8 @@ func (r *Recorder) Record(source Source, data []byte) error {
 			}
 			r.buffers[source] = nil
 			r.truncated[source] = false
+			r.binary[source] = false
+			r.lineLen[source] = 0
 			buf = nil
 			isTruncated = false
 			data = data[lineEnd:]
@@ -105,9 +503,31 @@ func (r *Recorder) Record(source Source, data []byte) error {
 		if idx == -1 {
 			// No newline found - append to buffer (with truncation check)
 			newBuf := append(buf, data...)
-			if r.maxLineLength > 0 && len(newBuf) > r.maxLineLength {
+			if r.binaryLimit > 0 && !r.binary[source] && !validUTF8From(newBuf, len(buf)) {
+				r.binary[source] = true
+			}
+			limit := r.maxLineLength
+			if r.binary[source] {
+				limit = r.binaryLimit
+			}
+			if limit > 0 && len(newBuf) > limit && r.splitLines {
+				// Write the full parts and keep the rest until more arrives
+				rest, err := r.writeSplitLocked(now, source, newBuf, limit, r.binary[source])
+				r.buffers[source] = rest
+				return err
+			} else if limit > 0 && len(newBuf) > limit && r.truncateTail {
+				// Keep the last limit bytes at the front of the buffer, and
+				// a CR that may start the line ending
+				keep := limit
+				if newBuf[len(newBuf)-1] == '\r' {
+					keep++
+				}
+				n := copy(newBuf, newBuf[len(newBuf)-keep:])
+				r.buffers[source] = newBuf[:n]
+				r.truncated[source] = true
+			} else if limit > 0 && len(newBuf) > limit {
 				// Truncate to limit
-				r.buffers[source] = newBuf[:r.maxLineLength]
+				r.buffers[source] = newBuf[:limit]
 				r.truncated[source] = true
 			} else {
 				r.buffers[source] = newBuf
@@ -127,11 +547,40 @@ func (r *Recorder) Record(source Source, data []byte) error {
 			// No buffer - use slice directly
 			line = data[:lineEnd]
 		}
+		// The start of a tail-truncated line is gone, but was checked already
+		binary := r.binaryLimit > 0 && (r.binary[source] || !utf8.Valid(line))
+		r.binary[source] = false
+		tailTruncated := isTruncated
+		r.truncated[source] = false
+		isTruncated = false
 
 		// Check if line exceeds max length
-		if r.maxLineLength > 0 && len(line) > r.maxLineLength {
+		limit := r.maxLineLength
+		if binary {
+			limit = r.binaryLimit
+		}
+		if limit > 0 && len(line) > limit && r.splitLines {
+			content := line[:len(line)-len(extractLineEndingFromLine(line))]
+			rest, err := r.writeSplitLocked(now, source, content, limit, binary)
+			if err != nil {
+				return err
+			}
+			// The last part keeps the line ending
+			if err := r.writeRecord(now, source, line[len(content)-len(rest):], false); err != nil {
+				return err
+			}
+		} else if tailTruncated || limit > 0 && len(line) > limit && r.truncateTail {
 			lineEnding := extractLineEndingFromLine(line)
-			truncatedContent := line[:r.maxLineLength]
+			truncatedContent := line[:len(line)-len(lineEnding)]
+			if limit > 0 && len(truncatedContent) > limit {
+				truncatedContent = truncatedContent[len(truncatedContent)-limit:]
+			}
+			if err := r.writeTruncatedRecord(now, source, truncatedContent, lineEnding); err != nil {
+				return err
+			}
+		} else if limit > 0 && len(line) > limit {
+			lineEnding := extractLineEndingFromLine(line)
+			truncatedContent := line[:limit]
 			if err := r.writeTruncatedRecord(now, source, truncatedContent, lineEnding); err != nil {
 				return err
 			}
@@ -140,12 +589,85 @@ func (r *Recorder) Record(source Source, data []byte) error {
 				return err
 			}
 		}
+		r.continued[source] = false
+		r.lineLen[source] = 0
 		data = data[lineEnd:]
 	}
 
 	return nil
 }
 
+// writeChunkLocked writes data as one record, or as several if it is over
+// the line length limit with WithSplitLongLines. Must be called with mu held.
+func (r *Recorder) writeChunkLocked(now time.Time, source Source, data []byte) error {
+	r.lineLen[source] = len(data)
+	defer func() { r.lineLen[source] = 0 }()
+
+	binary := r.binaryLimit > 0 && !utf8.Valid(data)
+	limit := r.maxLineLength
+	if binary {
+		limit = r.binaryLimit
+	}
+
+	switch {
+	case limit <= 0 || len(data) <= limit:
+		return r.writeRecord(now, source, data, false)
+	case r.splitLines:
+		rest, err := r.writeSplitLocked(now, source, data, limit, binary)
+		if err == nil {
+			err = r.writeRecord(now, source, rest, false)
+		}
+		r.continued[source] = false
+		return err
+	case r.truncateTail:
+		return r.writeRecord(now, source, data[len(data)-limit:], true)
+	default:
+		return r.writeRecord(now, source, data[:limit], true)
+	}
+}
+
+// writeSplitLocked writes data as records of up to limit bytes until at most
+// limit bytes are left, and returns the rest. Unless data is binary, a rune
+// is not split across records. Must be called with mu held.
+func (r *Recorder) writeSplitLocked(now time.Time, source Source, data []byte, limit int, binary bool) ([]byte, error) {
+	for len(data) > limit {
+		n := limit
+		if !binary {
+			for i := 0; i < utf8.UTFMax-1 && n > 0 && !utf8.RuneStart(data[n]); i++ {
+				n--
+			}
+			if n == 0 || !utf8.RuneStart(data[n]) {
+				n = limit
+			}
+		}
+		if err := r.writeRecord(now, source, data[:n], false); err != nil {
+			return nil, err
+		}
+		r.continued[source] = true
+		data = data[n:]
+	}
+	return data, nil
+}
+
+// validUTF8From reports whether the bytes of b from offset from on are valid
+// UTF-8. A rune split at from is checked as a whole, and an incomplete rune
+// at the end of b is ignored since the rest of it may still arrive.
+func validUTF8From(b []byte, from int) bool {
+	for i := 0; i < utf8.UTFMax-1 && from > 0 && from < len(b) && !utf8.RuneStart(b[from]); i++ {
+		from--
+	}
+	tail := b[from:]
+	for i := 1; i < utf8.UTFMax && i <= len(tail); i++ {
+		if utf8.RuneStart(tail[len(tail)-i]) {
+			if !utf8.FullRune(tail[len(tail)-i:]) {
+				tail = tail[:len(tail)-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(tail)
+}
+
 // extractLineEnding extracts the line ending (\n or \r\n) from the end of the line.
 func extractLineEnding(buf, chunk []byte) []byte {
 	combined := append(buf, chunk...)
@@ -170,20 +692,75 @@ func extractLineEndingFromLine(line []byte) []byte {
 // Call this when the source stream ends (EOF).
 // This method is thread-safe.
 func (r *Recorder) Flush(source Source) error {
-	now := time.Now()
+	now := r.now()
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	return r.run(func() error {
+		return r.flushLocked(now, source)
+	})
+}
+
+// FlushAll writes any buffered incomplete lines for all sources and flushes
+// the buffered writer to the recording file.
+// Call this when ioetap may exit before Close runs (e.g. on a signal).
+// This method is thread-safe.
+func (r *Recorder) FlushAll() error {
+	now := r.now()
+
+	return r.run(func() error {
+		for source := range r.buffers {
+			if err := r.flushLocked(now, Source(source)); err != nil {
+				return err
+			}
+		}
+
+		if err := r.sink.Flush(); err != nil {
+			return fmt.Errorf("failed to flush recording: %w", err)
+		}
+		return nil
+	})
+}
+
+// Sync writes the buffered records to the sink and commits them to stable
+// storage if the sink is a Syncer, e.g. before taking a snapshot of the file
+// system while the recording goes on. Unlike FlushAll, it leaves incomplete
+// lines buffered, so that the records are the same as without Sync.
+// This method is thread-safe.
+func (r *Recorder) Sync() error {
+	return r.run(func() error {
+		syncer, ok := r.sink.(Syncer)
+		if !ok {
+			if err := r.sink.Flush(); err != nil {
+				return fmt.Errorf("failed to flush recording: %w", err)
+			}
+			return nil
+		}
+		if err := syncer.Sync(); err != nil {
+			return fmt.Errorf("failed to sync recording: %w", err)
+		}
+		return nil
+	})
+}
 
+// flushLocked writes any buffered incomplete line for the given source.
+// Must be called with mu held.
+func (r *Recorder) flushLocked(now time.Time, source Source) error {
 	buf := r.buffers[source]
 	if len(buf) == 0 {
 		r.truncated[source] = false
+		r.binary[source] = false
+		r.continued[source] = false
+		r.lineLen[source] = 0
 		return nil
 	}
 
 	isTruncated := r.truncated[source]
 	r.buffers[source] = nil
 	r.truncated[source] = false
+	r.binary[source] = false
+	defer func() {
+		r.continued[source] = false
+		r.lineLen[source] = 0
+	}()
 
 	if isTruncated {
 		return r.writeTruncatedRecord(now, source, buf, nil)
@@ -191,22 +768,223 @@ func (r *Recorder) Flush(source Source) error {
 	return r.writeRecord(now, source, buf, false)
 }
 
+// RecordMeta records a meta event such as "suspend" or "resume".
+// The event is written as a record with source "meta" and JSON content
+// containing the event name and any additional fields.
+// This method is thread-safe.
+func (r *Recorder) RecordMeta(event string, fields map[string]any) error {
+	now := r.now()
+
+	content := make(map[string]any, len(fields)+1)
+	for k, v := range fields {
+		content[k] = v
+	}
+	content["event"] = event
+
+	return r.run(func() error {
+		return r.writeNext(now, func(seq uint64) Record {
+			return NewMetaRecord(seq, now, content)
+		})
+	})
+}
+
+// RecordResource records a sample of the child's resource usage as a record
+// with source "resource" and the given JSON content.
+// This method is thread-safe.
+func (r *Recorder) RecordResource(content map[string]any) error {
+	now := r.now()
+	return r.run(func() error {
+		return r.writeNext(now, func(seq uint64) Record {
+			return NewResourceRecord(seq, now, content)
+		})
+	})
+}
+
+// RecordAnnotation records a note, e.g. one typed into stdin (see
+// AnnotationReader), as a record with source "annotation".
+// This method is thread-safe.
+func (r *Recorder) RecordAnnotation(text string) error {
+	now := r.now()
+	return r.run(func() error {
+		return r.writeNext(now, func(seq uint64) Record {
+			return NewAnnotationRecord(seq, now, text)
+		})
+	})
+}
+
 // writeRecord writes a single record. Must be called with mu held.
 func (r *Recorder) writeRecord(now time.Time, source Source, data []byte, truncated bool) error {
+	if r.collectTimings {
+		if !r.lastRecordTime.IsZero() {
+			r.timings = append(r.timings, now.Sub(r.lastRecordTime).Milliseconds())
+		}
+		r.lastRecordTime = now
+	}
+
+	continued := r.continued[source]
+	if !r.seqExhausted {
+		if !continued {
+			r.lines[source]++
+		}
+		if truncated {
+			r.truncLines++
+		}
+	}
+
+	var delta *int64
+	if r.deltas {
+		if last := r.sourceTimes[source]; !last.IsZero() {
+			ms := max(now.Sub(last).Milliseconds(), 0)
+			delta = &ms
+		}
+		r.sourceTimes[source] = now
+	}
+
+	burst := r.burst[source]
+	lineLen := r.lineLen[source]
+	newDataRecord := NewRecord
+	if r.lossyText {
+		newDataRecord = NewLossyRecord
+	}
+	newRecord := func(recordSource Source) func(seq uint64) Record {
+		return func(seq uint64) Record {
+			record := newDataRecord(seq, now, recordSource.String(), data)
+			record.Truncated = truncated
+			if truncated {
+				// The line was longer than the data kept
+				record.Len = lineLen
+			}
+			record.Continued = continued
+			record.Burst = burst
+			if recordSource == source {
+				record.DeltaMS = delta
+			}
+			return record
+		}
+	}
+
+	if err := r.writeNext(now, newRecord(source)); err != nil {
+		return err
+	}
+	if r.stdinEcho && source == Stdin {
+		// The echo is written right after the stdin record, as a terminal would
+		if err := r.writeNext(now, newRecord(Stdout)); err != nil {
+			return err
+		}
+	}
+	if r.flushOnLine && bytes.HasSuffix(data, []byte("\n")) {
+		if err := r.sink.Flush(); err != nil {
+			return fmt.Errorf("failed to flush recording: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeNext writes the record created by newRecord with the next sequence
+// number. When the sequence numbers reach maxSeq, it writes a
+// "max-seq-reached" meta record instead and drops all later records.
+// Must be called with mu held.
+func (r *Recorder) writeNext(now time.Time, newRecord func(seq uint64) Record) error {
+	if r.seqExhausted {
+		return nil
+	}
+
 	seq := r.seq.Add(1) - 1
-	record := NewRecord(seq, now, source.String(), data)
-	record.Truncated = truncated
+	if seq == r.maxSeq {
+		r.seqExhausted = true
+		return r.write(NewMetaRecord(seq, now, map[string]any{"event": "max-seq-reached", "max_seq": seq}))
+	}
+	return r.write(newRecord(seq))
+}
 
-	jsonData, err := record.ToJSON()
+// Stats summarizes what a Recorder has recorded.
+type Stats struct {
+	Records         uint64        // Number of records written, including meta and stats records
+	Bytes           [3]uint64     // Bytes of I/O data recorded, indexed by Source
+	Lines           [3]uint64     // Lines of I/O data recorded, indexed by Source (without stdin echoes)
+	TruncatedLines  uint64        // Lines among them that were truncated
+	Errors          uint64        // Records that failed to be serialized or written
+	LastOutput      time.Time     // When stdout or stderr data was last recorded (zero = never)
+	SeqLimitReached bool          // True if recording stopped at the limit set by WithMaxSeq
+	Paused          bool          // True if recording is paused (see Recorder.Pause)
+	SkippedBytes    [3]uint64     // Bytes of I/O data not recorded while paused, indexed by Source
+	SkippedLines    [3]uint64     // Lines of I/O data not recorded while paused, indexed by Source
+	HTTP            HTTPSinkStats // What the HTTP sinks have sent (see Recorder.HTTPStats)
+}
+
+// Stats returns what has been recorded so far. Data still buffered as an
+// incomplete line is counted in Bytes but not yet in Records or Lines.
+// This method is thread-safe.
+func (r *Recorder) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Stats{
+		Records:         r.seq.Load(),
+		Bytes:           r.bytes,
+		Lines:           r.lines,
+		TruncatedLines:  r.truncLines,
+		Errors:          r.writeErrs,
+		LastOutput:      r.lastOutput,
+		SeqLimitReached: r.seqExhausted,
+		Paused:          r.paused.Load(),
+		SkippedBytes:    r.skippedBytes,
+		SkippedLines:    r.skippedLines,
+		HTTP:            r.HTTPStats(),
+	}
+}
+
+// write serializes a record and writes it to the recording. Must be called with mu held.
+func (r *Recorder) write(record Record) error {
+	if len(r.fields) > 0 {
+		record.Fields = r.fields
+	}
+	if r.onRecord != nil {
+		var write bool
+		if record, write = r.applyOnRecord(record); !write {
+			return nil
+		}
+	}
+
+	// The chain hash covers the record as written
+	if r.rawJSONContent {
+		var err error
+		if record, err = record.withRawJSONContent(); err != nil {
+			r.writeErrs++
+			return fmt.Errorf("failed to serialize record: %w", err)
+		}
+	}
+
+	if r.chainHash != nil {
+		if err := r.applyChainHash(&record); err != nil {
+			r.writeErrs++
+			return err
+		}
+	}
+
+	jsonData, err := record.encode(encodeOptions{
+		sorted:     r.sortedFields,
+		escapeHTML: !r.noEscapeHTML,
+	})
 	if err != nil {
+		r.writeErrs++
 		return fmt.Errorf("failed to serialize record: %w", err)
 	}
 
-	if _, err := r.writer.Write(jsonData); err != nil {
+	// One write per record, including its newline (see Sink)
+	if _, err := r.sink.Write(append(jsonData, '\n')); err != nil {
+		r.writeErrs++
 		return fmt.Errorf("failed to write record: %w", err)
 	}
-	if _, err := r.writer.WriteString("\n"); err != nil {
-		return fmt.Errorf("failed to write newline: %w", err)
+	logging.Debugf("record: %s", jsonData)
+	if r.slogHandler != nil {
+		if err := r.logRecord(record); err != nil {
+			return err
+		}
+	}
+	if r.flushEvery {
+		if err := r.sink.Flush(); err != nil {
+			return fmt.Errorf("failed to flush recording: %w", err)
+		}
 	}
 
 	return nil
@@ -222,48 +1000,144 @@ func (r *Recorder) writeTruncatedRecord(now time.Time, source Source, content []
 
 // CopyAndRecord copies data from reader to writer while recording each chunk.
 // It returns when the reader reaches EOF or an error occurs.
-// Any incomplete line is flushed at EOF.
+// Any incomplete line is flushed at EOF or when reading fails otherwise, in
+// which case a "read-error" meta record is written and the error returned.
+// With WithBurstDetection, chunks returned by reads that did not have to wait
+// for data are recorded as burst.
+//
+// If writing the child's stdout or stderr fails, e.g. because the reader of
+// a pipe exited, an "output-closed" meta record is written and the rest is
+// still read and recorded until EOF, so that the child does not block on a
+// full pipe. A write error on stdin is returned instead.
 func (r *Recorder) CopyAndRecord(source Source, reader io.Reader, writer io.Writer) error {
 	buf := make([]byte, 32*1024) // 32KB buffer
 
 	for {
+		start := time.Now()
 		n, readErr := reader.Read(buf)
+		burst := r.burstDetection && time.Since(start) < burstReadThreshold
+		if n == 0 && readErr == nil && r.emptyMarker {
+			r.recordChunk(source, nil, burst)
+		}
 		if n > 0 {
 			data := buf[:n]
+			r.recordRead(source, n)
+
+			// In strict order mode, record before forwarding so that the
+			// record is not delayed by a slow destination
+			if r.strictOrder {
+				r.recordChunk(source, data, burst)
+			}
 
 			// Write to destination
 			if _, writeErr := writer.Write(data); writeErr != nil {
-				return fmt.Errorf("write error: %w", writeErr)
+				if source == Stdin {
+					return fmt.Errorf("write error: %w", writeErr)
+				}
+				r.recordOutputClosed(source, writeErr)
+				writer = io.Discard
 			}
 
-			// Record the data (log errors but don't fail)
-			if recordErr := r.Record(source, data); recordErr != nil {
-				fmt.Fprintf(os.Stderr, "ioetap: recording error: %v\n", recordErr)
+			if !r.strictOrder {
+				r.recordChunk(source, data, burst)
 			}
 		}
 
 		if readErr != nil {
+			// Any read error ends the stream, so flush any remaining buffered
+			// data rather than losing the final partial line
+			if flushErr := r.Flush(source); flushErr != nil && !errors.Is(flushErr, ErrClosed) {
+				logging.Warnf("flush error: %v", flushErr)
+			}
 			if readErr == io.EOF {
-				// Flush any remaining buffered data
-				if flushErr := r.Flush(source); flushErr != nil {
-					fmt.Fprintf(os.Stderr, "ioetap: flush error: %v\n", flushErr)
-				}
 				return nil
 			}
+			r.recordReadError(source, readErr)
 			return fmt.Errorf("read error: %w", readErr)
 		}
 	}
 }
 
+// recordChunk records a chunk read by CopyAndRecord. Errors are logged but
+// don't fail the copy; data read after Close (e.g. while ioetap is being
+// terminated) is dropped.
+func (r *Recorder) recordChunk(source Source, data []byte, burst bool) {
+	if err := r.record(source, data, burst); err != nil && !errors.Is(err, ErrClosed) {
+		logging.Warnf("recording error: %v", err)
+	}
+}
+
+// recordOutputClosed records an "output-closed" meta record when writing the
+// output of source failed with err. Like recordChunk, errors are logged.
+func (r *Recorder) recordOutputClosed(source Source, err error) {
+	err = r.RecordMeta("output-closed", map[string]any{"source": source.String(), "error": err.Error()})
+	if err != nil && !errors.Is(err, ErrClosed) {
+		logging.Warnf("recording error: %v", err)
+	}
+}
+
+// recordReadError records a "read-error" meta record when reading source
+// failed with err. Like recordChunk, errors are logged.
+func (r *Recorder) recordReadError(source Source, err error) {
+	err = r.RecordMeta("read-error", map[string]any{"source": source.String(), "error": err.Error()})
+	if err != nil && !errors.Is(err, ErrClosed) {
+		logging.Warnf("recording error: %v", err)
+	}
+}
+
+// recordRead records a "read" meta record of n bytes with WithReadSizes,
+// unless recording is paused.
+// Like recordChunk, errors are logged but don't fail the copy.
+func (r *Recorder) recordRead(source Source, n int) {
+	if !r.readSizes || r.paused.Load() {
+		return
+	}
+	err := r.RecordMeta("read", map[string]any{"source": source.String(), "bytes": n})
+	if err != nil && !errors.Is(err, ErrClosed) {
+		logging.Warnf("recording error: %v", err)
+	}
+}
+
 // Close flushes and closes the recording file.
+// If recording is paused, it is resumed first, so that the gap is still
+// summarized (see Resume). With WithTimingHistogram, the stats record is
+// written next.
+// Recording after Close returns ErrClosed. In queued mode, all operations
+// queued before Close are processed first. Calling Close again does nothing.
 func (r *Recorder) Close() error {
+	r.stopQueue()
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if err := r.writer.Flush(); err != nil {
-		r.file.Close()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if err := r.resumeLocked(r.now()); err != nil {
+		r.sink.Close()
+		return err
+	}
+
+	if r.collectTimings {
+		now := r.now()
+		err := r.writeNext(now, func(seq uint64) Record {
+			return NewStatsRecord(seq, now, timingHistogram(r.timings))
+		})
+		if err != nil {
+			r.sink.Close()
+			return err
+		}
+	}
+
+	if err := r.sink.Flush(); err != nil {
+		r.sink.Close()
 		return fmt.Errorf("failed to flush recording: %w", err)
 	}
 
-	return r.file.Close()
+	if err := r.sink.Close(); err != nil {
+		return fmt.Errorf("failed to close recording: %w", err)
+	}
+	return nil
 }
@@ -3,20 +3,29 @@ package recorder
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestRecorder_SequenceNumbers(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -61,7 +70,7 @@ func TestRecorder_ConcurrentRecording(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -130,7 +139,7 @@ func TestRecorder_ValidNDJSON(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -174,7 +183,7 @@ func TestRecorder_CopyAndRecord(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -218,7 +227,7 @@ func TestRecorder_CopyAndRecord(t *testing.T) {
 
 func TestRecorder_FileCreationError(t *testing.T) {
 	// Try to create a recorder in a non-existent directory
-	_, err := NewRecorder("/nonexistent/directory/test.jsonl", 0)
+	_, err := NewFileRecorder("/nonexistent/directory/test.jsonl", 0)
 	if err == nil {
 		t.Error("expected error for non-existent directory, got nil")
 	}
@@ -228,7 +237,7 @@ func TestRecorder_LineBuffering(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -273,7 +282,7 @@ func TestRecorder_LineBufferingMultipleLines(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -337,7 +346,7 @@ func TestRecorder_FlushWithoutData(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -362,11 +371,309 @@ func TestRecorder_FlushWithoutData(t *testing.T) {
 	}
 }
 
+// sinkRecords parses the records written to sink.
+func sinkRecords(t *testing.T, sink *memorySink) []Record {
+	t.Helper()
+	var records []Record
+	for _, write := range sink.writes {
+		var record Record
+		if err := json.Unmarshal(write, &record); err != nil {
+			t.Fatalf("failed to parse record %s: %v", write, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRecorder_EmptyData(t *testing.T) {
+	// Record and SplitLines agree: complete lines become one record each,
+	// and empty data has no lines
+	for _, data := range []string{"", "a\n", "a\nb\r\n", "\n\n"} {
+		sink := &memorySink{}
+		rec, err := NewRecorder(sink, 0)
+		if err != nil {
+			t.Fatalf("failed to create recorder: %v", err)
+		}
+		if err := rec.Record(Stdout, []byte(data)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+		rec.Close()
+
+		lines := SplitLines([]byte(data))
+		records := sinkRecords(t, sink)
+		if len(records) != len(lines) {
+			t.Fatalf("%q: expected %d records like SplitLines, got %d", data, len(lines), len(records))
+		}
+		for i, line := range lines {
+			if records[i].ContentString() != string(line.Content) || records[i].End != string(line.End) {
+				t.Errorf("%q: record %d is %+v, SplitLines has %+v", data, i, records[i], line)
+			}
+		}
+	}
+}
+
+func TestRecorder_EmptyReadMarker(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithEmptyReadMarker())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// The marker does not flush the incomplete line
+	for _, data := range []string{"par", "", "tial\n", ""} {
+		if err := rec.Record(Stdout, []byte(data)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	rec.Close()
+
+	records := sinkRecords(t, sink)
+	want := []struct{ content, end string }{{"", ""}, {"partial", "\n"}, {"", ""}}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(records))
+	}
+	for i, w := range want {
+		if records[i].ContentString() != w.content || records[i].End != w.end || records[i].Encoding != "text" {
+			t.Errorf("record %d: expected %q with end %q, got %+v", i, w.content, w.end, records[i])
+		}
+	}
+}
+
+// zeroReader returns a read with no data before each chunk.
+type zeroReader struct {
+	chunks []string
+	zero   bool
+}
+
+func (z *zeroReader) Read(p []byte) (int, error) {
+	if len(z.chunks) == 0 {
+		return 0, io.EOF
+	}
+	z.zero = !z.zero
+	if z.zero {
+		return 0, nil
+	}
+	n := copy(p, z.chunks[0])
+	z.chunks = z.chunks[1:]
+	return n, nil
+}
+
+func TestRecorder_EmptyReadMarkerCopyAndRecord(t *testing.T) {
+	for _, marker := range []bool{false, true} {
+		sink := &memorySink{}
+		var opts []Option
+		if marker {
+			opts = append(opts, WithEmptyReadMarker())
+		}
+		rec, err := NewRecorder(sink, 0, opts...)
+		if err != nil {
+			t.Fatalf("failed to create recorder: %v", err)
+		}
+
+		var out bytes.Buffer
+		if err := rec.CopyAndRecord(Stdout, &zeroReader{chunks: []string{"a\n", "b\n"}}, &out); err != nil {
+			t.Fatalf("CopyAndRecord failed: %v", err)
+		}
+		rec.Close()
+
+		// a, b and, with the marker, an empty record for each empty read
+		wantRecords := 2
+		if marker {
+			wantRecords = 4
+		}
+		if records := sinkRecords(t, sink); len(records) != wantRecords {
+			t.Errorf("marker %v: expected %d records, got %d", marker, wantRecords, len(records))
+		}
+		if out.String() != "a\nb\n" {
+			t.Errorf("marker %v: expected the data to be forwarded, got %q", marker, out.String())
+		}
+	}
+}
+
+func TestRecorder_WithReadSizes(t *testing.T) {
+	chunks := []string{"hel", "lo\nwor", "ld\n", strings.Repeat("x", 100)}
+
+	for _, readSizes := range []bool{false, true} {
+		sink := &memorySink{}
+		var opts []Option
+		if readSizes {
+			opts = append(opts, WithReadSizes())
+		}
+		rec, err := NewRecorder(sink, 0, opts...)
+		if err != nil {
+			t.Fatalf("failed to create recorder: %v", err)
+		}
+		if err := rec.CopyAndRecord(Stderr, &chunkedReader{chunks: chunks}, io.Discard); err != nil {
+			t.Fatalf("CopyAndRecord failed: %v", err)
+		}
+		rec.Close()
+
+		var sizes []float64
+		var lines []any
+		for _, record := range sinkRecords(t, sink) {
+			if record.Source != "meta" {
+				lines = append(lines, record.Content)
+				continue
+			}
+			content := record.Content.(map[string]any)
+			if content["event"] != "read" || content["source"] != "stderr" {
+				t.Errorf("unexpected meta record %v", content)
+			}
+			sizes = append(sizes, content["bytes"].(float64))
+		}
+
+		// The line records are the same either way
+		if want := []any{"hello", "world", strings.Repeat("x", 100)}; !reflect.DeepEqual(lines, want) {
+			t.Errorf("read sizes %v: expected lines %v, got %v", readSizes, want, lines)
+		}
+		var want []float64
+		if readSizes {
+			want = []float64{3, 6, 3, 100}
+		}
+		if !reflect.DeepEqual(sizes, want) {
+			t.Errorf("read sizes %v: expected read records %v, got %v", readSizes, want, sizes)
+		}
+	}
+}
+
+func TestRecorder_FlushAll(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	// Leave incomplete lines buffered for two sources
+	if err := rec.Record(Stdout, []byte("partial out")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stderr, []byte("partial err")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("FlushAll failed: %v", err)
+	}
+
+	// Records must be on disk before Close
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d: %q", len(lines), content)
+	}
+
+	contents := make(map[string]string)
+	for _, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		contents[record.Source] = record.ContentString()
+	}
+
+	if contents["stdout"] != "partial out" {
+		t.Errorf("expected stdout 'partial out', got %q", contents["stdout"])
+	}
+	if contents["stderr"] != "partial err" {
+		t.Errorf("expected stderr 'partial err', got %q", contents["stderr"])
+	}
+}
+
+func TestRecorder_RecordMeta(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("line\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.RecordMeta("suspend", map[string]any{"signal": "SIGTSTP"}); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(lines))
+	}
+
+	var record Record
+	if err := json.Unmarshal(lines[1], &record); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+
+	if record.Seq != 1 {
+		t.Errorf("expected seq 1, got %d", record.Seq)
+	}
+	if record.Source != MetaSource {
+		t.Errorf("expected source %q, got %q", MetaSource, record.Source)
+	}
+	if record.Encoding != "json" {
+		t.Errorf("expected json encoding, got %q", record.Encoding)
+	}
+
+	meta, ok := record.Content.(map[string]any)
+	if !ok {
+		t.Fatalf("expected object content, got %T", record.Content)
+	}
+	if meta["event"] != "suspend" {
+		t.Errorf("expected event 'suspend', got %v", meta["event"])
+	}
+	if meta["signal"] != "SIGTSTP" {
+		t.Errorf("expected signal 'SIGTSTP', got %v", meta["signal"])
+	}
+}
+
+func TestRecorder_RecordResource(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.RecordResource(map[string]any{"cpu_user_ms": 20, "cpu_sys_ms": 10, "rss_bytes": 4096}); err != nil {
+		t.Fatalf("failed to record resource usage: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var record Record
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if record.Source != ResourceSource || record.Encoding != "json" {
+		t.Errorf("expected a json resource record, got %+v", record)
+	}
+	want := map[string]any{"cpu_user_ms": 20.0, "cpu_sys_ms": 10.0, "rss_bytes": 4096.0}
+	if !reflect.DeepEqual(record.Content, want) {
+		t.Errorf("expected content %v, got %v", want, record.Content)
+	}
+}
+
 func TestRecorder_CopyAndRecordFlushesAtEOF(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -430,12 +737,230 @@ func (r *chunkedReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// closedPipeWriter accepts the first write, then fails like a pipe whose
+// reader has exited.
+type closedPipeWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *closedPipeWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > 1 {
+		return 0, syscall.EPIPE
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestRecorder_CopyAndRecordAfterWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// The output keeps being read and recorded after the pipe is closed
+	output := &closedPipeWriter{}
+	reader := &chunkedReader{chunks: []string{"one\n", "two\n", "three\n"}}
+	if err := rec.CopyAndRecord(Stdout, reader, output); err != nil {
+		t.Fatalf("CopyAndRecord failed: %v", err)
+	}
+	if output.String() != "one\n" || output.writes != 2 {
+		t.Errorf("expected 'one' and no write after the error, got %q (%d writes)", output.String(), output.writes)
+	}
+
+	// A write error on stdin still stops the copy
+	reader = &chunkedReader{chunks: []string{"in\n", "lost\n"}}
+	if err := rec.CopyAndRecord(Stdin, reader, &closedPipeWriter{}); !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("expected EPIPE for stdin, got %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if content, ok := record.Content.(map[string]any); ok {
+			if content["event"] != "output-closed" || content["source"] != "stdout" || content["error"] != syscall.EPIPE.Error() {
+				t.Errorf("unexpected meta record: %v", content)
+			}
+			got = append(got, "output-closed")
+			continue
+		}
+		got = append(got, record.Source+":"+record.Content.(string))
+	}
+	want := []string{"stdout:one", "output-closed", "stdout:two", "stdout:three", "stdin:in"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected records %v, got %v", want, got)
+	}
+}
+
+// failingReader returns data, then err.
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestRecorder_CopyAndRecordFlushesOnReadError(t *testing.T) {
+	errTornDown := errors.New("input/output error")
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"closed", fs.ErrClosed},
+		{"custom", errTornDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.jsonl")
+			rec, err := NewFileRecorder(filename, 0)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+
+			reader := &failingReader{data: "line\npartial", err: tt.err}
+			if err := rec.CopyAndRecord(Stderr, reader, io.Discard); !errors.Is(err, tt.err) {
+				t.Errorf("expected the read error, got %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+			if len(lines) != 3 {
+				t.Fatalf("expected 3 records, got %d:\n%s", len(lines), content)
+			}
+			var records [3]Record
+			for i, line := range lines {
+				if err := json.Unmarshal(line, &records[i]); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+			}
+
+			// The partial line is recorded before the error
+			if records[0].Content != "line" || records[1].Content != "partial" || records[1].End != "" {
+				t.Errorf("expected 'line' and 'partial', got %+v and %+v", records[0], records[1])
+			}
+			meta, _ := records[2].Content.(map[string]any)
+			if records[2].Source != MetaSource || meta["event"] != "read-error" || meta["source"] != "stderr" || meta["error"] != tt.err.Error() {
+				t.Errorf("expected a read-error meta record, got %+v", records[2])
+			}
+		})
+	}
+}
+
+// delayedChunk is a chunk returned by delayedReader after a delay.
+type delayedChunk struct {
+	data  string
+	delay time.Duration
+}
+
+// delayedReader returns each chunk after its delay, simulating a pipe that
+// is either waited on or already has data pending.
+type delayedReader struct {
+	chunks []delayedChunk
+	idx    int
+}
+
+func (r *delayedReader) Read(p []byte) (n int, err error) {
+	if r.idx >= len(r.chunks) {
+		return 0, io.EOF
+	}
+	chunk := r.chunks[r.idx]
+	r.idx++
+	time.Sleep(chunk.delay)
+	return copy(p, chunk.data), nil
+}
+
+func TestRecorder_BurstDetection(t *testing.T) {
+	chunks := []delayedChunk{
+		{"slow\n", 20 * time.Millisecond},
+		{"fast1\n", 0},
+		{"fast2\nfast3\n", 0},
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]bool
+	}{
+		{
+			name: "enabled",
+			opts: []Option{WithBurstDetection()},
+			want: map[string]bool{"slow": false, "fast1": true, "fast2": true, "fast3": true},
+		},
+		{
+			name: "disabled",
+			want: map[string]bool{"slow": false, "fast1": false, "fast2": false, "fast3": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+			rec, err := NewFileRecorder(filename, 0, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+
+			reader := &delayedReader{chunks: chunks}
+			if err := rec.CopyAndRecord(Stdout, reader, io.Discard); err != nil {
+				t.Fatalf("CopyAndRecord failed: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+
+			lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+			if len(lines) != len(tt.want) {
+				t.Fatalf("expected %d records, got %d", len(tt.want), len(lines))
+			}
+			for _, line := range lines {
+				var record Record
+				if err := json.Unmarshal(line, &record); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+				if want := tt.want[record.ContentString()]; record.Burst != want {
+					t.Errorf("record %q: burst = %v, want %v", record.ContentString(), record.Burst, want)
+				}
+				if !record.Burst && bytes.Contains(line, []byte(`"burst"`)) {
+					t.Errorf("burst field should be omitted when false: %s", line)
+				}
+			}
+		})
+	}
+}
+
 func TestRecorder_TruncationSingleChunk(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 10
-	rec, err := NewRecorder(filename, 10)
+	rec, err := NewFileRecorder(filename, 10)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -476,7 +1001,7 @@ func TestRecorder_TruncationMultipleChunks(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 10
-	rec, err := NewRecorder(filename, 10)
+	rec, err := NewFileRecorder(filename, 10)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -525,7 +1050,7 @@ func TestRecorder_TruncationExactLimit(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 11 (10 content + 1 newline)
-	rec, err := NewRecorder(filename, 11)
+	rec, err := NewFileRecorder(filename, 11)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -563,7 +1088,7 @@ func TestRecorder_TruncationUnlimited(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with unlimited line length
-	rec, err := NewRecorder(filename, 0)
+	rec, err := NewFileRecorder(filename, 0)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -603,7 +1128,7 @@ func TestRecorder_TruncationFlushAtEOF(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 10
-	rec, err := NewRecorder(filename, 10)
+	rec, err := NewFileRecorder(filename, 10)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -650,7 +1175,7 @@ func TestRecorder_TruncationCRLF(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 10
-	rec, err := NewRecorder(filename, 10)
+	rec, err := NewFileRecorder(filename, 10)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -692,7 +1217,7 @@ func TestRecorder_TruncationMultipleLines(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 10
-	rec, err := NewRecorder(filename, 10)
+	rec, err := NewFileRecorder(filename, 10)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -758,7 +1283,7 @@ func TestRecorder_TruncationJSONContent(t *testing.T) {
 	filename := filepath.Join(tmpDir, "test.jsonl")
 
 	// Create recorder with max line length of 20
-	rec, err := NewRecorder(filename, 20)
+	rec, err := NewFileRecorder(filename, 20)
 	if err != nil {
 		t.Fatalf("failed to create recorder: %v", err)
 	}
@@ -795,3 +1320,1205 @@ func TestRecorder_TruncationJSONContent(t *testing.T) {
 		t.Errorf("expected content length 20, got %d", len(contentStr))
 	}
 }
+
+func TestRecorder_BinaryLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		binaryLimit   int
+		chunks        []string
+		wantContent   string // raw bytes including the line ending, before encoding
+		wantEncoding  string
+		wantTruncated bool
+	}{
+		{"text above binary limit", 4, []string{"abcdefghij\n"}, "abcdefghij\n", "text", false},
+		{"text above max line length", 4, []string{"0123456789abcdefghijXYZ\n"}, "0123456789abcdefghij\n", "text", true},
+		{"binary above binary limit", 4, []string{"\xff\xfeabcdefgh\n"}, "\xff\xfeab\n", "base64", true},
+		{"binary within binary limit", 4, []string{"\xff\xfe\n"}, "\xff\xfe\n", "base64", false},
+		{"binary across chunks", 4, []string{"ab", "\xff", "cdefg\n"}, "ab\xffc\n", "base64", true},
+		{"binary buffered across chunks", 4, []string{"\xffa", "bcdefg", "hij\n"}, "\xffabc\n", "base64", true},
+		{"rune split across chunks", 4, []string{"ab\xc3", "\xa9cdefgh", "\n"}, "ab\xc3\xa9cdefgh\n", "text", false},
+		{"binary flushed at close", 4, []string{"\xffabcdefgh"}, "\xffabc", "base64", true},
+		{"binary without binary limit", 0, []string{"\xff0123456789abcdefghijXYZ\n"}, "\xff0123456789abcdefghi\n", "base64", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+			var opts []Option
+			if tt.binaryLimit > 0 {
+				opts = append(opts, WithBinaryLimit(tt.binaryLimit))
+			}
+			rec, err := NewFileRecorder(filename, 20, opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			for _, chunk := range tt.chunks {
+				if err := rec.Record(Stdout, []byte(chunk)); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.FlushAll(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			var record Record
+			if err := json.Unmarshal(bytes.TrimSpace(content), &record); err != nil {
+				t.Fatalf("failed to parse record: %v", err)
+			}
+
+			got := record.ContentString()
+			if record.Encoding == "base64" {
+				decoded, err := base64.StdEncoding.DecodeString(got)
+				if err != nil {
+					t.Fatalf("invalid base64 content: %v", err)
+				}
+				got = string(decoded)
+			}
+			got += record.End
+			if record.Encoding != tt.wantEncoding {
+				t.Errorf("expected encoding %q, got %q", tt.wantEncoding, record.Encoding)
+			}
+			if got != tt.wantContent {
+				t.Errorf("expected content %q, got %q", tt.wantContent, got)
+			}
+			if record.Truncated != tt.wantTruncated {
+				t.Errorf("expected Truncated %v, got %v", tt.wantTruncated, record.Truncated)
+			}
+		})
+	}
+}
+
+func TestRecorder_TailTruncation(t *testing.T) {
+	long := strings.Repeat("x", 90) + "0123456789"
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string // content and end of each record, "!" marking truncated records
+	}{
+		{"100-byte line", []string{long + "\n"}, []string{"!0123456789\n"}},
+		{"across chunks", []string{long[:50], long[50:95], long[95:] + "\n"}, []string{"!0123456789\n"}},
+		{"newline in a later chunk", []string{long, "\n"}, []string{"!0123456789\n"}},
+		{"CRLF split across chunks", []string{long + "\r", "\n"}, []string{"!0123456789\r\n"}},
+		{"exact limit", []string{"012345678\n"}, []string{"012345678\n"}},
+		{"flushed at close", []string{long}, []string{"!0123456789"}},
+		{"next line", []string{long + "\nab", "c\n"}, []string{"!0123456789\n", "abc\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rec, err := NewRecorder(NewWriterSink(&buf), 10, WithTailTruncation())
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			for _, chunk := range tt.chunks {
+				if err := rec.Record(Stdout, []byte(chunk)); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.FlushAll(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var record Record
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+				data, err := record.Bytes()
+				if err != nil {
+					t.Fatalf("failed to decode record: %v", err)
+				}
+				part := string(data) + record.End
+				if record.Truncated {
+					part = "!" + part
+				}
+				got = append(got, part)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected records %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRecorder_SplitLongLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string // content and end of each record, "+" marking continued records
+	}{
+		{"three times the limit", []string{"aaaabbbbcccc\n"}, []string{"aaaa", "+bbbb", "+cccc\n"}},
+		{"across chunks", []string{"aaaab", "bbbcc", "cc\n"}, []string{"aaaa", "+bbbb", "+cccc\n"}},
+		{"CRLF", []string{"aaaabb\r\n"}, []string{"aaaa", "+bb\r\n"}},
+		{"limit without line ending", []string{"aaaa\n"}, []string{"aaaa\n"}},
+		{"rune boundary", []string{"abc\u00e9de\n"}, []string{"abc", "+\u00e9de\n"}},
+		{"binary", []string{"\xff\xfe\xfdab\n"}, []string{"\xff\xfe\xfda", "+b\n"}},
+		{"flushed at close", []string{"aaaabbbbcc"}, []string{"aaaa", "+bbbb", "+cc"}},
+		{"next line", []string{"aaaabb\nxy\n"}, []string{"aaaa", "+bb\n", "xy\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rec, err := NewRecorder(NewWriterSink(&buf), 4, WithSplitLongLines())
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			for _, chunk := range tt.chunks {
+				if err := rec.Record(Stdout, []byte(chunk)); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.FlushAll(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			lines := rec.Stats().Lines[Stdout]
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var record Record
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+				if record.Truncated {
+					t.Errorf("expected no truncated record, got %s", line)
+				}
+				data, err := record.Bytes()
+				if err != nil {
+					t.Fatalf("failed to decode record: %v", err)
+				}
+				part := string(data) + record.End
+				if record.Continued {
+					part = "+" + part
+				}
+				got = append(got, part)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected records %q, got %q", tt.want, got)
+			}
+
+			// A split line is still counted once
+			var wantLines uint64
+			for _, part := range tt.want {
+				if !strings.HasPrefix(part, "+") {
+					wantLines++
+				}
+			}
+			if lines != wantLines {
+				t.Errorf("expected %d lines, got %d", wantLines, lines)
+			}
+		})
+	}
+}
+
+func TestRecorder_TimingHistogram(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, WithTimingHistogram())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// 19 short gaps and one long gap between 21 records
+	delays := []time.Duration{0}
+	for i := 0; i < 19; i++ {
+		delays = append(delays, 10)
+	}
+	delays = append(delays, 100)
+	for _, delay := range delays {
+		time.Sleep(delay * time.Millisecond)
+		if err := rec.Record(Stdout, []byte("line\n")); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != len(delays)+1 {
+		t.Fatalf("expected %d records, got %d", len(delays)+1, len(lines))
+	}
+
+	var stats Record
+	if err := json.Unmarshal(lines[len(lines)-1], &stats); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if stats.Source != StatsSource || stats.Encoding != "json" || stats.Seq != uint64(len(delays)) {
+		t.Fatalf("unexpected stats record: %s", lines[len(lines)-1])
+	}
+
+	histogram, ok := stats.Content.(map[string]any)
+	if !ok {
+		t.Fatalf("expected object content, got %T", stats.Content)
+	}
+
+	// Sleeps may overshoot but never undershoot
+	const tolerance = 30
+	want := map[string]float64{"p50": 10, "p90": 10, "p99": 100, "p999": 100, "max": 100}
+	for key, min := range want {
+		got, ok := histogram[key].(float64)
+		if !ok {
+			t.Errorf("%s missing from histogram: %v", key, histogram)
+			continue
+		}
+		if got < min || got > min+tolerance {
+			t.Errorf("%s = %v, want %v (+%d)", key, got, min, tolerance)
+		}
+	}
+}
+
+func TestRecorder_TimingHistogramDisabled(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("line\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if bytes.Contains(content, []byte(`"stats"`)) {
+		t.Errorf("unexpected stats record: %s", content)
+	}
+}
+
+func TestRecorder_WithFields(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	fields := map[string]string{"trace_id": "abc", "env": "test"}
+	rec, err := NewFileRecorder(filename, 0, WithFields(fields), WithTimingHistogram())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("out\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stderr, []byte("partial")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.RecordMeta("suspend", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if err := rec.Flush(Stderr); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	// stdout, meta, stderr and stats records all carry the fields
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if len(record.Fields) != len(fields) || record.Fields["trace_id"] != "abc" || record.Fields["env"] != "test" {
+			t.Errorf("record %s: fields = %v, want %v", record.Source, record.Fields, fields)
+		}
+
+		// Round trip
+		data, err := record.ToJSON()
+		if err != nil {
+			t.Fatalf("failed to serialize record: %v", err)
+		}
+		if !bytes.Equal(data, line) {
+			t.Errorf("round trip mismatch:\n got %s\nwant %s", data, line)
+		}
+	}
+}
+
+func TestRecorder_WithHeader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, WithHeader([]byte(`{"$schema":"x"}`)))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("out\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected header and 1 record, got %d lines", len(lines))
+	}
+	if string(lines[0]) != `{"$schema":"x"}` {
+		t.Errorf("header = %s", lines[0])
+	}
+	var record Record
+	if err := json.Unmarshal(lines[1], &record); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if record.Seq != 0 || record.ContentString() != "out" {
+		t.Errorf("unexpected record: %s", lines[1])
+	}
+}
+
+func TestRecorder_StdinEcho(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 4, WithStdinEcho())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.Record(Stdin, []byte("hello\nhi")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("out\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Flush(Stdin); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	stats := rec.Stats()
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// The echo is not I/O
+	if stats.Bytes[Stdin] != 8 || stats.Bytes[Stdout] != 4 {
+		t.Errorf("unexpected bytes: %v", stats.Bytes)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	want := []struct {
+		source    string
+		content   string
+		end       string
+		truncated bool
+	}{
+		{"stdin", "hell", "\n", true},
+		{"stdout", "hell", "\n", true},
+		{"stdout", "out", "\n", false},
+		{"stdin", "hi", "", false},
+		{"stdout", "hi", "", false},
+	}
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d records, got %d:\n%s", len(want), len(lines), content)
+	}
+	for i, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		w := want[i]
+		if record.Seq != uint64(i) || record.Source != w.source || record.ContentString() != w.content ||
+			record.End != w.end || record.Truncated != w.truncated {
+			t.Errorf("record %d: unexpected record: %s", i, line)
+		}
+	}
+}
+
+func TestRecorder_WithSortedFields(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithSortedFields(), WithFields(map[string]string{"env": "test"}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("out\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.RecordMeta("suspend", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	if len(sink.writes) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(sink.writes))
+	}
+	for _, write := range sink.writes {
+		var keys []string
+		dec := json.NewDecoder(bytes.NewReader(write))
+		dec.Token() // {
+		for dec.More() {
+			key, _ := dec.Token()
+			keys = append(keys, key.(string))
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				t.Fatalf("failed to parse record %s: %v", write, err)
+			}
+		}
+		if !sort.StringsAreSorted(keys) {
+			t.Errorf("expected the fields in alphabetical order, got %q", keys)
+		}
+	}
+}
+
+func TestRecorder_WithoutHTMLEscaping(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, `"content":"\u003chtml\u003e"`},
+		{"without escaping", []Option{WithoutHTMLEscaping()}, `"content":"<html>"`},
+		{"sorted without escaping", []Option{WithoutHTMLEscaping(), WithSortedFields()}, `"content":"<html>"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			rec, err := NewRecorder(sink, 0, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			if err := rec.Record(Stdout, []byte("<html>\n")); err != nil {
+				t.Fatalf("failed to record: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			if len(sink.writes) != 1 {
+				t.Fatalf("expected 1 record, got %d", len(sink.writes))
+			}
+			if !bytes.Contains(sink.writes[0], []byte(tt.want)) {
+				t.Errorf("expected %s in %s", tt.want, sink.writes[0])
+			}
+		})
+	}
+}
+
+func TestRecorder_WithLossyText(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithLossyText())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	_ = rec.Record(Stdout, []byte("caf\xe9\n\xff\xfe\x00\n"))
+	_ = rec.Record(Stderr, []byte("valid\n"))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	records := sinkRecords(t, sink)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	want := []struct {
+		content string
+		lossy   bool
+	}{
+		{"caf\uFFFD", true},
+		{"\uFFFD\uFFFD\x00", true},
+		{"valid", false},
+	}
+	for i, w := range want {
+		if records[i].Encoding != "text" || records[i].ContentString() != w.content || records[i].Lossy != w.lossy {
+			t.Errorf("record %d: expected text %q lossy=%v, got %s %q lossy=%v",
+				i, w.content, w.lossy, records[i].Encoding, records[i].ContentString(), records[i].Lossy)
+		}
+	}
+}
+
+func TestRecorder_WithRawJSONContent(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithRawJSONContent(), WithChainHash())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	lines := []string{`{"key":"<value>"}`, "[1,2,3]", "42", "true", "null", `"quoted"`, "plain text", "\xff\xfe"}
+	for _, line := range lines {
+		if err := rec.Record(Stdout, []byte(line+"\n")); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	if err := rec.RecordMeta("suspend", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	if len(sink.writes) != len(lines)+1 {
+		t.Fatalf("expected %d records, got %d", len(lines)+1, len(sink.writes))
+	}
+	verifier := NewChainVerifier()
+	for i, data := range sink.writes {
+		var raw map[string]any
+		if err := json.Unmarshal(data, &raw); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if _, ok := raw["content"].(string); !ok {
+			t.Errorf("record %d: expected string content, got %T in %s", i, raw["content"], data)
+		}
+
+		// The chain hash covers the content as written
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if err := verifier.Verify(record); err != nil {
+			t.Errorf("record %d: %v", i, err)
+		}
+	}
+
+	// The string of a json record is the JSON text of the line
+	for i, want := range map[int]string{0: `{"key":"<value>"}`, 4: "null", 5: `"quoted"`, 8: `{"event":"suspend"}`} {
+		var record Record
+		if err := json.Unmarshal(sink.writes[i], &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if record.Encoding != "json" || record.Content != want {
+			t.Errorf("record %d: expected json content %q, got %s %q", i, want, record.Encoding, record.Content)
+		}
+	}
+}
+
+func TestRecorder_FlushEveryRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+	rec, err := NewFileRecorder(filename, 0, WithFlushEveryRecord())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	tail, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("failed to open recording: %v", err)
+	}
+	defer tail.Close()
+
+	// A tailing reader sees each record right after it is recorded,
+	// long before the buffer of a buffered sink would fill up
+	var offset int64
+	buf := make([]byte, 4096)
+	for i := 0; i < 5; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		if err := rec.Record(Stdout, []byte(line)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+
+		deadline := time.Now().Add(50 * time.Millisecond)
+		for {
+			n, _ := tail.ReadAt(buf, offset)
+			if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+				var record Record
+				if err := json.Unmarshal(buf[:i], &record); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+				if record.ContentString()+"\n" != line {
+					t.Errorf("expected %q, got %q", line, record.ContentString())
+				}
+				offset += int64(i + 1)
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("record %d is not visible in the file", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestRecorder_FlushOnLine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+	rec, err := NewFileRecorder(filename, 0, WithFlushOnLine())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	readRecording := func() []byte {
+		t.Helper()
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read recording: %v", err)
+		}
+		return data
+	}
+
+	// A complete line is on disk right after it is recorded
+	if err := rec.Record(Stdout, []byte("hello\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	data := readRecording()
+	var record Record
+	if err := json.Unmarshal(bytes.TrimSpace(data), &record); err != nil {
+		t.Fatalf("expected one record on disk, got %q: %v", data, err)
+	}
+	if record.Content != "hello" || record.End != "\n" {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	// Records of incomplete lines and meta records stay buffered
+	if err := rec.Record(Stdout, []byte("partial")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Flush(Stdout); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := rec.RecordMeta("suspend", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if got := readRecording(); !bytes.Equal(got, data) {
+		t.Errorf("expected the partial line and meta record to be buffered, got %q", got)
+	}
+
+	// The next complete line flushes them as well
+	if err := rec.Record(Stderr, []byte("done\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if lines := bytes.Count(readRecording(), []byte("\n")); lines != 4 {
+		t.Errorf("expected 4 records on disk, got %d", lines)
+	}
+}
+
+func TestRecorder_MaxSeq(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		startSeq uint64 // sequence number of the first record
+		wantSeqs []uint64
+	}{
+		{"configured limit", []Option{WithMaxSeq(3)}, 0, []uint64{0, 1, 2, 3}},
+		{"default limit at uint64 boundary", nil, math.MaxUint64 - 2, []uint64{math.MaxUint64 - 2, math.MaxUint64 - 1, math.MaxUint64}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+			opts := append(tt.opts, WithTimingHistogram())
+			rec, err := NewFileRecorder(filename, 0, opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			rec.seq.Store(tt.startSeq)
+
+			for i := 0; i < 5; i++ {
+				if err := rec.Record(Stdout, []byte("line\n")); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.RecordMeta("suspend", nil); err != nil {
+				t.Fatalf("failed to record meta: %v", err)
+			}
+			if !rec.Stats().SeqLimitReached {
+				t.Error("expected the seq limit to be reached")
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+			if len(lines) != len(tt.wantSeqs) {
+				t.Fatalf("expected %d records, got %d:\n%s", len(tt.wantSeqs), len(lines), content)
+			}
+
+			for i, line := range lines {
+				var record Record
+				if err := json.Unmarshal(line, &record); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+				if record.Seq != tt.wantSeqs[i] {
+					t.Errorf("record %d: expected seq %d, got %d", i, tt.wantSeqs[i], record.Seq)
+				}
+
+				last := i == len(lines)-1
+				if !last && record.Source != "stdout" {
+					t.Errorf("record %d: expected stdout, got %s", i, record.Source)
+				}
+				if last {
+					content, ok := record.Content.(map[string]any)
+					if record.Source != MetaSource || !ok || content["event"] != "max-seq-reached" {
+						t.Errorf("expected max-seq-reached meta record, got %s", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecorder_WriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("out\npartial")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stderr, []byte("err\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+
+	stats := rec.Stats()
+	if stats.Records != 2 || stats.Bytes != [3]uint64{0, 11, 4} || stats.SeqLimitReached {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %d:\n%s", len(lines), buf.Bytes())
+	}
+	if rec.Stats().Records != 3 {
+		t.Errorf("expected 3 records in stats, got %d", rec.Stats().Records)
+	}
+}
+
+func TestRecorder_StatsLines(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 4, WithStdinEcho())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdin, []byte("in\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if stats := rec.Stats(); !stats.LastOutput.IsZero() {
+		t.Errorf("expected no output yet, got %v", stats.LastOutput)
+	}
+
+	before := time.Now()
+	if err := rec.Record(Stdout, []byte("out\ntoo long\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stderr, []byte("err\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+
+	// The stdin echo is not a line of stdout
+	stats := rec.Stats()
+	if stats.Lines != [3]uint64{1, 2, 1} || stats.TruncatedLines != 1 || stats.Errors != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.LastOutput.Before(before) {
+		t.Errorf("expected the last output after %v, got %v", before, stats.LastOutput)
+	}
+
+	// A failed write is counted
+	sink.err = errors.New("disk full")
+	if err := rec.Record(Stdout, []byte("lost\n")); err == nil {
+		t.Fatal("expected a write error")
+	}
+	if stats := rec.Stats(); stats.Errors != 1 {
+		t.Errorf("expected 1 error, got %d", stats.Errors)
+	}
+}
+
+func TestRecorder_RecordAfterClose(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("before\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("after\n")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Record after Close: expected ErrClosed, got %v", err)
+	}
+	if err := rec.RecordMeta("late", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("RecordMeta after Close: expected ErrClosed, got %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if lines := bytes.Split(bytes.TrimSpace(content), []byte("\n")); len(lines) != 1 {
+		t.Errorf("expected 1 record, got %d: %s", len(lines), content)
+	}
+}
+
+func TestRecorder_StrictOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{
+			name: "default",
+			want: []string{"stdin:answer", "stderr:warning", "stdout:prompt> done"},
+		},
+		{
+			name: "strict order",
+			opts: []Option{WithStrictOrder()},
+			want: []string{"stdout:prompt> ", "stdin:answer", "stderr:warning", "stdout:done"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+			rec, err := NewFileRecorder(filename, 0, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+
+			chunks := []struct {
+				source Source
+				data   string
+			}{
+				{Stdout, "prompt> "},
+				{Stdin, "answer\n"},
+				{Stderr, "warning\n"},
+				{Stdout, "done\n"},
+			}
+			for _, chunk := range chunks {
+				if err := rec.Record(chunk.source, []byte(chunk.data)); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+
+			var got []string
+			for _, line := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+				var record Record
+				if err := json.Unmarshal(line, &record); err != nil {
+					t.Fatalf("failed to parse record: %v", err)
+				}
+				got = append(got, record.Source+":"+record.ContentString())
+			}
+
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// blockingWriter blocks every Write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestRecorder_StrictOrderRecordsBeforeForwarding(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, WithStrictOrder())
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// The stdout destination is stuck, but its data must still be recorded
+	// before the stderr data read later
+	writer := &blockingWriter{release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- rec.CopyAndRecord(Stdout, strings.NewReader("first\n"), writer)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := rec.Record(Stderr, []byte("second\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	close(writer.release)
+	if err := <-done; err != nil {
+		t.Fatalf("CopyAndRecord failed: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 2 || !bytes.Contains(lines[0], []byte(`"first"`)) || !bytes.Contains(lines[1], []byte(`"second"`)) {
+		t.Errorf("expected first then second, got:\n%s", content)
+	}
+}
+
+func TestRecorder_ChunkMode(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithSourceMode(Stdin, ChunkMode))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// Every read of stdin is a record, whatever newlines it has
+	stdin := &chunkedReader{chunks: []string{"\x00\x01\x02", "\x03\n\x04", "\x05\n"}}
+	if err := rec.CopyAndRecord(Stdin, stdin, io.Discard); err != nil {
+		t.Fatalf("CopyAndRecord failed: %v", err)
+	}
+	// Stdout is still recorded line by line
+	stdout := &chunkedReader{chunks: []string{"one\ntw", "o\n"}}
+	if err := rec.CopyAndRecord(Stdout, stdout, io.Discard); err != nil {
+		t.Fatalf("CopyAndRecord failed: %v", err)
+	}
+	stats := rec.Stats()
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var got []string
+	for _, record := range sinkRecords(t, sink) {
+		data, err := record.Bytes()
+		if err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		got = append(got, record.Source+":"+string(data)+record.End)
+	}
+	want := []string{"stdin:\x00\x01\x02", "stdin:\x03\n\x04", "stdin:\x05\n", "stdout:one\n", "stdout:two\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected records %q, got %q", want, got)
+	}
+	if stats.Lines[Stdin] != 3 || stats.Bytes[Stdin] != 8 {
+		t.Errorf("expected 3 stdin lines of 8 bytes, got %d lines of %d bytes", stats.Lines[Stdin], stats.Bytes[Stdin])
+	}
+}
+
+func TestRecorder_ChunkModeLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want []string // content and end of each record, "!" marking truncated and "+" continued records
+	}{
+		{"truncated", nil, []string{"!ab\ncd"}},
+		{"tail truncated", []Option{WithTailTruncation()}, []string{"!f\ngh\n"}},
+		{"split", []Option{WithSplitLongLines()}, []string{"ab\ncd", "+ef\ngh", "+\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			opts := append([]Option{WithSourceMode(Stdin, ChunkMode)}, tt.opts...)
+			rec, err := NewRecorder(sink, 5, opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			if err := rec.Record(Stdin, []byte("ab\ncdef\ngh\n")); err != nil {
+				t.Fatalf("failed to record: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			var got []string
+			for _, record := range sinkRecords(t, sink) {
+				data, err := record.Bytes()
+				if err != nil {
+					t.Fatalf("failed to decode record: %v", err)
+				}
+				part := string(data) + record.End
+				if record.Truncated {
+					part = "!" + part
+				}
+				if record.Continued {
+					part = "+" + part
+				}
+				got = append(got, part)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected records %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRecorder_LenOfTruncatedLines(t *testing.T) {
+	long := strings.Repeat("x", 30)
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		chunks []string
+		want   []int // len of each record
+	}{
+		{"head", nil, []string{long + "\n"}, []int{31}},
+		{"head across chunks", nil, []string{long[:5], long[5:20], long[20:] + "\r", "\nok\n"}, []int{32, 3}},
+		{"head flushed at close", nil, []string{long}, []int{30}},
+		{"tail", []Option{WithTailTruncation()}, []string{long[:12], long[12:] + "\r\n"}, []int{32}},
+		{"binary", []Option{WithBinaryLimit(4)}, []string{"\xff" + long[:9], "\n"}, []int{11}},
+		{"split", []Option{WithSplitLongLines()}, []string{long[:25] + "\n"}, []int{10, 10, 6}},
+		{"chunk", []Option{WithSourceMode(Stdout, ChunkMode)}, []string{long, "a\nb"}, []int{30, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			rec, err := NewRecorder(sink, 10, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			for _, chunk := range tt.chunks {
+				if err := rec.Record(Stdout, []byte(chunk)); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.FlushAll(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+
+			var got []int
+			for _, record := range sinkRecords(t, sink) {
+				got = append(got, record.Len)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected lens %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRecorder_Deltas(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithDeltas(), WithStdinEcho(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	steps := []struct {
+		after  time.Duration // since start
+		source Source
+		data   string
+	}{
+		{0, Stdout, "a\n"},
+		{150 * time.Millisecond, Stderr, "e\n"},
+		{250 * time.Millisecond, Stdout, "b\n"},
+		{250 * time.Millisecond, Stdout, "c\n"},
+		{400 * time.Millisecond, Stdin, "in\n"},
+		{1250 * time.Millisecond, Stdout, "par"},
+		{1300 * time.Millisecond, Stdout, "tial\n"},
+		{1300*time.Millisecond + 999*time.Microsecond, Stderr, "f\n"},
+	}
+	for _, step := range steps {
+		now = start.Add(step.after)
+		if err := rec.Record(step.source, []byte(step.data)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// A line is timed when it is complete, and the stdin echo has no delta
+	want := []string{
+		"stdout a <none>",
+		"stderr e <none>",
+		"stdout b 250",
+		"stdout c 0",
+		"stdin in <none>",
+		"stdout in <none>",
+		"stdout partial 1050",
+		"stderr f 1150",
+	}
+	var got []string
+	for _, record := range sinkRecords(t, sink) {
+		delta := "<none>"
+		if record.DeltaMS != nil {
+			delta = fmt.Sprint(*record.DeltaMS)
+		}
+		got = append(got, fmt.Sprintf("%s %v %s", record.Source, record.Content, delta))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected records %q, got %q", want, got)
+	}
+
+	// Without WithDeltas, there is no delta_ms
+	sink = &memorySink{}
+	rec, err = NewRecorder(sink, 0, WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("a\nb\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	for _, line := range sink.writes {
+		if bytes.Contains(line, []byte("delta_ms")) {
+			t.Errorf("expected no delta_ms without WithDeltas, got %s", line)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// StdinReconstructor returns the bytes ioetap forwarded to the child's stdin,
+// rebuilt from the stdin records among records (see reconstruct).
+func StdinReconstructor(records []Record) ([]byte, error) {
+	return reconstruct(records, Stdin)
+}
+
+// StdoutReconstructor returns the bytes the child wrote to stdout, rebuilt
+// from the stdout records among records (see reconstruct).
+func StdoutReconstructor(records []Record) ([]byte, error) {
+	return reconstruct(records, Stdout)
+}
+
+// StderrReconstructor returns the bytes the child wrote to stderr, rebuilt
+// from the stderr records among records (see reconstruct).
+func StderrReconstructor(records []Record) ([]byte, error) {
+	return reconstruct(records, Stderr)
+}
+
+// reconstruct concatenates the content and the line ending of the records of
+// source in seq order, decoding base64 content, so that the stream is
+// reproduced byte for byte. records may be in any order and are not
+// modified. json records are written as compact JSON, since their original
+// spacing is not recorded. Truncated and lossy records are written as
+// recorded, and the stream is returned along with an error wrapping
+// ErrTruncated or ErrLossy for the first of them.
+func reconstruct(records []Record, source Source) ([]byte, error) {
+	var selected []Record
+	for _, record := range records {
+		if record.Source == source.String() {
+			selected = append(selected, record)
+		}
+	}
+	slices.SortStableFunc(selected, func(a, b Record) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	var stream []byte
+	var incomplete error
+	for _, record := range selected {
+		data, err := streamBytes(record)
+		if err != nil {
+			return nil, fmt.Errorf("seq %d: %w", record.Seq, err)
+		}
+		stream = append(stream, data...)
+		stream = append(stream, record.End...)
+		if incomplete != nil {
+			continue
+		}
+		switch {
+		case record.Truncated:
+			incomplete = fmt.Errorf("seq %d: %w", record.Seq, ErrTruncated)
+		case record.Lossy:
+			incomplete = fmt.Errorf("seq %d: %w", record.Seq, ErrLossy)
+		}
+	}
+	return stream, incomplete
+}
+
+// streamBytes returns the content of a record as it appeared on its stream,
+// without the line ending. Unlike Bytes, json content is not HTML-escaped,
+// since the child did not write it so.
+func streamBytes(record Record) ([]byte, error) {
+	if record.Encoding != "json" {
+		return record.Bytes()
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(record.Content); err != nil {
+		return nil, fmt.Errorf("failed to serialize json content: %w", err)
+	}
+	// Encode terminates the value with a newline
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,135 @@
+package recorder
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// streamChunk is data read from a source.
+type streamChunk struct {
+	source Source
+	data   string
+}
+
+// recordStreams records chunks of data of each source with a Recorder, as
+// ioetap does while a child runs, until all streams end, and returns the
+// records.
+func recordStreams(t *testing.T, chunks []streamChunk, maxLineLength int, opts ...Option) []Record {
+	t.Helper()
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, maxLineLength, opts...)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	for _, chunk := range chunks {
+		if err := rec.Record(chunk.source, []byte(chunk.data)); err != nil {
+			t.Fatalf("failed to record: %v", err)
+		}
+	}
+	// Every stream ends, which records its incomplete line
+	for _, source := range []Source{Stdin, Stdout, Stderr} {
+		if err := rec.Flush(source); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+	}
+	if err := rec.RecordMeta("exit", map[string]any{"code": 0}); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	return sinkRecords(t, sink)
+}
+
+func TestReconstructors(t *testing.T) {
+	chunks := []streamChunk{
+		{Stdin, "print('hi')\r\n"},
+		{Stdout, "hi\n\x00\xff\xfe binary\n"},
+		{Stderr, "warn"},
+		{Stdin, "{\"key\":\"<value>\"}\n[1,2]\n"},
+		{Stdout, "partial "},
+		{Stderr, "ing\r\n\n"},
+		{Stdout, "line\nno newline at the end"},
+		{Stdin, "\xc3"},
+		{Stdin, "\xa9\n"},
+	}
+	var want [3]bytes.Buffer
+	for _, chunk := range chunks {
+		want[chunk.source].WriteString(chunk.data)
+	}
+	records := recordStreams(t, chunks, 0)
+
+	// The order of the records does not matter
+	rand.New(rand.NewSource(1)).Shuffle(len(records), func(i, j int) {
+		records[i], records[j] = records[j], records[i]
+	})
+
+	reconstructors := [3]func([]Record) ([]byte, error){StdinReconstructor, StdoutReconstructor, StderrReconstructor}
+	for source, reconstruct := range reconstructors {
+		got, err := reconstruct(records)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", Source(source), err)
+		}
+		if !bytes.Equal(got, want[source].Bytes()) {
+			t.Errorf("%s: expected %q, got %q", Source(source), want[source].Bytes(), got)
+		}
+	}
+}
+
+func TestReconstructors_SplitLines(t *testing.T) {
+	// Lines split into continued records are joined again
+	line := "0123456789abcdef0123456789\n"
+	chunks := []streamChunk{
+		{Stdout, line},
+		{Stdout, "\xff\xfe\xfd\xfc\xfb\xfa\xf9\xf8\xf7\xf6\xf5\n"},
+	}
+	records := recordStreams(t, chunks, 8, WithSplitLongLines())
+	if len(records) < 6 {
+		t.Fatalf("expected the lines to be split, got %d records", len(records))
+	}
+
+	got, err := StdoutReconstructor(records)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := chunks[0].data + chunks[1].data; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestReconstructors_Incomplete(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	truncated := NewRecord(2, timestamp, "stdout", []byte("long\n"))
+	truncated.Truncated = true
+	records := []Record{
+		NewRecord(1, timestamp, "stdout", []byte("one\n")),
+		truncated,
+		NewLossyRecord(3, timestamp, "stdout", []byte("caf\xe9\n")),
+	}
+
+	// The known bytes are returned along with the first incomplete record
+	got, err := StdoutReconstructor(records)
+	if !errors.Is(err, ErrTruncated) || err.Error() != "seq 2: "+ErrTruncated.Error() {
+		t.Errorf("expected ErrTruncated at seq 2, got %v", err)
+	}
+	if want := "one\nlong\ncaf�\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if _, err := StdoutReconstructor(records[2:]); !errors.Is(err, ErrLossy) {
+		t.Errorf("expected ErrLossy, got %v", err)
+	}
+
+	// Content that cannot be decoded fails
+	invalid := Record{Seq: 4, Source: "stdout", Content: "not base64!", Encoding: "base64"}
+	if _, err := StdoutReconstructor(append(records, invalid)); err == nil || !bytes.Contains([]byte(err.Error()), []byte("seq 4")) {
+		t.Errorf("expected an error at seq 4, got %v", err)
+	}
+
+	// No records of a source is an empty stream
+	if got, err := StdinReconstructor(records); len(got) != 0 || err != nil {
+		t.Errorf("expected an empty stdin stream, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,346 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// Defaults of RetryOptions.
+const (
+	DefaultRetryInitial = 100 * time.Millisecond
+	DefaultRetryCap     = 30 * time.Second
+	DefaultRetryBuffer  = 1 << 20
+)
+
+// RetryOptions configures how remote destinations are retried: by
+// NewRetryWriter, and by the HTTP, OTLP, S3 and Kafka sinks given one in
+// their options. Zero values select the defaults.
+type RetryOptions struct {
+	Initial     time.Duration // Delay before the first retry (default: DefaultRetryInitial)
+	Cap         time.Duration // Maximum delay between attempts (default: DefaultRetryCap)
+	MaxAttempts int           // Failed retries in a row before giving up (0 = never give up)
+	MaxBuffered int           // Bytes of records kept meanwhile by a RetryWriter or an HTTP sink (default: DefaultRetryBuffer)
+}
+
+// withDefaults returns o with its zero values replaced by the defaults.
+func (o RetryOptions) withDefaults() RetryOptions {
+	if o.Initial <= 0 {
+		o.Initial = DefaultRetryInitial
+	}
+	if o.Cap <= 0 {
+		o.Cap = DefaultRetryCap
+	}
+	if o.MaxBuffered <= 0 {
+		o.MaxBuffered = DefaultRetryBuffer
+	}
+	return o
+}
+
+// delay returns the delay before reconnect attempt n (from 0): Initial
+// doubled n times up to Cap, with jitter so that several writers that lost
+// the same destination do not reconnect in lockstep. The delay is between
+// half of that and all of it.
+func (o RetryOptions) delay(n int) time.Duration {
+	d := o.Initial
+	for i := 0; i < n && d < o.Cap; i++ {
+		d *= 2
+	}
+	d = min(d, o.Cap)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// giveUp reports whether to give up after the given number of failed
+// retries in a row.
+func (o RetryOptions) giveUp(retries int) bool {
+	return o.MaxAttempts > 0 && retries >= o.MaxAttempts
+}
+
+// retry calls fn until it succeeds, waiting longer after every failure like
+// a RetryWriter does before reconnecting, and returns the last error once
+// it gave up or stop is closed. With nil opts, fn is called once. It is used
+// by the sinks that send records from a background goroutine, so that a
+// failing request blocks only that goroutine while records queue up.
+func retry(opts *RetryOptions, name string, stop <-chan struct{}, fn func() error) error {
+	err := fn()
+	if err == nil || opts == nil {
+		return err
+	}
+	o := opts.withDefaults()
+	logging.Warnf("%s: %v; retrying", name, err)
+	for retries := 0; !o.giveUp(retries); retries++ {
+		timer := time.NewTimer(o.delay(retries))
+		select {
+		case <-stop:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if err = fn(); err == nil {
+			logging.Infof("%s: recovered", name)
+			return nil
+		}
+	}
+	return fmt.Errorf("gave up after %d retries: %w", o.MaxAttempts, err)
+}
+
+// RetryWriter writes a recording to a connection, reconnecting with
+// exponential backoff when the connection fails. See NewRetryWriter.
+type RetryWriter struct {
+	name    string
+	connect func() (io.WriteCloser, error)
+	opts    RetryOptions
+
+	mu           sync.Mutex
+	conn         io.WriteCloser // nil while disconnected
+	partial      []byte         // incomplete last line
+	pending      [][]byte       // complete lines not delivered yet
+	pendingBytes int
+	dropped      int    // lines dropped since the last delivery
+	droppedSeq   uint64 // seq of the first of them
+	reconnecting bool   // true while the reconnecting goroutine runs
+	err          error  // set when giving up
+	closed       bool
+	stop         chan struct{}  // closed by Close to stop reconnecting
+	wg           sync.WaitGroup // the reconnecting goroutine
+}
+
+// NewRetryWriter returns a writer that writes the records written to it to
+// conn, e.g. a remote destination of the recording. If conn is nil, e.g.
+// because the destination could not be connected to, it starts reconnecting
+// right away. When a write fails, the
+// connection is closed and connect is called to replace it in the
+// background, after a delay that doubles after every failed attempt (see
+// RetryOptions). Writes do not block meanwhile: the records are kept until
+// the connection is back, up to RetryOptions.MaxBuffered bytes, beyond which
+// the oldest are dropped, and the new connection first gets a
+// "records-dropped" meta record with their number, with the seq of the
+// first of them. The write that failed is written again over the new
+// connection, but records written shortly before may be lost with the old
+// one, as TCP reports a broken connection only on a later write.
+//
+// After RetryOptions.MaxAttempts failed attempts, the records are dropped
+// and every later write returns the error. Close stops reconnecting, writes
+// what is left if connected, and closes the connection.
+func NewRetryWriter(name string, conn io.WriteCloser, connect func() (io.WriteCloser, error), opts RetryOptions) *RetryWriter {
+	w := &RetryWriter{
+		name:    name,
+		connect: connect,
+		opts:    opts.withDefaults(),
+		conn:    conn,
+		stop:    make(chan struct{}),
+	}
+	if conn == nil {
+		w.mu.Lock()
+		w.startReconnectLocked()
+		w.mu.Unlock()
+	}
+	return w
+}
+
+// Write implements io.Writer. Records may be split across writes; only
+// complete lines are written to the connection.
+func (w *RetryWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	data := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		w.addLocked(bytes.Clone(data[:i+1]))
+		data = data[i+1:]
+	}
+	w.partial = bytes.Clone(data)
+
+	if w.conn != nil && len(w.pending) > 0 {
+		if err := w.deliverLocked(); err != nil {
+			logging.Warnf("%s: %v; reconnecting", w.name, err)
+			w.startReconnectLocked()
+		}
+	}
+	return len(p), nil
+}
+
+// addLocked adds line to the lines to deliver, dropping the oldest ones
+// beyond MaxBuffered. Must be called with mu held.
+func (w *RetryWriter) addLocked(line []byte) {
+	w.pending = append(w.pending, line)
+	w.pendingBytes += len(line)
+	for w.pendingBytes > w.opts.MaxBuffered && len(w.pending) > 1 {
+		if w.dropped == 0 {
+			var record struct {
+				Seq uint64 `json:"seq"`
+			}
+			_ = json.Unmarshal(w.pending[0], &record)
+			w.droppedSeq = record.Seq
+		}
+		w.dropped++
+		w.pendingBytes -= len(w.pending[0])
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+	}
+}
+
+// deliverLocked writes the pending lines to the connection, preceded by a
+// "records-dropped" meta record if lines were dropped. On failure, the
+// connection is closed and the lines are kept. Must be called with mu held
+// and a connection.
+func (w *RetryWriter) deliverLocked() error {
+	var batch []byte
+	if w.dropped > 0 {
+		notice, err := NewMetaRecord(w.droppedSeq, time.Now(), map[string]any{
+			"event":   "records-dropped",
+			"records": w.dropped,
+		}).ToJSON()
+		if err != nil {
+			return err
+		}
+		batch = append(notice, '\n')
+	}
+	for _, line := range w.pending {
+		batch = append(batch, line...)
+	}
+
+	if _, err := w.conn.Write(batch); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	if w.dropped > 0 {
+		logging.Warnf("%s: dropped %d records while disconnected", w.name, w.dropped)
+	}
+	w.pending = nil
+	w.pendingBytes = 0
+	w.dropped = 0
+	return nil
+}
+
+// startReconnectLocked starts reconnecting in the background unless it is
+// already. Must be called with mu held.
+func (w *RetryWriter) startReconnectLocked() {
+	if w.reconnecting {
+		return
+	}
+	w.reconnecting = true
+	w.wg.Add(1)
+	go w.reconnect()
+}
+
+// reconnect calls connect until it succeeds and the pending lines are
+// delivered, waiting longer after every failure, or until Close is called
+// or MaxAttempts attempts failed.
+func (w *RetryWriter) reconnect() {
+	defer w.wg.Done()
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if w.opts.giveUp(attempt) {
+			w.mu.Lock()
+			w.err = fmt.Errorf("gave up reconnecting after %d attempts: %w", attempt, lastErr)
+			w.pending = nil
+			w.pendingBytes = 0
+			w.reconnecting = false
+			w.mu.Unlock()
+			return
+		}
+
+		timer := time.NewTimer(w.opts.delay(attempt))
+		select {
+		case <-w.stop:
+			timer.Stop()
+			w.mu.Lock()
+			w.reconnecting = false
+			w.mu.Unlock()
+			return
+		case <-timer.C:
+		}
+
+		conn, err := w.connect()
+		w.mu.Lock()
+		if w.closed {
+			w.reconnecting = false
+			w.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			lastErr = err
+			w.mu.Unlock()
+			continue
+		}
+
+		w.conn = conn
+		if err := w.deliverLocked(); err != nil {
+			// Connected but failed again; start over from the initial delay
+			lastErr = err
+			attempt = -1
+			w.mu.Unlock()
+			continue
+		}
+		logging.Infof("%s: reconnected", w.name)
+		w.reconnecting = false
+		w.mu.Unlock()
+		return
+	}
+}
+
+// Close implements io.Closer. It returns an error if records could not be
+// delivered.
+func (w *RetryWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.stop)
+	w.mu.Unlock()
+
+	// The reconnecting goroutine may be waiting for connect
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.addLocked(w.partial)
+		w.partial = nil
+	}
+
+	var errs []error
+	if w.conn != nil {
+		if len(w.pending) > 0 || w.dropped > 0 {
+			if err := w.deliverLocked(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if w.conn != nil {
+			if err := w.conn.Close(); err != nil {
+				errs = append(errs, err)
+			}
+			w.conn = nil
+		}
+	}
+	if n := len(w.pending) + w.dropped; n > 0 {
+		errs = append(errs, fmt.Errorf("%d records were not delivered", n))
+	}
+	return errors.Join(errs...)
+}
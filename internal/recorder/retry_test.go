@@ -0,0 +1,357 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyConn is a connection whose writes fail once err is set.
+type flakyConn struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	err    error
+	closed bool
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errors.New("use of closed connection")
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.buf.Write(p)
+}
+
+func (c *flakyConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *flakyConn) lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lines := strings.SplitAfter(c.buf.String(), "\n")
+	return lines[:len(lines)-1]
+}
+
+// flakyDialer hands out a new flakyConn after failing a number of times,
+// noting when it was called.
+type flakyDialer struct {
+	mu       sync.Mutex
+	failures int // Calls left to fail (-1 = forever)
+	calls    []time.Time
+	conns    []*flakyConn
+}
+
+func (d *flakyDialer) connect() (io.WriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, time.Now())
+	if d.failures != 0 {
+		if d.failures > 0 {
+			d.failures--
+		}
+		return nil, errors.New("connection refused")
+	}
+	conn := &flakyConn{}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *flakyDialer) setFailures(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = n
+}
+
+// waitConnected waits until the writer has a connection again.
+func waitConnected(t *testing.T, w *RetryWriter) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		connected := w.conn != nil && !w.reconnecting
+		w.mu.Unlock()
+		if connected {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the writer to reconnect")
+}
+
+func recordLine(seq int) string {
+	return fmt.Sprintf(`{"seq":%d,"src":"stdout","text":"line %d"}`+"\n", seq, seq)
+}
+
+func TestRetryOptions_Delay(t *testing.T) {
+	opts := RetryOptions{Initial: 100 * time.Millisecond, Cap: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{100, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if d := opts.delay(tt.attempt); d < tt.max/2 || d > tt.max {
+					t.Fatalf("delay(%d) = %v, want between %v and %v", tt.attempt, d, tt.max/2, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryWriter_Backoff(t *testing.T) {
+	first := &flakyConn{}
+	dialer := &flakyDialer{failures: 3}
+	initial := 20 * time.Millisecond
+	w := NewRetryWriter("tcp://logger:5170", first, dialer.connect, RetryOptions{Initial: initial, Cap: time.Second})
+
+	if _, err := io.WriteString(w, recordLine(1)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// The connection breaks; writes keep succeeding while reconnecting
+	first.mu.Lock()
+	first.err = errors.New("connection reset by peer")
+	first.mu.Unlock()
+	start := time.Now()
+	if _, err := io.WriteString(w, recordLine(2)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := io.WriteString(w, recordLine(3)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitConnected(t, w)
+
+	// Every attempt waits at least half of the doubling delay
+	dialer.mu.Lock()
+	calls := dialer.calls
+	dialer.mu.Unlock()
+	if len(calls) != 4 {
+		t.Fatalf("connect was called %d times, want 4", len(calls))
+	}
+	prev := start
+	for i, call := range calls {
+		if min := initial << i / 2; call.Sub(prev) < min {
+			t.Errorf("attempt %d came %v after the previous, want at least %v", i, call.Sub(prev), min)
+		}
+		prev = call
+	}
+
+	if _, err := io.WriteString(w, recordLine(4)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := first.lines(); len(got) != 1 || got[0] != recordLine(1) {
+		t.Errorf("first connection got %q, want record 1", got)
+	}
+	want := []string{recordLine(2), recordLine(3), recordLine(4)}
+	if got := dialer.conns[0].lines(); strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("new connection got %q, want %q", got, want)
+	}
+	if !first.closed || !dialer.conns[0].closed {
+		t.Error("expected both connections to be closed")
+	}
+}
+
+func TestRetryWriter_BoundedBuffering(t *testing.T) {
+	first := &flakyConn{err: errors.New("broken pipe")}
+	dialer := &flakyDialer{failures: -1}
+	maxBuffered := 3 * len(recordLine(1))
+	w := NewRetryWriter("tcp://logger:5170", first, dialer.connect, RetryOptions{
+		Initial:     time.Millisecond,
+		Cap:         5 * time.Millisecond,
+		MaxBuffered: maxBuffered,
+	})
+
+	for seq := 1; seq <= 8; seq++ {
+		// Records may come in pieces
+		line := recordLine(seq)
+		for _, part := range []string{line[:5], line[5:]} {
+			if _, err := io.WriteString(w, part); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+		w.mu.Lock()
+		buffered := w.pendingBytes
+		w.mu.Unlock()
+		if buffered > maxBuffered {
+			t.Fatalf("%d bytes buffered after record %d, want at most %d", buffered, seq, maxBuffered)
+		}
+	}
+
+	dialer.setFailures(0)
+	waitConnected(t, w)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The oldest records were dropped, and the destination is told so first
+	got := dialer.conns[0].lines()
+	if len(got) != 4 {
+		t.Fatalf("new connection got %d lines, want 4: %q", len(got), got)
+	}
+	var notice Record
+	if err := json.Unmarshal([]byte(got[0]), &notice); err != nil {
+		t.Fatalf("failed to parse %q: %v", got[0], err)
+	}
+	content, _ := notice.Content.(map[string]any)
+	if notice.Source != MetaSource || notice.Seq != 1 || content["event"] != "records-dropped" || content["records"] != float64(5) {
+		t.Errorf("unexpected notice %q", got[0])
+	}
+	if want := recordLine(6) + recordLine(7) + recordLine(8); strings.Join(got[1:], "") != want {
+		t.Errorf("new connection got %q, want records 6 to 8", got[1:])
+	}
+}
+
+func TestRetryWriter_GiveUp(t *testing.T) {
+	first := &flakyConn{err: errors.New("broken pipe")}
+	dialer := &flakyDialer{failures: -1}
+	w := NewRetryWriter("tcp://logger:5170", first, dialer.connect, RetryOptions{
+		Initial:     time.Millisecond,
+		Cap:         time.Millisecond,
+		MaxAttempts: 3,
+	})
+
+	if _, err := io.WriteString(w, recordLine(1)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var err error
+	deadline := time.Now().Add(5 * time.Second)
+	for err == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		_, err = io.WriteString(w, recordLine(2))
+	}
+	if err == nil || !strings.Contains(err.Error(), "gave up reconnecting after 3 attempts: connection refused") {
+		t.Fatalf("Write() error = %v, want giving up", err)
+	}
+	if len(dialer.calls) != 3 {
+		t.Errorf("connect was called %d times, want 3", len(dialer.calls))
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestRetryWriter_CloseWhileDisconnected(t *testing.T) {
+	first := &flakyConn{err: errors.New("broken pipe")}
+	dialer := &flakyDialer{failures: -1}
+	w := NewRetryWriter("tcp://logger:5170", first, dialer.connect, RetryOptions{Initial: time.Hour})
+
+	if _, err := io.WriteString(w, recordLine(1)+recordLine(2)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// Close does not wait for the next attempt
+	err := w.Close()
+	if err == nil || err.Error() != "2 records were not delivered" {
+		t.Errorf("Close() error = %v, want 2 records not delivered", err)
+	}
+	if _, err := io.WriteString(w, recordLine(3)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestRetryWriter_StartsDisconnected(t *testing.T) {
+	dialer := &flakyDialer{failures: 2}
+	w := NewRetryWriter("tcp://logger:5170", nil, dialer.connect, RetryOptions{Initial: 10 * time.Millisecond})
+
+	// Records are kept until the destination can be connected to
+	if _, err := io.WriteString(w, recordLine(1)+recordLine(2)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitConnected(t, w)
+	if _, err := io.WriteString(w, recordLine(3)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+	if len(dialer.calls) != 3 || len(dialer.conns) != 1 {
+		t.Fatalf("expected 3 attempts and 1 connection, got %d and %d", len(dialer.calls), len(dialer.conns))
+	}
+	want := []string{recordLine(1), recordLine(2), recordLine(3)}
+	if got := dialer.conns[0].lines(); strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	initial := 20 * time.Millisecond
+	opts := &RetryOptions{Initial: initial, Cap: time.Second, MaxAttempts: 3}
+	stop := make(chan struct{})
+
+	// Intermittent failures are retried with growing delays
+	var calls []time.Time
+	err := retry(opts, "test", stop, func() error {
+		calls = append(calls, time.Now())
+		if len(calls) < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retry() error = %v", err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(calls))
+	}
+	for i := 1; i < len(calls); i++ {
+		if want := initial << (i - 1) / 2; calls[i].Sub(calls[i-1]) < want {
+			t.Errorf("retry %d came after %v, want at least %v", i, calls[i].Sub(calls[i-1]), want)
+		}
+	}
+
+	// It gives up after MaxAttempts failed retries
+	calls = nil
+	unavailable := errors.New("unavailable")
+	err = retry(opts, "test", stop, func() error {
+		calls = append(calls, time.Now())
+		return unavailable
+	})
+	if !errors.Is(err, unavailable) || len(calls) != 4 {
+		t.Errorf("expected to give up after 4 calls, got %d: %v", len(calls), err)
+	}
+
+	// Without options, or once stopped, fn is called once
+	calls = nil
+	close(stop)
+	for _, opts := range []*RetryOptions{nil, {Initial: time.Hour}} {
+		if err := retry(opts, "test", stop, func() error {
+			calls = append(calls, time.Now())
+			return unavailable
+		}); err != unavailable {
+			t.Errorf("retry() error = %v, want %v", err, unavailable)
+		}
+	}
+	if len(calls) != 2 {
+		t.Errorf("expected 1 call each, got %d", len(calls))
+	}
+}
@@ -0,0 +1,349 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustin/ioetap/internal/logging"
+)
+
+// ObjectStore stores objects in a bucket of an object storage such as S3.
+// The bucket and credentials are configured by the store. S3Client
+// implements it for S3 and S3-compatible services.
+type ObjectStore interface {
+	// PutObject creates or replaces the object at key with body.
+	PutObject(ctx context.Context, key string, body []byte) error
+	// CreateMultipartUpload starts an upload of the object at key in parts
+	// and returns its ID.
+	CreateMultipartUpload(ctx context.Context, key string) (uploadID string, err error)
+	// UploadPart uploads part number (from 1) of an upload and returns its
+	// ETag.
+	UploadPart(ctx context.Context, key, uploadID string, number int, body []byte) (etag string, err error)
+	// UploadPartCopy uploads part number (from 1) of an upload as a copy of
+	// length bytes from offset of the existing object at sourceKey, without
+	// sending them, and returns its ETag.
+	UploadPartCopy(ctx context.Context, key, uploadID string, number int, sourceKey string, offset, length int64) (etag string, err error)
+	// CompleteMultipartUpload creates the object at key from the parts with
+	// the given ETags, in order.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error
+	// AbortMultipartUpload discards an upload and its parts.
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+// S3SinkOptions configures an S3 sink.
+type S3SinkOptions struct {
+	PartSize           int           // Bytes per uploaded part (0 = DefaultS3PartSize); S3 rejects parts under 5 MiB except the last
+	CheckpointInterval time.Duration // Store the recording so far after this long (0 = 30s)
+	MaxBuffered        int           // Bytes waiting to be uploaded before new records are dropped (0 = 8 parts)
+	Retry              *RetryOptions // Retry a failed request with backoff (nil = fail)
+}
+
+// DefaultS3PartSize is the size of the parts the S3 sink uploads unless
+// given otherwise. S3 rejects parts under 5 MiB, except the last one.
+const DefaultS3PartSize = 8 * 1024 * 1024
+
+// S3 sink defaults and limits.
+const (
+	defaultS3CheckpointInterval = 30 * time.Second
+	defaultS3MaxBufferedParts   = 8
+	s3MaxCopyPartSize           = 5 * 1024 * 1024 * 1024 // Largest part S3 copies
+)
+
+// s3Sink is a Sink that uploads the recording to an object store in parts.
+type s3Sink struct {
+	store       ObjectStore
+	key         string
+	partSize    int
+	interval    time.Duration
+	maxBuffered int
+	retry       *RetryOptions
+
+	kick chan struct{} // wakes the upload goroutine up
+	stop chan struct{} // closed by Close to stop retrying
+	done chan struct{} // closed when the upload goroutine exits
+
+	mu         sync.Mutex
+	buf        []byte // written, not yet taken by the upload goroutine
+	checkpoint bool   // a checkpoint was requested
+	closed     bool
+	dropped    uint64 // records dropped since the last one written
+	droppedSeq uint64 // seq of the first of them
+	err        error  // first upload error; nothing is uploaded after it
+	reported   bool   // err was returned from Write or Flush
+
+	// Owned by the upload goroutine
+	pending      []byte   // taken, not yet uploaded; the whole recording until a part is uploaded
+	multipart    bool     // a part was uploaded, so pending only has what came after
+	uploadID     string   // ID of the multipart upload in progress (empty = none)
+	etags        []string // ETags of its parts
+	uploaded     int64    // bytes in its parts
+	stored       int64    // size of the object completed by the last checkpoint (0 = none)
+	dirty        bool     // written since the last checkpoint
+	checkpointed bool     // the object exists
+}
+
+// NewS3Sink returns a Sink that uploads the recording to store at key.
+// Object storage cannot append, so the recording is uploaded with multipart
+// uploads by a background goroutine, so that recording never waits for the
+// store: every opts.PartSize bytes are uploaded as a part once written.
+//
+// So that a partial recording survives ioetap being killed, the recording so
+// far is stored as the object at key on every checkpoint: when the Recorder
+// flushes and every opts.CheckpointInterval. A checkpoint uploads the rest
+// as the last part and completes the upload; the next upload starts with a
+// server-side copy of the object, so nothing is uploaded twice. A
+// recording smaller than a part is put as a whole instead, and Close does a
+// last checkpoint, which creates the object even if empty.
+//
+// Writes never block: if the store falls opts.MaxBuffered bytes behind,
+// records are dropped, and the next record written is preceded by a
+// "records-dropped" meta record with their number and the seq of the
+// first of them. With opts.Retry, a request that fails is sent again with
+// backoff while records are buffered. After an upload fails for good,
+// nothing more is uploaded, the upload in progress is aborted, the object
+// keeps the last checkpoint, and Write, Flush and Close return the error.
+func NewS3Sink(store ObjectStore, key string, opts S3SinkOptions) Sink {
+	if opts.PartSize <= 0 {
+		opts.PartSize = DefaultS3PartSize
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = defaultS3CheckpointInterval
+	}
+	if opts.MaxBuffered <= 0 {
+		opts.MaxBuffered = defaultS3MaxBufferedParts * opts.PartSize
+	}
+	s := &s3Sink{
+		store:       store,
+		key:         key,
+		partSize:    opts.PartSize,
+		interval:    opts.CheckpointInterval,
+		maxBuffered: opts.MaxBuffered,
+		retry:       opts.Retry,
+		kick:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go s.upload()
+	return s
+}
+
+// Write implements Sink.
+func (s *s3Sink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		s.reported = true
+		return 0, s.err
+	}
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if len(s.buf)+len(p) > s.maxBuffered {
+		var record struct {
+			Seq uint64 `json:"seq"`
+		}
+		json.Unmarshal(p, &record)
+		if s.dropped == 0 {
+			s.droppedSeq = record.Seq
+			logging.Warnf("%s: dropping records while the upload falls behind", s.key)
+		}
+		s.dropped++
+		return len(p), nil
+	}
+	if s.dropped > 0 {
+		notice, err := NewMetaRecord(s.droppedSeq, time.Now(), map[string]any{
+			"event":   "records-dropped",
+			"records": s.dropped,
+		}).ToJSON()
+		if err == nil {
+			s.buf = append(append(s.buf, notice...), '\n')
+		}
+		s.dropped = 0
+	}
+
+	s.buf = append(s.buf, p...)
+	if len(s.buf) >= s.partSize {
+		s.wake()
+	}
+	return len(p), nil
+}
+
+// Flush implements Sink. It requests a checkpoint without waiting for it.
+func (s *s3Sink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		s.reported = true
+		return s.err
+	}
+	s.checkpoint = true
+	s.wake()
+	return nil
+}
+
+// Close implements Sink. It waits for the last checkpoint, and returns the
+// upload error unless Write or Flush returned it already.
+func (s *s3Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.wake()
+	s.mu.Unlock()
+
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reported {
+		return nil
+	}
+	return s.err
+}
+
+// wake wakes the upload goroutine up unless it is already. Must be called
+// with mu held.
+func (s *s3Sink) wake() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// upload runs in the background and uploads what is written until the sink
+// is closed.
+func (s *s3Sink) upload() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		var tick bool
+		select {
+		case <-s.kick:
+		case <-ticker.C:
+			tick = true
+		}
+
+		s.mu.Lock()
+		data, checkpoint, closed := s.buf, s.checkpoint || tick, s.closed
+		s.buf, s.checkpoint = nil, false
+		s.mu.Unlock()
+
+		s.pending = append(s.pending, data...)
+		s.dirty = s.dirty || len(data) > 0
+		// Both pick up where a failed attempt left off
+		err := retry(s.retry, s.key, s.stop, func() error {
+			if err := s.uploadParts(); err != nil {
+				return err
+			}
+			if (checkpoint || closed) && (s.dirty || !s.checkpointed) {
+				return s.storeCheckpoint()
+			}
+			return nil
+		})
+		if err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			if abortErr := s.abort(); abortErr != nil {
+				logging.Warnf("%v", abortErr)
+			}
+			return
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// uploadParts uploads the full parts of pending.
+func (s *s3Sink) uploadParts() error {
+	for len(s.pending) >= s.partSize {
+		if err := s.uploadPart(s.pending[:s.partSize]); err != nil {
+			return err
+		}
+		s.pending = s.pending[s.partSize:]
+	}
+	return nil
+}
+
+// storeCheckpoint stores the recording so far as the object: it puts the
+// recording as a whole if no part was uploaded, and otherwise uploads the
+// rest as the last part and completes the upload.
+func (s *s3Sink) storeCheckpoint() error {
+	ctx := context.Background()
+	if !s.multipart {
+		if err := s.store.PutObject(ctx, s.key, s.pending); err != nil {
+			return fmt.Errorf("failed to upload recording to %s: %w", s.key, err)
+		}
+		s.dirty, s.checkpointed = false, true
+		return nil
+	}
+
+	if len(s.pending) > 0 {
+		if err := s.uploadPart(s.pending); err != nil {
+			return err
+		}
+		s.pending = nil
+	}
+	if err := s.store.CompleteMultipartUpload(ctx, s.key, s.uploadID, s.etags); err != nil {
+		return fmt.Errorf("failed to complete upload of recording to %s: %w", s.key, err)
+	}
+	s.stored = s.uploaded
+	s.uploadID, s.etags, s.uploaded = "", nil, 0
+	s.dirty, s.checkpointed = false, true
+	return nil
+}
+
+// uploadPart uploads data as the next part, starting a multipart upload
+// first if needed. A new upload starts with a copy of the object stored by
+// the last checkpoint, if any.
+func (s *s3Sink) uploadPart(data []byte) error {
+	ctx := context.Background()
+	if s.uploadID == "" {
+		uploadID, err := s.store.CreateMultipartUpload(ctx, s.key)
+		if err != nil {
+			return fmt.Errorf("failed to start upload of recording to %s: %w", s.key, err)
+		}
+		s.uploadID = uploadID
+	}
+	for s.uploaded < s.stored {
+		length := min(s.stored-s.uploaded, s3MaxCopyPartSize)
+		etag, err := s.store.UploadPartCopy(ctx, s.key, s.uploadID, len(s.etags)+1, s.key, s.uploaded, length)
+		if err != nil {
+			return fmt.Errorf("failed to copy part %d of recording to %s: %w", len(s.etags)+1, s.key, err)
+		}
+		s.etags = append(s.etags, etag)
+		s.uploaded += length
+	}
+	etag, err := s.store.UploadPart(ctx, s.key, s.uploadID, len(s.etags)+1, data)
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d of recording to %s: %w", len(s.etags)+1, s.key, err)
+	}
+	s.etags = append(s.etags, etag)
+	s.uploaded += int64(len(data))
+	s.multipart = true
+	return nil
+}
+
+// abort discards the multipart upload in progress, if any, so that its
+// parts are not kept around. The object keeps the last checkpoint.
+func (s *s3Sink) abort() error {
+	if s.uploadID == "" {
+		return nil
+	}
+	uploadID := s.uploadID
+	s.uploadID = ""
+	if err := s.store.AbortMultipartUpload(context.Background(), s.key, uploadID); err != nil {
+		return fmt.Errorf("failed to abort upload of recording to %s: %w", s.key, err)
+	}
+	return nil
+}
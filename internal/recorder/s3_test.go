@@ -0,0 +1,368 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps the objects put to it in memory.
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string][][]byte // parts of the multipart uploads in progress, by ID
+	calls   []string
+	partErr error         // returned by UploadPart if set
+	err     error         // returned by the other calls if set
+	gate    chan struct{} // PutObject waits for it to be closed if set
+}
+
+func (s *memoryStore) PutObject(ctx context.Context, key string, body []byte) error {
+	s.mu.Lock()
+	s.calls = append(s.calls, "put")
+	gate := s.gate
+	s.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putLocked(key, body)
+}
+
+func (s *memoryStore) putLocked(key string, body []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.objects == nil {
+		s.objects = map[string][]byte{}
+	}
+	s.objects[key] = bytes.Clone(body)
+	return nil
+}
+
+func (s *memoryStore) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "create")
+	if s.err != nil {
+		return "", s.err
+	}
+	if s.uploads == nil {
+		s.uploads = map[string][][]byte{}
+	}
+	uploadID := fmt.Sprintf("upload-%d", len(s.calls))
+	s.uploads[uploadID] = nil
+	return uploadID, nil
+}
+
+func (s *memoryStore) UploadPart(ctx context.Context, key, uploadID string, number int, body []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, fmt.Sprintf("part %d", number))
+	if s.partErr != nil {
+		return "", s.partErr
+	}
+	return s.addPartLocked(uploadID, number, body)
+}
+
+func (s *memoryStore) UploadPartCopy(ctx context.Context, key, uploadID string, number int, sourceKey string, offset, length int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, fmt.Sprintf("copy %d", number))
+	if s.err != nil {
+		return "", s.err
+	}
+	source, ok := s.objects[sourceKey]
+	if !ok || offset+length > int64(len(source)) {
+		return "", fmt.Errorf("no %d bytes at %d in %s", length, offset, sourceKey)
+	}
+	return s.addPartLocked(uploadID, number, source[offset:offset+length])
+}
+
+func (s *memoryStore) addPartLocked(uploadID string, number int, body []byte) (string, error) {
+	if len(s.uploads[uploadID]) != number-1 {
+		return "", fmt.Errorf("unexpected part %d", number)
+	}
+	s.uploads[uploadID] = append(s.uploads[uploadID], bytes.Clone(body))
+	return fmt.Sprintf(`"etag-%d"`, number), nil
+}
+
+func (s *memoryStore) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "complete")
+	if s.err != nil {
+		return s.err
+	}
+	parts := s.uploads[uploadID]
+	if len(etags) != len(parts) {
+		return fmt.Errorf("%d etags for %d parts", len(etags), len(parts))
+	}
+	delete(s.uploads, uploadID)
+	return s.putLocked(key, bytes.Join(parts, nil))
+}
+
+func (s *memoryStore) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "abort")
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// waitCalls waits until the calls to the store are want.
+func (s *memoryStore) waitCalls(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		got := fmt.Sprint(s.calls)
+		s.mu.Unlock()
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected calls %s, got %s", want, got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// object returns the object at key.
+func (s *memoryStore) object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+func TestS3Sink(t *testing.T) {
+	store := &memoryStore{}
+	rec, err := NewRecorder(NewS3Sink(store, "recordings/sh.jsonl", S3SinkOptions{PartSize: 1024, CheckpointInterval: time.Hour}), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// A flush below the part size puts the recording as a whole
+	if err := rec.Record(Stdout, []byte("first\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	store.waitCalls(t, "[put]")
+
+	// Writing past the part size uploads the new data as parts, and a flush
+	// completes the upload with the rest as the last part
+	if err := rec.Record(Stdout, bytes.Repeat([]byte("x"), 2500)); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Record(Stdout, []byte("\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	store.waitCalls(t, "[put create part 1 part 2 part 3 complete]")
+	checkpoint, _ := store.object("recordings/sh.jsonl")
+
+	// The next upload starts with a copy of the object, so that nothing is
+	// uploaded twice
+	if err := rec.Record(Stderr, []byte("last\n")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	store.waitCalls(t, "[put create part 1 part 2 part 3 complete create copy 1 part 2 complete]")
+
+	// The object is the complete recording
+	object, _ := store.object("recordings/sh.jsonl")
+	if !bytes.HasPrefix(object, checkpoint) {
+		t.Errorf("expected the object to start with the last checkpoint")
+	}
+	lines := bytes.Split(bytes.TrimSpace(object), []byte("\n"))
+	if len(lines) != 3 || !bytes.Contains(lines[0], []byte(`"first"`)) || !bytes.Contains(lines[2], []byte(`"last"`)) {
+		t.Errorf("unexpected object content:\n%s", object)
+	}
+}
+
+func TestS3Sink_Checkpoint(t *testing.T) {
+	// The recording so far is stored every interval, so that the object
+	// exists if ioetap is killed before Close
+	store := &memoryStore{}
+	sink := NewS3Sink(store, "sh.jsonl", S3SinkOptions{PartSize: 16, CheckpointInterval: 10 * time.Millisecond})
+	defer sink.Close()
+
+	waitObject := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if object, _ := store.object("sh.jsonl"); string(object) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				object, _ := store.object("sh.jsonl")
+				t.Fatalf("expected the object %q, got %q", want, object)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if _, err := sink.Write([]byte("one\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	waitObject("one\n")
+
+	// Also once parts are uploaded
+	if _, err := sink.Write([]byte("two, past the part size\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	waitObject("one\ntwo, past the part size\n")
+	if _, err := sink.Write([]byte("three\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	waitObject("one\ntwo, past the part size\nthree\n")
+}
+
+func TestS3Sink_SmallRecording(t *testing.T) {
+	// A recording smaller than a part is put as a whole, even if empty
+	store := &memoryStore{}
+	sink := NewS3Sink(store, "sh.jsonl", S3SinkOptions{})
+	if _, err := sink.Write([]byte("line\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	if err := NewS3Sink(store, "empty.jsonl", S3SinkOptions{}).Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	store.waitCalls(t, "[put put]")
+	if object, _ := store.object("sh.jsonl"); string(object) != "line\n" {
+		t.Errorf("unexpected object content %q", object)
+	}
+	if object, ok := store.object("empty.jsonl"); !ok || len(object) != 0 {
+		t.Errorf("expected an empty object for an empty recording, got %q (exists: %v)", object, ok)
+	}
+}
+
+func TestS3Sink_DropsRecords(t *testing.T) {
+	// Writes do not wait for a slow store
+	store := &memoryStore{gate: make(chan struct{})}
+	sink := NewS3Sink(store, "sh.jsonl", S3SinkOptions{PartSize: 1024, CheckpointInterval: time.Hour, MaxBuffered: 200})
+	line := func(seq int) []byte {
+		return []byte(fmt.Sprintf(`{"seq":%d,"source":"stdout","content":"%s"}`+"\n", seq, bytes.Repeat([]byte("x"), 40)))
+	}
+	if _, err := sink.Write(line(0)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	store.waitCalls(t, "[put]")
+	for seq := 1; seq <= 5; seq++ {
+		if _, err := sink.Write(line(seq)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	// The records beyond MaxBuffered are dropped, which the next record
+	// written tells
+	close(store.gate)
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	store.waitCalls(t, "[put put]")
+	if _, err := sink.Write(line(6)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	object, _ := store.object("sh.jsonl")
+	lines := bytes.Split(bytes.TrimSpace(object), []byte("\n"))
+	var seqs []uint64
+	var notice Record
+	for _, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse %s: %v", line, err)
+		}
+		if record.Source == MetaSource {
+			notice = record
+		}
+		seqs = append(seqs, record.Seq)
+	}
+	if got := fmt.Sprint(seqs); got != "[0 1 2 3 6]" {
+		t.Errorf("expected records 0 to 2, a notice from seq 3 and record 6, got seqs %s", got)
+	}
+	content, _ := notice.Content.(map[string]any)
+	if content["event"] != "records-dropped" || content["records"] != float64(3) {
+		t.Errorf("expected a records-dropped meta record for 3 records, got %v", notice.Content)
+	}
+}
+
+func TestS3Sink_UploadError(t *testing.T) {
+	storeErr := errors.New("access denied")
+
+	store := &memoryStore{err: storeErr}
+	sink := NewS3Sink(store, "sh.jsonl", S3SinkOptions{})
+	if _, err := sink.Write([]byte("line\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Close(); !errors.Is(err, storeErr) {
+		t.Errorf("expected the store error, got %v", err)
+	}
+
+	// A failed part fails the next write, and the upload is discarded
+	store = &memoryStore{partErr: storeErr}
+	sink = NewS3Sink(store, "sh.jsonl", S3SinkOptions{PartSize: 4})
+	if _, err := sink.Write([]byte("line\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	store.waitCalls(t, "[create part 1 abort]")
+	if _, err := sink.Write([]byte("line\n")); !errors.Is(err, storeErr) {
+		t.Errorf("expected the store error, got %v", err)
+	}
+	if err := sink.Flush(); !errors.Is(err, storeErr) {
+		t.Errorf("expected Flush to return the store error, got %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("expected Close not to return the error again, got %v", err)
+	}
+	if _, ok := store.object("sh.jsonl"); ok {
+		t.Error("expected no object after a failed upload")
+	}
+}
+
+func TestS3Sink_Retry(t *testing.T) {
+	store := &memoryStore{partErr: errors.New("slow down")}
+	sink := NewS3Sink(store, "sh.jsonl", S3SinkOptions{PartSize: 4, Retry: &RetryOptions{Initial: 50 * time.Millisecond}})
+	if _, err := sink.Write([]byte("line\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	// The failed part is uploaded again once the store recovers
+	store.waitCalls(t, "[create part 1]")
+	store.mu.Lock()
+	store.partErr = nil
+	store.mu.Unlock()
+	store.waitCalls(t, "[create part 1 part 1]")
+	if _, err := sink.Write([]byte("more\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	if object, _ := store.object("sh.jsonl"); string(object) != "line\nmore\n" {
+		t.Errorf("expected the whole recording, got %q", object)
+	}
+}
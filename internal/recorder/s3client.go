@@ -0,0 +1,319 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3ClientOptions configures an S3Client.
+type S3ClientOptions struct {
+	Bucket          string       // Bucket of the objects
+	Region          string       // Region of the bucket (empty = "us-east-1")
+	Endpoint        string       // URL of an S3-compatible service, e.g. "http://localhost:9000" (empty = AWS)
+	AccessKeyID     string       // Access key ID of the credentials
+	SecretAccessKey string       // Secret access key of the credentials
+	SessionToken    string       // Session token of temporary credentials (empty = none)
+	Client          *http.Client // Client for the requests (nil = a client with a 60s timeout)
+}
+
+// defaultS3Region is the region of a bucket unless given otherwise.
+const defaultS3Region = "us-east-1"
+
+// S3Client is an ObjectStore that calls the S3 REST API directly, signing
+// the requests with AWS Signature Version 4, so that ioetap does not depend
+// on the AWS SDK.
+type S3Client struct {
+	opts S3ClientOptions
+	base *url.URL // URL of the bucket, without a trailing slash
+	now  func() time.Time
+}
+
+// NewS3Client returns an S3Client for the bucket in opts. Objects are
+// addressed virtual-hosted style on AWS, i.e. at
+// https://<bucket>.s3.<region>.amazonaws.com/<key>, and path style on
+// opts.Endpoint, i.e. at <endpoint>/<bucket>/<key>, which S3-compatible
+// services such as MinIO support.
+func NewS3Client(opts S3ClientOptions) (*S3Client, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("no bucket specified")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("no credentials specified")
+	}
+	if opts.Region == "" {
+		opts.Region = defaultS3Region
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: time.Minute}
+	}
+
+	var base *url.URL
+	if opts.Endpoint == "" {
+		base = &url.URL{Scheme: "https", Host: opts.Bucket + ".s3." + opts.Region + ".amazonaws.com"}
+	} else {
+		endpoint, err := url.Parse(opts.Endpoint)
+		if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return nil, fmt.Errorf("invalid endpoint: %s", opts.Endpoint)
+		}
+		base = &url.URL{
+			Scheme: endpoint.Scheme,
+			Host:   endpoint.Host,
+			Path:   strings.TrimSuffix(endpoint.Path, "/") + "/" + opts.Bucket,
+		}
+	}
+	return &S3Client{opts: opts, base: base, now: time.Now}, nil
+}
+
+// PutObject implements ObjectStore.
+func (c *S3Client) PutObject(ctx context.Context, key string, body []byte) error {
+	_, _, err := c.do(ctx, http.MethodPut, key, nil, body)
+	return err
+}
+
+// CreateMultipartUpload implements ObjectStore.
+func (c *S3Client) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	_, respBody, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(respBody, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid response to CreateMultipartUpload: %q", respBody)
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart implements ObjectStore.
+func (c *S3Client) UploadPart(ctx context.Context, key, uploadID string, number int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	header, _, err := c.do(ctx, http.MethodPut, key, query, body)
+	if err != nil {
+		return "", err
+	}
+	etag := header.Get("ETag")
+	if etag == "" {
+		return "", errors.New("no ETag in the response to UploadPart")
+	}
+	return etag, nil
+}
+
+// UploadPartCopy implements ObjectStore.
+func (c *S3Client) UploadPartCopy(ctx context.Context, key, uploadID string, number int, sourceKey string, offset, length int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	header := http.Header{
+		"X-Amz-Copy-Source":       {s3Escape("/"+c.opts.Bucket+"/"+sourceKey, false)},
+		"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)},
+	}
+	_, respBody, err := c.doWithHeader(ctx, http.MethodPut, key, query, header, nil)
+	if err != nil {
+		return "", err
+	}
+	// S3 may report a failure after it sent 200 OK, in the body
+	if err := s3ResponseError("200 OK", respBody); err != nil {
+		return "", err
+	}
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+	if err := xml.Unmarshal(respBody, &result); err != nil || result.ETag == "" {
+		return "", fmt.Errorf("invalid response to UploadPartCopy: %q", respBody)
+	}
+	return result.ETag, nil
+}
+
+// s3CompletedPart is a part in the request of CompleteMultipartUpload.
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// CompleteMultipartUpload implements ObjectStore.
+func (c *S3Client) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	request := struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		request.Parts = append(request.Parts, s3CompletedPart{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, respBody, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	// S3 may report a failure after it sent 200 OK, in the body
+	return s3ResponseError("200 OK", respBody)
+}
+
+// AbortMultipartUpload implements ObjectStore.
+func (c *S3Client) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, _, err := c.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	return err
+}
+
+// do sends a signed request for the object at key and returns the header
+// and the body of a successful response.
+func (c *S3Client) do(ctx context.Context, method, key string, query url.Values, body []byte) (http.Header, []byte, error) {
+	return c.doWithHeader(ctx, method, key, query, nil, body)
+}
+
+// doWithHeader is like do, with the given request headers, which are
+// signed too.
+func (c *S3Client) doWithHeader(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (http.Header, []byte, error) {
+	u := *c.base
+	u.Path = c.base.Path + "/" + key
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req, body, c.now())
+
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if err := s3ResponseError(resp.Status, respBody); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New(resp.Status)
+	}
+	return resp.Header, respBody, nil
+}
+
+// s3ResponseError returns the error in the XML body of an S3 response, if
+// any.
+func s3ResponseError(status string, body []byte) error {
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &result) != nil || result.XMLName.Local != "Error" {
+		return nil
+	}
+	return fmt.Errorf("%s: %s: %s", status, result.Code, result.Message)
+}
+
+// sign adds the AWS Signature Version 4 of req, whose body is body, as its
+// Authorization header. All headers of req are signed, along with the host.
+func (c *S3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.opts.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + c.opts.SecretAccessKey)
+	for _, part := range []string{date, c.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.opts.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3CanonicalQuery returns the query string of query in the canonical form
+// of Signature Version 4: sorted by name, with names and values escaped,
+// including empty values ("uploads=").
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes every byte of s except the unreserved characters
+// of RFC 3986, and the slash unless escapeSlash is set, as Signature
+// Version 4 requires.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,80 @@
+package recorder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Sink receives the records of a Recorder. Each Write call carries exactly
+// one NDJSON line (a record or the header) including its trailing newline,
+// so message-oriented sinks can map writes to messages.
+// Writes are serialized by the Recorder.
+type Sink interface {
+	io.Writer
+	// Flush delivers any records buffered by the sink. It is called by
+	// Recorder.FlushAll and before Close.
+	Flush() error
+	// Close releases the sink. No writes follow.
+	Close() error
+}
+
+// Syncer is implemented by sinks that can commit the records written to them
+// to stable storage, e.g. with fsync (see Recorder.Sync).
+type Syncer interface {
+	Sync() error
+}
+
+// writerSink is a Sink that buffers records for an io.Writer.
+type writerSink struct {
+	*bufio.Writer
+	closer io.Closer // nil = the caller owns the writer
+	syncer Syncer    // nil = the writer cannot be synced
+}
+
+// NewWriterSink returns a Sink that buffers records and writes them to w.
+// Close flushes the records but does not close w. If w is a Syncer, like
+// *os.File, the sink is a Syncer too.
+func NewWriterSink(w io.Writer) Sink {
+	syncer, _ := w.(Syncer)
+	return &writerSink{Writer: bufio.NewWriter(w), syncer: syncer}
+}
+
+// NewFileSink returns a Sink that creates the file at filename and writes
+// the records to it. Close flushes and closes the file.
+func NewFileSink(filename string) (Sink, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording file: %w", err)
+	}
+	return &writerSink{Writer: bufio.NewWriter(file), closer: file, syncer: file}, nil
+}
+
+// Sync implements Syncer. It flushes the records and syncs the writer.
+func (s *writerSink) Sync() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	if s.syncer == nil {
+		return nil
+	}
+	// Pipes and terminals cannot be synced, and need not be
+	if err := s.syncer.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (s *writerSink) Close() error {
+	err := s.Flush()
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
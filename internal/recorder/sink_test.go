@@ -0,0 +1,256 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memorySink keeps the records written to it in memory.
+type memorySink struct {
+	writes  [][]byte
+	flushes int
+	closed  bool
+	err     error // returned by Write if set
+}
+
+func (s *memorySink) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write after close")
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.writes = append(s.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func (s *memorySink) Flush() error {
+	s.flushes++
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestRecorder_CustomSink(t *testing.T) {
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 0, WithHeader([]byte(`{"$schema":"x"}`)))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if err := rec.Record(Stdout, []byte("one\ntwo\npartial")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.RecordMeta("suspend", nil); err != nil {
+		t.Fatalf("failed to record meta: %v", err)
+	}
+	if err := rec.FlushAll(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if sink.flushes != 1 {
+		t.Errorf("expected FlushAll to flush the sink once, got %d", sink.flushes)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	if !sink.closed || sink.flushes != 2 {
+		t.Errorf("expected Close to flush and close the sink: flushes %d, closed %v", sink.flushes, sink.closed)
+	}
+
+	// Header, one, two, meta, partial: one write per line
+	if len(sink.writes) != 5 {
+		t.Fatalf("expected 5 writes, got %d", len(sink.writes))
+	}
+	if string(sink.writes[0]) != "{\"$schema\":\"x\"}\n" {
+		t.Errorf("unexpected header write %q", sink.writes[0])
+	}
+	want := []string{"one", "two", `{"event":"suspend"}`, "partial"}
+	for i, data := range sink.writes[1:] {
+		if bytes.Count(data, []byte("\n")) != 1 || data[len(data)-1] != '\n' {
+			t.Errorf("write %d is not a single line: %q", i, data)
+		}
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if record.Seq != uint64(i) || record.ContentString() != want[i] {
+			t.Errorf("write %d: expected seq %d %q, got %d %q", i, i, want[i], record.Seq, record.ContentString())
+		}
+	}
+}
+
+func TestRecorder_SinkWriteError(t *testing.T) {
+	sinkErr := errors.New("broker unavailable")
+	sink := &memorySink{err: sinkErr}
+	rec, err := NewRecorder(sink, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	if err := rec.Record(Stdout, []byte("line\n")); !errors.Is(err, sinkErr) {
+		t.Errorf("expected the sink error, got %v", err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+	sink, err := NewFileSink(filename)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+
+	if _, err := sink.Write([]byte("line\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != "line\n" {
+		t.Errorf("expected %q, got %q", "line\n", content)
+	}
+
+	if _, err := NewFileSink(filepath.Join(t.TempDir(), "missing", "test.jsonl")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+// syncSink is a memorySink that can also be synced.
+type syncSink struct {
+	memorySink
+	syncs int
+}
+
+func (s *syncSink) Sync() error {
+	s.syncs++
+	return nil
+}
+
+func TestRecorder_Sync(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+	sink, err := NewFileSink(filename)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	rec, err := NewRecorder(sink, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	if err := rec.Record(Stdout, []byte("one\ntw")); err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if err := rec.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// The complete line is on disk; the incomplete one is still buffered
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	var record Record
+	if err := json.Unmarshal(bytes.TrimSpace(content), &record); err != nil {
+		t.Fatalf("expected a single record, got %q: %v", content, err)
+	}
+	if record.ContentString() != "one" {
+		t.Errorf("expected record one, got %+v", record)
+	}
+
+	// A sink that cannot be synced is flushed
+	plain := &memorySink{}
+	synced := &syncSink{}
+	for _, s := range []Sink{plain, synced, NewMultiSink(Destination{Sink: plain}, Destination{Sink: synced})} {
+		rec, err := NewRecorder(s, 0)
+		if err != nil {
+			t.Fatalf("failed to create recorder: %v", err)
+		}
+		if err := rec.Sync(); err != nil {
+			t.Errorf("Sync() error = %v", err)
+		}
+	}
+	if plain.flushes != 2 || synced.flushes != 0 || synced.syncs != 2 {
+		t.Errorf("got %d flushes of the plain sink, %d flushes and %d syncs of the syncer; want 2, 0, 2", plain.flushes, synced.flushes, synced.syncs)
+	}
+}
+
+func TestRecorder_SyncConcurrent(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+	sink, err := NewFileSink(filename)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	rec, err := NewRecorder(sink, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	// Syncing while recording neither loses nor splits records
+	var wg sync.WaitGroup
+	for _, source := range []Source{Stdout, Stderr} {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if err := rec.Record(source, []byte("line\n")); err != nil {
+					t.Errorf("failed to record: %v", err)
+					return
+				}
+			}
+		}(source)
+	}
+	for i := 0; i < 20; i++ {
+		if err := rec.Sync(); err != nil {
+			t.Errorf("Sync() error = %v", err)
+		}
+	}
+	wg.Wait()
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if n := bytes.Count(content, []byte(`"content":"line"`)); n != 400 {
+		t.Errorf("expected 400 records, got %d", n)
+	}
+}
+
+func TestWriterSink_SyncPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// A pipe cannot be synced, which is not an error
+	sink := NewWriterSink(w)
+	if _, err := sink.Write([]byte("line\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.(Syncer).Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "line\n" {
+		t.Errorf("read %q, %v; want the flushed line", buf, err)
+	}
+}
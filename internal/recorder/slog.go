@@ -0,0 +1,76 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// WithSlogHandler also passes every record, after WithOnRecord, to h as a
+// log record, so that records can be shipped through an existing log/slog
+// handler chain. See SlogRecord for how records are converted.
+func WithSlogHandler(h slog.Handler) Option {
+	return func(r *Recorder) {
+		r.slogHandler = h
+	}
+}
+
+// SlogLevel returns the log level of records from the given source: Debug
+// for stdin, Warn for stderr and Info for everything else.
+func SlogLevel(source string) slog.Level {
+	switch source {
+	case Stdin.String():
+		return slog.LevelDebug
+	case Stderr.String():
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// SlogRecord converts record to a log record whose message is the content
+// and whose attributes are seq, source, encoding and truncated, followed
+// by the custom fields (see WithFields), sorted by key, in a "fields" group.
+func SlogRecord(record Record) slog.Record {
+	t, err := record.Time()
+	if err != nil {
+		t = time.Now()
+	}
+
+	logRecord := slog.NewRecord(t, SlogLevel(record.Source), record.ContentString(), 0)
+	logRecord.AddAttrs(
+		slog.Uint64("seq", record.Seq),
+		slog.String("source", record.Source),
+		slog.String("encoding", record.Encoding),
+		slog.Bool("truncated", record.Truncated),
+	)
+	if len(record.Fields) > 0 {
+		keys := make([]string, 0, len(record.Fields))
+		for k := range record.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fields := make([]any, 0, len(keys))
+		for _, k := range keys {
+			fields = append(fields, slog.String(k, record.Fields[k]))
+		}
+		logRecord.AddAttrs(slog.Group("fields", fields...))
+	}
+	return logRecord
+}
+
+// logRecord passes record to the handler of WithSlogHandler.
+// Must be called with mu held.
+func (r *Recorder) logRecord(record Record) error {
+	ctx := context.Background()
+	if !r.slogHandler.Enabled(ctx, SlogLevel(record.Source)) {
+		return nil
+	}
+	if err := r.slogHandler.Handle(ctx, SlogRecord(record)); err != nil {
+		return fmt.Errorf("failed to log record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+// capturingHandler is a slog.Handler that keeps the records it handles.
+type capturingHandler struct {
+	level   slog.Level
+	records []slog.Record
+	err     error // returned by Handle if set
+}
+
+func (h *capturingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *capturingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.records = append(h.records, record.Clone())
+	return nil
+}
+
+func (h *capturingHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h *capturingHandler) WithGroup(name string) slog.Handler       { return h }
+
+// attrs returns the attributes of record by key.
+func attrs(record slog.Record) map[string]slog.Value {
+	values := map[string]slog.Value{}
+	record.Attrs(func(attr slog.Attr) bool {
+		values[attr.Key] = attr.Value
+		return true
+	})
+	return values
+}
+
+func TestRecorder_WithSlogHandler(t *testing.T) {
+	handler := &capturingHandler{level: slog.LevelDebug}
+	sink := &memorySink{}
+	rec, err := NewRecorder(sink, 4, WithSlogHandler(handler), WithFields(map[string]string{"job": "ci", "env": "test"}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	rec.Record(Stdin, []byte("in\n"))
+	rec.Record(Stdout, []byte("out\n"))
+	rec.Record(Stderr, []byte("too long\n"))
+	rec.RecordMeta("suspend", nil)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// The sink still gets every record
+	if len(sink.writes) != 4 {
+		t.Errorf("expected 4 records in the sink, got %d", len(sink.writes))
+	}
+
+	want := []struct {
+		level     slog.Level
+		message   string
+		source    string
+		truncated bool
+	}{
+		{slog.LevelDebug, "in", "stdin", false},
+		{slog.LevelInfo, "out", "stdout", false},
+		{slog.LevelWarn, "too ", "stderr", true},
+		{slog.LevelInfo, `{"event":"suspend"}`, "meta", false},
+	}
+	if len(handler.records) != len(want) {
+		t.Fatalf("expected %d log records, got %d", len(want), len(handler.records))
+	}
+	for i, logRecord := range handler.records {
+		w := want[i]
+		values := attrs(logRecord)
+		if logRecord.Level != w.level || logRecord.Message != w.message {
+			t.Errorf("log record %d: expected %v %q, got %v %q", i, w.level, w.message, logRecord.Level, logRecord.Message)
+		}
+		if values["seq"].Uint64() != uint64(i) || values["source"].String() != w.source || values["truncated"].Bool() != w.truncated {
+			t.Errorf("log record %d: unexpected attributes %v", i, values)
+		}
+		if values["encoding"].String() == "" || logRecord.Time.IsZero() {
+			t.Errorf("log record %d: expected encoding and time, got %v", i, logRecord)
+		}
+		if fields := values["fields"].Group(); len(fields) != 2 || fields[0].Key != "env" || fields[1].Key != "job" {
+			t.Errorf("log record %d: expected sorted fields, got %v", i, fields)
+		}
+	}
+}
+
+func TestRecorder_WithSlogHandlerLevel(t *testing.T) {
+	handler := &capturingHandler{level: slog.LevelInfo}
+	rec, err := NewRecorder(&memorySink{}, 0, WithSlogHandler(handler))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	rec.Record(Stdin, []byte("in\n"))
+	rec.Record(Stdout, []byte("out\n"))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// Debug (stdin) records are not enabled
+	if len(handler.records) != 1 || handler.records[0].Message != "out" {
+		t.Errorf("expected only the stdout record, got %v", handler.records)
+	}
+}
+
+func TestRecorder_WithSlogHandlerError(t *testing.T) {
+	handlerErr := errors.New("collector unavailable")
+	rec, err := NewRecorder(&memorySink{}, 0, WithSlogHandler(&capturingHandler{err: handlerErr}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	defer rec.Close()
+
+	if err := rec.Record(Stdout, []byte("out\n")); !errors.Is(err, handlerErr) {
+		t.Errorf("expected the handler error, got %v", err)
+	}
+}
+
+func TestRecorder_WithSlogJSONHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	rec, err := NewRecorder(&memorySink{}, 0, WithSlogHandler(handler))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	rec.Record(Stderr, []byte("oops\n"))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse log entry %q: %v", buf.Bytes(), err)
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "oops" || entry["source"] != "stderr" || entry["seq"] != 0.0 || entry["truncated"] != false {
+		t.Errorf("unexpected log entry %v", entry)
+	}
+}
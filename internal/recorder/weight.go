@@ -0,0 +1,81 @@
+package recorder
+
+import "sync"
+
+// WithSourceWeights admits the goroutines recording different sources in
+// weighted round-robin order when they contend for the Recorder, instead of
+// in the arbitrary order of the mutex. A source with weight 3 records three
+// chunks for every chunk of a contending source with weight 1, so it keeps
+// a higher recording rate under load. Sources without a weight, or with a
+// weight <= 0, have weight 1. Without contention, the order is unaffected.
+//
+// The weights have no effect in queued mode (see WithQueue), where
+// recording does not contend for the mutex.
+func WithSourceWeights(weights map[Source]int) Option {
+	return func(r *Recorder) {
+		var w [3]int
+		for source := range w {
+			w[source] = 1
+			if weight := weights[Source(source)]; weight > 0 {
+				w[source] = weight
+			}
+		}
+		r.gate = newSourceGate(w)
+	}
+}
+
+// sourceGate lets one goroutine at a time record, choosing among the waiting
+// sources by their remaining turns in the current round.
+type sourceGate struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	busy    bool   // true while a goroutine holds the gate
+	waiting [3]int // goroutines waiting per source, i.e. the contention
+	weights [3]int // turns per round
+	tokens  [3]int // turns left in the current round
+}
+
+func newSourceGate(weights [3]int) *sourceGate {
+	g := &sourceGate{weights: weights, tokens: weights}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire blocks until source is admitted. Call release afterwards.
+func (g *sourceGate) acquire(source Source) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.waiting[source]++
+	for g.busy || g.next() != source {
+		g.cond.Wait()
+	}
+	g.waiting[source]--
+	g.tokens[source]--
+	g.busy = true
+}
+
+// release admits the next waiting goroutine, if any.
+func (g *sourceGate) release() {
+	g.mu.Lock()
+	g.busy = false
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// next returns the waiting source with the most turns left, or -1 if none
+// is waiting. A new round starts when no waiting source has turns left.
+// Must be called with mu held.
+func (g *sourceGate) next() Source {
+	best := Source(-1)
+	for source, n := range g.waiting {
+		if n > 0 && (best < 0 || g.tokens[source] > g.tokens[best]) {
+			best = Source(source)
+		}
+	}
+	if best >= 0 && g.tokens[best] <= 0 {
+		g.tokens = g.weights
+		return g.next()
+	}
+	return best
+}
@@ -0,0 +1,156 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSourceGate_WeightedOrder(t *testing.T) {
+	g := newSourceGate([3]int{1, 3, 1})
+
+	// Both stdout and stderr are contending for every turn
+	g.waiting[Stdout] = 1
+	g.waiting[Stderr] = 1
+
+	var got []Source
+	for i := 0; i < 8; i++ {
+		source := g.next()
+		g.tokens[source]--
+		got = append(got, source)
+	}
+
+	want := []Source{Stdout, Stdout, Stdout, Stderr, Stdout, Stdout, Stdout, Stderr}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("turn order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSourceGate_NoContention(t *testing.T) {
+	g := newSourceGate([3]int{1, 3, 1})
+
+	// A lone waiter is admitted even after it used up its turns
+	g.waiting[Stderr] = 1
+	for i := 0; i < 3; i++ {
+		if source := g.next(); source != Stderr {
+			t.Fatalf("next() = %v, want stderr", source)
+		}
+		g.tokens[Stderr]--
+	}
+
+	g.waiting[Stderr] = 0
+	if source := g.next(); source != -1 {
+		t.Errorf("next() without waiters = %v, want -1", source)
+	}
+}
+
+func TestRecorder_SourceWeights(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, WithSourceWeights(map[Source]int{Stdout: 3}))
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	sources := []Source{Stdin, Stdout, Stderr}
+	linesPerSource := 200
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			for i := 0; i < linesPerSource; i++ {
+				if err := rec.Record(source, []byte("line\n")); err != nil {
+					t.Errorf("failed to record: %v", err)
+				}
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	// Weighting changes the order, but every record is written once
+	counts := make(map[string]int)
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	for i, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if record.Seq != uint64(i) {
+			t.Errorf("expected seq %d, got %d", i, record.Seq)
+		}
+		counts[record.Source]++
+	}
+	for _, source := range sources {
+		if counts[source.String()] != linesPerSource {
+			t.Errorf("expected %d %s records, got %d", linesPerSource, source, counts[source.String()])
+		}
+	}
+}
+
+// benchmarkContendedRecording records lines from stdout and stderr
+// concurrently until b.N records are written, and reports the share of
+// stdout records, which shows the recording rate of stdout relative to
+// stderr under contention.
+func benchmarkContendedRecording(b *testing.B, opts ...Option) {
+	filename := filepath.Join(b.TempDir(), "bench.jsonl")
+
+	rec, err := NewFileRecorder(filename, 0, opts...)
+	if err != nil {
+		b.Fatalf("failed to create recorder: %v", err)
+	}
+
+	line := []byte("the quick brown fox jumps over the lazy dog\n")
+	sources := []Source{Stdout, Stderr}
+
+	var total atomic.Int64
+	var counts [3]atomic.Int64
+
+	b.SetBytes(int64(len(line)))
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			for total.Add(1) <= int64(b.N) {
+				if err := rec.Record(source, line); err != nil {
+					b.Errorf("failed to record: %v", err)
+				}
+				counts[source].Add(1)
+			}
+		}(source)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	if err := rec.Close(); err != nil {
+		b.Fatalf("failed to close recorder: %v", err)
+	}
+	b.ReportMetric(float64(counts[Stdout].Load())/float64(b.N), "stdout-share")
+}
+
+func BenchmarkRecorder_Unweighted(b *testing.B) {
+	benchmarkContendedRecording(b)
+}
+
+func BenchmarkRecorder_SourceWeights(b *testing.B) {
+	benchmarkContendedRecording(b, WithSourceWeights(map[Source]int{Stdout: 3, Stderr: 1}))
+}
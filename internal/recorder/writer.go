@@ -0,0 +1,51 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+)
+
+// recordingWriter is the io.WriteCloser returned by Recorder.Writer.
+type recordingWriter struct {
+	recorder *Recorder
+	source   Source
+	forward  io.Writer // nil = record only
+}
+
+// Writer returns an io.WriteCloser that records everything written to it as
+// data from source, the same way as data read by CopyAndRecord, and forwards
+// it to forward unless forward is nil. Close writes any buffered incomplete
+// line of source; it does not close forward or the Recorder.
+// Like Record, the writer is safe to use concurrently with other sources,
+// but writes to the same source from several goroutines may interleave.
+func (r *Recorder) Writer(source Source, forward io.Writer) io.WriteCloser {
+	return &recordingWriter{recorder: r, source: source, forward: forward}
+}
+
+// Write implements io.Writer.
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	// In strict order mode, record before forwarding (see CopyAndRecord)
+	if w.recorder.strictOrder {
+		if err := w.recorder.Record(w.source, p); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.forward != nil {
+		if n, err := w.forward.Write(p); err != nil {
+			return n, fmt.Errorf("write error: %w", err)
+		}
+	}
+
+	if !w.recorder.strictOrder {
+		if err := w.recorder.Record(w.source, p); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Close implements io.Closer.
+func (w *recordingWriter) Close() error {
+	return w.recorder.Flush(w.source)
+}
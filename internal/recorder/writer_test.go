@@ -0,0 +1,135 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+)
+
+func TestRecorder_Writer(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	var forwarded bytes.Buffer
+	w := rec.Writer(Stderr, &forwarded)
+	logger := log.New(w, "app: ", 0)
+	logger.Println("starting")
+	logger.Printf("listening on %d", 8080)
+	fmt.Fprint(w, "partial")
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	if forwarded.String() != "app: starting\napp: listening on 8080\npartial" {
+		t.Errorf("unexpected forwarded output %q", forwarded.String())
+	}
+
+	want := []struct{ content, end string }{
+		{"app: starting", "\n"},
+		{"app: listening on 8080", "\n"},
+		{"partial", ""},
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d records, got %d:\n%s", len(want), len(lines), buf.Bytes())
+	}
+	for i, line := range lines {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if record.Source != "stderr" || record.ContentString() != want[i].content || record.End != want[i].end {
+			t.Errorf("record %d: unexpected record %s", i, line)
+		}
+	}
+}
+
+func TestRecorder_WriterWithoutForward(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	if _, err := rec.Writer(Stdout, nil).Write([]byte("only recorded\n")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	var record Record
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if record.Source != "stdout" || record.ContentString() != "only recorded" {
+		t.Errorf("unexpected record %s", buf.Bytes())
+	}
+}
+
+func TestRecorder_WriterConcurrentSources(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(NewWriterSink(&buf), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	const lines = 200
+	var wg sync.WaitGroup
+	for _, source := range []Source{Stdout, Stderr} {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			logger := log.New(rec.Writer(source, nil), "", 0)
+			for i := 0; i < lines; i++ {
+				logger.Printf("%s %d", source, i)
+			}
+		}(source)
+	}
+	wg.Wait()
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	// Every line is recorded intact under its own source, in order
+	next := map[string]int{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record: %v", err)
+		}
+		if want := fmt.Sprintf("%s %d", record.Source, next[record.Source]); record.ContentString() != want {
+			t.Fatalf("expected %q, got %q", want, record.ContentString())
+		}
+		next[record.Source]++
+	}
+	if next["stdout"] != lines || next["stderr"] != lines {
+		t.Errorf("expected %d lines per source, got %v", lines, next)
+	}
+}
+
+func TestRecorder_WriterAfterClose(t *testing.T) {
+	rec, err := NewRecorder(NewWriterSink(&bytes.Buffer{}), 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	w := rec.Writer(Stdout, nil)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	if _, err := w.Write([]byte("late\n")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -3,40 +3,115 @@ package version
 import (
 	"fmt"
 	"runtime"
+	"runtime/debug"
+	"strings"
 )
 
+// defaultVersion is the development version used when Version is not
+// overridden at build time.
+const defaultVersion = "1.0.1-dev"
+
 // Version is the current version of ioetap.
 // This is set to the development version by default and overridden
 // at build time using ldflags for release builds:
 //
 //	go build -ldflags "-X github.com/trustin/ioetap/internal/version.Version=1.0.0"
-var Version = "1.0.1-dev"
+//
+// Without ldflags, e.g. with go install, Info uses the module version from
+// the build info instead.
+var Version = defaultVersion
 
 // GitCommit is the git commit hash of the build.
 // This is set at build time using ldflags:
 //
 //	go build -ldflags "-X github.com/trustin/ioetap/internal/version.GitCommit=$(git rev-parse --short HEAD)"
+//
+// Without ldflags, Info uses the vcs.revision build setting instead.
 var GitCommit = ""
 
 // BuildTime is the time the binary was built.
 // This is set at build time using ldflags:
 //
 //	go build -ldflags "-X github.com/trustin/ioetap/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without ldflags, Info uses the vcs.time build setting instead, which is
+// the time of the commit.
 var BuildTime = ""
 
+// readBuildInfo reads the build info embedded in the binary.
+// Tests replace it to inject a fake build info.
+var readBuildInfo = debug.ReadBuildInfo
+
 // Info returns the full version information string.
 func Info() string {
-	info := fmt.Sprintf("ioetap %s", Version)
+	version, commit, buildTime := resolve()
+	info := fmt.Sprintf("ioetap %s", version)
 
-	if GitCommit != "" {
-		info += fmt.Sprintf(" (%s)", GitCommit)
+	if commit != "" {
+		info += fmt.Sprintf(" (%s)", commit)
 	}
 
-	if BuildTime != "" {
-		info += fmt.Sprintf(" built %s", BuildTime)
+	if buildTime != "" {
+		info += fmt.Sprintf(" built %s", buildTime)
 	}
 
 	info += fmt.Sprintf(" %s/%s", runtime.GOOS, runtime.GOARCH)
 
 	return info
 }
+
+// Details returns the version information as fields of a recording:
+// version and platform, and commit and build_time if known.
+func Details() map[string]any {
+	version, commit, buildTime := resolve()
+	details := map[string]any{
+		"version":  version,
+		"platform": runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if commit != "" {
+		details["commit"] = commit
+	}
+	if buildTime != "" {
+		details["build_time"] = buildTime
+	}
+	return details
+}
+
+// resolve returns the version, commit and build time of the binary.
+// Values set with ldflags take precedence. If none is set, they come from
+// the build info embedded by the go command.
+func resolve() (version, commit, buildTime string) {
+	if Version != defaultVersion || GitCommit != "" || BuildTime != "" {
+		return Version, GitCommit, BuildTime
+	}
+
+	version = Version
+	info, ok := readBuildInfo()
+	if !ok {
+		return version, "", ""
+	}
+
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		version = strings.TrimPrefix(v, "v")
+	}
+
+	modified := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			commit = setting.Value
+			if len(commit) > 7 {
+				commit = commit[:7]
+			}
+		case "vcs.time":
+			buildTime = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if commit != "" && modified {
+		commit += "-dirty"
+	}
+
+	return version, commit, buildTime
+}
@@ -0,0 +1,119 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	platform := " " + runtime.GOOS + "/" + runtime.GOARCH
+
+	installed := &debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/trustin/ioetap", Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2024-01-15T10:30:45Z"},
+			{Key: "vcs.modified", Value: "false"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		version   string
+		commit    string
+		buildTime string
+		info      *debug.BuildInfo // nil = no build info
+		want      string
+	}{
+		{
+			name:    "module version and vcs settings",
+			version: defaultVersion,
+			info:    installed,
+			want:    "ioetap 1.2.3 (0123456) built 2024-01-15T10:30:45Z",
+		},
+		{
+			name:    "devel version with modified tree",
+			version: defaultVersion,
+			info: &debug.BuildInfo{
+				Main: debug.Module{Path: "github.com/trustin/ioetap", Version: "(devel)"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: "0123456789abcdef"},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			want: "ioetap " + defaultVersion + " (0123456-dirty)",
+		},
+		{
+			name:    "no vcs settings",
+			version: defaultVersion,
+			info:    &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}},
+			want:    "ioetap " + defaultVersion,
+		},
+		{
+			name:    "no build info",
+			version: defaultVersion,
+			want:    "ioetap " + defaultVersion,
+		},
+		{
+			name:      "ldflags take precedence",
+			version:   "2.0.0",
+			commit:    "abc1234",
+			buildTime: "2025-02-01T00:00:00Z",
+			info:      installed,
+			want:      "ioetap 2.0.0 (abc1234) built 2025-02-01T00:00:00Z",
+		},
+		{
+			name:    "ldflags commit only",
+			version: defaultVersion,
+			commit:  "abc1234",
+			info:    installed,
+			want:    "ioetap " + defaultVersion + " (abc1234)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVersion(t, tt.version, tt.commit, tt.buildTime, tt.info)
+			if got := Info(); got != tt.want+platform {
+				t.Errorf("Info() = %q, want %q", got, tt.want+platform)
+			}
+		})
+	}
+}
+
+func TestDetails(t *testing.T) {
+	setVersion(t, defaultVersion, "", "", &debug.BuildInfo{
+		Main:     debug.Module{Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "0123456789abcdef"}},
+	})
+
+	details := Details()
+	want := map[string]any{
+		"version":  "1.2.3",
+		"commit":   "0123456",
+		"platform": runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if len(details) != len(want) {
+		t.Errorf("Details() = %v, want %v", details, want)
+	}
+	for key, value := range want {
+		if details[key] != value {
+			t.Errorf("Details()[%q] = %v, want %v", key, details[key], value)
+		}
+	}
+}
+
+// setVersion sets the version variables and the build info for the test.
+func setVersion(t *testing.T, version, commit, buildTime string, info *debug.BuildInfo) {
+	t.Helper()
+	oldVersion, oldCommit, oldBuildTime, oldRead := Version, GitCommit, BuildTime, readBuildInfo
+	t.Cleanup(func() {
+		Version, GitCommit, BuildTime, readBuildInfo = oldVersion, oldCommit, oldBuildTime, oldRead
+	})
+
+	Version, GitCommit, BuildTime = version, commit, buildTime
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return info, info != nil
+	}
+}
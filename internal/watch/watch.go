@@ -0,0 +1,122 @@
+// Package watch detects changes to the files matching glob patterns.
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Watcher polls the files matching a set of glob patterns and reports when
+// any of them is created, removed or modified. Polling needs no platform
+// support and also works on network file systems, at the cost of noticing
+// a change up to one interval late.
+type Watcher struct {
+	patterns []string
+	changes  chan struct{}
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// fileState is what a change is detected by.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// New returns a Watcher that polls the files matching patterns, in the
+// syntax of filepath.Match, every interval until Close is called.
+func New(patterns []string, interval time.Duration) (*Watcher, error) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	w := &Watcher{
+		patterns: patterns,
+		changes:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	last := w.snapshot()
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+
+			current := w.snapshot()
+			if changed(last, current) {
+				w.notify()
+			}
+			last = current
+		}
+	}()
+	return w, nil
+}
+
+// Changes returns a channel that receives a value when the watched files
+// change. Changes made before the previous value was received are
+// coalesced into it.
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops watching. It is safe to call Close more than once.
+func (w *Watcher) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// notify sends a change unless one is pending already.
+func (w *Watcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+// snapshot returns the state of the files matching the patterns.
+// Files that cannot be read, e.g. because they were removed just now, are
+// left out.
+func (w *Watcher) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, pattern := range w.patterns {
+		// The patterns were validated by New
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				continue
+			}
+			files[match] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return files
+}
+
+// changed returns whether any file was added, removed or modified between
+// the snapshots.
+func changed(last, current map[string]fileState) bool {
+	if len(last) != len(current) {
+		return true
+	}
+	for name, state := range current {
+		if prev, ok := last[name]; !ok || !prev.modTime.Equal(state.modTime) || prev.size != state.size {
+			return true
+		}
+	}
+	return false
+}
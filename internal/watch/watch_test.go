@@ -0,0 +1,101 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testInterval = 10 * time.Millisecond
+
+func TestWatcher_Changes(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("v1"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"modified", func() error {
+			return os.Chtimes(existing, time.Now(), time.Now().Add(time.Hour))
+		}},
+		{"grown", func() error {
+			return os.WriteFile(existing, []byte("version 2"), 0o644)
+		}},
+		{"created", func() error {
+			return os.WriteFile(filepath.Join(dir, "new.txt"), nil, 0o644)
+		}},
+		{"removed", func() error {
+			return os.Remove(existing)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New([]string{filepath.Join(dir, "*.txt")}, testInterval)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer w.Close()
+
+			if err := tt.change(); err != nil {
+				t.Fatalf("failed to change file: %v", err)
+			}
+			select {
+			case <-w.Changes():
+			case <-time.After(2 * time.Second):
+				t.Fatal("change was not reported")
+			}
+		})
+	}
+}
+
+func TestWatcher_IgnoresUnmatchedFiles(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New([]string{filepath.Join(dir, "*.go")}, testInterval)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer w.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	select {
+	case <-w.Changes():
+		t.Error("change to an unmatched file was reported")
+	case <-time.After(20 * testInterval):
+	}
+}
+
+func TestWatcher_CoalescesChanges(t *testing.T) {
+	w := &Watcher{changes: make(chan struct{}, 1)}
+	w.notify()
+	w.notify()
+
+	<-w.Changes()
+	select {
+	case <-w.Changes():
+		t.Error("pending changes were not coalesced")
+	default:
+	}
+}
+
+func TestNew_InvalidPattern(t *testing.T) {
+	if _, err := New([]string{"[unclosed"}, testInterval); err == nil {
+		t.Error("New() with an invalid pattern succeeded")
+	}
+}
+
+func TestWatcher_CloseTwice(t *testing.T) {
+	w, err := New([]string{filepath.Join(t.TempDir(), "*")}, testInterval)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	w.Close()
+	w.Close()
+}
@@ -0,0 +1,44 @@
+package ioetap_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustin/ioetap/pkg/ioetap"
+)
+
+func ExampleRun() {
+	var recording bytes.Buffer
+	status, stats, err := ioetap.Run(context.Background(), ioetap.RunOptions{
+		Command: "echo",
+		Args:    []string{"hello"},
+		Sinks:   []io.Writer{&recording},
+	})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("exit code:", status.ShellCode())
+	fmt.Println("records:", stats.Records)
+
+	scanner := bufio.NewScanner(&recording)
+	for scanner.Scan() {
+		var record struct {
+			Source  string `json:"source"`
+			Content any    `json:"content"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Printf("%s: %v\n", record.Source, record.Content)
+	}
+	// Output:
+	// exit code: 0
+	// records: 1
+	// stdout: hello
+}
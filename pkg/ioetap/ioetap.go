@@ -0,0 +1,902 @@
+// Package ioetap runs a command while recording its stdin, stdout and stderr
+// as NDJSON records, so that Go programs can tap commands in-process instead
+// of running the ioetap binary.
+package ioetap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/trustin/ioetap/internal/control"
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/metrics"
+	"github.com/trustin/ioetap/internal/process"
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// DefaultMaxLineLength is the maximum bytes per recorded line used by the
+// ioetap command (16 MiB).
+const DefaultMaxLineLength = 16 * 1024 * 1024
+
+// Rlimit is a resource limit for the child process.
+type Rlimit = process.Rlimit
+
+// Stats summarizes what was recorded.
+type Stats = recorder.Stats
+
+// Record is a record passed to RunOptions.OnRecord.
+type Record = recorder.Record
+
+// RecordAction is returned by RunOptions.OnRecord.
+type RecordAction = recorder.RecordAction
+
+// Actions for RunOptions.OnRecord.
+const (
+	RecordPass = recorder.RecordPass // Write the record, including any changes
+	RecordDrop = recorder.RecordDrop // Skip the record
+)
+
+// RunOptions configures Run.
+type RunOptions struct {
+	Command string   // Command to run, looked up in PATH if it has no slash
+	Args    []string // Arguments of the command
+
+	// Sinks receive the NDJSON records. Run does not close them. A sink
+	// that fails does not keep the records from the others, and Run
+	// returns its error. Records are buffered before they are written to a
+	// sink, unless it also has the methods Flush() error and Close() error,
+	// e.g. a sink that sends batches of records over the network, which
+	// then gets each record in a single write and is flushed with the
+	// recording.
+	Sinks []io.Writer
+	// OptionalSinks receive the records like Sinks, but one that fails is
+	// reported on stderr and dropped without failing the recording, e.g. a
+	// remote destination. Run does not close them.
+	OptionalSinks []OptionalSink
+	// OpenSink, if set, is called once the child has started and returns an
+	// additional sink, e.g. a file named after the child's PID. pid is 0 if
+	// the child failed to start. Run does not close the returned sink.
+	OpenSink func(pid int) (io.Writer, error)
+
+	MaxLineLength   int               // Maximum bytes per recorded line (0 = unlimited)
+	BinaryLimit     int               // Maximum bytes per binary line (0 = MaxLineLength)
+	SplitLongLines  bool              // Split lines over the limit into continued records instead of truncating them
+	TruncateTail    bool              // Keep the last bytes of lines over the limit instead of the first ones
+	MaxSeq          uint64            // Stop recording at this sequence number (0 = no limit)
+	MarkBursts      bool              // Mark records whose data was read back-to-back as burst
+	Deltas          bool              // Add the time since the previous record of the same source to every record
+	RecordReadSizes bool              // Record a read meta record with the size of every read
+	StrictOrder     bool              // Record in the order data was read across sources
+	StdinEcho       bool              // Record every stdin record again as a stdout record
+	StdinChunks     bool              // Record every read from stdin as one record instead of line by line
+	TimingHistogram bool              // Append a stats record with inter-record latencies
+	NoBuffering     bool              // Write every record to the sinks right away
+	FlushOnLine     bool              // Write every record of a complete line to the sinks right away
+	SortedFields    bool              // Write the fields of every record in alphabetical order
+	NoEscapeHTML    bool              // Write <, > and & in the strings of every record as is instead of as \u003c, \u003e and \u0026
+	JSONRawContent  bool              // Write the content of json records as a string of the JSON text
+	LossyText       bool              // Record lines that are not valid UTF-8 as text with replaced bytes instead of as base64
+	RecordChecksums bool              // Link every record to the one before it with a chain hash
+	Fields          map[string]string // Custom fields added to every record
+	SourceWeights   map[string]int    // Recording weights of "stdin", "stdout" and "stderr" under contention (default 1; ignored with QueueSize)
+	QueueSize       int               // > 0 records through a single writer goroutine with a queue of this size, so sources never wait for each other
+	Header          []byte            // Line written before the first record (e.g. a JSON Schema)
+	Label           string            // Describes the command in a "start" meta record
+	Comment         string            // Free-text note, e.g. why the command was run, added to a "start" meta record
+	RecordCWD       bool              // Add the child's working directory to a "start" meta record
+	CommandPath     string            // Resolved path of Command, added to a "start" meta record as "path"
+	RecordIdentity  bool              // Add the child's effective uid, gid and umask to a "start" meta record
+	Config          map[string]any    // Added to a "start" meta record as "ioetap", e.g. the version and options of the caller
+	RecordSignals   bool              // Record a "signal" meta record for every forwarded signal
+	ReapProcesses   bool              // Reap orphaned descendants of the child (Linux only; reaps every child of the caller)
+
+	// OnRecord, if set, is called for every record before it is written to
+	// the sinks. It may change the record, e.g. to redact content, and
+	// returns whether to write or drop it. A dropped record leaves a gap in
+	// seq. OnRecord runs while records are serialized, so it must be fast;
+	// if it panics, the panic is logged and the record is written unchanged.
+	OnRecord func(*Record) RecordAction
+	// SlogHandler, if set, also receives every record as a log record: Debug
+	// for stdin, Warn for stderr and Info for the rest, with the content as
+	// the message and seq, source, encoding and truncated as attributes.
+	SlogHandler slog.Handler
+
+	// Stdin is forwarded to the child. If nil, the child's stdin is closed
+	// right away. If Stdin has an Interrupt method (like the reader the
+	// ioetap command uses for its own stdin), Run calls it when the child
+	// exits and waits for the last data read from Stdin to be recorded.
+	// Otherwise, Run does not wait for a pending read to return.
+	Stdin io.Reader
+	// StdinUnavailable, if set, is why ioetap's stdin cannot be forwarded.
+	// Run records it in a "stdin-unavailable" meta record. Stdin should be
+	// nil then.
+	StdinUnavailable error
+	// Stdout and Stderr receive the child's output (nil = discarded).
+	Stdout io.Writer
+	Stderr io.Writer
+	// StdinTimeout kills the child if it does not read forwarded stdin data
+	// within the duration (0 = disabled).
+	StdinTimeout time.Duration
+	// StdinRateLimit limits the bytes per second forwarded to the child's
+	// stdin (0 = unlimited). Stdin is still recorded as soon as it is read.
+	StdinRateLimit int
+	// AnnotatePrefix, if set, makes the lines of Stdin that start with it
+	// annotations: they are recorded with source "annotation", without the
+	// prefix, and are not forwarded to the child.
+	AnnotatePrefix string
+	// StdinBroadcast, if set, gets a copy of the data forwarded to the
+	// child's stdin as the child gets it, e.g. a named pipe to watch stdin
+	// with. It must not block or fail, since it sits in front of the child.
+	// Run does not close it.
+	StdinBroadcast io.Writer
+	// MetricsAddr, if set, is the address of an HTTP server that serves the
+	// Stats of the recording at /metrics in the Prometheus text format while
+	// the child runs (e.g. ":9151").
+	MetricsAddr string
+	// ControlSocket, if set, is the path of a unix socket that accepts
+	// commands while the child runs: pause, resume, mark, flush and stats,
+	// like those of ioetap ctl. It is created with the permissions
+	// ControlSocketPerm (0 = 0600) and removed when Run returns.
+	ControlSocket     string
+	ControlSocketPerm fs.FileMode
+	// CPUTimeInterval, if set, records a "resource" record with the
+	// cumulative CPU time and the resident set size of the child at this
+	// interval until it exits (Linux only).
+	CPUTimeInterval time.Duration
+
+	// Rlimits are resource limits applied to the child (Linux only). Go
+	// cannot apply them between fork and exec, so the child starts as a
+	// copy of the running program, which applies them while its packages
+	// are initialized and then execs the command. Packages that ioetap does
+	// not depend on may be initialized before that, so in a program that
+	// embeds ioetap, their init functions run in the copy as well and must
+	// not have side effects, such as writing to stdout, which would come
+	// before the output of the command.
+	Rlimits    []Rlimit
+	ExtraFiles []*os.File // Open files passed to the child as fd 3, 4, ... (closed by Run)
+
+	// ForwardSignals forwards the signals ioetap's process receives to the
+	// child and records suspend/resume events, like the ioetap command.
+	// If the process receives SIGTERM or SIGHUP and the child does not exit
+	// within GracePeriod, Run records a "terminated" meta record, calls
+	// Hooks.OnTerminate and terminates the process with the same signal.
+	// Off by default, since it changes process-wide signal handling.
+	// SIGUSR1 also syncs the recording to disk, through the Sync() error
+	// method of the sinks that have one, before it is forwarded, unless ConsumeUSR1 keeps it from the child.
+	// ToggleSignal, if set, pauses the recording when the process receives
+	// it and resumes it when it is received again, instead of forwarding it,
+	// leaving a gap marked by meta records. It needs ForwardSignals.
+	ForwardSignals bool
+	ConsumeUSR1    bool
+	ToggleSignal   os.Signal
+	GracePeriod    time.Duration
+
+	// Restart, if set, makes Run re-run the command every time it receives
+	// a value, like the ioetap command with --watch. The current child gets
+	// SIGTERM and is killed if it does not exit within GracePeriod, and
+	// every run is recorded in the same recording after a "run" meta record
+	// with its number and PID. A child that exits by itself is not re-run
+	// until the next value. Run returns the exit status of the last run
+	// once ctx is done, which also stops the current child. Stdin,
+	// StdinTimeout, StdinRateLimit and ForwardSignals are ignored; the
+	// child's stdin is closed.
+	Restart <-chan struct{}
+
+	// Retries makes Run re-run the command up to this many times while it
+	// exits with a nonzero code, waiting RetryDelay before every attempt.
+	// Every attempt is recorded in the same recording after a "retry" meta
+	// record with its number, PID and the exit code of the previous attempt,
+	// and Run returns the exit status of the last one. Only the first
+	// attempt gets Stdin and ExtraFiles. Retrying stops when ctx is done or,
+	// with ForwardSignals, when ioetap gets SIGINT, SIGTERM or SIGHUP.
+	// Ignored with Restart.
+	Retries    int
+	RetryDelay time.Duration
+
+	Hooks Hooks
+}
+
+// OptionalSink is a sink whose failure does not fail the recording (see
+// RunOptions.OptionalSinks).
+type OptionalSink struct {
+	Name string // Names the sink when its failure is reported, e.g. its address
+	W    io.Writer
+}
+
+// Hooks are optional callbacks invoked by Run.
+type Hooks struct {
+	// OnStart is called once the child has started, before any I/O is
+	// recorded.
+	OnStart func(pid int)
+	// OnRecording is called once the recording has started, with a function
+	// that returns the Stats recorded so far. The function can be called
+	// from any goroutine until Run returns.
+	OnRecording func(stats func() Stats)
+	// OnTerminate is called when the process is about to be terminated
+	// because the child did not exit within the grace period (see
+	// RunOptions.ForwardSignals). The records have been flushed to the sinks
+	// at this point, so it can finalize them.
+	OnTerminate func(sig os.Signal)
+}
+
+// ErrMetricsListen is wrapped by the error Run returns when it cannot listen
+// on MetricsAddr.
+var ErrMetricsListen = errors.New("failed to listen for metrics")
+
+// ErrControlListen is wrapped by the error Run returns when it cannot create
+// ControlSocket.
+var ErrControlListen = errors.New("failed to create the control socket")
+
+// StartError is the error Run returns, possibly joined with errors recording
+// the failure, when the command cannot be started.
+type StartError struct {
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// ExitStatus describes how the child exited.
+type ExitStatus struct {
+	Code          int       // Exit code, or -1 if it was killed by a signal
+	Signal        os.Signal // Signal that terminated the child (nil if it exited normally)
+	StdinTimedOut bool      // True if the child was killed by RunOptions.StdinTimeout
+}
+
+// ShellCode returns the exit code a shell would report: the exit code, or
+// 128 plus the signal number if the child was killed by a signal. Like
+// timeout(1), it is 124 if the child was killed by RunOptions.StdinTimeout.
+func (s ExitStatus) ShellCode() int {
+	if s.StdinTimedOut {
+		return 124
+	}
+	return process.ExitStatus{Code: s.Code, Signal: s.Signal}.ShellCode()
+}
+
+// Run starts the command, records its I/O to the sinks until it exits and
+// returns how it exited along with what was recorded.
+//
+// If the command cannot be started, Run records a "start" and a
+// "start-failed" meta record and returns a *StartError with an ExitStatus
+// whose Code follows shell conventions: 127 if the command was not found and
+// 126 if it could not be executed. For other errors, Code is 1.
+//
+// If recording the child's I/O panics, Run recovers, ends the recording with
+// a "panic" meta record, kills the child and returns a *PanicError.
+func Run(ctx context.Context, opts RunOptions) (ExitStatus, Stats, error) {
+	// Listen before starting the child, so that a busy address fails early
+	var listeners listeners
+	if opts.MetricsAddr != "" {
+		var err error
+		if listeners.metrics, err = net.Listen("tcp", opts.MetricsAddr); err != nil {
+			for _, file := range opts.ExtraFiles {
+				file.Close()
+			}
+			return ExitStatus{Code: 1}, Stats{}, fmt.Errorf("%w: %w", ErrMetricsListen, err)
+		}
+		defer listeners.metrics.Close()
+	}
+	if opts.ControlSocket != "" {
+		var err error
+		if listeners.control, err = control.Listen(opts.ControlSocket, opts.ControlSocketPerm); err != nil {
+			for _, file := range opts.ExtraFiles {
+				file.Close()
+			}
+			return ExitStatus{Code: 1}, Stats{}, fmt.Errorf("%w: %w", ErrControlListen, err)
+		}
+		defer listeners.control.Close()
+	}
+
+	if opts.Restart != nil {
+		return runRestarting(ctx, opts, listeners)
+	}
+
+	procOpts := process.ProcessOptions{Rlimits: opts.Rlimits, ExtraFiles: opts.ExtraFiles}
+	start := process.StartWithOptions
+	if opts.ReapProcesses {
+		start = process.StartWithReaping
+	}
+	proc, err := start(ctx, opts.Command, opts.Args, procOpts)
+
+	// The child has its own copies of the passed descriptors now
+	for _, file := range opts.ExtraFiles {
+		file.Close()
+	}
+
+	if err != nil {
+		return recordStartFailure(opts, err)
+	}
+
+	if opts.Hooks.OnStart != nil {
+		opts.Hooks.OnStart(proc.PID())
+	}
+
+	rec, err := newRecorder(opts, proc.PID())
+	if err != nil {
+		_ = proc.Signal(os.Kill)
+		proc.Wait()
+		return ExitStatus{Code: 1}, Stats{}, err
+	}
+	defer rec.Close()
+
+	defer listeners.serve(rec)()
+	if opts.Hooks.OnRecording != nil {
+		opts.Hooks.OnRecording(rec.Stats)
+	}
+
+	if hasStartMeta(opts) {
+		if err := rec.RecordMeta("start", startMetaFields(opts)); err != nil {
+			return killAfterError(proc, rec, err)
+		}
+	}
+	if opts.StdinUnavailable != nil {
+		if err := rec.RecordMeta("stdin-unavailable", map[string]any{"error": opts.StdinUnavailable.Error()}); err != nil {
+			return killAfterError(proc, rec, err)
+		}
+	}
+
+	// exiting is held by whoever finalizes the recording: the normal exit
+	// path below, or terminateAfterGrace if the process is terminated while
+	// the child keeps running. stopping is set once ioetap is interrupted or
+	// terminated, which stops retrying.
+	var exiting sync.Mutex
+	var stopping atomic.Bool
+	status, err := runChild(proc, rec, opts, opts.Stdin, &exiting, &stopping)
+	defer exiting.Unlock()
+
+	// With Retries, a failed command is run again without stdin, which
+	// cannot be replayed, or the passed descriptors, which are closed
+	for attempt := 2; err == nil && status.ShellCode() != 0 && attempt <= opts.Retries+1; attempt++ {
+		if stopping.Load() {
+			break
+		}
+		logging.Warnf("%s exited with code %d; retrying (%d of %d)", opts.Command, status.ShellCode(), attempt-1, opts.Retries)
+		if !waitToRetry(ctx, opts) {
+			break
+		}
+		proc, err = start(ctx, opts.Command, opts.Args, process.ProcessOptions{Rlimits: opts.Rlimits})
+		if err != nil {
+			err = &StartError{Err: err}
+			status = ExitStatus{Code: startFailureExitCode(err)}
+			recErr := rec.RecordMeta("start-failed", map[string]any{"error": err.Error(), "exit_code": status.Code})
+			closeErr := rec.Close()
+			return status, rec.Stats(), errors.Join(err, recErr, closeErr)
+		}
+		if opts.Hooks.OnStart != nil {
+			opts.Hooks.OnStart(proc.PID())
+		}
+		if err := rec.RecordMeta("retry", map[string]any{"attempt": attempt, "pid": proc.PID(), "exit_code": status.ShellCode()}); err != nil {
+			return killAfterError(proc, rec, err)
+		}
+		exiting.Unlock()
+		status, err = runChild(proc, rec, opts, nil, &exiting, &stopping)
+	}
+	if err != nil {
+		return status, rec.Stats(), err
+	}
+	err = rec.Close()
+	return status, rec.Stats(), err
+}
+
+// runChild forwards the I/O of proc with recording until it exits, and
+// returns how it exited, or a *PanicError if recording panicked (see
+// panicGuard). stdin is forwarded to proc unless it is nil. It returns with
+// exiting held, and sets stopping if ioetap gets SIGINT, SIGTERM or SIGHUP
+// with ForwardSignals.
+func runChild(proc *process.Process, rec *recorder.Recorder, opts RunOptions, stdin io.Reader, exiting *sync.Mutex, stopping *atomic.Bool) (ExitStatus, error) {
+	// A panic in a goroutine that records finalizes the recording
+	guard := &panicGuard{proc: proc, rec: rec}
+
+	// Sample the child's resource usage until it exits
+	resourceDone := make(chan struct{})
+	if opts.CPUTimeInterval > 0 {
+		go func() {
+			defer close(resourceDone)
+			defer guard.catch()
+			recordResourceUsage(proc, rec, opts.CPUTimeInterval)
+		}()
+	} else {
+		close(resourceDone)
+	}
+
+	// copyDone is closed once the child's output has been drained
+	copyDone := make(chan struct{})
+	if opts.ForwardSignals {
+		sigChan := forwardSignals(proc, rec, opts, copyDone, exiting, stopping)
+		defer process.StopForwardingSignals(sigChan)
+	}
+
+	// Wait group for stdout/stderr goroutines
+	var wg sync.WaitGroup
+
+	// With StdinTimeout, kill the child if it does not consume stdin in time
+	var stdinTimedOut atomic.Bool
+	var childStdin io.Writer = proc.Stdin
+	if opts.StdinTimeout > 0 {
+		childStdin = process.NewTimeoutWriter(proc.Stdin, opts.StdinTimeout, func() {
+			stdinTimedOut.Store(true)
+			logging.Warnf("child did not read stdin within %v", opts.StdinTimeout)
+			_ = proc.Signal(os.Kill)
+		})
+	}
+	if opts.StdinRateLimit > 0 {
+		childStdin = process.NewRateLimitedWriter(childStdin, opts.StdinRateLimit)
+	}
+
+	// Forward stdin with recording
+	stdinDone := make(chan struct{})
+	if stdin != nil {
+		go func() {
+			defer close(stdinDone)
+			defer proc.Stdin.Close()
+			defer guard.catch()
+			if opts.AnnotatePrefix != "" {
+				stdin = rec.AnnotationReader(opts.AnnotatePrefix, stdin)
+			}
+			forward := childStdin
+			if opts.StdinBroadcast != nil {
+				forward = io.MultiWriter(childStdin, opts.StdinBroadcast)
+			}
+			if opts.StdinRateLimit > 0 {
+				// Record as the data is read rather than once the child got it
+				_, _ = io.Copy(forward, rec.TeeReader(recorder.Stdin, stdin))
+				return
+			}
+			_ = rec.CopyAndRecord(recorder.Stdin, stdin, forward)
+		}()
+	} else {
+		proc.Stdin.Close()
+		close(stdinDone)
+	}
+
+	// Forward stdout and stderr with recording
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer guard.catch()
+		_ = rec.CopyAndRecord(recorder.Stdout, proc.Stdout, writerOrDiscard(opts.Stdout))
+	}()
+	go func() {
+		defer wg.Done()
+		defer guard.catch()
+		_ = rec.CopyAndRecord(recorder.Stderr, proc.Stderr, writerOrDiscard(opts.Stderr))
+	}()
+
+	// Wait for stdout/stderr goroutines to finish first.
+	// They will finish when they read EOF from the pipes, which happens
+	// when the child process exits and closes its end of the pipes.
+	wg.Wait()
+	close(copyDone)
+	exiting.Lock()
+
+	waitStatus := proc.WaitStatus()
+	<-resourceDone
+
+	// Stop forwarding stdin and wait until its final partial line is recorded.
+	// Closing the pipe also unblocks a write if a grandchild still holds the
+	// read end without reading it.
+	interrupter, interruptible := stdin.(interface{ Interrupt() })
+	if interruptible {
+		interrupter.Interrupt()
+	}
+	proc.Stdin.Close()
+	if interruptible {
+		<-stdinDone
+	}
+
+	status := ExitStatus{
+		Code:          waitStatus.Code,
+		Signal:        waitStatus.Signal,
+		StdinTimedOut: stdinTimedOut.Load(),
+	}
+	return status, guard.panicked()
+}
+
+// newRecorder creates a Recorder writing to the sinks of opts, opening the
+// sink returned by OpenSink for the child with the given PID.
+func newRecorder(opts RunOptions, pid int) (*recorder.Recorder, error) {
+	var dests []recorder.Destination
+	for _, w := range opts.Sinks {
+		dests = append(dests, destination(w))
+	}
+	if opts.OpenSink != nil {
+		w, err := opts.OpenSink(pid)
+		if err != nil {
+			return nil, err
+		}
+		dests = append(dests, destination(w))
+	}
+	for _, optional := range opts.OptionalSinks {
+		dest := destination(optional.W)
+		dest.Name = optional.Name
+		dest.Continue = true
+		dests = append(dests, dest)
+	}
+
+	var sink recorder.Sink
+	switch {
+	case len(dests) == 0:
+		sink = recorder.NewWriterSink(io.Discard)
+	case len(dests) == 1 && !dests[0].Continue && !dests[0].KeepOpen:
+		sink = dests[0].Sink
+	default:
+		sink = recorder.NewMultiSink(dests...)
+	}
+	return recorder.NewRecorder(sink, opts.MaxLineLength, recorderOptions(opts)...)
+}
+
+// destination returns the destination of a multi-sink that writes to w. A w
+// that is a recorder.Sink is written to directly, so that e.g. the stats of
+// an HTTP sink reach Recorder.HTTPStats, but is not closed, since Run does
+// not close its sinks.
+func destination(w io.Writer) recorder.Destination {
+	if sink, ok := w.(recorder.Sink); ok {
+		return recorder.Destination{Sink: sink, KeepOpen: true}
+	}
+	return recorder.Destination{Sink: recorder.NewWriterSink(w)}
+}
+
+// recorderOptions returns the Recorder options for opts.
+func recorderOptions(opts RunOptions) []recorder.Option {
+	var recOpts []recorder.Option
+	if opts.MarkBursts {
+		recOpts = append(recOpts, recorder.WithBurstDetection())
+	}
+	if opts.Deltas {
+		recOpts = append(recOpts, recorder.WithDeltas())
+	}
+	if opts.RecordReadSizes {
+		recOpts = append(recOpts, recorder.WithReadSizes())
+	}
+	if opts.StrictOrder {
+		recOpts = append(recOpts, recorder.WithStrictOrder())
+	}
+	if opts.StdinEcho {
+		recOpts = append(recOpts, recorder.WithStdinEcho())
+	}
+	if opts.StdinChunks {
+		recOpts = append(recOpts, recorder.WithSourceMode(recorder.Stdin, recorder.ChunkMode))
+	}
+	if opts.NoBuffering {
+		recOpts = append(recOpts, recorder.WithFlushEveryRecord())
+	}
+	if opts.FlushOnLine {
+		recOpts = append(recOpts, recorder.WithFlushOnLine())
+	}
+	if opts.RecordChecksums {
+		recOpts = append(recOpts, recorder.WithChainHash())
+	}
+	if opts.SortedFields {
+		recOpts = append(recOpts, recorder.WithSortedFields())
+	}
+	if opts.NoEscapeHTML {
+		recOpts = append(recOpts, recorder.WithoutHTMLEscaping())
+	}
+	if opts.JSONRawContent {
+		recOpts = append(recOpts, recorder.WithRawJSONContent())
+	}
+	if opts.LossyText {
+		recOpts = append(recOpts, recorder.WithLossyText())
+	}
+	if opts.TimingHistogram {
+		recOpts = append(recOpts, recorder.WithTimingHistogram())
+	}
+	if len(opts.Fields) > 0 {
+		recOpts = append(recOpts, recorder.WithFields(opts.Fields))
+	}
+	if opts.BinaryLimit > 0 {
+		recOpts = append(recOpts, recorder.WithBinaryLimit(opts.BinaryLimit))
+	}
+	if opts.SplitLongLines {
+		recOpts = append(recOpts, recorder.WithSplitLongLines())
+	}
+	if opts.TruncateTail {
+		recOpts = append(recOpts, recorder.WithTailTruncation())
+	}
+	if len(opts.SourceWeights) > 0 {
+		weights := make(map[recorder.Source]int)
+		for _, source := range []recorder.Source{recorder.Stdin, recorder.Stdout, recorder.Stderr} {
+			weights[source] = opts.SourceWeights[source.String()]
+		}
+		recOpts = append(recOpts, recorder.WithSourceWeights(weights))
+	}
+	if opts.QueueSize > 0 {
+		recOpts = append(recOpts, recorder.WithQueue(opts.QueueSize))
+	}
+	if opts.MaxSeq > 0 {
+		recOpts = append(recOpts, recorder.WithMaxSeq(opts.MaxSeq))
+	}
+	if opts.OnRecord != nil {
+		recOpts = append(recOpts, recorder.WithOnRecord(opts.OnRecord))
+	}
+	if opts.SlogHandler != nil {
+		recOpts = append(recOpts, recorder.WithSlogHandler(opts.SlogHandler))
+	}
+	if len(opts.Header) > 0 {
+		recOpts = append(recOpts, recorder.WithHeader(opts.Header))
+	}
+	return recOpts
+}
+
+// hasStartMeta returns whether opts asks for a "start" meta record.
+func hasStartMeta(opts RunOptions) bool {
+	return opts.Label != "" || opts.Comment != "" || opts.RecordCWD || opts.CommandPath != "" || opts.RecordIdentity || opts.Config != nil
+}
+
+// startMetaFields returns the fields of the "start" meta record, which
+// describes the command and its label.
+func startMetaFields(opts RunOptions) map[string]any {
+	args := opts.Args
+	if args == nil {
+		args = []string{}
+	}
+	fields := map[string]any{"command": opts.Command, "args": args}
+	if opts.Label != "" {
+		fields["label"] = opts.Label
+	}
+	if opts.Comment != "" {
+		fields["comment"] = opts.Comment
+	}
+	if opts.CommandPath != "" {
+		fields["path"] = opts.CommandPath
+	}
+	if opts.RecordCWD {
+		// The child inherits the working directory of this process
+		if cwd, err := os.Getwd(); err == nil {
+			if abs, err := filepath.Abs(cwd); err == nil {
+				cwd = abs
+			}
+			fields["cwd"] = cwd
+		}
+	}
+	if opts.RecordIdentity {
+		// The child inherits the identity of this process
+		identity := process.CurrentIdentity()
+		fields["uid"] = identity.UID
+		fields["gid"] = identity.GID
+		fields["umask"] = fmt.Sprintf("%04o", identity.Umask)
+	}
+	if opts.Config != nil {
+		fields["ioetap"] = opts.Config
+	}
+	return fields
+}
+
+// listeners are the listeners Run creates before starting the child, nil if
+// not enabled.
+type listeners struct {
+	metrics net.Listener // RunOptions.MetricsAddr
+	control net.Listener // RunOptions.ControlSocket
+}
+
+// serve serves the metrics and the control socket of rec in the background,
+// and returns a function that stops serving them.
+func (l listeners) serve(rec *recorder.Recorder) func() {
+	var stops []func() error
+	if l.metrics != nil {
+		stops = append(stops, metrics.Serve(l.metrics, rec.Stats, time.Now()).Close)
+	}
+	if l.control != nil {
+		stops = append(stops, control.Serve(l.control, rec).Close)
+	}
+	return func() {
+		for _, stop := range stops {
+			_ = stop()
+		}
+	}
+}
+
+// recordStartFailure records a "start" meta record followed by a
+// "start-failed" meta record with err, so that callers still get a
+// recording when the child could not be started.
+func recordStartFailure(opts RunOptions, err error) (ExitStatus, Stats, error) {
+	err = &StartError{Err: err}
+	status := ExitStatus{Code: startFailureExitCode(err)}
+
+	rec, recErr := newRecorder(opts, 0)
+	if recErr != nil {
+		return status, Stats{}, errors.Join(err, recErr)
+	}
+
+	recErr = rec.RecordMeta("start", startMetaFields(opts))
+	if recErr == nil {
+		recErr = rec.RecordMeta("start-failed", map[string]any{"error": err.Error(), "exit_code": status.Code})
+	}
+	stats := rec.Stats()
+	if closeErr := rec.Close(); recErr == nil {
+		recErr = closeErr
+	}
+	if recErr != nil {
+		return status, stats, errors.Join(err, recErr)
+	}
+	return status, stats, err
+}
+
+// startFailureExitCode returns the exit code a shell would use when it fails
+// to run a command with err: 127 if the command was not found and 126 if it
+// could not be executed, e.g. for lack of permission.
+func startFailureExitCode(err error) int {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return 127
+	}
+	return 126
+}
+
+// recordResourceUsage records a "resource" record with the resource usage of
+// the child every interval until it exits.
+func recordResourceUsage(proc *process.Process, rec *recorder.Recorder, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-proc.Done():
+			return
+		case <-ticker.C:
+		}
+
+		usage, err := proc.ResourceUsage()
+		if err != nil {
+			// The child may have exited just now
+			if !errors.Is(err, os.ErrProcessDone) {
+				logging.Warnf("%v", err)
+			}
+			return
+		}
+		_ = rec.RecordResource(map[string]any{
+			"cpu_user_ms": usage.UserTime.Milliseconds(),
+			"cpu_sys_ms":  usage.SystemTime.Milliseconds(),
+			"rss_bytes":   usage.RSS,
+		})
+	}
+}
+
+// killAfterError kills the child after recording failed and waits for it.
+func killAfterError(proc *process.Process, rec *recorder.Recorder, err error) (ExitStatus, Stats, error) {
+	_ = proc.Signal(os.Kill)
+	proc.Wait()
+	return ExitStatus{Code: 1}, rec.Stats(), err
+}
+
+// waitToRetry waits RetryDelay before the next attempt of the command. It
+// returns false if ctx is done first or, with ForwardSignals, if ioetap gets
+// SIGINT, SIGTERM or SIGHUP meanwhile.
+func waitToRetry(ctx context.Context, opts RunOptions) bool {
+	var sigChan chan os.Signal
+	if opts.ForwardSignals {
+		sigChan = make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(sigChan)
+	}
+
+	timer := time.NewTimer(opts.RetryDelay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-sigChan:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// forwardSignals forwards the signals received by the process to the child.
+// On SIGINT/SIGTERM/SIGHUP, it flushes all buffered records (including
+// partial lines) first so nothing is lost if the process is killed before
+// Run returns. On SIGTERM/SIGHUP, it also finalizes the recording and
+// terminates the process if the child does not exit within the grace period.
+// On SIGTSTP/SIGCONT (Ctrl-Z and fg/bg), it records suspend/resume meta
+// events, flushing before the process stops itself. On SIGUSR1, it syncs the
+// recording, and forwards the signal unless opts.ConsumeUSR1 is set. On
+// opts.ToggleSignal, it pauses or resumes the recording instead of
+// forwarding the signal.
+func forwardSignals(proc *process.Process, rec *recorder.Recorder, opts RunOptions, copyDone <-chan struct{}, exiting *sync.Mutex, stopping *atomic.Bool) chan os.Signal {
+	var terminating sync.Once
+
+	return process.ForwardSignals(proc, func(sig os.Signal) bool {
+		if opts.ToggleSignal != nil && sig == opts.ToggleSignal {
+			toggleRecording(rec)
+			return false
+		}
+		if opts.RecordSignals {
+			fields := map[string]any{"signal": sig.String()}
+			if n, ok := sig.(syscall.Signal); ok {
+				fields["number"] = int(n)
+			}
+			if err := rec.RecordMeta("signal", fields); err != nil {
+				logging.Warnf("recording error: %v", err)
+			}
+		}
+
+		switch sig {
+		case syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP:
+			stopping.Store(true)
+			if err := rec.FlushAll(); err != nil {
+				logging.Warnf("flush error: %v", err)
+			}
+			if sig == syscall.SIGINT {
+				// Ctrl-C also reaches the child, which decides whether to exit
+				break
+			}
+			terminating.Do(func() {
+				go terminateAfterGrace(sig.(syscall.Signal), opts.GracePeriod, copyDone, func() {
+					exiting.Lock()
+					// Flush data read since the signal before the terminated record
+					if err := rec.FlushAll(); err != nil {
+						logging.Warnf("flush error: %v", err)
+					}
+					if err := rec.RecordMeta("terminated", map[string]any{"signal": sig.String()}); err != nil {
+						logging.Warnf("recording error: %v", err)
+					}
+					if err := rec.Close(); err != nil {
+						logging.Warnf("%v", err)
+					}
+					if opts.Hooks.OnTerminate != nil {
+						opts.Hooks.OnTerminate(sig)
+					}
+				})
+			})
+		case syscall.SIGTSTP:
+			if err := rec.RecordMeta("suspend", nil); err != nil {
+				logging.Warnf("recording error: %v", err)
+			}
+			if err := rec.FlushAll(); err != nil {
+				logging.Warnf("flush error: %v", err)
+			}
+		case syscall.SIGCONT:
+			if err := rec.RecordMeta("resume", nil); err != nil {
+				logging.Warnf("recording error: %v", err)
+			}
+		case syscall.SIGUSR1:
+			if err := rec.Sync(); err != nil {
+				logging.Warnf("%v", err)
+			} else {
+				logging.Debugf("recording synced on %v", sig)
+			}
+			return !opts.ConsumeUSR1
+		}
+		return true
+	})
+}
+
+// toggleRecording pauses the recording of rec, or resumes it if it is paused.
+func toggleRecording(rec *recorder.Recorder) {
+	if rec.Paused() {
+		if err := rec.Resume(); err != nil {
+			logging.Warnf("recording error: %v", err)
+			return
+		}
+		logging.Infof("recording resumed")
+		return
+	}
+	if err := rec.Pause(); err != nil {
+		logging.Warnf("recording error: %v", err)
+		return
+	}
+	logging.Infof("recording paused")
+}
+
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
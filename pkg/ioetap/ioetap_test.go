@@ -0,0 +1,534 @@
+package ioetap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// parseRecords parses the NDJSON records in data.
+func parseRecords(t *testing.T, data []byte) []recorder.Record {
+	t.Helper()
+
+	var records []recorder.Record
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var record recorder.Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("failed to parse record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRun(t *testing.T) {
+	var sink1, sink2, stdout, stderr bytes.Buffer
+	status, stats, err := Run(context.Background(), RunOptions{
+		Command: "sh",
+		Args:    []string{"-c", "read line; echo \"got $line\"; echo oops >&2; exit 3"},
+		Sinks:   []io.Writer{&sink1, &sink2},
+		Stdin:   strings.NewReader("hello\n"),
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if status.Code != 3 || status.Signal != nil || status.ShellCode() != 3 {
+		t.Errorf("unexpected exit status: %+v", status)
+	}
+	if stdout.String() != "got hello\n" || stderr.String() != "oops\n" {
+		t.Errorf("unexpected output: stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+	if stats.Records != 3 {
+		t.Errorf("expected 3 records, got %d", stats.Records)
+	}
+	if stats.Bytes[recorder.Stdin] != 6 || stats.Bytes[recorder.Stdout] != 10 || stats.Bytes[recorder.Stderr] != 5 {
+		t.Errorf("unexpected byte counts: %v", stats.Bytes)
+	}
+
+	if !bytes.Equal(sink1.Bytes(), sink2.Bytes()) {
+		t.Error("expected both sinks to receive the same records")
+	}
+	var contents []string
+	for _, r := range parseRecords(t, sink1.Bytes()) {
+		contents = append(contents, r.Source+":"+r.ContentString())
+	}
+	for _, want := range []string{"stdin:hello", "stdout:got hello", "stderr:oops"} {
+		if !slices.Contains(contents, want) {
+			t.Errorf("expected record %q in %q", want, contents)
+		}
+	}
+}
+
+func TestRun_QueueSize(t *testing.T) {
+	var sink, stdout, stderr bytes.Buffer
+	status, stats, err := Run(context.Background(), RunOptions{
+		Command:   "sh",
+		Args:      []string{"-c", "for i in 1 2 3 4 5; do echo out $i; echo err $i >&2; done"},
+		Sinks:     []io.Writer{&sink},
+		Stdout:    &stdout,
+		Stderr:    &stderr,
+		QueueSize: 2,
+	})
+	if err != nil || status.Code != 0 {
+		t.Fatalf("Run failed: %+v, %v", status, err)
+	}
+
+	// Every line is recorded through the queue before the recording is closed
+	if stats.Records != 10 {
+		t.Errorf("expected 10 records, got %d", stats.Records)
+	}
+	records := parseRecords(t, sink.Bytes())
+	if len(records) != 10 {
+		t.Fatalf("expected 10 records in the sink, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != uint64(i) {
+			t.Errorf("expected seq %d, got %d", i, r.Seq)
+		}
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestRun_FailingSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    RunOptions
+		wantErr bool
+	}{
+		{"sink", RunOptions{Sinks: []io.Writer{failingWriter{}}}, true},
+		{"optional sink", RunOptions{OptionalSinks: []OptionalSink{{Name: "tcp://logger:5170", W: failingWriter{}}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recording bytes.Buffer
+			opts := tt.opts
+			opts.Command = "echo"
+			opts.Args = []string{"hello"}
+			opts.Sinks = append(opts.Sinks, &recording)
+
+			_, _, err := Run(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			// The failure does not keep the record from the other sink
+			records := parseRecords(t, recording.Bytes())
+			if len(records) != 1 || records[0].ContentString() != "hello" {
+				t.Errorf("expected the record in the other sink, got %+v", records)
+			}
+		})
+	}
+}
+
+func TestRun_OpenSink(t *testing.T) {
+	var startPID, sinkPID int
+	var recording bytes.Buffer
+	_, _, err := Run(context.Background(), RunOptions{
+		Command: "true",
+		Label:   "probe",
+		OpenSink: func(pid int) (io.Writer, error) {
+			sinkPID = pid
+			return &recording, nil
+		},
+		Hooks: Hooks{
+			OnStart: func(pid int) { startPID = pid },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if startPID == 0 || sinkPID != startPID {
+		t.Errorf("expected OpenSink to get the child's PID %d, got %d", startPID, sinkPID)
+	}
+	records := parseRecords(t, recording.Bytes())
+	if len(records) != 1 || records[0].Source != recorder.MetaSource {
+		t.Fatalf("expected a start meta record, got %+v", records)
+	}
+	if content := records[0].Content.(map[string]any); content["event"] != "start" || content["label"] != "probe" {
+		t.Errorf("unexpected start record: %v", content)
+	}
+}
+
+func TestRun_RecordIdentity(t *testing.T) {
+	var recording bytes.Buffer
+	_, _, err := Run(context.Background(), RunOptions{
+		Command:        "true",
+		Sinks:          []io.Writer{&recording},
+		RecordIdentity: true,
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	records := parseRecords(t, recording.Bytes())
+	if len(records) != 1 || records[0].Source != recorder.MetaSource {
+		t.Fatalf("expected a start meta record, got %+v", records)
+	}
+	content := records[0].Content.(map[string]any)
+	if content["event"] != "start" {
+		t.Errorf("unexpected start record: %v", content)
+	}
+	if uid, ok := content["uid"].(float64); !ok || int(uid) != os.Geteuid() {
+		t.Errorf("expected uid %d, got %v", os.Geteuid(), content["uid"])
+	}
+	if gid, ok := content["gid"].(float64); !ok || int(gid) != os.Getegid() {
+		t.Errorf("expected gid %d, got %v", os.Getegid(), content["gid"])
+	}
+	umask, _ := content["umask"].(string)
+	if n, err := strconv.ParseUint(umask, 8, 32); err != nil || len(umask) != 4 || n > 0o777 {
+		t.Errorf("expected a 4-digit octal umask, got %v", content["umask"])
+	}
+}
+
+func TestRun_Config(t *testing.T) {
+	var recording bytes.Buffer
+	_, _, err := Run(context.Background(), RunOptions{
+		Command: "true",
+		Sinks:   []io.Writer{&recording},
+		Config:  map[string]any{"version": "1.2.3"},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	records := parseRecords(t, recording.Bytes())
+	if len(records) != 1 || records[0].Source != recorder.MetaSource {
+		t.Fatalf("expected a start meta record, got %+v", records)
+	}
+	content := records[0].Content.(map[string]any)
+	config, ok := content["ioetap"].(map[string]any)
+	if content["event"] != "start" || !ok || config["version"] != "1.2.3" {
+		t.Errorf("expected the config in the start record, got %v", content)
+	}
+}
+
+func TestRun_Restart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	restart := make(chan struct{}, 1)
+	var recording bytes.Buffer
+	var outputs int
+	status, _, err := Run(ctx, RunOptions{
+		Command:     "sh",
+		Args:        []string{"-c", "echo started; exec sleep 10"},
+		Sinks:       []io.Writer{&recording},
+		Restart:     restart,
+		GracePeriod: time.Second,
+		OnRecord: func(record *Record) RecordAction {
+			// Restart after the first run started, and stop after the second
+			if record.Source == "stdout" {
+				outputs++
+				if outputs == 1 {
+					restart <- struct{}{}
+				} else {
+					cancel()
+				}
+			}
+			return RecordPass
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if status.Signal == nil {
+		t.Errorf("expected the last run to be stopped by a signal, got %+v", status)
+	}
+
+	var events []string
+	pids := make(map[float64]bool)
+	for _, record := range parseRecords(t, recording.Bytes()) {
+		if record.Source != recorder.MetaSource {
+			events = append(events, record.ContentString())
+			continue
+		}
+		content := record.Content.(map[string]any)
+		events = append(events, fmt.Sprintf("%s %v", content["event"], content["run"]))
+		pids[content["pid"].(float64)] = true
+	}
+	want := []string{"run 1", "started", "run 2", "started"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if len(pids) != 2 {
+		t.Errorf("expected a different pid for every run, got %v", pids)
+	}
+}
+
+func TestRun_Retries(t *testing.T) {
+	// The command fails twice before it succeeds
+	counter := filepath.Join(t.TempDir(), "attempts")
+	script := `echo x >> "$1"; n=$(wc -l < "$1"); echo "attempt $n"; [ "$n" -ge 3 ]`
+	var recording bytes.Buffer
+	status, _, err := Run(context.Background(), RunOptions{
+		Command:    "sh",
+		Args:       []string{"-c", script, "sh", counter},
+		Sinks:      []io.Writer{&recording},
+		Retries:    5,
+		RetryDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if status.Code != 0 {
+		t.Errorf("expected the last attempt to succeed, got %+v", status)
+	}
+
+	var events []string
+	for i, record := range parseRecords(t, recording.Bytes()) {
+		if record.Seq != uint64(i) {
+			t.Errorf("record %d has seq %d", i, record.Seq)
+		}
+		if record.Source != recorder.MetaSource {
+			events = append(events, strings.TrimSpace(record.ContentString()))
+			continue
+		}
+		content := record.Content.(map[string]any)
+		events = append(events, fmt.Sprintf("%s %v %v", content["event"], content["attempt"], content["exit_code"]))
+	}
+	want := []string{"attempt 1", "retry 2 1", "attempt 2", "retry 3 1", "attempt 3"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestRun_RetriesExhausted(t *testing.T) {
+	var recording bytes.Buffer
+	status, _, err := Run(context.Background(), RunOptions{
+		Command: "sh",
+		Args:    []string{"-c", "exit 3"},
+		Sinks:   []io.Writer{&recording},
+		Retries: 2,
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if status.Code != 3 {
+		t.Errorf("expected the exit code of the last attempt, got %+v", status)
+	}
+	if records := parseRecords(t, recording.Bytes()); len(records) != 2 {
+		t.Errorf("expected 2 retry records, got %d", len(records))
+	}
+}
+
+func TestRun_Comment(t *testing.T) {
+	comment := "retrying after cache purge\n\n\tsee \"INC-42\" <ops>\r\n"
+	var recording bytes.Buffer
+	_, _, err := Run(context.Background(), RunOptions{
+		Command: "true",
+		Sinks:   []io.Writer{&recording},
+		Comment: comment,
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	records := parseRecords(t, recording.Bytes())
+	if len(records) != 1 || records[0].Source != recorder.MetaSource {
+		t.Fatalf("expected a start meta record, got %+v", records)
+	}
+	content := records[0].Content.(map[string]any)
+	if content["event"] != "start" || content["comment"] != comment {
+		t.Errorf("expected the comment %q in the start record, got %v", comment, content)
+	}
+}
+
+// panicWriter panics on the first write.
+type panicWriter struct{}
+
+func (panicWriter) Write([]byte) (int, error) {
+	panic("formatter bug")
+}
+
+func TestRun_Panic(t *testing.T) {
+	var recording bytes.Buffer
+	status, _, err := Run(context.Background(), RunOptions{
+		Command: "sh",
+		Args:    []string{"-c", "echo err >&2; sleep 0.2; echo out; exec sleep 10"},
+		Sinks:   []io.Writer{&recording},
+		Stdout:  panicWriter{},
+	})
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "formatter bug" {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if !bytes.Contains(panicErr.Stack, []byte("panicWriter")) {
+		t.Errorf("expected the stack of the panic, got %s", panicErr.Stack)
+	}
+	if status.Signal == nil {
+		t.Errorf("expected the child to be killed, got %+v", status)
+	}
+
+	// The recording is valid NDJSON up to and ending with the panic record.
+	// The stdout data is forwarded before it is recorded, so the panic
+	// loses it.
+	records := parseRecords(t, recording.Bytes())
+	if len(records) != 2 {
+		t.Fatalf("expected stderr and panic records, got %+v", records)
+	}
+	if records[0].Source != "stderr" || records[0].ContentString() != "err" {
+		t.Errorf("expected the data recorded before the panic, got %+v", records[0])
+	}
+	last := records[1]
+	content, ok := last.Content.(map[string]any)
+	if last.Source != recorder.MetaSource || !ok || content["event"] != "panic" || content["error"] != "formatter bug" {
+		t.Fatalf("expected a panic meta record last, got %+v", last)
+	}
+	if stack, _ := content["stack"].(string); !strings.Contains(stack, "panicWriter") {
+		t.Errorf("expected the stack in the panic record, got %q", stack)
+	}
+}
+
+func TestRun_OnRecord(t *testing.T) {
+	var recording bytes.Buffer
+	var seen []string
+	_, _, err := Run(context.Background(), RunOptions{
+		Command: "sh",
+		Args:    []string{"-c", "echo token=secret; echo keep; echo drop >&2"},
+		Sinks:   []io.Writer{&recording},
+		OnRecord: func(record *Record) RecordAction {
+			seen = append(seen, record.ContentString())
+			if record.Source == "stderr" {
+				return RecordDrop
+			}
+			if strings.HasPrefix(record.ContentString(), "token=") {
+				record.Content = "token=[redacted]"
+			}
+			return RecordPass
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(seen) != 3 {
+		t.Errorf("expected the callback to see 3 records, got %q", seen)
+	}
+	var contents []string
+	for _, record := range parseRecords(t, recording.Bytes()) {
+		contents = append(contents, record.ContentString())
+	}
+	if strings.Join(contents, ",") != "token=[redacted],keep" {
+		t.Errorf("unexpected recorded contents %q", contents)
+	}
+}
+
+func TestRun_OpenSinkError(t *testing.T) {
+	sinkErr := errors.New("no space left")
+	status, _, err := Run(context.Background(), RunOptions{
+		Command:  "sleep",
+		Args:     []string{"30"},
+		OpenSink: func(int) (io.Writer, error) { return nil, sinkErr },
+	})
+	if !errors.Is(err, sinkErr) {
+		t.Errorf("expected the sink error, got %v", err)
+	}
+	if status.Code != 1 {
+		t.Errorf("expected exit code 1, got %d", status.Code)
+	}
+}
+
+func TestRun_MetricsAddrInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The child is not started when the address is taken
+	started := false
+	status, _, err := Run(context.Background(), RunOptions{
+		Command:     "true",
+		MetricsAddr: listener.Addr().String(),
+		Hooks:       Hooks{OnStart: func(int) { started = true }},
+	})
+	if !errors.Is(err, ErrMetricsListen) {
+		t.Errorf("expected a listen error, got %v", err)
+	}
+	if status.Code != 1 || started {
+		t.Errorf("expected exit code 1 without starting the child, got %+v (started: %v)", status, started)
+	}
+}
+
+func TestRun_ControlSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ioetap.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	// The child is not started, and the file is left alone
+	started := false
+	status, _, err := Run(context.Background(), RunOptions{
+		Command:       "true",
+		ControlSocket: path,
+		Hooks:         Hooks{OnStart: func(int) { started = true }},
+	})
+	if !errors.Is(err, ErrControlListen) {
+		t.Errorf("expected a listen error, got %v", err)
+	}
+	if status.Code != 1 || started {
+		t.Errorf("expected exit code 1 without starting the child, got %+v (started: %v)", status, started)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the file to be kept, got %v", err)
+	}
+}
+
+func TestRun_StartFailure(t *testing.T) {
+	var sinkPID = -1
+	var recording bytes.Buffer
+	status, stats, err := Run(context.Background(), RunOptions{
+		Command: "ioetap-no-such-command",
+		OpenSink: func(pid int) (io.Writer, error) {
+			sinkPID = pid
+			return &recording, nil
+		},
+	})
+	var startErr *StartError
+	if !errors.As(err, &startErr) {
+		t.Fatalf("expected a StartError, got %v", err)
+	}
+	if status.Code != 127 || status.ShellCode() != 127 {
+		t.Errorf("expected exit code 127, got %+v", status)
+	}
+	if sinkPID != 0 {
+		t.Errorf("expected OpenSink to get PID 0, got %d", sinkPID)
+	}
+	if stats.Records != 2 {
+		t.Errorf("expected 2 records, got %d", stats.Records)
+	}
+
+	records := parseRecords(t, recording.Bytes())
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	failed := records[1].Content.(map[string]any)
+	if failed["event"] != "start-failed" || failed["error"] != err.Error() {
+		t.Errorf("unexpected start-failed record: %v", failed)
+	}
+}
@@ -0,0 +1,77 @@
+package ioetap
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/trustin/ioetap/internal/logging"
+	"github.com/trustin/ioetap/internal/process"
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// PanicError is returned by Run when a panic occurred in ioetap while the
+// child was running, e.g. in the Recorder or in a Sink. The recording up to
+// the panic was flushed and finalized with a "panic" meta record, and the
+// child was killed.
+type PanicError struct {
+	Value any    // Value passed to panic
+	Stack []byte // Stack trace of the goroutine that panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic while recording: %v", e.Value)
+}
+
+// panicGuard finalizes the recording when a goroutine of Run panics, so the
+// data recorded so far is not lost with the process. Every goroutine that
+// records must defer catch.
+type panicGuard struct {
+	proc *process.Process
+	rec  *recorder.Recorder
+
+	mu  sync.Mutex
+	err *PanicError // First panic caught
+}
+
+// catch recovers from a panic in the calling goroutine, if any. On the first
+// panic, it records a "panic" meta record with the panic value and stack
+// after flushing the records before it, closes the Recorder so nothing
+// follows it, and kills the child. Later panics are only logged.
+// It must be deferred directly.
+func (g *panicGuard) catch() {
+	p := recover()
+	if p == nil {
+		return
+	}
+	stack := debug.Stack()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		logging.Errorf("panic while recording: %v\n\n%s", p, stack)
+		return
+	}
+	g.err = &PanicError{Value: p, Stack: stack}
+
+	if err := g.rec.FlushAll(); err != nil {
+		logging.Warnf("flush error: %v", err)
+	}
+	if err := g.rec.RecordMeta("panic", map[string]any{"error": fmt.Sprint(p), "stack": string(stack)}); err != nil {
+		logging.Warnf("recording error: %v", err)
+	}
+	if err := g.rec.Close(); err != nil {
+		logging.Warnf("%v", err)
+	}
+	_ = g.proc.KillGroup()
+}
+
+// panicked returns the error for the first panic caught, or nil if none was.
+func (g *panicGuard) panicked() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		return nil
+	}
+	return g.err
+}
@@ -0,0 +1,176 @@
+package ioetap
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/trustin/ioetap/internal/process"
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// runRestarting is Run with opts.Restart set. It runs the command until ctx
+// is done, recording every run in the same recording after a "run" meta
+// record. A value on opts.Restart stops the current run and starts the next
+// one. A run that ends by itself is followed by the next value, so the
+// command is not re-run until something changes.
+func runRestarting(ctx context.Context, opts RunOptions, listeners listeners) (ExitStatus, Stats, error) {
+	procOpts := process.ProcessOptions{Rlimits: opts.Rlimits, ExtraFiles: opts.ExtraFiles}
+	start := process.StartWithOptions
+	if opts.ReapProcesses {
+		start = process.StartWithReaping
+	}
+	// When ctx is done, the child is stopped like for a restart rather than
+	// killed right away
+	childCtx := context.WithoutCancel(ctx)
+	proc, err := start(childCtx, opts.Command, opts.Args, procOpts)
+
+	// The first child has its own copies of the passed descriptors now, and
+	// later ones get none
+	for _, file := range opts.ExtraFiles {
+		file.Close()
+	}
+	procOpts.ExtraFiles = nil
+
+	if err != nil {
+		return recordStartFailure(opts, err)
+	}
+
+	if opts.Hooks.OnStart != nil {
+		opts.Hooks.OnStart(proc.PID())
+	}
+
+	rec, err := newRecorder(opts, proc.PID())
+	if err != nil {
+		_ = proc.Signal(os.Kill)
+		proc.Wait()
+		return ExitStatus{Code: 1}, Stats{}, err
+	}
+	defer rec.Close()
+
+	defer listeners.serve(rec)()
+	if opts.Hooks.OnRecording != nil {
+		opts.Hooks.OnRecording(rec.Stats)
+	}
+
+	if hasStartMeta(opts) {
+		if err := rec.RecordMeta("start", startMetaFields(opts)); err != nil {
+			return killAfterError(proc, rec, err)
+		}
+	}
+
+	var status ExitStatus
+	for run := 1; ; run++ {
+		if err := rec.RecordMeta("run", map[string]any{"run": run, "pid": proc.PID()}); err != nil {
+			return killAfterError(proc, rec, err)
+		}
+
+		var restarted bool
+		status, restarted, err = runOnce(ctx, proc, rec, opts)
+		if err != nil {
+			return status, rec.Stats(), err
+		}
+		if !restarted && !waitForRestart(ctx, opts.Restart) {
+			break
+		}
+
+		// A command that cannot be started now may be fixed by the next change
+		for {
+			proc, err = start(childCtx, opts.Command, opts.Args, procOpts)
+			if err == nil {
+				break
+			}
+			err = &StartError{Err: err}
+			status = ExitStatus{Code: startFailureExitCode(err)}
+			if err := rec.RecordMeta("start-failed", map[string]any{"error": err.Error(), "exit_code": status.Code}); err != nil {
+				return status, rec.Stats(), err
+			}
+			if !waitForRestart(ctx, opts.Restart) {
+				err = rec.Close()
+				return status, rec.Stats(), err
+			}
+		}
+
+		if opts.Hooks.OnStart != nil {
+			opts.Hooks.OnStart(proc.PID())
+		}
+	}
+
+	err = rec.Close()
+	return status, rec.Stats(), err
+}
+
+// runOnce records the output of proc until it exits. If opts.Restart receives
+// a value or ctx is done first, it stops proc (see stopProcess). It returns the
+// exit status of proc and whether it was stopped for a restart, or a
+// *PanicError if recording panicked (see panicGuard).
+func runOnce(ctx context.Context, proc *process.Process, rec *recorder.Recorder, opts RunOptions) (ExitStatus, bool, error) {
+	// Stdin is not forwarded, since it cannot be replayed to every run
+	proc.Stdin.Close()
+
+	guard := &panicGuard{proc: proc, rec: rec}
+	resourceDone := make(chan struct{})
+	if opts.CPUTimeInterval > 0 {
+		go func() {
+			defer close(resourceDone)
+			defer guard.catch()
+			recordResourceUsage(proc, rec, opts.CPUTimeInterval)
+		}()
+	} else {
+		close(resourceDone)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer guard.catch()
+		_ = rec.CopyAndRecord(recorder.Stdout, proc.Stdout, writerOrDiscard(opts.Stdout))
+	}()
+	go func() {
+		defer wg.Done()
+		defer guard.catch()
+		_ = rec.CopyAndRecord(recorder.Stderr, proc.Stderr, writerOrDiscard(opts.Stderr))
+	}()
+
+	restarted := false
+	select {
+	case <-proc.Done():
+	case <-opts.Restart:
+		restarted = true
+		stopProcess(proc, opts.GracePeriod)
+	case <-ctx.Done():
+		stopProcess(proc, opts.GracePeriod)
+	}
+
+	wg.Wait()
+	waitStatus := proc.WaitStatus()
+	<-resourceDone
+	return ExitStatus{Code: waitStatus.Code, Signal: waitStatus.Signal}, restarted, guard.panicked()
+}
+
+// stopProcess sends SIGTERM to proc and kills it (see Process.KillGroup) if
+// it does not exit within grace.
+func stopProcess(proc *process.Process, grace time.Duration) {
+	_ = proc.Signal(syscall.SIGTERM)
+	if _, exited := proc.WaitTimeout(grace); !exited {
+		_ = proc.KillGroup()
+		proc.Wait()
+	}
+}
+
+// waitForRestart waits for a value on restart. It returns false if ctx is
+// done first.
+func waitForRestart(ctx context.Context, restart <-chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-restart:
+		return true
+	}
+}
@@ -0,0 +1,40 @@
+package ioetap
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// terminateAfterGrace is called when ioetap itself receives the fatal signal
+// sig. It waits up to grace for done to be closed, i.e. for the child to exit
+// and its output to be drained. If that does not happen in time (e.g. the
+// child ignores sig), it calls finalize to complete the recording and then
+// re-raises sig on ioetap.
+func terminateAfterGrace(sig syscall.Signal, grace time.Duration, done <-chan struct{}, finalize func()) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		// The normal exit path takes over
+		return
+	case <-timer.C:
+	}
+
+	finalize()
+	reraise(sig)
+}
+
+// reraise terminates ioetap with sig using the default signal action, so that
+// the parent sees ioetap killed by the signal it received.
+func reraise(sig syscall.Signal) {
+	signal.Reset(sig)
+	_ = syscall.Kill(os.Getpid(), sig)
+
+	// The default action may be to ignore sig if ioetap was started with it
+	// ignored (e.g. SIGHUP under nohup), so exit like a shell would.
+	time.Sleep(time.Second)
+	os.Exit(128 + int(sig))
+}
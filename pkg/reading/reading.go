@@ -0,0 +1,277 @@
+// Package reading reads ioetap recordings one record at a time, so that
+// recordings of any size can be processed without loading them into memory.
+package reading
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// Record is a single record of a recording.
+type Record = recorder.Record
+
+// Header is the line that describes a recording made with --header.
+type Header = recorder.Header
+
+// Errors returned by Record.Raw.
+var (
+	ErrTruncated      = recorder.ErrTruncated      // The record was truncated
+	ErrLossy          = recorder.ErrLossy          // The record has replaced invalid UTF-8
+	ErrNotRecoverable = recorder.ErrNotRecoverable // The record has json content
+)
+
+// gzipMagic is the first two bytes of a gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// TornLineError is returned when the final line of a recording is cut off,
+// typically because ioetap was killed while writing it. The records before
+// it have been read successfully.
+type TornLineError struct {
+	// Line is the 1-based line number of the torn line.
+	Line int
+	// Offset is the byte offset of the start of the torn line in the
+	// (decompressed) recording.
+	Offset int64
+	// Err is the error from parsing the torn line.
+	Err error
+}
+
+func (e *TornLineError) Error() string {
+	return fmt.Sprintf("line %d: torn final line at offset %d: %v", e.Line, e.Offset, e.Err)
+}
+
+func (e *TornLineError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads records from a recording. Its use follows bufio.Scanner:
+//
+//	for reader.Next() {
+//		record := reader.Record()
+//		...
+//	}
+//	if err := reader.Err(); err != nil {
+//		...
+//	}
+//
+// or reads all records at once with All. Gzip-compressed recordings are
+// decompressed transparently, and so are zstd-compressed ones once
+// UseZstdCommand is called, lines have no length limit, and a header line,
+// either a JSON Schema as written with --output-format=ndjson-schema or a
+// Header as written with --header, is skipped. A line that is not a record,
+// e.g. one without a source, is an error.
+type Reader struct {
+	reader  *bufio.Reader
+	closer  io.Closer
+	decomp  io.Closer // gzip or zstd reader of a compressed recording
+	filters []func(Record) bool
+
+	record Record
+	header *Header
+	err    error
+	line   int
+	offset int64
+}
+
+// Open opens the recording file at path. The returned Reader must be closed.
+// A zstd-compressed recording is decompressed by running the command set by
+// UseZstdCommand, found in $PATH, and Open returns ErrZstdUnsupported if
+// none is set.
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+
+	reader, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// NewReader returns a Reader that reads records from r, which may be gzip-
+// or zstd-compressed. Closing the Reader does not close r. A
+// zstd-compressed r is decompressed by running the command set by
+// UseZstdCommand, found in $PATH, and NewReader returns ErrZstdUnsupported
+// if none is set.
+func NewReader(r io.Reader) (*Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress recording: %w", err)
+		}
+		return &Reader{reader: bufio.NewReader(gz), decomp: gz}, nil
+	case bytes.Equal(magic, zstdMagic):
+		zr, err := newZstdReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress recording: %w", err)
+		}
+		return &Reader{reader: bufio.NewReader(zr), decomp: zr}, nil
+	default:
+		return &Reader{reader: buffered}, nil
+	}
+}
+
+// Next advances to the next record, which is then available through Record.
+// It returns false at the end of the recording or on an error, which is
+// reported by Err.
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	for {
+		// ReadBytes has no line length limit, unlike bufio.Scanner
+		offset := r.offset
+		line, readErr := r.reader.ReadBytes('\n')
+		r.offset += int64(len(line))
+		r.line++
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			r.err = fmt.Errorf("failed to read recording: %w", readErr)
+			return false
+		}
+		eof := readErr != nil
+
+		trimmed := bytes.TrimSpace(line)
+		if r.line == 1 {
+			if header, ok := recorder.ParseHeader(trimmed); ok {
+				r.header = &header
+				trimmed = nil
+			}
+		}
+		if len(trimmed) == 0 || (r.line == 1 && isSchemaHeader(trimmed)) {
+			if eof {
+				r.err = io.EOF
+				return false
+			}
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(trimmed, &record); err != nil {
+			if eof {
+				r.err = &TornLineError{Line: r.line, Offset: offset, Err: err}
+			} else {
+				r.err = fmt.Errorf("line %d: failed to parse record: %w", r.line, err)
+			}
+			return false
+		}
+		if err := validate(record); err != nil {
+			r.err = fmt.Errorf("line %d: invalid record: %w", r.line, err)
+			return false
+		}
+
+		if !r.keep(record) {
+			if eof {
+				r.err = io.EOF
+				return false
+			}
+			continue
+		}
+
+		r.record = record
+		if eof {
+			// Report the end of the recording on the next call
+			r.err = io.EOF
+		}
+		return true
+	}
+}
+
+// Filter makes Next skip the records for which keep returns false, and
+// returns r for chaining. Records must pass all filters to be returned.
+func (r *Reader) Filter(keep func(Record) bool) *Reader {
+	r.filters = append(r.filters, keep)
+	return r
+}
+
+// keep reports whether record passes all filters.
+func (r *Reader) keep(record Record) bool {
+	for _, keep := range r.filters {
+		if !keep(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// All reads the remaining records. On an error, it returns the records read
+// before it along with the error.
+func (r *Reader) All() ([]Record, error) {
+	var records []Record
+	for r.Next() {
+		records = append(records, r.record)
+	}
+	return records, r.Err()
+}
+
+// Record returns the record read by the last successful call to Next.
+func (r *Reader) Record() Record {
+	return r.record
+}
+
+// Header returns the Header the recording starts with, or nil if it has
+// none. It is known once Next has been called.
+func (r *Reader) Header() *Header {
+	return r.header
+}
+
+// Err returns the error that stopped Next, or nil at the end of the
+// recording.
+func (r *Reader) Err() error {
+	if errors.Is(r.err, io.EOF) {
+		return nil
+	}
+	return r.err
+}
+
+// Close releases the resources of the Reader, closing the file if it was
+// opened with Open.
+func (r *Reader) Close() error {
+	if r.decomp != nil {
+		r.decomp.Close()
+	}
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// validate checks that record has the fields every record has.
+func validate(record Record) error {
+	switch {
+	case record.Timestamp == "":
+		return errors.New("missing timestamp")
+	case record.Source == "":
+		return errors.New("missing source")
+	case record.Encoding == "":
+		return errors.New("missing encoding")
+	}
+	return nil
+}
+
+// isSchemaHeader reports whether line is a JSON Schema rather than a record.
+func isSchemaHeader(line []byte) bool {
+	var header struct {
+		Schema *string `json:"$schema"`
+	}
+	return json.Unmarshal(line, &header) == nil && header.Schema != nil
+}
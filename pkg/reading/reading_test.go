@@ -0,0 +1,401 @@
+package reading
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trustin/ioetap/internal/output"
+	"github.com/trustin/ioetap/internal/recorder"
+)
+
+// recordLine returns the NDJSON line of a stdout record with content data.
+func recordLine(t *testing.T, seq uint64, data string) string {
+	t.Helper()
+	jsonData, err := recorder.NewRecord(seq, time.Now(), "stdout", []byte(data)).ToJSON()
+	if err != nil {
+		t.Fatalf("failed to serialize record: %v", err)
+	}
+	return string(jsonData) + "\n"
+}
+
+// readAll reads the records of recording and returns their contents.
+func readAll(t *testing.T, recording []byte) ([]string, error) {
+	t.Helper()
+	reader, err := NewReader(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	var contents []string
+	for reader.Next() {
+		contents = append(contents, reader.Record().ContentString())
+	}
+	return contents, reader.Err()
+}
+
+func TestReader(t *testing.T) {
+	long := strings.Repeat("x", 2*1024*1024)
+	plain := recordLine(t, 0, "a\n") + recordLine(t, 1, "b\n")
+
+	tests := []struct {
+		name      string
+		recording string
+		want      []string
+	}{
+		{"empty", "", nil},
+		{"records", plain, []string{"a", "b"}},
+		{"no final newline", strings.TrimSuffix(plain, "\n"), []string{"a", "b"}},
+		{"blank lines", "\n" + recordLine(t, 0, "a\n") + "\n\n", []string{"a"}},
+		{"schema header", string(output.GenerateRecordSchema()) + "\n" + plain, []string{"a", "b"}},
+		{"line longer than scanner buffer", recordLine(t, 0, long), []string{long}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(t, []byte(tt.recording))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d records, got %d", len(tt.want), len(got))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("record %d: expected %d bytes, got %d bytes", i, len(tt.want[i]), len(got[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestReader_Header(t *testing.T) {
+	header, err := recorder.NewHeader("make", []string{"test"}, map[string]string{"env": "ci"}).ToJSON()
+	if err != nil {
+		t.Fatalf("failed to serialize header: %v", err)
+	}
+	recording := string(header) + "\n" + recordLine(t, 0, "a\n") + recordLine(t, 1, "b\n")
+
+	reader, err := NewReader(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	records, err := reader.All()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected 2 records, got %d", len(records))
+	}
+
+	got := reader.Header()
+	if got == nil {
+		t.Fatal("expected a header")
+	}
+	if got.Type != "ioetap" || got.Version != recorder.FormatVersion || got.Command != "make" || strings.Join(got.Args, " ") != "test" {
+		t.Errorf("unexpected header %+v", got)
+	}
+	if got.Fields[0] != "seq" || strings.Join(got.CustomFields, ",") != "env" {
+		t.Errorf("expected the built-in fields and the env custom field, got %q and %q", got.Fields, got.CustomFields)
+	}
+
+	// A header is only recognized on the first line
+	reader, err = NewReader(strings.NewReader(recordLine(t, 0, "a\n") + string(header) + "\n"))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if _, err := reader.All(); err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+	if reader.Header() != nil {
+		t.Errorf("expected no header, got %+v", reader.Header())
+	}
+}
+
+func TestReader_Gzip(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(recordLine(t, 0, "a\n") + recordLine(t, 1, "b\n")))
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to compress recording: %v", err)
+	}
+
+	got, err := readAll(t, compressed.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(got, ",") != "a,b" {
+		t.Errorf("expected records a,b, got %q", got)
+	}
+}
+
+func TestReader_Zstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skipf("no zstd command: %v", err)
+	}
+	compress := exec.Command("zstd", "-c", "-q")
+	compress.Stdin = strings.NewReader(recordLine(t, 0, "a\n") + recordLine(t, 1, "b\n"))
+	compressed, err := compress.Output()
+	if err != nil {
+		t.Fatalf("failed to compress recording: %v", err)
+	}
+
+	// zstd is only decompressed once enabled
+	if _, err := NewReader(bytes.NewReader(compressed)); !errors.Is(err, ErrZstdUnsupported) {
+		t.Errorf("expected ErrZstdUnsupported, got %v", err)
+	}
+	UseZstdCommand("zstd")
+	t.Cleanup(func() { UseZstdCommand("") })
+
+	got, err := readAll(t, compressed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(got, ",") != "a,b" {
+		t.Errorf("expected records a,b, got %q", got)
+	}
+
+	// A corrupt frame is an error rather than the end of the recording
+	corrupt := append([]byte{}, zstdMagic...)
+	corrupt = append(corrupt, "not zstd"...)
+	if _, err := readAll(t, corrupt); err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("expected a zstd error, got %v", err)
+	}
+}
+
+func TestReader_TornLine(t *testing.T) {
+	complete := recordLine(t, 0, "a\n") + recordLine(t, 1, "b\n")
+	torn := recordLine(t, 2, "c\n")
+	recording := complete + torn[:len(torn)/2]
+
+	got, err := readAll(t, []byte(recording))
+	if strings.Join(got, ",") != "a,b" {
+		t.Errorf("expected records a,b before the torn line, got %q", got)
+	}
+
+	var tornErr *TornLineError
+	if !errors.As(err, &tornErr) {
+		t.Fatalf("expected a TornLineError, got %v", err)
+	}
+	if tornErr.Line != 3 {
+		t.Errorf("expected line 3, got %d", tornErr.Line)
+	}
+	if tornErr.Offset != int64(len(complete)) {
+		t.Errorf("expected offset %d, got %d", len(complete), tornErr.Offset)
+	}
+}
+
+func TestReader_InvalidLine(t *testing.T) {
+	recording := recordLine(t, 0, "a\n") + "not json\n" + recordLine(t, 1, "b\n")
+
+	got, err := readAll(t, []byte(recording))
+	if strings.Join(got, ",") != "a" {
+		t.Errorf("expected records before the invalid line, got %q", got)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: failed to parse record") {
+		t.Errorf("expected a parse error on line 2, got %v", err)
+	}
+	var tornErr *TornLineError
+	if errors.As(err, &tornErr) {
+		t.Errorf("expected a non-final invalid line not to be torn")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	if err := os.WriteFile(path, []byte(recordLine(t, 0, "a\n")), 0o644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+
+	reader, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if !reader.Next() || reader.Record().ContentString() != "a" {
+		t.Errorf("expected record a")
+	}
+	if reader.Next() {
+		t.Errorf("expected the end of the recording")
+	}
+	if err := reader.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.jsonl")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestReader_InvalidRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantErr string
+	}{
+		{"missing timestamp", `{"seq":1,"source":"stdout","content":"b","encoding":"text"}`, "line 2: invalid record: missing timestamp"},
+		{"missing source", `{"seq":1,"timestamp":"2024-01-15T10:30:45.000Z","content":"b","encoding":"text"}`, "line 2: invalid record: missing source"},
+		{"missing encoding", `{"seq":1,"timestamp":"2024-01-15T10:30:45.000Z","source":"stdout","content":"b"}`, "line 2: invalid record: missing encoding"},
+		{"array", `[1,2]`, "line 2: failed to parse record"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recording := recordLine(t, 0, "a\n") + tt.line + "\n" + recordLine(t, 2, "c\n")
+			got, err := readAll(t, []byte(recording))
+			if strings.Join(got, ",") != "a" {
+				t.Errorf("expected records before the invalid line, got %q", got)
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReader_All(t *testing.T) {
+	recording := recordLine(t, 0, "a\n") + recordLine(t, 1, "b\n") + recordLine(t, 2, "c\n")
+	reader, err := NewReader(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+
+	// All returns the records after those read with Next
+	if !reader.Next() || reader.Record().ContentString() != "a" {
+		t.Fatalf("expected record a")
+	}
+	records, err := reader.All()
+	if err != nil {
+		t.Fatalf("All failed: %v", err)
+	}
+	if len(records) != 2 || records[0].ContentString() != "b" || records[1].ContentString() != "c" {
+		t.Errorf("expected records b and c, got %+v", records)
+	}
+
+	// The end of the recording is not an error, however often it is reached
+	if reader.Next() {
+		t.Errorf("expected the end of the recording")
+	}
+	if records, err := reader.All(); len(records) != 0 || err != nil {
+		t.Errorf("expected no records and no error at the end, got %+v and %v", records, err)
+	}
+
+	// On an error, All returns the records read before it
+	reader, err = NewReader(strings.NewReader(recordLine(t, 0, "a\n") + "not json\n"))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	records, err = reader.All()
+	if len(records) != 1 || records[0].ContentString() != "a" {
+		t.Errorf("expected record a before the error, got %+v", records)
+	}
+	if err == nil {
+		t.Errorf("expected a parse error")
+	}
+}
+
+func TestReader_Filter(t *testing.T) {
+	var recording strings.Builder
+	for i, content := range []string{"a", "bb", "c", "dd", "e"} {
+		recording.WriteString(recordLine(t, uint64(i), content+"\n"))
+	}
+
+	reader, err := NewReader(strings.NewReader(recording.String()))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	records, err := reader.
+		Filter(func(r Record) bool { return r.Seq > 0 }).
+		Filter(func(r Record) bool { return len(r.ContentString()) == 1 }).
+		All()
+	if err != nil {
+		t.Fatalf("All failed: %v", err)
+	}
+
+	var got []string
+	for _, record := range records {
+		got = append(got, record.ContentString())
+	}
+	if strings.Join(got, ",") != "c,e" {
+		t.Errorf("expected records c,e, got %q", got)
+	}
+}
+
+func TestReader_RecorderOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []recorder.Option
+	}{
+		{"default", nil},
+		{"schema header", []recorder.Option{recorder.WithHeader(output.GenerateRecordSchema())}},
+		{"sorted fields without HTML escaping", []recorder.Option{
+			recorder.WithSortedFields(), recorder.WithoutHTMLEscaping(), recorder.WithFields(map[string]string{"env": "<test>"}),
+		}},
+		{"chain hash and histogram", []recorder.Option{recorder.WithChainHash(), recorder.WithTimingHistogram()}},
+		{"bursts and read sizes", []recorder.Option{recorder.WithBurstDetection(), recorder.WithReadSizes(), recorder.WithStdinEcho()}},
+		{"split long lines", []recorder.Option{recorder.WithSplitLongLines(), recorder.WithBinaryLimit(8)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "recording.jsonl")
+			rec, err := recorder.NewFileRecorder(path, 16, tt.opts...)
+			if err != nil {
+				t.Fatalf("failed to create recorder: %v", err)
+			}
+			writes := []struct {
+				source recorder.Source
+				data   string
+			}{
+				{recorder.Stdin, "input\n"},
+				{recorder.Stdout, "<html> & text\r\n"},
+				{recorder.Stdout, `{"key":[1,"two",null]}` + "\n"},
+				{recorder.Stderr, "\xff\xfe binary \x00\n"},
+				{recorder.Stdout, strings.Repeat("long ", 10) + "\n"},
+				{recorder.Stderr, "partial"},
+			}
+			for _, w := range writes {
+				if err := rec.Record(w.source, []byte(w.data)); err != nil {
+					t.Fatalf("failed to record: %v", err)
+				}
+			}
+			if err := rec.RecordMeta("mark", map[string]any{"n": 1}); err != nil {
+				t.Fatalf("failed to record meta: %v", err)
+			}
+			if err := rec.RecordResource(map[string]any{"cpu_user_ms": 1, "cpu_sys_ms": 2, "rss_bytes": 3}); err != nil {
+				t.Fatalf("failed to record resource: %v", err)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("failed to close recorder: %v", err)
+			}
+			written := rec.Stats().Records
+
+			reader, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer reader.Close()
+			records, err := reader.All()
+			if err != nil {
+				t.Fatalf("failed to re-read the recording: %v", err)
+			}
+			if uint64(len(records)) != written {
+				t.Fatalf("expected %d records, got %d", written, len(records))
+			}
+			for i, record := range records {
+				if record.Seq != uint64(i) {
+					t.Errorf("expected seq %d, got %d", i, record.Seq)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package reading
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+)
+
+// zstdMagic is the first four bytes of a zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// ErrZstdUnsupported is returned by NewReader and Open for a zstd-compressed
+// recording unless UseZstdCommand enabled decompressing it.
+var ErrZstdUnsupported = errors.New("zstd-compressed recordings are not supported without the zstd command; see reading.UseZstdCommand")
+
+// zstdCommand is the command set by UseZstdCommand, empty if disabled.
+var zstdCommand atomic.Pointer[string]
+
+// UseZstdCommand makes Readers decompress zstd-compressed recordings by
+// running the command name, e.g. "zstd", as "<name> -d -c -q". A name
+// without a slash is looked up in $PATH when a recording is opened. An
+// empty name disables zstd, which is the default: the standard library has
+// no zstd decoder, and a library running a program found in $PATH would be
+// a surprise, so programs opt in. The ioetap command does.
+func UseZstdCommand(name string) {
+	zstdCommand.Store(&name)
+}
+
+// zstdCommandName returns the command set by UseZstdCommand.
+func zstdCommandName() string {
+	if name := zstdCommand.Load(); name != nil {
+		return *name
+	}
+	return ""
+}
+
+// zstdReader reads the output of the zstd command decompressing its input.
+type zstdReader struct {
+	name   string // command name
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	err    error // io.EOF or the error of the command, once it has exited
+}
+
+// newZstdReader starts decompressing r with the command set by
+// UseZstdCommand. It returns ErrZstdUnsupported if none is set.
+func newZstdReader(r io.Reader) (*zstdReader, error) {
+	name := zstdCommandName()
+	if name == "" {
+		return nil, ErrZstdUnsupported
+	}
+	z := &zstdReader{name: name, cmd: exec.Command(name, "-d", "-c", "-q")}
+	z.cmd.Stdin = r
+	z.cmd.Stderr = &z.stderr
+	stdout, err := z.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := z.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("zstd-compressed recordings need the %s command: %w", name, err)
+	}
+	z.stdout = stdout
+	return z, nil
+}
+
+// Read reads decompressed data. Once all of it has been read, it returns
+// io.EOF if the command succeeded, or its error otherwise, e.g. for corrupt
+// input.
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		z.err = io.EOF
+		if waitErr := z.cmd.Wait(); waitErr != nil {
+			z.err = fmt.Errorf("%s: %w: %s", z.name, waitErr, strings.TrimSpace(z.stderr.String()))
+		}
+		return n, z.err
+	}
+	return n, err
+}
+
+// Close stops the command if it is still running.
+func (z *zstdReader) Close() error {
+	if z.err != nil {
+		return nil
+	}
+	z.err = errors.New("zstd reader is closed")
+	_ = z.cmd.Process.Kill()
+	_ = z.cmd.Wait()
+	return nil
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPTY opens a pseudo-terminal and returns its master and slave ends.
+func openPTY(t *testing.T) (master, slave *os.File) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Fatalf("failed to unlock pseudo-terminal: %v", errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Fatalf("failed to get pseudo-terminal number: %v", errno)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		t.Fatalf("failed to open pseudo-terminal: %v", err)
+	}
+	return master, slave
+}
+
+func TestIntegration_ProgressOnTerminal(t *testing.T) {
+	binary := buildIoetap(t)
+	workDir := t.TempDir()
+	outputFile := filepath.Join(workDir, "progress.jsonl")
+
+	master, slave := openPTY(t)
+	defer master.Close()
+
+	// The progress is redrawn every second, so the child runs for longer
+	cmd := exec.Command(binary, "--progress", "--out="+outputFile, "--",
+		"sh", "-c", "echo hello; echo oops >&2; sleep 1.5; echo bye")
+	cmd.Dir = workDir
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if err := cmd.Start(); err != nil {
+		slave.Close()
+		t.Fatalf("failed to start ioetap: %v", err)
+	}
+	slave.Close()
+
+	// Reading the master fails with EIO once ioetap has exited
+	terminal := make(chan []byte, 1)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, master)
+		terminal <- buf.Bytes()
+	}()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("ioetap failed: %v", err)
+	}
+	output := string(<-terminal)
+
+	for _, want := range []string{"hello\r\n", "oops\r\n", "bye\r\n", "\rioetap: ", " records, "} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q on the terminal, got %q", want, output)
+		}
+	}
+	// The progress line is cleared before the child's output and at the end
+	last := strings.LastIndex(output, "\rioetap: ")
+	if last < 0 || !strings.Contains(output[last:], "\r\x1b[K") {
+		t.Errorf("expected the progress line to be cleared, got %q", output)
+	}
+
+	var contents []string
+	for _, r := range readRecords(t, outputFile) {
+		contents = append(contents, r.Source+": "+r.ContentString())
+	}
+	slices.Sort(contents)
+	want := []string{"stderr: oops", "stdout: bye", "stdout: hello"}
+	if !slices.Equal(contents, want) {
+		t.Errorf("expected records %q without the progress, got %q", want, contents)
+	}
+}